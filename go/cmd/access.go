@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// accessFile is the file of API tokens read by the -tokens flag of
+// `dbx serve http` and `dbx serve grpc`. Each token is stored as the SHA-256
+// of its value, e.g. from `printf %s "$TOKEN" | sha256sum`, so the file
+// holds no secrets:
+//
+//	tokens:
+//	  - name: analytics
+//	    sha256: 5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8
+//	    operations: [export, stream, jobs]
+//	    tables: [public.orders, "sales.*"]
+//	    destinations: ["analytics/"]
+//
+// Clients send the token as "Authorization: Bearer <token>", in an HTTP
+// header or gRPC metadata.
+type accessFile struct {
+	Tokens []*accessToken `yaml:"tokens"`
+}
+
+// accessToken is an API token and what it allows. Empty Tables and
+// Destinations allow every table and destination.
+type accessToken struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+	// Operations are among export, import, stream and jobs (listing,
+	// inspecting and cancelling the jobs started with the token).
+	Operations []string `yaml:"operations"`
+	// Tables are patterns of the tables that may be exported, streamed or
+	// imported into. Each part of a pattern is matched, as in path.Match,
+	// against the same part of the table name parsed and folded as by
+	// -identifier-case, so "sales.*" allows every table of schema sales,
+	// however it is spelled, but no catalog.schema.table name.
+	Tables []string `yaml:"tables"`
+	// Queries allows SQL queries besides tables. Queries can read any
+	// table, so it is best left off for tokens restricted to some tables.
+	Queries bool `yaml:"queries"`
+	// Destinations are patterns of the output paths, relative to the
+	// server's -dir, that exports may write. A pattern ending in / allows
+	// everything under that directory.
+	Destinations []string `yaml:"destinations"`
+
+	hash []byte
+	// tables are the parsed Tables, folded with identCase.
+	tables    []tableIdent
+	identCase string
+}
+
+// accessOperations are the operations tokens can be allowed.
+var accessOperations = map[string]bool{"export": true, "import": true, "stream": true, "jobs": true}
+
+// accessPolicy authenticates the API tokens of a server. A nil
+// *accessPolicy lets every request through.
+type accessPolicy struct {
+	tokens []*accessToken
+}
+
+// errUnauthenticated is returned for requests without a known token.
+var errUnauthenticated = errors.New("a valid API token is required")
+
+// loadAccessPolicy reads the tokens file, or returns nil if file is empty.
+// Table names are folded with the -identifier-case mode identCase.
+func loadAccessPolicy(file, identCase string) (*accessPolicy, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %w", err)
+	}
+	var f accessFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse tokens %s: %w", file, err)
+	}
+	names := make(map[string]bool)
+	for i, t := range f.Tokens {
+		if t == nil || t.Name == "" {
+			return nil, fmt.Errorf("tokens %s: token %d has no name", file, i+1)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("tokens %s: duplicate token %s", file, t.Name)
+		}
+		names[t.Name] = true
+		if t.hash, err = hex.DecodeString(t.SHA256); err != nil || len(t.hash) != sha256.Size {
+			return nil, fmt.Errorf("tokens %s: token %s: sha256 must be 64 hex digits", file, t.Name)
+		}
+		for _, op := range t.Operations {
+			if !accessOperations[op] {
+				return nil, fmt.Errorf("tokens %s: token %s: unknown operation %q, expected export, import, stream or jobs", file, t.Name, op)
+			}
+		}
+		t.identCase = identCase
+		for _, p := range t.Tables {
+			ident, err := parseTableIdent(p)
+			if err == nil {
+				for _, part := range ident.parts {
+					if _, err = path.Match(part.name, ""); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tokens %s: token %s: invalid table pattern %q", file, t.Name, p)
+			}
+			t.tables = append(t.tables, ident.fold(identCase))
+		}
+		for _, p := range t.Destinations {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("tokens %s: token %s: invalid pattern %q", file, t.Name, p)
+			}
+		}
+	}
+	if len(f.Tokens) == 0 {
+		return nil, fmt.Errorf("tokens %s: no tokens", file)
+	}
+	return &accessPolicy{tokens: f.Tokens}, nil
+}
+
+// authenticate returns the token of an Authorization header value, which
+// is nil when p is. It fails with errUnauthenticated for unknown tokens.
+func (p *accessPolicy) authenticate(header string) (*accessToken, error) {
+	if p == nil {
+		return nil, nil
+	}
+	value, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || value == "" {
+		return nil, errUnauthenticated
+	}
+	sum := sha256.Sum256([]byte(value))
+	for _, t := range p.tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.hash) == 1 {
+			return t, nil
+		}
+	}
+	return nil, errUnauthenticated
+}
+
+// allow checks that t may perform op. A nil token allows everything.
+func (t *accessToken) allow(op string) error {
+	if t == nil {
+		return nil
+	}
+	for _, o := range t.Operations {
+		if o == op {
+			return nil
+		}
+	}
+	return fmt.Errorf("token %s is not allowed to %s", t.Name, op)
+}
+
+// allowSource checks that t may read or write table, or run query.
+func (t *accessToken) allowSource(table, query string) error {
+	if t == nil {
+		return nil
+	}
+	if query != "" {
+		if !t.Queries {
+			return fmt.Errorf("token %s is not allowed to run queries", t.Name)
+		}
+		return nil
+	}
+	if len(t.Tables) == 0 || t.matchTable(table) {
+		return nil
+	}
+	return fmt.Errorf("token %s is not allowed to access table %s", t.Name, table)
+}
+
+// allowDestination checks that t may write to name, a path under the
+// server's -dir.
+func (t *accessToken) allowDestination(name string) error {
+	if t == nil || len(t.Destinations) == 0 {
+		return nil
+	}
+	name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+name)), "/")
+	for _, p := range t.Destinations {
+		if dir, ok := strings.CutSuffix(p, "/"); ok {
+			if strings.HasPrefix(name, dir+"/") {
+				return nil
+			}
+		} else if ok, _ := path.Match(p, name); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("token %s is not allowed to write %s", t.Name, name)
+}
+
+// name returns the name jobs started with t are recorded under, empty for
+// a nil token.
+func (t *accessToken) name() string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+// ownsJob reports whether t may see and cancel j: only the token that
+// started a job may, and a nil token may see every job.
+func (t *accessToken) ownsJob(j *job) bool {
+	return t == nil || j.Owner == t.Name
+}
+
+// matchTable reports whether table, as given in a request, matches one of
+// the patterns of t part by part.
+func (t *accessToken) matchTable(table string) bool {
+	ident, err := parseTableIdent(table)
+	if err != nil {
+		return false
+	}
+	ident = ident.fold(t.identCase)
+	for _, p := range t.tables {
+		if matchIdent(p, ident) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchIdent(pattern, ident tableIdent) bool {
+	if len(pattern.parts) != len(ident.parts) {
+		return false
+	}
+	for i, p := range pattern.parts {
+		if ok, _ := path.Match(p.name, ident.parts[i].name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type accessTokenKey struct{}
+
+// withAccessToken returns a context carrying the token of a request, to
+// which its audited operations are attributed.
+func withAccessToken(ctx context.Context, t *accessToken) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return withActor(context.WithValue(ctx, accessTokenKey{}, t), "token:"+t.Name)
+}
+
+// tokenFrom returns the token carried by ctx, or nil.
+func tokenFrom(ctx context.Context) *accessToken {
+	t, _ := ctx.Value(accessTokenKey{}).(*accessToken)
+	return t
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// allocator allocates the Arrow buffers dbX builds itself. -debug-allocator
+// replaces it with a checked allocator so unreleased buffers can be found.
+var allocator memory.Allocator = memory.DefaultAllocator
+
+// checkedAllocator is allocator when -debug-allocator is set.
+var checkedAllocator *memory.CheckedAllocator
+
+func enableDebugAllocator() {
+	checkedAllocator = memory.NewCheckedAllocator(memory.NewGoAllocator())
+	allocator = checkedAllocator
+}
+
+// reportLeaks prints every buffer still held by the checked allocator, with
+// the stack that allocated it, and returns the number of bytes leaked. It
+// does nothing unless -debug-allocator is set.
+func reportLeaks() int {
+	if checkedAllocator == nil {
+		return 0
+	}
+	n := checkedAllocator.CurrentAlloc()
+	if n != 0 {
+		checkedAllocator.AssertSize(leakReporter{}, 0)
+	}
+	return n
+}
+
+// leakReporter adapts stderr to the memory.TestingT interface the checked
+// allocator reports through.
+type leakReporter struct{}
+
+func (leakReporter) Helper() {}
+
+func (leakReporter) Errorf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
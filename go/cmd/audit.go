@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditRecord is an entry of the audit log: who ran what, when, and what it
+// read and wrote.
+type auditRecord struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Host  string    `json:"host"`
+	// Operation is export, import or exec.
+	Operation string `json:"operation"`
+	// Database is the connection URI, with its password redacted.
+	Database string `json:"database"`
+	// QuerySHA256 identifies the SQL that ran without recording it, as it
+	// may hold sensitive literals.
+	QuerySHA256  string        `json:"query_sha256,omitempty"`
+	Source       string        `json:"source,omitempty"`
+	Destinations []string      `json:"destinations,omitempty"`
+	Rows         int64         `json:"rows"`
+	Duration     time.Duration `json:"duration"`
+	Status       string        `json:"status"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// auditLog persists an append-only record of every export, import and
+// script run, to a local JSON lines file, a table, or both. Its methods do
+// nothing on a nil *auditLog.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	// table, if set, is created in the database of opts if it does not
+	// exist.
+	table   *tableIdent
+	opts    connOptions
+	created bool
+	host    string
+}
+
+// audit is the audit log of the process, set with -audit-log and
+// -audit-table.
+var audit *auditLog
+
+// openAuditLog opens the audit log at path, if set, for appending, and
+// checks that table, if set, is a valid table name of opts.
+func openAuditLog(path, table string, opts connOptions) (*auditLog, error) {
+	if path == "" && table == "" {
+		return nil, nil
+	}
+	a := &auditLog{opts: opts}
+	a.opts.Job = "audit"
+	a.host, _ = os.Hostname()
+	if table != "" {
+		t, err := opts.table(table)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -audit-table: %w", err)
+		}
+		a.table = &t
+	}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		a.file = f
+	}
+	return a, nil
+}
+
+// Close closes the audit log file.
+func (a *auditLog) Close() error {
+	if a == nil || a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// record appends r to the audit log, filling in when, where and by whom it
+// ran. A failure to record is returned so that unaudited operations do not
+// go unnoticed.
+func (a *auditLog) record(ctx context.Context, r auditRecord) error {
+	if a == nil {
+		return nil
+	}
+	r.Time = time.Now().UTC()
+	r.Actor, r.Host = actor(ctx), a.host
+	r.Database = redactURI(r.Database)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		// A single write of a whole line keeps entries of concurrent
+		// processes from interleaving.
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			return classify(errWrite, fmt.Errorf("failed to write audit log: %w", err))
+		}
+	}
+	if a.table != nil {
+		if err := a.insert(ctx, r); err != nil {
+			return classify(errWrite, fmt.Errorf("failed to write audit table: %w", err))
+		}
+	}
+	return nil
+}
+
+// insert adds r to the audit table, creating the table first if needed.
+func (a *auditLog) insert(ctx context.Context, r auditRecord) error {
+	ctx = context.WithoutCancel(ctx)
+	cnxn, err := openConnection(ctx, a.opts)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	table := a.table.quote(a.opts.dialect())
+	if !a.created {
+		ddl := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+	at            TEXT NOT NULL,
+	actor         TEXT NOT NULL,
+	host          TEXT NOT NULL,
+	operation     TEXT NOT NULL,
+	database_uri  TEXT NOT NULL,
+	query_sha256  TEXT NOT NULL,
+	source        TEXT NOT NULL,
+	destinations  TEXT NOT NULL,
+	row_count     BIGINT NOT NULL,
+	duration_ms   BIGINT NOT NULL,
+	status        TEXT NOT NULL,
+	error         TEXT NOT NULL
+)`
+		if err := execUpdate(ctx, cnxn, ddl); err != nil {
+			return err
+		}
+		a.created = true
+	}
+	values := []string{
+		quoteLiteral(r.Time.Format(time.RFC3339Nano)),
+		quoteLiteral(r.Actor),
+		quoteLiteral(r.Host),
+		quoteLiteral(r.Operation),
+		quoteLiteral(r.Database),
+		quoteLiteral(r.QuerySHA256),
+		quoteLiteral(r.Source),
+		quoteLiteral(strings.Join(r.Destinations, ",")),
+		fmt.Sprint(r.Rows),
+		fmt.Sprint(r.Duration.Milliseconds()),
+		quoteLiteral(r.Status),
+		quoteLiteral(r.Error),
+	}
+	return execUpdate(ctx, cnxn, "INSERT INTO "+table+" VALUES ("+strings.Join(values, ", ")+")")
+}
+
+// newAuditRecord describes operation on database, started at start, that
+// ended with resp or err.
+func newAuditRecord(operation, database, query string, start time.Time, resp *response, err error) auditRecord {
+	r := auditRecord{Operation: operation, Database: database, Duration: time.Since(start), Status: jobSucceeded}
+	if query != "" {
+		sum := sha256.Sum256([]byte(query))
+		r.QuerySHA256 = hex.EncodeToString(sum[:])
+	}
+	if err != nil {
+		r.Status, r.Error = jobFailed, err.Error()
+		if kindOf(err) == errCancelled {
+			r.Status = jobCancelled
+		}
+	} else if resp != nil {
+		r.Rows = resp.RowsWritten
+	}
+	return r
+}
+
+type actorKey struct{}
+
+// withActor returns a context whose audited operations are attributed to
+// name rather than to the user running dbX.
+func withActor(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, actorKey{}, name)
+}
+
+// actor returns whom the operations of ctx are attributed to.
+func actor(ctx context.Context) string {
+	if name, ok := ctx.Value(actorKey{}).(string); ok {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// auditExport records the export of spec from the database of opts.
+func auditExport(ctx context.Context, opts connOptions, spec exportSpec, start time.Time, resp *response, err error) error {
+	r := newAuditRecord("export", opts.URI, spec.Query, start, resp, err)
+	for _, s := range spec.outputs() {
+		r.Destinations = append(r.Destinations, redactURI(s.Output))
+	}
+	return audit.record(ctx, r)
+}
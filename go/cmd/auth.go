@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication methods of -auth, used instead of a password in the URI:
+//
+//	gssapi        Kerberos, with the ticket obtained by kinit; Postgres
+//	              through the libpq-based ADBC driver only
+//	rds-iam       an AWS RDS or Aurora IAM token, signed with the AWS
+//	              credentials of the environment or the aws CLI
+//	cloudsql-iam  a Google Cloud SQL IAM login with an OAuth access token
+//	              from gcloud or the metadata server
+//
+// IAM tokens are generated when a connection opens and reused until shortly
+// before they expire, so long-running jobs that reconnect keep working.
+// Tokens only authenticate new connections; open ones stay usable after
+// their token expires.
+var authMethods = []string{"gssapi", "rds-iam", "cloudsql-iam"}
+
+// tokenRefreshMargin is how long before it expires a token is replaced.
+const tokenRefreshMargin = time.Minute
+
+// validAuth checks an -auth value.
+func validAuth(method string) error {
+	if method == "" {
+		return nil
+	}
+	for _, m := range authMethods {
+		if method == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown auth method %q, expected %s", method, strings.Join(authMethods, ", "))
+}
+
+// authURI returns the URI to connect with under the auth method of opts,
+// with a freshly generated token as the password for the IAM methods.
+func authURI(ctx context.Context, opts connOptions) (string, error) {
+	if opts.Auth == "" {
+		return opts.URI, nil
+	}
+	u, err := url.Parse(opts.URI)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("%s auth requires a URI of the form scheme://user@host:port/db", opts.Auth)
+	}
+	if _, ok := u.User.Password(); ok {
+		return "", fmt.Errorf("%s auth does not use a password, remove it from the URI", opts.Auth)
+	}
+	user := u.User.Username()
+
+	var token string
+	switch opts.Auth {
+	case "gssapi":
+		if opts.SQLDriver != "" {
+			return "", fmt.Errorf("gssapi auth requires the libpq-based ADBC driver, not -sql-driver %s", opts.SQLDriver)
+		}
+		// klist -s fails silently without a valid ticket; without klist
+		// installed, leave it to the driver to report.
+		if path, err := exec.LookPath("klist"); err == nil {
+			if err := exec.CommandContext(ctx, path, "-s").Run(); err != nil {
+				return "", fmt.Errorf("no valid Kerberos ticket, run kinit first")
+			}
+		}
+		return opts.URI, nil
+	case "rds-iam":
+		if user == "" {
+			return "", fmt.Errorf("rds-iam auth requires a user in the URI")
+		}
+		token, err = tokens.get(ctx, "rds-iam "+u.Host+" "+user, func(ctx context.Context) (string, time.Time, error) {
+			return rdsAuthToken(ctx, u.Host, user, time.Now())
+		})
+		if isPostgresScheme(u.Scheme) && u.Query().Get("sslmode") == "" {
+			// RDS only accepts IAM tokens over TLS.
+			q := u.Query()
+			q.Set("sslmode", "require")
+			u.RawQuery = q.Encode()
+		}
+	case "cloudsql-iam":
+		if user == "" {
+			return "", fmt.Errorf("cloudsql-iam auth requires the IAM user or service account in the URI")
+		}
+		token, err = tokens.get(ctx, "cloudsql-iam", googleAccessToken)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate %s token: %w", opts.Auth, err)
+	}
+	u.User = url.UserPassword(user, token)
+	return u.String(), nil
+}
+
+func isPostgresScheme(scheme string) bool {
+	return scheme == "postgres" || scheme == "postgresql"
+}
+
+// tokenCache holds generated tokens until shortly before they expire.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	value   string
+	expires time.Time
+}
+
+var tokens = &tokenCache{tokens: make(map[string]cachedToken)}
+
+// get returns the token cached under key, generating a new one with
+// generate if there is none or it is about to expire.
+func (c *tokenCache) get(ctx context.Context, key string, generate func(context.Context) (string, time.Time, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[key]; ok && time.Until(t.expires) > tokenRefreshMargin {
+		return t.value, nil
+	}
+	value, expires, err := generate(ctx)
+	if err != nil {
+		return "", err
+	}
+	logger(ctx).Debug("Generated auth token", "for", key, "expires", expires)
+	c.tokens[key] = cachedToken{value: value, expires: expires}
+	return value, nil
+}
+
+// rdsTokenLifetime is how long RDS accepts an IAM token for.
+const rdsTokenLifetime = 15 * time.Minute
+
+// awsCredentials are the credentials RDS IAM tokens and AWS API requests are
+// signed with.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+}
+
+// loadAWSCredentials reads credentials from the standard environment
+// variables, or else asks the aws CLI, which knows profiles, SSO and
+// instance roles.
+func loadAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "aws", "configure", "export-credentials", "--format", "process")
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return creds, fmt.Errorf("no AWS credentials in the environment, and the aws CLI failed: %w", err)
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return creds, fmt.Errorf("failed to parse aws CLI credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// rdsRegion returns the AWS region of an RDS endpoint such as
+// db.abc123.eu-west-1.rds.amazonaws.com, or of the environment.
+func rdsRegion(host string) (string, error) {
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r, nil
+	}
+	if r := os.Getenv("AWS_DEFAULT_REGION"); r != "" {
+		return r, nil
+	}
+	labels := strings.Split(host, ".")
+	for i := 1; i < len(labels); i++ {
+		if labels[i] == "rds" {
+			return labels[i-1], nil
+		}
+	}
+	return "", fmt.Errorf("cannot tell the AWS region of %s, set AWS_REGION", host)
+}
+
+// rdsAuthToken returns an IAM token for user at the RDS endpoint host
+// (host:port), as `aws rds generate-db-auth-token` does: a URL for the
+// rds-db:connect action, presigned with Signature Version 4 at now.
+func rdsAuthToken(ctx context.Context, host, user string, now time.Time) (string, time.Time, error) {
+	if !strings.Contains(host, ":") {
+		return "", time.Time{}, fmt.Errorf("rds-iam auth requires the port in the URI")
+	}
+	region, err := rdsRegion(strings.Split(host, ":")[0])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	creds, err := loadAWSCredentials(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now = now.UTC()
+	date, stamp := now.Format("20060102"), now.Format("20060102T150405Z")
+	scope := date + "/" + region + "/rds-db/aws4_request"
+	query := map[string]string{
+		"Action":              "connect",
+		"DBUser":              user,
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    creds.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          stamp,
+		"X-Amz-Expires":       fmt.Sprint(int(rdsTokenLifetime.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	if creds.SessionToken != "" {
+		query["X-Amz-Security-Token"] = creds.SessionToken
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = awsEscape(name) + "=" + awsEscape(query[name])
+	}
+	canonicalQuery := strings.Join(pairs, "&")
+
+	emptyHash := sha256.Sum256(nil)
+	request := strings.Join([]string{"GET", "/", canonicalQuery, "host:" + host, "", "host", hex.EncodeToString(emptyHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(request))
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", stamp, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, "rds-db", "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	token := host + "/?" + canonicalQuery + "&X-Amz-Signature=" + hex.EncodeToString(key)
+	return token, now.Add(rdsTokenLifetime), nil
+}
+
+// signAWSRequest signs req, a request to an AWS service's JSON API at its
+// root path, with Signature Version 4 at now. Every header set on req is
+// signed.
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	date, stamp := now.Format("20060102"), now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	bodyHash := sha256.Sum256(body)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	request := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(request))
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", stamp, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(key))
+}
+
+// awsEscape percent-encodes s as Signature Version 4 requires: everything
+// but unreserved characters, with spaces as %20.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// gcloudTokenLifetime is how long gcloud access tokens are assumed to last;
+// gcloud does not say, but issues them for an hour.
+const gcloudTokenLifetime = 55 * time.Minute
+
+// googleMetadataToken is the metadata server's endpoint for access tokens
+// of the instance's service account.
+const googleMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// googleAccessToken returns an OAuth access token for Cloud SQL IAM login,
+// from gcloud where it is installed, as on workstations, or else from the
+// metadata server, as on GCE, GKE and Cloud Run.
+func googleAccessToken(ctx context.Context) (string, time.Time, error) {
+	if path, err := exec.LookPath("gcloud"); err == nil {
+		var stderr bytes.Buffer
+		c := exec.CommandContext(ctx, path, "auth", "print-access-token")
+		c.Stderr = &stderr
+		out, err := c.Output()
+		if err != nil {
+			if stderr.Len() > 0 {
+				err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+			}
+			return "", time.Time{}, fmt.Errorf("gcloud auth print-access-token failed: %w", err)
+		}
+		return strings.TrimSpace(string(out)), time.Now().Add(gcloudTokenLifetime), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleMetadataToken, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("gcloud is not installed and the metadata server is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("metadata server returned %s", resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse metadata server token: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, errors.New("metadata server returned no token")
+	}
+	return body.AccessToken, time.Now().Add(time.Duration(body.ExpiresIn) * time.Second), nil
+}
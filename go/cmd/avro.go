@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/hamba/avro/v2/ocf"
+	"github.com/hamba/avro/v2/registry"
+)
+
+// Keys of the Avro file metadata the schema registry ID and subject of an
+// output's schema are stamped into.
+const (
+	avroSchemaIDKey      = "schema.registry.id"
+	avroSchemaSubjectKey = "schema.registry.subject"
+)
+
+// avroCodecs are the accepted -compression values of Avro output.
+var avroCodecs = map[string]ocf.CodecName{
+	"":             ocf.Null,
+	"none":         ocf.Null,
+	"uncompressed": ocf.Null,
+	"deflate":      ocf.Deflate,
+	"snappy":       ocf.Snappy,
+	"zstd":         ocf.ZStandard,
+}
+
+// schemaRegistry is a Confluent-compatible schema registry the schemas of
+// Avro outputs are registered with, and Kafka messages' schemas resolved
+// against.
+type schemaRegistry struct {
+	// URL may hold user:password for basic auth.
+	URL string `yaml:"url"`
+	// Subject is the subject schemas are registered under; it defaults to
+	// the output's file name without extension, suffixed with -value.
+	Subject string `yaml:"subject"`
+}
+
+// client returns a registry client for r.
+func (r *schemaRegistry) client() (*registry.Client, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema registry URL: %w", err)
+	}
+	// Go through the default transport, which -proxy configures.
+	opts := []registry.ClientFunc{registry.WithHTTPClient(&http.Client{Timeout: notifyTimeout})}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		opts = append(opts, registry.WithBasicAuth(u.User.Username(), pass))
+		u.User = nil
+	}
+	return registry.NewClient(u.String(), opts...)
+}
+
+// register registers schema under subject, or returns the ID it was
+// registered with before.
+func (r *schemaRegistry) register(ctx context.Context, subject, schema string) (int, error) {
+	c, err := r.client()
+	if err != nil {
+		return 0, err
+	}
+	id, _, err := c.CreateSchema(ctx, subject, schema)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register schema under %s: %w", subject, err)
+	}
+	return id, nil
+}
+
+// avroName matches the names Avro allows for records and fields.
+var avroName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// avroSafeName replaces the characters of name Avro does not allow.
+func avroSafeName(name string) string {
+	if avroName.MatchString(name) {
+		return name
+	}
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// avroSchema returns the Avro record schema, named name, of records of
+// schema. Types Avro has no equivalent for, such as lists, are written as
+// strings.
+func avroSchema(schema *arrow.Schema, name string) (string, error) {
+	fields := make([]map[string]any, 0, schema.NumFields())
+	for _, f := range schema.Fields() {
+		var typ any = avroType(f.Type)
+		if f.Nullable {
+			typ = []any{"null", typ}
+		}
+		field := map[string]any{"name": avroSafeName(f.Name), "type": typ}
+		if f.Nullable {
+			field["default"] = nil
+		}
+		fields = append(fields, field)
+	}
+	data, err := json.Marshal(map[string]any{"type": "record", "name": avroSafeName(name), "fields": fields})
+	return string(data), err
+}
+
+func avroType(t arrow.DataType) any {
+	switch t := t.(type) {
+	case *arrow.BooleanType:
+		return "boolean"
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Uint8Type, *arrow.Uint16Type:
+		return "int"
+	case *arrow.Int64Type, *arrow.Uint32Type, *arrow.Uint64Type:
+		return "long"
+	case *arrow.Float16Type, *arrow.Float32Type:
+		return "float"
+	case *arrow.Float64Type:
+		return "double"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType:
+		return "bytes"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return map[string]any{"type": "int", "logicalType": "date"}
+	case *arrow.TimestampType:
+		return map[string]any{"type": "long", "logicalType": "timestamp-micros"}
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return map[string]any{"type": "long", "logicalType": "time-micros"}
+	case *arrow.Decimal128Type:
+		return map[string]any{"type": "bytes", "logicalType": "decimal", "precision": t.Precision, "scale": t.Scale}
+	default:
+		return "string"
+	}
+}
+
+// avroValue returns row i of arr as the Go value the Avro encoder writes
+// for the type avroType gives it.
+func avroValue(arr arrow.Array, i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
+	switch a := arr.(type) {
+	case *array.Boolean:
+		return a.Value(i)
+	case *array.Int8:
+		return int32(a.Value(i))
+	case *array.Int16:
+		return int32(a.Value(i))
+	case *array.Int32:
+		return a.Value(i)
+	case *array.Uint8:
+		return int32(a.Value(i))
+	case *array.Uint16:
+		return int32(a.Value(i))
+	case *array.Int64:
+		return a.Value(i)
+	case *array.Uint32:
+		return int64(a.Value(i))
+	case *array.Uint64:
+		return int64(a.Value(i))
+	case *array.Float16:
+		return a.Value(i).Float32()
+	case *array.Float32:
+		return a.Value(i)
+	case *array.Float64:
+		return a.Value(i)
+	case *array.Binary:
+		return a.Value(i)
+	case *array.LargeBinary:
+		return a.Value(i)
+	case *array.FixedSizeBinary:
+		return a.Value(i)
+	case *array.Date32:
+		return a.Value(i).ToTime()
+	case *array.Date64:
+		return a.Value(i).ToTime()
+	case *array.Timestamp:
+		return a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit)
+	case *array.Time32:
+		return time.Duration(a.Value(i)) * a.DataType().(*arrow.Time32Type).Unit.Multiplier()
+	case *array.Time64:
+		return time.Duration(a.Value(i)) * a.DataType().(*arrow.Time64Type).Unit.Multiplier()
+	case *array.Decimal128:
+		scale := a.DataType().(*arrow.Decimal128Type).Scale
+		return new(big.Rat).SetFrac(a.Value(i).BigInt(), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	case *array.String:
+		return a.Value(i)
+	case *array.LargeString:
+		return a.Value(i)
+	default:
+		return arr.ValueStr(i)
+	}
+}
+
+// avroWriter writes records to an Avro object container file.
+type avroWriter struct {
+	enc   *ocf.Encoder
+	names []string
+}
+
+// newAvroWriter writes records of schema to w, naming the Avro record
+// after name and stamping the registry ID of the schema, if registered,
+// into the file metadata.
+func newAvroWriter(w io.Writer, schema *arrow.Schema, name string, opts sinkOptions) (*avroWriter, error) {
+	s, err := avroSchema(schema, name)
+	if err != nil {
+		return nil, err
+	}
+	encOpts := []ocf.EncoderFunc{ocf.WithCodec(avroCodecs[strings.ToLower(opts.Compression)])}
+	if opts.schemaID != 0 {
+		encOpts = append(encOpts, ocf.WithMetadata(map[string][]byte{
+			avroSchemaIDKey:      []byte(strconv.Itoa(opts.schemaID)),
+			avroSchemaSubjectKey: []byte(opts.schemaSubject),
+		}))
+	}
+	enc, err := ocf.NewEncoder(s, w, encOpts...)
+	if err != nil {
+		return nil, err
+	}
+	aw := &avroWriter{enc: enc}
+	for _, f := range schema.Fields() {
+		aw.names = append(aw.names, avroSafeName(f.Name))
+	}
+	return aw, nil
+}
+
+func (w *avroWriter) Write(rec arrow.Record) error {
+	for i := 0; i < int(rec.NumRows()); i++ {
+		row := make(map[string]any, len(w.names))
+		for j, name := range w.names {
+			row[name] = avroValue(rec.Column(j), i)
+		}
+		if err := w.enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the last block; the file is closed by the sink.
+func (w *avroWriter) Close() error {
+	return w.enc.Close()
+}
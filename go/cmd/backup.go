@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// backupManifestName is the manifest `dbx backup` writes next to the table
+// files.
+const backupManifestName = "backup.json"
+
+// backupManifest describes a backup: the tables, in the order to restore
+// them, and the database objects to recreate after loading them.
+type backupManifest struct {
+	Engine    string        `json:"engine"`
+	CreatedAt time.Time     `json:"created_at"`
+	Tables    []backupTable `json:"tables"`
+	Indexes   []index       `json:"indexes,omitempty"`
+	Sequences []sequence    `json:"sequences,omitempty"`
+	Views     []view        `json:"views,omitempty"`
+}
+
+type backupTable struct {
+	subsetEntry
+	tableDef
+	// DDL creates the table, as defined by tableDef, on the backup's
+	// engine. It is empty if a column type has no equivalent there.
+	DDL string `json:"ddl,omitempty"`
+}
+
+// restoreOptions control how `dbx restore` recreates a database.
+type restoreOptions struct {
+	// Clean drops the backed up tables first.
+	Clean bool
+	// Constraints recreates primary keys and unique constraints.
+	Constraints bool
+	// Indexes is "create" to create indexes with their table, "after-load"
+	// to create them once every table is loaded, or "none".
+	Indexes string
+}
+
+// backupDatabase exports tables, or every table if names is empty, to one
+// Parquet file each in dir and writes the backup manifest. Names may also
+// refer to views, whose rows are then backed up like a table's. With views,
+// the definitions of every other view are recorded too.
+func backupDatabase(ctx context.Context, opts connOptions, names []string, views bool, dir string, sinkOpts sinkOptions, force bool) (*backupManifest, error) {
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cnxn.Close()
+
+	fks, err := loadForeignKeys(ctx, cnxn)
+	if err != nil {
+		logger(ctx).Warn("Tables are not ordered by foreign keys", "reason", err)
+	}
+	var tables []tableIdent
+	if len(names) == 0 {
+		if tables, err = listTables(ctx, cnxn); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		t, err := opts.table(name)
+		if err != nil {
+			return nil, err
+		}
+		if t, err = resolveTable(t, fks); err != nil {
+			return nil, classify(errUsage, err)
+		}
+		tables = append(tables, t)
+	}
+	tables, _ = dependencyOrder(tables, fks)
+	defs := make(map[string]tableDef)
+	if err := loadTableKeys(ctx, cnxn, defs); err != nil {
+		logger(ctx).Warn("Primary keys and unique constraints not recorded", "reason", err)
+	}
+	if err := loadColumnDefs(ctx, cnxn, defs); err != nil {
+		logger(ctx).Warn("Column defaults not recorded", "reason", err)
+	}
+
+	d := opts.dialect()
+	m := &backupManifest{Engine: d.name, CreatedAt: time.Now().UTC()}
+	included := make(map[string]bool)
+	for _, t := range tables {
+		schema, err := getTableSchema(ctx, cnxn, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get schema of %s: %w", t, err)
+		}
+		def := defs[t.String()]
+		ddl, err := d.createTableSQL(t, schema, nil, "", def)
+		if err != nil {
+			logger(ctx).Warn("Table DDL not recorded", "table", t.String(), "reason", err)
+		}
+		m.Tables = append(m.Tables, backupTable{
+			subsetEntry: subsetEntry{Table: t.String(), File: t.String() + sinkOpts.extension()},
+			tableDef:    def,
+			DDL:         ddl,
+		})
+		included[t.String()] = true
+	}
+
+	indexes, err := loadIndexes(ctx, cnxn)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range indexes {
+		if included[idx.Table] {
+			m.Indexes = append(m.Indexes, idx)
+		}
+	}
+	if m.Sequences, err = loadSequences(ctx, cnxn); err != nil {
+		return nil, err
+	}
+	if views {
+		all, err := loadViews(ctx, cnxn)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range all {
+			if !included[v.Name] {
+				m.Views = append(m.Views, v)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	for i, bt := range m.Tables {
+		t := tables[i]
+		resp, err := exportQuery(withLogAttrs(ctx, "table", bt.Table), opts, exportSpec{
+			Query:       "SELECT * FROM " + t.quote(d),
+			Output:      filepath.Join(dir, bt.File),
+			Table:       bt.Table,
+			Force:       force,
+			sinkOptions: sinkOpts,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", bt.Table, err)
+		}
+		m.Tables[i].Rows = resp.RowsWritten
+		logger(ctx).Info("Backed up table", "table", bt.Table, "rows", resp.RowsWritten, "bytes", resp.OutputFileSize)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, backupManifestName), append(data, '\n'), 0o644); err != nil {
+		return nil, classify(errWrite, fmt.Errorf("failed to write backup manifest: %w", err))
+	}
+	return m, nil
+}
+
+// readBackupManifest reads the manifest of the backup in dir.
+func readBackupManifest(dir string) (*backupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		return nil, classify(errUsage, fmt.Errorf("failed to read backup manifest: %w", err))
+	}
+	var m backupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", backupManifestName, err)
+	}
+	return &m, nil
+}
+
+// restoreDatabase recreates the tables of the backup in dir, loads them and
+// recreates its indexes, sequences and views. The recorded DDL, expressions,
+// indexes, sequences and views are only used on the engine the backup was
+// taken from; on others, tables are created from the files' schemas and the
+// recorded keys, and the rest is skipped.
+func restoreDatabase(ctx context.Context, opts connOptions, dir string, ro restoreOptions) (*backupManifest, error) {
+	m, err := readBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	d := opts.dialect()
+	sameEngine := m.Engine == d.name
+
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cnxn.Close()
+
+	if ro.Clean {
+		// Views go first, as they depend on the tables.
+		for i := len(m.Views) - 1; i >= 0 && sameEngine; i-- {
+			v := m.Views[i]
+			quoted, err := opts.quoteTable(v.Name)
+			if err != nil {
+				return nil, err
+			}
+			kind := "VIEW"
+			if v.Materialized {
+				kind = "MATERIALIZED VIEW"
+			}
+			if err := execUpdate(ctx, cnxn, "DROP "+kind+" IF EXISTS "+quoted); err != nil {
+				return nil, err
+			}
+		}
+		for i := len(m.Tables) - 1; i >= 0; i-- {
+			quoted, err := opts.quoteTable(m.Tables[i].Table)
+			if err != nil {
+				return nil, err
+			}
+			if err := execUpdate(ctx, cnxn, "DROP TABLE IF EXISTS "+quoted); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !sameEngine && len(m.Indexes) > 0 && ro.Indexes != "none" {
+		logger(ctx).Warn("Skipping indexes of a backup from another engine", "engine", m.Engine, "indexes", len(m.Indexes))
+		ro.Indexes = "none"
+	}
+	if sameEngine {
+		// Sequences come first, as column defaults may draw from them.
+		for _, seq := range m.Sequences {
+			quoted, err := opts.quoteTable(seq.Name)
+			if err != nil {
+				return nil, err
+			}
+			if err := execUpdate(ctx, cnxn, "CREATE SEQUENCE IF NOT EXISTS "+quoted); err != nil {
+				return nil, err
+			}
+		}
+	} else if len(m.Sequences) > 0 {
+		logger(ctx).Warn("Skipping sequences of a backup from another engine", "engine", m.Engine, "sequences", len(m.Sequences))
+	}
+	createIndexes := func(table string) error {
+		for _, idx := range m.Indexes {
+			if table != "" && idx.Table != table {
+				continue
+			}
+			if err := execUpdate(ctx, cnxn, idx.SQL); err != nil {
+				return fmt.Errorf("failed to create index %s: %w", idx.Name, err)
+			}
+		}
+		return nil
+	}
+
+	loads := make([]subsetEntry, len(m.Tables))
+	for i, bt := range m.Tables {
+		ddl := bt.DDL
+		if !sameEngine || ddl == "" || !ro.Constraints {
+			def := bt.tableDef
+			if !ro.Constraints {
+				def.tableKeys = tableKeys{}
+			}
+			if !sameEngine {
+				if len(def.Defaults) > 0 || len(def.Generated) > 0 {
+					logger(ctx).Warn("Skipping column defaults and generated columns of a backup from another engine", "table", bt.Table)
+				}
+				def.Defaults, def.Generated = nil, nil
+			}
+			t, err := opts.table(bt.Table)
+			if err != nil {
+				return nil, err
+			}
+			schema, err := parquetSchema(filepath.Join(dir, bt.File))
+			if err != nil {
+				return nil, err
+			}
+			if ddl, err = d.createTableSQL(t, schema, nil, opts.IdentifierCase, def); err != nil {
+				return nil, classify(errSchemaMismatch, fmt.Errorf("table %s: %w", bt.Table, err))
+			}
+		}
+		if err := execUpdate(ctx, cnxn, ddl); err != nil {
+			return nil, fmt.Errorf("failed to create table %s: %w", bt.Table, err)
+		}
+		if ro.Indexes == "create" {
+			if err := createIndexes(bt.Table); err != nil {
+				return nil, err
+			}
+		}
+		loads[i] = bt.subsetEntry
+	}
+
+	if _, err := importTables(ctx, opts, dir, loads, importOptions{}); err != nil {
+		return nil, err
+	}
+	if ro.Indexes == "after-load" {
+		if err := createIndexes(""); err != nil {
+			return nil, err
+		}
+	}
+
+	if !sameEngine {
+		if len(m.Views) > 0 {
+			logger(ctx).Warn("Skipping views of a backup from another engine", "engine", m.Engine, "views", len(m.Views))
+		}
+		return m, nil
+	}
+	for _, seq := range m.Sequences {
+		quoted, err := opts.quoteTable(seq.Name)
+		if err != nil {
+			return nil, err
+		}
+		if seq.Value > 0 {
+			if err := execUpdate(ctx, cnxn, fmt.Sprintf("ALTER SEQUENCE %s RESTART WITH %d", quoted, seq.Value+1)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := createViews(ctx, cnxn, m.Views); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// createViews creates views. A view may select from views recorded after
+// it, so views that fail are retried as long as others succeed.
+func createViews(ctx context.Context, cnxn *connection, views []view) error {
+	pending := views
+	for len(pending) > 0 {
+		var failed []view
+		var firstErr error
+		for _, v := range pending {
+			if err := execUpdate(ctx, cnxn, v.SQL); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				failed = append(failed, v)
+			}
+		}
+		if len(failed) == len(pending) {
+			return fmt.Errorf("failed to create view %s: %w", failed[0].Name, firstErr)
+		}
+		pending = failed
+	}
+	return nil
+}
+
+// runBackup implements `dbx backup -all -dir backup/`.
+func runBackup(cfg config, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	all := fs.Bool("all", false, "Back up every table of the database")
+	dir := fs.String("dir", "", "Directory to write the table files and "+backupManifestName+" to")
+	compression := fs.String("compression", "zstd", "Parquet compression codec")
+	force := fs.Bool("force", false, "Overwrite existing table files")
+	views := fs.Bool("views", false, "Record the definitions of views and materialized views, to recreate them on restore")
+	var tables stringList
+	fs.Var(&tables, "table", "Table or view to back up (repeatable)")
+	parseFlags(fs, args)
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	if *all == (len(tables) > 0) {
+		return fmt.Errorf("exactly one of -all or -table is required")
+	}
+	sinkOpts := sinkOptions{Format: "parquet", Compression: *compression}
+	if err := sinkOpts.validate(); err != nil {
+		return classify(errUsage, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m, err := backupDatabase(ctx, cfg.conn, tables, *views, *dir, sinkOpts, *force)
+	if err != nil {
+		return err
+	}
+	slog.Info("Backup complete", "tables", len(m.Tables), "indexes", len(m.Indexes), "sequences", len(m.Sequences), "views", len(m.Views), "dir", *dir)
+	if cfg.json {
+		printJSON(m)
+	}
+	return nil
+}
+
+// runRestore implements `dbx restore backup/`.
+func runRestore(cfg config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	var ro restoreOptions
+	fs.BoolVar(&ro.Clean, "clean", false, "Drop the backed up tables before recreating them")
+	fs.BoolVar(&ro.Constraints, "constraints", true, "Recreate primary keys and unique constraints")
+	fs.StringVar(&ro.Indexes, "indexes", "create", "When to recreate indexes: create (with their table), after-load (faster for large tables) or none")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: dbx restore [flags] <dir>")
+	}
+	switch ro.Indexes {
+	case "create", "after-load", "none":
+	default:
+		return fmt.Errorf("invalid -indexes %q, expected create, after-load or none", ro.Indexes)
+	}
+	dir := fs.Arg(0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m, err := restoreDatabase(ctx, cfg.conn, dir, ro)
+	if err != nil {
+		return err
+	}
+	slog.Info("Restore complete", "tables", len(m.Tables), "dir", dir)
+	if cfg.json {
+		printJSON(m)
+	}
+	return nil
+}
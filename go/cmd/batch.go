@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// Statement options setting how many rows a driver returns per record
+// batch. ADBC has no standard key for this, so it is only set for drivers
+// known to support one.
+const (
+	optionSQLiteBatchRows = "adbc.sqlite.query.batch_rows"
+	// optionSQLBatchRows is understood by the database/sql fallback.
+	optionSQLBatchRows = "dbx.sql.query.batch_rows"
+)
+
+// readBatchOption returns the statement option controlling the read batch
+// size for the database opts points at, or "" if there is none.
+func readBatchOption(opts connOptions) string {
+	if opts.SQLDriver != "" {
+		return optionSQLBatchRows
+	}
+	if opts.dialect().name == "sqlite" {
+		return optionSQLiteBatchRows
+	}
+	return ""
+}
+
+// sliceReader re-chunks a record stream into records of at most maxRows
+// rows. Slices share the buffers of the records they are cut from, so
+// nothing is copied, but the whole record stays in memory until its last
+// slice is released: slicing bounds the rows each write or bind handles,
+// not the memory read. That is bounded by asking the driver for smaller
+// batches, see newQueryStatement.
+type sliceReader struct {
+	refCount int64
+	src      array.RecordReader
+	maxRows  int64
+	cur      arrow.Record // record being sliced, owned by src
+	offset   int64
+	rec      arrow.Record
+}
+
+// newSliceReader returns src re-chunked to at most maxRows rows per record.
+// The returned reader takes a reference to src.
+func newSliceReader(src array.RecordReader, maxRows int64) *sliceReader {
+	src.Retain()
+	return &sliceReader{refCount: 1, src: src, maxRows: maxRows}
+}
+
+func (r *sliceReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *sliceReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.rec != nil {
+			r.rec.Release()
+			r.rec = nil
+		}
+		r.src.Release()
+	}
+}
+
+func (r *sliceReader) Schema() *arrow.Schema { return r.src.Schema() }
+func (r *sliceReader) Record() arrow.Record  { return r.rec }
+func (r *sliceReader) Err() error            { return r.src.Err() }
+
+func (r *sliceReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	for r.cur == nil || r.offset >= r.cur.NumRows() {
+		if !r.src.Next() {
+			r.cur = nil
+			return false
+		}
+		r.cur, r.offset = r.src.Record(), 0
+	}
+
+	end := min(r.offset+r.maxRows, r.cur.NumRows())
+	r.rec = r.cur.NewSlice(r.offset, end)
+	r.offset = end
+	return true
+}
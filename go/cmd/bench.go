@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// benchResult summarizes the runs of one workload.
+type benchResult struct {
+	Workload      string        `json:"workload"`
+	Runs          int           `json:"runs"`
+	Rows          int64         `json:"rows"`
+	Bytes         int64         `json:"bytes"`
+	RowsPerSecond float64       `json:"rows_per_second"`
+	MBPerSecond   float64       `json:"mb_per_second"`
+	P50           time.Duration `json:"p50"`
+	P90           time.Duration `json:"p90"`
+	P99           time.Duration `json:"p99"`
+	Max           time.Duration `json:"max"`
+	PeakHeapBytes uint64        `json:"peak_heap_bytes"`
+	AllocBytes    uint64        `json:"alloc_bytes"`
+}
+
+// runBench implements `dbx bench`, which loads a synthetic table and times
+// repeated imports into and exports out of it, so writer and parallelism
+// settings can be compared on the same data.
+func runBench(cfg config, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	workload := fs.String("workload", "both", "Workload to run: export, import or both")
+	rows := fs.Int64("rows", 1_000_000, "Rows in the synthetic table")
+	columns := fs.Int("columns", 8, "Columns in the synthetic table, including the id column")
+	width := fs.Int("width", 16, "Length of each string value")
+	batchRows := fs.Int("batch-rows", 64*1024, "Rows per generated record batch")
+	iterations := fs.Int("iterations", 5, "Times to run each workload")
+	parallel := fs.Int("parallel", 1, "Concurrent runs per iteration")
+	table := fs.String("table", "dbx_bench", "Scratch table to create; it is dropped afterwards")
+	dir := fs.String("dir", "", "Directory for generated and exported files (default a temporary directory)")
+	keep := fs.Bool("keep", false, "Keep the scratch table and files")
+	format := fs.String("format", "parquet", "Export format: parquet or arrow")
+	compression := fs.String("compression", "", "Export compression, as for the global -compression flag")
+	rowGroupSize := fs.Int64("row-group-size", 0, "Maximum rows per exported Parquet row group")
+	loadStrategy := fs.String("load-strategy", "bind", "Comma-separated load strategies to time imports with, as for dbx import, e.g. bind,copy to compare them")
+	parseFlags(fs, args)
+
+	runExport := *workload == "export" || *workload == "both"
+	runImport := *workload == "import" || *workload == "both"
+	switch {
+	case !runExport && !runImport:
+		return fmt.Errorf("unknown workload %q", *workload)
+	case *rows <= 0 || *columns < 1 || *iterations < 1 || *parallel < 1 || *batchRows < 1:
+		return fmt.Errorf("-rows, -columns, -iterations, -parallel and -batch-rows must be positive")
+	}
+	sinkOpts := sinkOptions{Format: *format, Compression: *compression, RowGroupSize: *rowGroupSize}
+	if err := sinkOpts.validate(); err != nil {
+		return err
+	}
+	strategies := splitList(*loadStrategy)
+	for _, s := range strategies {
+		if err := validLoadStrategy(s, cfg.conn); err != nil {
+			return classify(errUsage, err)
+		}
+	}
+
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", "dbx-bench-")
+		if err != nil {
+			return fmt.Errorf("failed to create bench directory: %w", err)
+		}
+		*dir = tmp
+	} else if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create bench directory: %w", err)
+	}
+	if !*keep {
+		defer os.RemoveAll(*dir)
+	}
+
+	ctx := context.Background()
+	source := filepath.Join(*dir, "source.parquet")
+	schema := benchSchema(*columns)
+	if err := writeBenchData(ctx, source, schema, *rows, *width, *batchRows); err != nil {
+		return err
+	}
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("failed to stat bench data: %w", err)
+	}
+
+	quoted, err := cfg.conn.quoteTable(*table)
+	if err != nil {
+		return err
+	}
+	if err := createBenchTable(ctx, cfg.conn, quoted, schema); err != nil {
+		return err
+	}
+	if !*keep {
+		defer execSQL(ctx, cfg.conn, "DROP TABLE "+quoted)
+	}
+
+	var results []benchResult
+	for _, strategy := range strategies {
+		if !runImport {
+			break
+		}
+		name := "import"
+		if len(strategies) > 1 {
+			name += " (" + strategy + ")"
+		}
+		res, err := benchWorkload(name, *iterations, *parallel, func(iter, worker int) (int64, int64, error) {
+			resp, err := importFile(ctx, cfg.conn, source, *table, nil, importOptions{LoadStrategy: strategy})
+			if err != nil {
+				return 0, 0, err
+			}
+			return resp.RowsWritten, sourceInfo.Size(), nil
+		})
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+	}
+
+	if runExport {
+		// Start the exports from a table holding exactly one copy of the data.
+		if err := execSQL(ctx, cfg.conn, "DELETE FROM "+quoted); err != nil {
+			return err
+		}
+		if _, err := importFile(ctx, cfg.conn, source, *table, nil, importOptions{}); err != nil {
+			return err
+		}
+		res, err := benchWorkload("export", *iterations, *parallel, func(iter, worker int) (int64, int64, error) {
+			resp, err := exportQuery(ctx, cfg.conn, exportSpec{
+				Query:       "SELECT * FROM " + quoted,
+				Output:      filepath.Join(*dir, fmt.Sprintf("export-%d-%d%s", iter, worker, sinkOpts.extension())),
+				Force:       true,
+				sinkOptions: sinkOpts,
+			})
+			if err != nil {
+				return 0, 0, err
+			}
+			return resp.RowsWritten, resp.OutputFileSize, nil
+		})
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+	}
+
+	if cfg.json {
+		printJSON(results)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKLOAD\tRUNS\tROWS/S\tMB/S\tP50\tP90\tP99\tMAX\tPEAK HEAP\tALLOC")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%.1f\t%v\t%v\t%v\t%v\t%.1f MiB\t%.1f MiB\n", r.Workload, r.Runs, r.RowsPerSecond, r.MBPerSecond,
+			r.P50.Round(time.Millisecond), r.P90.Round(time.Millisecond), r.P99.Round(time.Millisecond), r.Max.Round(time.Millisecond),
+			float64(r.PeakHeapBytes)/(1<<20), float64(r.AllocBytes)/(1<<20))
+	}
+	return w.Flush()
+}
+
+// benchWorkload runs fn parallel times concurrently, iterations times over,
+// and summarizes throughput over wall-clock time, per-run latency and memory.
+// fn returns the rows and bytes it processed.
+func benchWorkload(name string, iterations, parallel int, fn func(iter, worker int) (int64, int64, error)) (benchResult, error) {
+	res := benchResult{Workload: name}
+	var latencies []time.Duration
+	var wall time.Duration
+	var mu sync.Mutex
+
+	stopSampling := sampleHeap(&res.PeakHeapBytes)
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	for iter := 0; iter < iterations; iter++ {
+		start := time.Now()
+		var wg sync.WaitGroup
+		errs := make([]error, parallel)
+		for worker := 0; worker < parallel; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				runStart := time.Now()
+				rows, bytes, err := fn(iter, worker)
+				elapsed := time.Since(runStart)
+				mu.Lock()
+				defer mu.Unlock()
+				errs[worker] = err
+				latencies = append(latencies, elapsed)
+				res.Rows += rows
+				res.Bytes += bytes
+			}(worker)
+		}
+		wg.Wait()
+		wall += time.Since(start)
+		for _, err := range errs {
+			if err != nil {
+				stopSampling()
+				return res, fmt.Errorf("%s run %d: %w", name, iter, err)
+			}
+		}
+	}
+
+	stopSampling()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	res.AllocBytes = after.TotalAlloc - before.TotalAlloc
+
+	res.Runs = len(latencies)
+	res.RowsPerSecond = float64(res.Rows) / wall.Seconds()
+	res.MBPerSecond = float64(res.Bytes) / (1 << 20) / wall.Seconds()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.P50 = percentile(latencies, 0.50)
+	res.P90 = percentile(latencies, 0.90)
+	res.P99 = percentile(latencies, 0.99)
+	res.Max = latencies[len(latencies)-1]
+	return res, nil
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// sampleHeap records the largest heap size seen in peak until the returned
+// function is called.
+func sampleHeap(peak *uint64) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > *peak {
+				*peak = ms.HeapAlloc
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// benchSchema is an int64 id followed by columns-1 string columns.
+func benchSchema(columns int) *arrow.Schema {
+	fields := []arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}
+	for i := 1; i < columns; i++ {
+		fields = append(fields, arrow.Field{Name: fmt.Sprintf("c%d", i), Type: arrow.BinaryTypes.String, Nullable: true})
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// writeBenchData writes rows of random data with the given string width to a
+// Parquet file at path.
+func writeBenchData(ctx context.Context, path string, schema *arrow.Schema, rows int64, width, batchRows int) error {
+	out, err := newSink(ctx, path, schema, sinkOptions{})
+	if err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	buf := make([]byte, width)
+
+	bldr := array.NewRecordBuilder(allocator, schema)
+	defer bldr.Release()
+	for written := int64(0); written < rows; {
+		n := min(int64(batchRows), rows-written)
+		for i := int64(0); i < n; i++ {
+			bldr.Field(0).(*array.Int64Builder).Append(written + i)
+			for c := 1; c < schema.NumFields(); c++ {
+				for j := range buf {
+					buf[j] = letters[rng.Intn(len(letters))]
+				}
+				bldr.Field(c).(*array.StringBuilder).Append(string(buf))
+			}
+		}
+		rec := bldr.NewRecord()
+		err := out.write(rec)
+		rec.Release()
+		if err != nil {
+			out.abort()
+			return err
+		}
+		written += n
+	}
+	if _, err := out.close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createBenchTable (re)creates table, given quoted, with columns matching
+// schema.
+func createBenchTable(ctx context.Context, opts connOptions, table string, schema *arrow.Schema) error {
+	cols := []string{opts.quoteIdent("id") + " BIGINT"}
+	for _, f := range schema.Fields()[1:] {
+		cols = append(cols, opts.quoteIdent(f.Name)+" TEXT")
+	}
+	if err := execSQL(ctx, opts, "DROP TABLE IF EXISTS "+table); err != nil {
+		return err
+	}
+	return execSQL(ctx, opts, fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(cols, ", ")))
+}
+
+// execSQL runs a statement that returns no rows.
+func execSQL(ctx context.Context, opts connOptions, query string) error {
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+	return execUpdate(ctx, cnxn, query)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+)
+
+// cacheMaxAge is how long a cached stream can be resumed from; older ones
+// are removed the next time the cache is opened.
+const cacheMaxAge = 24 * time.Hour
+
+// streamCache persists the Arrow streams read from the source as
+// zstd-compressed IPC files, so an export that fails after reading its
+// source, in writing an output or registering it, is resumed from the cache
+// by the next run rather than querying the database again.
+type streamCache struct {
+	// Dir holds a file per cached stream, named by its key.
+	Dir string
+	// Keep leaves cached streams in place once the export succeeds, and
+	// incomplete ones when it fails, to inspect what was read.
+	Keep bool
+}
+
+// cacheKey identifies the stream read by spec: the same query with the
+// same parameters against the same database.
+func cacheKey(opts connOptions, spec exportSpec) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "uri=%s\nquery=%s\n", opts.URI, spec.Query)
+	if spec.Pagination != nil {
+		fmt.Fprintf(h, "pagination=%s\n", spec.Pagination)
+	}
+	for _, p := range spec.Params {
+		fmt.Fprintf(h, "param=%s\n", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+func (c *streamCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".arrow")
+}
+
+// open returns the complete stream cached under key, or nil if there is
+// none. Expired streams are removed first.
+func (c *streamCache) open(ctx context.Context, key string) (array.RecordReader, error) {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	c.expire(ctx)
+	f, err := os.Open(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cached stream: %w", err)
+	}
+	r, err := ipc.NewFileReader(f, ipc.WithAllocator(allocator))
+	if err != nil {
+		f.Close()
+		// A stream that cannot be read is read from the source again.
+		logger(ctx).Warn("Ignoring unreadable cached stream", "path", c.path(key), "error", err)
+		c.remove(key)
+		return nil, nil
+	}
+	return &cachedReader{refCount: 1, f: f, r: r}, nil
+}
+
+// expire removes streams older than cacheMaxAge.
+func (c *streamCache) expire(ctx context.Context) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".arrow") && !strings.HasSuffix(e.Name(), ".arrow.partial") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < cacheMaxAge {
+			continue
+		}
+		if os.Remove(filepath.Join(c.Dir, e.Name())) == nil {
+			logger(ctx).Debug("Removed expired cached stream", "path", filepath.Join(c.Dir, e.Name()))
+		}
+	}
+}
+
+// remove deletes the stream cached under key.
+func (c *streamCache) remove(key string) {
+	os.Remove(c.path(key))
+	os.Remove(c.path(key) + ".partial")
+}
+
+// finish cleans up the stream cached under key once the export ends with
+// err. Only a stream read to the end is left after a failure, to resume
+// from. With Keep, nothing is removed, and the stream of a successful
+// export is renamed aside so later runs do not resume from it.
+func (c *streamCache) finish(ctx context.Context, key string, err error) {
+	switch {
+	case !c.Keep && err == nil:
+		c.remove(key)
+	case !c.Keep:
+		os.Remove(c.path(key) + ".partial")
+	case err == nil:
+		kept := filepath.Join(c.Dir, key+"-"+time.Now().UTC().Format("20060102T150405")+".arrow")
+		if os.Rename(c.path(key), kept) == nil {
+			logger(ctx).Info("Kept cached stream", "path", kept)
+		}
+	}
+}
+
+// tee returns a reader passing on the records of src while caching them
+// under key. The stream is only resumable once src has been read to the
+// end.
+func (c *streamCache) tee(key string, src array.RecordReader) (*cacheWriter, error) {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	partial := c.path(key) + ".partial"
+	f, err := os.Create(partial)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cached stream: %w", err)
+	}
+	w, err := ipc.NewFileWriter(f, ipc.WithSchema(src.Schema()), ipc.WithZstd(), ipc.WithAllocator(allocator))
+	if err != nil {
+		f.Close()
+		os.Remove(partial)
+		return nil, fmt.Errorf("failed to create cached stream: %w", err)
+	}
+	src.Retain()
+	return &cacheWriter{refCount: 1, src: src, f: f, w: w, partial: partial, path: c.path(key)}, nil
+}
+
+// cacheWriter is a record reader that writes what it reads to the cache.
+type cacheWriter struct {
+	refCount int64
+	src      array.RecordReader
+	f        *os.File
+	w        *ipc.FileWriter
+	partial  string
+	path     string
+	err      error
+	done     bool
+}
+
+func (r *cacheWriter) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *cacheWriter) Release() {
+	if atomic.AddInt64(&r.refCount, -1) != 0 {
+		return
+	}
+	if r.w != nil {
+		r.w.Close()
+		r.f.Close()
+	}
+	r.src.Release()
+}
+
+func (r *cacheWriter) Schema() *arrow.Schema { return r.src.Schema() }
+func (r *cacheWriter) Record() arrow.Record  { return r.src.Record() }
+
+func (r *cacheWriter) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.src.Err()
+}
+
+func (r *cacheWriter) Next() bool {
+	if r.err != nil || r.done {
+		return false
+	}
+	if r.src.Next() {
+		if rec := r.src.Record(); rec != nil {
+			if err := r.w.Write(rec); err != nil {
+				r.err = fmt.Errorf("failed to write cached stream: %w", err)
+				return false
+			}
+		}
+		return true
+	}
+	if err := r.src.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	// The whole stream has been read, so it can be resumed from.
+	r.done = true
+	err := r.w.Close()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.w = nil
+	if err == nil {
+		err = os.Rename(r.partial, r.path)
+	}
+	if err != nil {
+		r.err = fmt.Errorf("failed to write cached stream: %w", err)
+	}
+	return false
+}
+
+// cachedReader replays a cached stream.
+type cachedReader struct {
+	refCount int64
+	f        *os.File
+	r        *ipc.FileReader
+	i        int
+	cur      arrow.Record
+	err      error
+}
+
+func (r *cachedReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *cachedReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) != 0 {
+		return
+	}
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	r.r.Close()
+	r.f.Close()
+}
+
+func (r *cachedReader) Schema() *arrow.Schema { return r.r.Schema() }
+func (r *cachedReader) Record() arrow.Record  { return r.cur }
+func (r *cachedReader) Err() error            { return r.err }
+
+func (r *cachedReader) Next() bool {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	if r.err != nil || r.i >= r.r.NumRecords() {
+		return false
+	}
+	rec, err := r.r.RecordAt(r.i)
+	if err != nil {
+		r.err = fmt.Errorf("failed to read cached stream: %w", err)
+		return false
+	}
+	r.cur, r.i = rec, r.i+1
+	return true
+}
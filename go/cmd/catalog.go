@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// catalog is a local SQLite database recording every dataset dbX has
+// written, so exports can be found again without walking the filesystem.
+type catalog struct {
+	db *sql.DB
+}
+
+// dataset is a single entry in the catalog.
+type dataset struct {
+	Location  string    `json:"location"`
+	Schema    string    `json:"schema"`
+	Rows      int64     `json:"rows"`
+	Bytes     int64     `json:"bytes"`
+	Source    string    `json:"source"`
+	Origin    string    `json:"origin"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const catalogDDL = `
+CREATE TABLE IF NOT EXISTS datasets (
+	location   TEXT PRIMARY KEY,
+	schema     TEXT NOT NULL,
+	rows       INTEGER NOT NULL,
+	bytes      INTEGER NOT NULL,
+	source     TEXT NOT NULL,
+	origin     TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`
+
+func openCatalog(path string) (*catalog, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no catalog configured; pass -catalog or set DBX_CATALOG")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create catalog directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	if _, err := db.Exec(catalogDDL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize catalog: %w", err)
+	}
+	return &catalog{db: db}, nil
+}
+
+func (c *catalog) Close() error {
+	return c.db.Close()
+}
+
+// record inserts or replaces the catalog entry for ds.Location.
+func (c *catalog) record(ds dataset) error {
+	_, err := c.db.Exec(`
+	INSERT INTO datasets (location, schema, rows, bytes, source, origin, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (location) DO UPDATE SET
+		schema = excluded.schema,
+		rows = excluded.rows,
+		bytes = excluded.bytes,
+		source = excluded.source,
+		origin = excluded.origin,
+		updated_at = excluded.updated_at`,
+		ds.Location, ds.Schema, ds.Rows, ds.Bytes, ds.Source, ds.Origin, ds.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record dataset: %w", err)
+	}
+	return nil
+}
+
+// search returns the datasets whose location, source or schema contain term,
+// most recently updated first. An empty term matches everything.
+func (c *catalog) search(term string) ([]dataset, error) {
+	pattern := "%" + term + "%"
+	rows, err := c.db.Query(`
+	SELECT location, schema, rows, bytes, source, origin, updated_at
+	FROM datasets
+	WHERE location LIKE ? OR source LIKE ? OR schema LIKE ?
+	ORDER BY updated_at DESC`, pattern, pattern, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var out []dataset
+	for rows.Next() {
+		var ds dataset
+		if err := rows.Scan(&ds.Location, &ds.Schema, &ds.Rows, &ds.Bytes, &ds.Source, &ds.Origin, &ds.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read catalog entry: %w", err)
+		}
+		out = append(out, ds)
+	}
+	return out, rows.Err()
+}
+
+// recordExport adds the output of a successful export to the catalog.
+func recordExport(cfg config, source string, resp *response) error {
+	cat, err := openCatalog(cfg.catalog)
+	if err != nil {
+		return err
+	}
+	defer cat.Close()
+
+	location := resp.Location
+	if !strings.Contains(location, "://") {
+		if location, err = filepath.Abs(location); err != nil {
+			return fmt.Errorf("failed to resolve output location: %w", err)
+		}
+	}
+	return cat.record(dataset{
+		Location:  location,
+		Schema:    resp.schema.String(),
+		Rows:      resp.RowsWritten,
+		Bytes:     resp.OutputFileSize,
+		Source:    source,
+		Origin:    redactURI(cfg.conn.URI),
+		UpdatedAt: time.Now(),
+	})
+}
+
+// runDatasets implements `dbx datasets list`, `dbx datasets search <term>`
+// and `dbx datasets describe <location>`. Datasets are those of the catalog,
+// if one is configured, and those whose manifests are found under the
+// -location directories or the locations of the config file, which serve
+// teams without a shared catalog.
+func runDatasets(cfg config, args []string) error {
+	if len(args) == 0 {
+		return classify(errUsage, fmt.Errorf("usage: dbx datasets list | search <term> | describe <location>"))
+	}
+	fs := flag.NewFlagSet("datasets "+args[0], flag.ExitOnError)
+	var locations stringList
+	fs.Var(&locations, "location", "Directory to scan for the manifests of exported datasets (repeatable; default the locations of the config file)")
+	parseFlags(fs, args[1:])
+	rest := fs.Args()
+
+	var term string
+	switch args[0] {
+	case "list":
+		if len(rest) != 0 {
+			return classify(errUsage, fmt.Errorf("usage: dbx datasets list [-location dir]..."))
+		}
+	case "search":
+		if len(rest) != 1 {
+			return classify(errUsage, fmt.Errorf("usage: dbx datasets search [-location dir]... <term>"))
+		}
+		term = rest[0]
+	case "describe":
+		if len(rest) != 1 {
+			return classify(errUsage, fmt.Errorf("usage: dbx datasets describe <location>"))
+		}
+		return describeDataset(cfg, rest[0])
+	default:
+		return classify(errUsage, fmt.Errorf("unknown datasets command %q", args[0]))
+	}
+
+	if len(locations) == 0 {
+		c, err := loadConfig(cfg.configPath)
+		if err != nil {
+			return err
+		}
+		locations = c.Locations
+	}
+	if cfg.catalog == "" && len(locations) == 0 {
+		return classify(errUsage, fmt.Errorf("no catalog or dataset locations configured; pass -catalog or -location, set DBX_CATALOG, or list locations in %s", cfg.configPath))
+	}
+
+	byLocation := make(map[string]dataset)
+	if cfg.catalog != "" {
+		cat, err := openCatalog(cfg.catalog)
+		if err != nil {
+			return err
+		}
+		defer cat.Close()
+		found, err := cat.search(term)
+		if err != nil {
+			return err
+		}
+		for _, ds := range found {
+			byLocation[ds.Location] = ds
+		}
+	}
+	scanned, err := scanDatasets(locations)
+	if err != nil {
+		return err
+	}
+	for _, ds := range scanned {
+		if !ds.matches(term) {
+			continue
+		}
+		// The manifest is written with the data, so it wins over the
+		// catalog, which only knows the connection read from.
+		if prev, ok := byLocation[ds.Location]; ok && ds.Origin == "" {
+			ds.Origin = prev.Origin
+		}
+		byLocation[ds.Location] = ds
+	}
+	datasets := make([]dataset, 0, len(byLocation))
+	for _, ds := range byLocation {
+		datasets = append(datasets, ds)
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].UpdatedAt.After(datasets[j].UpdatedAt) })
+
+	if cfg.json {
+		printJSON(datasets)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCATION\tROWS\tBYTES\tUPDATED\tSOURCE")
+	for _, ds := range datasets {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", ds.Location, ds.Rows, ds.Bytes, ds.UpdatedAt.Local().Format(time.RFC3339), ds.Source)
+	}
+	return w.Flush()
+}
+
+// matches reports whether the location, source or schema of ds contain
+// term, ignoring case as the catalog's LIKE does.
+func (ds dataset) matches(term string) bool {
+	term = strings.ToLower(term)
+	for _, s := range []string{ds.Location, ds.Source, ds.Schema} {
+		if strings.Contains(strings.ToLower(s), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanDatasets walks locations for the manifests written next to exports,
+// and returns the datasets they describe. Manifests whose output is gone,
+// or that cannot be read, are skipped.
+func scanDatasets(locations []string) ([]dataset, error) {
+	var datasets []dataset
+	for _, root := range locations {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".manifest.json") {
+				return nil
+			}
+			output := strings.TrimSuffix(path, ".manifest.json")
+			if _, err := os.Stat(output); err != nil {
+				slog.Debug("Skipping manifest without output", "manifest", path)
+				return nil
+			}
+			m, err := readManifestFile(path)
+			if err != nil {
+				slog.Warn("Skipping unreadable manifest", "manifest", path, "error", err)
+				return nil
+			}
+			ds, err := manifestDataset(output, m)
+			if err != nil {
+				return err
+			}
+			datasets = append(datasets, ds)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s for datasets: %w", root, err)
+		}
+	}
+	return datasets, nil
+}
+
+// manifestDataset is the dataset at output, as its manifest m describes it.
+func manifestDataset(output string, m *manifest) (dataset, error) {
+	location, err := filepath.Abs(output)
+	if err != nil {
+		return dataset{}, fmt.Errorf("failed to resolve dataset location: %w", err)
+	}
+	source := m.Query
+	if m.Lineage != nil && m.Lineage.Table != "" {
+		source = m.Lineage.Table
+	}
+	return dataset{
+		Location:  location,
+		Schema:    m.Schema,
+		Rows:      m.Rows,
+		Bytes:     m.Bytes,
+		Source:    source,
+		UpdatedAt: m.CreatedAt,
+	}, nil
+}
+
+// datasetDescription is what `dbx datasets describe` reports.
+type datasetDescription struct {
+	dataset
+	// Columns are read from the data of Parquet datasets.
+	Columns []schemaField `json:"columns,omitempty"`
+	Cursor  string        `json:"cursor,omitempty"`
+	Parts   int           `json:"parts,omitempty"`
+	Tags    []string      `json:"tags,omitempty"`
+	Lineage *lineage      `json:"lineage,omitempty"`
+}
+
+// describeDataset prints the manifest of the dataset at location, or its
+// catalog entry if it has no manifest.
+func describeDataset(cfg config, location string) error {
+	m, err := readManifest(location)
+	if err != nil {
+		return err
+	}
+	var desc datasetDescription
+	if m != nil {
+		if desc.dataset, err = manifestDataset(location, m); err != nil {
+			return err
+		}
+		desc.Cursor, desc.Parts, desc.Lineage = m.Cursor, len(m.Parts), m.Lineage
+		for name := range m.Tags {
+			desc.Tags = append(desc.Tags, name)
+		}
+		sort.Strings(desc.Tags)
+	} else {
+		abs, err := filepath.Abs(location)
+		if err != nil {
+			return fmt.Errorf("failed to resolve dataset location: %w", err)
+		}
+		var found bool
+		if cfg.catalog != "" {
+			cat, err := openCatalog(cfg.catalog)
+			if err != nil {
+				return err
+			}
+			defer cat.Close()
+			entries, err := cat.search(abs)
+			if err != nil {
+				return err
+			}
+			for _, ds := range entries {
+				if ds.Location == abs {
+					desc.dataset, found = ds, true
+				}
+			}
+		}
+		if !found {
+			return classify(errUsage, fmt.Errorf("%s has no manifest and is not in the catalog", location))
+		}
+	}
+
+	if info, err := os.Stat(location); err == nil && (info.IsDir() || strings.HasSuffix(location, ".parquet")) {
+		if rdr, closeFn, err := openParquetInput(context.Background(), location, nil, nil, nil, mismatchUnion, ""); err != nil {
+			slog.Debug("Cannot read the columns of the dataset", "location", location, "error", err)
+		} else {
+			desc.Columns = describeSchema(rdr.Schema()).Fields
+			rdr.Release()
+			closeFn()
+		}
+	}
+	printJSON(desc)
+	return nil
+}
+
+// redactURI strips the password from a connection URI so it can be stored.
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return uri
+	}
+	return u.Redacted()
+}
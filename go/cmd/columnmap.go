@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// parseColumnMap parses -map flags of the form src_col=dest_col.
+func parseColumnMap(pairs []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		src, dest, ok := strings.Cut(p, "=")
+		if !ok || src == "" || dest == "" {
+			return nil, fmt.Errorf("invalid -map %q, expected src_col=dest_col", p)
+		}
+		if _, dup := mapping[src]; dup {
+			return nil, fmt.Errorf("column %q is mapped twice", src)
+		}
+		mapping[src] = dest
+	}
+	return mapping, nil
+}
+
+// columnPlan says which source column each column written to the target
+// table comes from, by index.
+type columnPlan struct {
+	schema  *arrow.Schema
+	sources []int
+}
+
+// planColumns matches the columns of source to those of target by name,
+// after renaming source columns through mapping (src -> dest). Names that
+// do not match exactly are matched case-insensitively. Target columns
+// without a source are left out, for the database to fill with their
+// DEFAULT or NULL, unless they are NOT NULL without one of the columns in
+// defaults; source columns without a target are reported as unused. Target
+// columns in skip are left for the database to fill, and source columns
+// matching them are dropped.
+func planColumns(source, target *arrow.Schema, mapping map[string]string, skip, defaults []string) (*columnPlan, []string, error) {
+	for src := range mapping {
+		if !source.HasField(src) {
+			return nil, nil, fmt.Errorf("-map: file has no column %q", src)
+		}
+	}
+
+	// destination name -> source index
+	byDest := make(map[string]int)
+	for i, f := range source.Fields() {
+		dest := f.Name
+		if m, ok := mapping[f.Name]; ok {
+			dest = m
+		}
+		if j, dup := byDest[dest]; dup {
+			return nil, nil, fmt.Errorf("columns %q and %q both map to %q", source.Field(j).Name, f.Name, dest)
+		}
+		byDest[dest] = i
+	}
+
+	// Matched columns keep their source type and take the target's name;
+	// converting between types is left to the driver.
+	skipped := make(map[string]bool, len(skip))
+	for _, name := range skip {
+		skipped[strings.ToLower(name)] = true
+	}
+	hasDefault := make(map[string]bool, len(defaults))
+	for _, name := range defaults {
+		hasDefault[strings.ToLower(name)] = true
+	}
+	fields := make([]arrow.Field, 0, target.NumFields())
+	plan := &columnPlan{sources: make([]int, 0, target.NumFields())}
+	used := make(map[int]bool)
+	var missing []string
+	for _, f := range target.Fields() {
+		if skipped[strings.ToLower(f.Name)] {
+			continue
+		}
+		src, ok := byDest[f.Name]
+		if !ok {
+			for dest, j := range byDest {
+				if strings.EqualFold(dest, f.Name) && !used[j] {
+					src, ok = j, true
+					break
+				}
+			}
+		}
+		switch {
+		case ok:
+			plan.sources = append(plan.sources, src)
+			used[src] = true
+			field := source.Field(src)
+			field.Name = f.Name
+			fields = append(fields, field)
+		case f.Nullable || hasDefault[strings.ToLower(f.Name)]:
+		default:
+			missing = append(missing, f.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("required columns %s have no source column in the file; map them with -map src_col=dest_col", strings.Join(missing, ", "))
+	}
+	plan.schema = arrow.NewSchema(fields, nil)
+
+	var unused []string
+	for dest, i := range byDest {
+		f := source.Field(i)
+		if used[i] || skipped[strings.ToLower(dest)] {
+			continue
+		}
+		if _, mapped := mapping[f.Name]; mapped {
+			return nil, nil, fmt.Errorf("-map: table has no column %q", dest)
+		}
+		unused = append(unused, f.Name)
+	}
+	sort.Strings(unused)
+	return plan, unused, nil
+}
+
+// project returns rec rearranged into the target table's column order.
+func (p *columnPlan) project(rec arrow.Record) arrow.Record {
+	cols := make([]arrow.Array, len(p.sources))
+	for i, src := range p.sources {
+		cols[i] = rec.Column(src)
+	}
+	return array.NewRecord(p.schema, cols, rec.NumRows())
+}
+
+// mapColumns wraps stream so its records match the columns of table,
+// leaving out the columns in skip. If the driver cannot describe the table
+// and there is no explicit mapping or skip, stream is returned unchanged.
+func mapColumns(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader, mapping map[string]string, skip []string) (array.RecordReader, error) {
+	target, err := getTableSchema(ctx, cnxn, table)
+	if err != nil {
+		var adbcErr adbc.Error
+		if len(mapping) == 0 && len(skip) == 0 && errors.As(err, &adbcErr) && adbcErr.Code == adbc.StatusNotImplemented {
+			stream.Retain()
+			return stream, nil
+		}
+		return nil, fmt.Errorf("failed to get schema of table %s: %w", table, err)
+	}
+
+	defaults, err := defaultColumns(ctx, cnxn, table)
+	if err != nil {
+		return nil, err
+	}
+	plan, unused, err := planColumns(stream.Schema(), target, mapping, skip, defaults)
+	if err != nil {
+		return nil, classify(errSchemaMismatch, err)
+	}
+	if len(unused) > 0 {
+		logger(ctx).Warn("Skipping file columns with no matching table column", "columns", unused)
+	}
+	stream.Retain()
+	return &projectedReader{RecordReader: stream, plan: plan, refCount: 1}, nil
+}
+
+// projectedReader applies a columnPlan to every record of a stream.
+type projectedReader struct {
+	array.RecordReader
+	plan     *columnPlan
+	rec      arrow.Record
+	refCount int64
+}
+
+func (r *projectedReader) Retain() { atomic.AddInt64(&r.refCount, 1) }
+
+func (r *projectedReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.rec != nil {
+			r.rec.Release()
+		}
+		r.RecordReader.Release()
+	}
+}
+
+func (r *projectedReader) Schema() *arrow.Schema { return r.plan.schema }
+func (r *projectedReader) Record() arrow.Record  { return r.rec }
+
+func (r *projectedReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	if !r.RecordReader.Next() {
+		return false
+	}
+	r.rec = r.plan.project(r.RecordReader.Record())
+	return true
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// compactGroup is a run of small Parquet files in one directory that are
+// merged into a single file.
+type compactGroup struct {
+	Dir    string   `json:"dir"`
+	Inputs []string `json:"inputs"`
+	Bytes  int64    `json:"bytes"`
+	Output string   `json:"output,omitempty"`
+	Rows   int64    `json:"rows,omitempty"`
+}
+
+// runCompact implements `dbx compact [-target-size 512MB] <dir>`, merging
+// the small Parquet parts left by incremental runs into files of about the
+// target size. Each directory, and so each Hive partition, is compacted on
+// its own, so the partitioning of the dataset is preserved.
+func runCompact(cfg config, args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	targetSize := fs.String("target-size", "512MB", "Approximate size of the merged files, e.g. 128MB or 1GB")
+	compression := fs.String("compression", "", "Compression of the merged files: snappy, gzip, zstd, brotli or none")
+	dryRun := fs.Bool("dry-run", false, "Print the files that would be merged without merging them")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		return classify(errUsage, fmt.Errorf("usage: dbx compact [-target-size 512MB] [-dry-run] <dir>"))
+	}
+	target, err := parseByteSize(*targetSize)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	so := sinkOptions{Compression: *compression}
+	if err := so.validate(); err != nil {
+		return classify(errUsage, err)
+	}
+
+	groups, err := planCompaction(fs.Arg(0), target)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		if cfg.json {
+			printJSON(groups)
+			return nil
+		}
+		for _, g := range groups {
+			fmt.Printf("%s: %d files, %d bytes\n", g.Dir, len(g.Inputs), g.Bytes)
+		}
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for i := range groups {
+		g := &groups[i]
+		g.Output = filepath.Join(g.Dir, fmt.Sprintf("compact-%s-%d.parquet", stamp, i))
+		if err := compactFiles(withLogAttrs(ctx, "dir", g.Dir), g, so); err != nil {
+			return err
+		}
+		slog.Info("Compacted files", "dir", g.Dir, "files", len(g.Inputs), "rows", g.Rows, "location", g.Output)
+	}
+	if cfg.json {
+		printJSON(groups)
+	}
+	return nil
+}
+
+// planCompaction groups the Parquet files under root by directory and, in
+// name order, into runs of files adding up to about target bytes. Files that
+// are already at least target bytes, and runs of a single file, are left
+// alone.
+func planCompaction(root string, target int64) ([]compactGroup, error) {
+	type part struct {
+		path string
+		size int64
+	}
+	dirs := make(map[string][]part)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".parquet" {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		dirs[dir] = append(dirs[dir], part{path: path, size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+
+	var groups []compactGroup
+	for _, dir := range names {
+		var g compactGroup
+		flush := func() {
+			if len(g.Inputs) > 1 {
+				groups = append(groups, g)
+			}
+			g = compactGroup{}
+		}
+		for _, p := range dirs[dir] {
+			if p.size >= target {
+				continue
+			}
+			if g.Bytes+p.size > target {
+				flush()
+			}
+			g.Dir = dir
+			g.Inputs = append(g.Inputs, p.path)
+			g.Bytes += p.size
+		}
+		flush()
+	}
+	return groups, nil
+}
+
+// compactFiles writes the rows of g's inputs to g.Output and then removes
+// the inputs and their manifests. The inputs must share a schema. Until the
+// merged file is in place the inputs are untouched, so an interrupted
+// compaction loses nothing.
+func compactFiles(ctx context.Context, g *compactGroup, so sinkOptions) error {
+	schema, err := parquetSchema(g.Inputs[0])
+	if err != nil {
+		return err
+	}
+	for _, in := range g.Inputs[1:] {
+		other, err := parquetSchema(in)
+		if err != nil {
+			return err
+		}
+		if !other.Equal(schema) {
+			return classify(errSchemaMismatch, fmt.Errorf("schema of %s differs from %s", in, g.Inputs[0]))
+		}
+	}
+
+	out, err := newFileSink(g.Output, schema, so)
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	for _, in := range g.Inputs {
+		rows, err := copyParquet(ctx, in, out)
+		if err != nil {
+			return err
+		}
+		g.Rows += rows
+	}
+	if _, err := out.close(); err != nil {
+		return err
+	}
+
+	for _, in := range g.Inputs {
+		if err := os.Remove(in); err != nil {
+			return fmt.Errorf("failed to remove compacted file: %w", err)
+		}
+		if err := os.Remove(manifestPath(in)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyParquet writes every record of the Parquet file at path to s.
+func copyParquet(ctx context.Context, path string, s sink) (int64, error) {
+	reader, closeFile, err := openParquetColumns(ctx, path, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile()
+	defer reader.Release()
+
+	var rows int64
+	for reader.Next() {
+		rec := reader.Record()
+		if err := s.write(rec); err != nil {
+			return 0, err
+		}
+		rows += rec.NumRows()
+	}
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return rows, nil
+}
+
+// parseByteSize parses a size such as 1048576, 512MB or 1.5GB. Units are
+// powers of 1024.
+func parseByteSize(text string) (int64, error) {
+	s, mult := strings.ToUpper(strings.TrimSpace(text)), 1.0
+	for _, u := range []struct {
+		suffix string
+		mult   float64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	return int64(n * mult), nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// commandDoc describes a command for shell completions and the man page.
+type commandDoc struct {
+	summary string
+	// subcommands are the words accepted right after the command.
+	subcommands []string
+	// flagless commands parse no flags, and run rather than describe their
+	// flags in commandFlags.
+	flagless bool
+}
+
+var commandDocs = map[string]commandDoc{
+	"backup":      {summary: "Back up tables to Parquet files and a manifest"},
+	"bench":       {summary: "Time imports and exports of a synthetic table"},
+	"compact":     {summary: "Merge the small Parquet parts of incremental runs"},
+	"completion":  {summary: "Print the shell completion script for bash, zsh or fish", subcommands: []string{"bash", "zsh", "fish"}, flagless: true},
+	"datasets":    {summary: "List, search or describe exported datasets", subcommands: []string{"describe", "list", "search"}},
+	"ddl":         {summary: "Print the CREATE TABLE statement for a Parquet file"},
+	"drivers":     {summary: "List the ADBC drivers found and whether they load", subcommands: []string{"list"}, flagless: true},
+	"emit":        {summary: "Generate files describing exported datasets for other tools", subcommands: []string{"dbt-sources"}},
+	"exec":        {summary: "Run the statements of a SQL script in one transaction"},
+	"export":      {summary: "Run a named export of the config file"},
+	"gen":         {summary: "Generate synthetic data into a file or table"},
+	"head":        {summary: "Print the first rows of a file or table"},
+	"import":      {summary: "Import Parquet files into tables"},
+	"jobs":        {summary: "List, inspect or cancel background jobs", subcommands: []string{"list", "status", "cancel"}, flagless: true},
+	"k8s":         {summary: "Render Kubernetes manifests running a pipeline", subcommands: []string{"render"}},
+	"kafka":       {summary: "Ingest a Kafka topic into a table"},
+	"man":         {summary: "Print the dbx(1) man page", flagless: true},
+	"materialize": {summary: "Write the result of a query into a table, refreshed fully or incrementally"},
+	"migrate":     {summary: "Apply, revert or list versioned SQL migrations of the database schema", subcommands: []string{"up", "down", "status"}},
+	"profile":     {summary: "Write a data profile of a table or file"},
+	"query":       {summary: "Run SQL over exported files with an embedded engine"},
+	"restore":     {summary: "Restore the tables of a backup"},
+	"rewrite":     {summary: "Rewrite Parquet files with new sink options"},
+	"run":         {summary: "Run a pipeline file"},
+	"sample":      {summary: "Bundle sample rows and the schema of a table for a bug report"},
+	"schedule":    {summary: "Run the recurring exports of a schedule file"},
+	"schema":      {summary: "Print the Arrow schema of a table or query"},
+	"serve":       {summary: "Serve exports over Arrow Flight, exported datasets over Flight SQL, or a control API over gRPC or HTTP", subcommands: []string{"flight", "flightsql", "grpc", "http"}},
+	"snapshot":    {summary: "Tag, list or drop the snapshots of an appended dataset", subcommands: []string{"tag", "list", "drop"}, flagless: true},
+	"sql":         {summary: "Run SQL interactively on the connection, with paged results"},
+	"stats":       {summary: "Print column statistics of a table or file"},
+	"tui":         {summary: "Browse connections, tables and rows, and run exports, in a terminal UI", flagless: true},
+	"vacuum":      {summary: "Remove the parts of an appended dataset older than its retention"},
+}
+
+// completeCommand is the hidden command completion scripts call for values
+// only known at run time.
+const completeCommand = "__complete"
+
+func init() {
+	// Registered here, as the commands refer back to the commands map.
+	commands["completion"] = runCompletion
+	commands["man"] = runMan
+	commands[completeCommand] = runComplete
+}
+
+// describeFlags, when set, is handed the flag set of the running command by
+// parseFlags, which then stops the command; see commandFlags.
+var describeFlags func(fs *flag.FlagSet)
+
+// flagsDescribed is the panic value stopping a command in commandFlags.
+type flagsDescribed struct{}
+
+// commandFlags returns the flags of the command at path, such as
+// ["serve", "http"], or the global flags for an empty path. Flags are
+// declared where each command parses them, so the command is run up to its
+// parseFlags call.
+func commandFlags(path ...string) (fs *flag.FlagSet) {
+	if len(path) == 0 {
+		return flag.CommandLine
+	}
+	run, ok := commands[path[0]]
+	if !ok || commandDocs[path[0]].flagless {
+		return nil
+	}
+	describeFlags = func(f *flag.FlagSet) { fs = f }
+	defer func() {
+		describeFlags = nil
+		if r := recover(); r != nil {
+			if _, ok := r.(flagsDescribed); !ok {
+				panic(r)
+			}
+		}
+	}()
+	run(config{}, path[1:])
+	return fs
+}
+
+// commandNames returns the documented commands in order.
+func commandNames() []string {
+	names := make([]string, 0, len(commandDocs))
+	for name := range commandDocs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandPath is a command, or a command and subcommand.
+type commandPath []string
+
+func (p commandPath) String() string { return strings.Join(p, " ") }
+
+// commandPaths returns the documented commands with flags, in order, each
+// subcommand on its own.
+func commandPaths() []commandPath {
+	var paths []commandPath
+	for _, name := range commandNames() {
+		doc := commandDocs[name]
+		if doc.flagless {
+			continue
+		}
+		if len(doc.subcommands) == 0 {
+			paths = append(paths, commandPath{name})
+		}
+		for _, sub := range doc.subcommands {
+			paths = append(paths, commandPath{name, sub})
+		}
+	}
+	return paths
+}
+
+// flagNames returns the flags of fs, and those of them that take no value.
+func flagNames(fs *flag.FlagSet) (all, bools []string) {
+	if fs == nil {
+		return nil, nil
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		all = append(all, "-"+f.Name)
+		if isBoolFlag(f) {
+			bools = append(bools, "-"+f.Name)
+		}
+	})
+	return all, bools
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// dynamicValues maps flags to the `dbx __complete` kind completing their
+// values.
+var dynamicValues = map[string]string{
+	"-named": "exports",
+	"-table": "tables",
+}
+
+// runComplete implements `dbx __complete exports|tables`, printing the named
+// exports of the config file, or the tables they read, one per line.
+func runComplete(cfg config, args []string) error {
+	if len(args) != 1 {
+		return classify(errUsage, fmt.Errorf("usage: dbx %s exports|tables", completeCommand))
+	}
+	c, err := loadConfig(cfg.configPath)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for name, p := range c.Exports {
+		switch args[0] {
+		case "exports":
+			seen[name] = true
+		case "tables":
+			if p.Source.Table != "" {
+				seen[p.Source.Table] = true
+			}
+		default:
+			return classify(errUsage, fmt.Errorf("unknown completion %q", args[0]))
+		}
+	}
+	values := make([]string, 0, len(seen))
+	for v := range seen {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Println(v)
+	}
+	return nil
+}
+
+// runCompletion implements `dbx completion bash|zsh|fish`.
+func runCompletion(cfg config, args []string) error {
+	if len(args) != 1 {
+		return classify(errUsage, fmt.Errorf("usage: dbx completion bash|zsh|fish"))
+	}
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout)
+	case "zsh":
+		// zsh runs the bash completion through its compatibility layer.
+		fmt.Fprintln(os.Stdout, "#compdef dbx")
+		fmt.Fprintln(os.Stdout, "autoload -U +X bashcompinit && bashcompinit")
+		writeBashCompletion(os.Stdout)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	default:
+		return classify(errUsage, fmt.Errorf("unknown shell %q, expected bash, zsh or fish", args[0]))
+	}
+	return nil
+}
+
+// writeBashCompletion writes the bash completion script. It finds the
+// command and subcommand typed so far, skipping flags and their values, and
+// completes flags, commands, or values of the flags in dynamicValues; other
+// values fall back to file names.
+func writeBashCompletion(w io.Writer) {
+	flagCase := func(fn string, pick func(all, bools []string) []string) {
+		fmt.Fprintf(w, "%s() {\n\tcase \"$1\" in\n", fn)
+		all, bools := flagNames(commandFlags())
+		fmt.Fprintf(w, "\t\"\") echo %q ;;\n", strings.Join(pick(all, bools), " "))
+		for _, p := range commandPaths() {
+			all, bools := flagNames(commandFlags(p...))
+			fmt.Fprintf(w, "\t%q) echo %q ;;\n", p.String(), strings.Join(pick(all, bools), " "))
+		}
+		fmt.Fprint(w, "\tesac\n}\n\n")
+	}
+	fmt.Fprint(w, "# bash completion for dbx, from `dbx completion bash`.\n\n")
+	flagCase("_dbx_flags", func(all, _ []string) []string { return all })
+	flagCase("_dbx_bool_flags", func(_, bools []string) []string { return bools })
+
+	names := commandNames()
+	fmt.Fprint(w, "_dbx_subcommands() {\n\tcase \"$1\" in\n")
+	for _, name := range names {
+		if subs := commandDocs[name].subcommands; len(subs) > 0 {
+			fmt.Fprintf(w, "\t%s) echo %q ;;\n", name, strings.Join(subs, " "))
+		}
+	}
+	fmt.Fprint(w, "\tesac\n}\n\n")
+
+	fmt.Fprintf(w, `_dbx() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local cmd="" key="" value=0 i word
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		word=${COMP_WORDS[i]}
+		if ((value)); then
+			value=0
+		elif [[ $word == -* ]]; then
+			word=${word#-}
+			[[ $word == *=* || " $(_dbx_bool_flags "$key") " == *" -${word#-} "* ]] || value=1
+		elif [[ -z $cmd ]]; then
+			cmd=$word key=$word
+		elif [[ $key == "$cmd" && -n $(_dbx_subcommands "$cmd") ]]; then
+			key="$cmd $word"
+		fi
+	done
+
+	if ((value)); then
+		case $prev in
+%s		esac
+		return
+	fi
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "$(_dbx_flags "$key")" -- "$cur"))
+	elif [[ -z $cmd ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+	elif [[ $key == "$cmd" ]]; then
+		COMPREPLY=($(compgen -W "$(_dbx_subcommands "$cmd")" -- "$cur"))
+	fi
+}
+
+complete -o default -F _dbx dbx
+`, bashDynamicCases(), strings.Join(names, " "))
+}
+
+func bashDynamicCases() string {
+	flags := make([]string, 0, len(dynamicValues))
+	for f := range dynamicValues {
+		flags = append(flags, f)
+	}
+	sort.Strings(flags)
+	var b strings.Builder
+	for _, f := range flags {
+		fmt.Fprintf(&b, "\t\t%s|-%s) COMPREPLY=($(compgen -W \"$(dbx %s %s 2>/dev/null)\" -- \"$cur\")) ;;\n",
+			f, f, completeCommand, dynamicValues[f])
+	}
+	return b.String()
+}
+
+// writeFishCompletion writes the fish completion script.
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprint(w, "# fish completion for dbx, from `dbx completion fish`.\n\n")
+	fmt.Fprint(w, "complete -c dbx -f\n")
+
+	for _, name := range commandNames() {
+		fmt.Fprintf(w, "complete -c dbx -n __fish_use_subcommand -a %s -d %s\n", name, fishQuote(commandDocs[name].summary))
+		for _, sub := range commandDocs[name].subcommands {
+			fmt.Fprintf(w, "complete -c dbx -n '__fish_seen_subcommand_from %s; and not __fish_seen_subcommand_from %s' -a %s\n",
+				name, strings.Join(commandDocs[name].subcommands, " "), sub)
+		}
+	}
+
+	writeFlags := func(cond string, fs *flag.FlagSet) {
+		if fs == nil {
+			return
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "complete -c dbx -n %s -o %s", fishQuote(cond), f.Name)
+			if !isBoolFlag(f) {
+				if kind, ok := dynamicValues["-"+f.Name]; ok {
+					fmt.Fprintf(w, " -x -a '(dbx %s %s 2>/dev/null)'", completeCommand, kind)
+				} else {
+					fmt.Fprint(w, " -r -F")
+				}
+			}
+			fmt.Fprintf(w, " -d %s\n", fishQuote(firstSentence(f.Usage)))
+		})
+	}
+	fmt.Fprintln(w)
+	writeFlags("__fish_use_subcommand", commandFlags())
+	for _, p := range commandPaths() {
+		cond := "__fish_seen_subcommand_from " + p[0]
+		if len(p) > 1 {
+			cond += "; and __fish_seen_subcommand_from " + p[1]
+		}
+		writeFlags(cond, commandFlags(p...))
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// firstSentence cuts a flag usage down to a completion description.
+func firstSentence(s string) string {
+	for _, sep := range []string{". ", "; ", " (", ", e.g."} {
+		if i := strings.Index(s, sep); i > 0 {
+			s = s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the dbX configuration file, ~/.dbx/config.yaml unless -config
+// or $DBX_CONFIG names another. It holds the named exports run by
+// `dbx export -named`, each a pipeline like those read by `dbx run`:
+//
+//	exports:
+//	  revenue_daily:
+//	    source:
+//	      query: SELECT day, sum(amount) AS revenue FROM orders GROUP BY day
+//	    sink:
+//	      path: exports/revenue/{{ ts }}.parquet
+//	      partition_by: [day]
+type configFile struct {
+	Exports map[string]*pipelineFile `yaml:"exports"`
+	// Locations are the directories `dbx datasets` scans for the manifests
+	// of exported datasets.
+	Locations []string `yaml:"locations"`
+}
+
+// defaultConfigPath returns the configuration file location, $DBX_CONFIG or
+// ~/.dbx/config.yaml.
+func defaultConfigPath() string {
+	if path := os.Getenv("DBX_CONFIG"); path != "" {
+		return path
+	}
+	return dbxPath("config.yaml")
+}
+
+// loadConfig reads the configuration file at path. A missing file is an
+// empty configuration.
+func loadConfig(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &configFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var c configFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	for name, p := range c.Exports {
+		if p == nil {
+			return nil, fmt.Errorf("config %s: export %s is empty", path, name)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: export %s: %w", path, name, err)
+		}
+		p.file = path
+	}
+	return &c, nil
+}
+
+// runExport implements `dbx export -named <name>` and `dbx export -list`.
+func runExport(cfg config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	named := fs.String("named", "", "Run the export of this name from the config file")
+	list := fs.Bool("list", false, "List the named exports of the config file")
+	output := fs.String("output", "", "Write to this path instead of the export's sink path")
+	force := fs.Bool("force", false, "Run even if the sink's manifest shows it is already up to date")
+	var explain explainMode
+	fs.Var(&explain, "explain", "Print the engine's plan for the source query instead of running; -explain=before prints it and then runs")
+	var params stringList
+	fs.Var(&params, "param", "Bind a query placeholder as name[:type]=value, overriding the export's params (repeatable)")
+	var vars stringList
+	fs.Var(&vars, "var", "Set a template variable as name=value, overriding the export's vars (repeatable)")
+	parseFlags(fs, args)
+
+	c, err := loadConfig(cfg.configPath)
+	if err != nil {
+		return err
+	}
+	if *list {
+		names := make([]string, 0, len(c.Exports))
+		for name := range c.Exports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if cfg.json {
+			printJSON(names)
+			return nil
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	if *named == "" {
+		return fmt.Errorf("-named or -list is required")
+	}
+	p, ok := c.Exports[*named]
+	if !ok {
+		return classify(errUsage, fmt.Errorf("no export named %q in %s", *named, cfg.configPath))
+	}
+	if *output != "" {
+		p.Sink.Path = *output
+	}
+	p.Source.Params = append(p.Source.Params, params...)
+	if p.Vars, err = parseVars(p.Vars, vars); err != nil {
+		return err
+	}
+	return p.run(cfg, *named, time.Now(), *force, explain)
+}
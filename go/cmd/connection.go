@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+)
+
+// connOptions describes how to reach the database.
+type connOptions struct {
+	// Driver is the path to the ADBC driver shared library, or the short
+	// name of one to find, such as postgresql. Empty means find the driver
+	// for the engine of URI.
+	Driver string
+	// SQLDriver names a registered database/sql driver to use instead of
+	// ADBC. Rows are converted to Arrow record batches internally.
+	SQLDriver string
+	// URI is the connection string handed to the driver.
+	URI string
+	// Auth authenticates with Kerberos or a cloud IAM token instead of a
+	// password in URI; see authMethods.
+	Auth string
+	// SSH, user@host[:port], is a jump host to reach the database at URI
+	// through, logging in with the private key at SSHKey if set.
+	SSH    string
+	SSHKey string
+	// Proxy is the URL of a SOCKS5 or HTTP proxy to reach the database,
+	// or the SSH jump host, through.
+	Proxy string
+	// Engine selects the SQL dialect used to quote identifiers. Empty means
+	// detect it from SQLDriver or the URI scheme.
+	Engine string
+	// IdentifierCase folds table and column names given by the user before
+	// they are quoted: "preserve" (the default), "lower" or "upper".
+	IdentifierCase string
+	// ReadBatchRows asks the driver for record batches of this many rows,
+	// where it supports setting that. Zero leaves the driver default.
+	ReadBatchRows int
+	// WriteBatchRows slices records into batches of at most this many rows
+	// before they are written to a sink or bound for ingest, and is the
+	// read batch size asked of drivers when ReadBatchRows is zero. Zero
+	// passes records through as the driver or file reader produced them.
+	WriteBatchRows int
+	// Replicas are URIs of read replicas of the database at URI, which
+	// exports read from in preference to it.
+	Replicas []string
+	// MaxReplicaLag is how far behind the primary a replica may be for
+	// exports to read from it; zero skips the check.
+	MaxReplicaLag time.Duration
+	// Session holds SQL statements run on every connection right after it
+	// opens, such as SET work_mem = '512MB'. Each entry may hold several
+	// statements separated by semicolons.
+	Session []string
+	// StatementTimeout, if set, caps the run time of each statement, set
+	// per connection in the engine's own terms.
+	StatementTimeout time.Duration
+	// Job names the pipeline, job or command the connection is for, in
+	// the application name dbX reports to the database.
+	Job string
+	// Tags are key=value pairs attached to every query as a trailing SQL
+	// comment, so they show in the database's activity views and logs.
+	Tags []string
+}
+
+// applicationName is the name dbX identifies its connections by:
+// dbx/<version>/<job>, within Postgres's 63-byte limit.
+func (o connOptions) applicationName() string {
+	name := "dbx/" + version
+	if o.Job != "" {
+		name += "/" + o.Job
+	}
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// parseTags validates -tag values of the form key=value.
+func parseTags(values []string) ([]string, error) {
+	for _, v := range values {
+		if k, _, ok := strings.Cut(v, "="); !ok || k == "" {
+			return nil, fmt.Errorf("invalid tag %q, use key=value", v)
+		}
+	}
+	return values, nil
+}
+
+// tagged returns query with the application name and o.Tags appended as a
+// comment in the sqlcommenter style: /* application='dbx/1.2', team='data' */.
+func (o connOptions) tagged(query string) string {
+	if len(o.Tags) == 0 {
+		return query
+	}
+	escape := strings.NewReplacer("'", `\'`, "*/", "* /")
+	pairs := []string{"application='" + escape.Replace(o.applicationName()) + "'"}
+	for _, t := range o.Tags {
+		k, v, _ := strings.Cut(t, "=")
+		pairs = append(pairs, escape.Replace(k)+"='"+escape.Replace(v)+"'")
+	}
+	// A newline ends any trailing -- comment of the query.
+	return query + "\n/* " + strings.Join(pairs, ", ") + " */"
+}
+
+// dialect returns the SQL dialect of the database.
+func (o connOptions) dialect() dialect {
+	engine := o.Engine
+	if engine == "" {
+		engine = detectEngine(o)
+	}
+	if d, err := lookupDialect(engine); err == nil {
+		return d
+	}
+	return ansiDialect
+}
+
+// ident folds a user-supplied identifier per o.IdentifierCase, without
+// quoting it, for APIs such as ADBC ingest that take raw names.
+func (o connOptions) ident(name string) string {
+	return foldIdent(name, o.IdentifierCase)
+}
+
+// quoteIdent folds and quotes a column name for use in SQL text.
+func (o connOptions) quoteIdent(name string) string {
+	return o.dialect().quoteIdent(o.ident(name))
+}
+
+// table parses and folds a user-supplied table name.
+func (o connOptions) table(name string) (tableIdent, error) {
+	t, err := parseTableIdent(name)
+	if err != nil {
+		return tableIdent{}, classify(errUsage, err)
+	}
+	return t.fold(o.IdentifierCase), nil
+}
+
+// quoteTable parses, folds and quotes a table name for use in SQL text.
+func (o connOptions) quoteTable(name string) (string, error) {
+	t, err := o.table(name)
+	if err != nil {
+		return "", err
+	}
+	return t.quote(o.dialect()), nil
+}
+
+// connection is an open ADBC connection together with the database it was
+// opened from, so both are released by Close, and the options it was opened
+// with.
+type connection struct {
+	adbc.Connection
+	db   adbc.Database
+	opts connOptions
+}
+
+func (c *connection) Close() error {
+	err := c.Connection.Close()
+	if dbErr := c.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// pgxFallbackOnce warns of the pgx fallback only for the first connection.
+var pgxFallbackOnce sync.Once
+
+// openConnection opens a connection using the ADBC driver manager, or the
+// database/sql fallback when opts.SQLDriver is set.
+func openConnection(ctx context.Context, opts connOptions) (_ *connection, err error) {
+	ctx, span := startSpan(ctx, "connect", attribute.String("db.driver", opts.Driver), attribute.String("db.sql_driver", opts.SQLDriver))
+	defer func() { endSpan(span, err) }()
+
+	uri, err := authURI(ctx, opts)
+	if err == nil {
+		uri, err = tunnelURI(ctx, opts, uri)
+	}
+	if err != nil {
+		return nil, classify(errConnection, err)
+	}
+	drv := driverManager()
+	dbOpts := map[string]string{adbc.OptionKeyURI: uri}
+	if opts.SQLDriver != "" {
+		drv = sqlDriver{name: opts.SQLDriver}
+	} else {
+		path, entrypoint, err := resolveDriver(opts)
+		if err != nil {
+			return nil, err
+		}
+		dbOpts["driver"] = path
+		if entrypoint != "" {
+			dbOpts["entrypoint"] = entrypoint
+		}
+	}
+
+	db, err := drv.NewDatabase(dbOpts)
+	if err != nil && opts.SQLDriver == "" && opts.Driver == "" && opts.dialect().name == "postgres" && driverLoadFailed(err) {
+		pgxFallbackOnce.Do(func() {
+			logger(ctx).Warn("ADBC Postgres driver not available, falling back to pgx", "err", err)
+		})
+		opts.SQLDriver = "pgx"
+		db, err = sqlDriver{name: opts.SQLDriver}.NewDatabase(map[string]string{adbc.OptionKeyURI: uri})
+	}
+	if err != nil {
+		return nil, classify(errConnection, fmt.Errorf("failed to create ADBC database: %w", err))
+	}
+
+	cnxn, err := db.Open(ctx)
+	if err != nil {
+		db.Close()
+		return nil, classify(errConnection, fmt.Errorf("failed to open ADBC connection: %w", err))
+	}
+	c := &connection{Connection: cnxn, db: db, opts: opts}
+	if err := c.applySession(ctx); err != nil {
+		c.Close()
+		return nil, classify(errConnection, err)
+	}
+	return c, nil
+}
+
+// applySession runs the session statements of c's options.
+func (c *connection) applySession(ctx context.Context) error {
+	var stmts []string
+	if set := c.opts.dialect().applicationName; set != nil {
+		stmts = append(stmts, set(c.opts.applicationName()))
+	}
+	if c.opts.StatementTimeout > 0 {
+		set := c.opts.dialect().statementTimeout
+		if set == nil {
+			return classify(errUsage, fmt.Errorf("statement timeouts are not supported for %s", c.opts.dialect().name))
+		}
+		stmts = append(stmts, set(c.opts.StatementTimeout))
+	}
+	for _, s := range c.opts.Session {
+		stmts = append(stmts, splitStatements(s)...)
+	}
+	for _, stmt := range stmts {
+		if err := execUpdate(ctx, c, stmt); err != nil {
+			return fmt.Errorf("failed to apply session settings: %w", err)
+		}
+	}
+	return nil
+}
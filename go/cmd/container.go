@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Containers are configured through the environment and mounted files
+// rather than command lines, so every flag can also be set as
+//
+//	DBX_<FLAG>            e.g. DBX_URI for -uri
+//	DBX_<FLAG>_FILE       the contents of a file, e.g. a mounted secret
+//
+// with subcommand flags prefixed by the command, as DBX_SERVE_HTTP_LISTEN
+// for `dbx serve http -listen`. Flags given on the command line win. In a
+// _FILE, a repeatable flag takes one value per non-empty line.
+
+// parseFlags parses args into fs, then sets the flags args left unset from
+// the environment. Like fs.Parse with flag.ExitOnError, it exits on errors.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if describeFlags != nil {
+		describeFlags(fs)
+		panic(flagsDescribed{})
+	}
+	fs.Parse(args)
+	if err := applyEnv(fs); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		os.Exit(2)
+	}
+}
+
+// applyEnv sets the flags of fs not set on the command line from their
+// DBX_ environment variables.
+func applyEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := envName(fs, f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid %s: %w", name, e)
+			}
+			return
+		}
+		path, ok := os.LookupEnv(name + "_FILE")
+		if !ok {
+			return
+		}
+		data, e := os.ReadFile(path)
+		if e != nil {
+			err = fmt.Errorf("failed to read %s_FILE: %w", name, e)
+			return
+		}
+		values := []string{strings.TrimRight(string(data), "\r\n")}
+		if _, repeatable := f.Value.(*stringList); repeatable {
+			values = strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' || r == '\r' })
+		}
+		for _, v := range values {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid %s_FILE: %w", name, e)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// envName returns the environment variable of flag name in fs.
+func envName(fs *flag.FlagSet, name string) string {
+	if fs != flag.CommandLine {
+		name = fs.Name() + "_" + name
+	}
+	return "DBX_" + strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+}
+
+// terminationLog is the file the outcome of the command is written to, as
+// Kubernetes reads /dev/termination-log, set with -termination-log.
+var terminationLog string
+
+// terminationMessageLimit is the most Kubernetes keeps of a termination
+// message.
+const terminationMessageLimit = 4096
+
+// writeTermination writes v as JSON to the termination log, if there is
+// one, cut to what Kubernetes keeps.
+func writeTermination(v any) {
+	if terminationLog == "" {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if len(data) > terminationMessageLimit {
+		data = data[:terminationMessageLimit]
+	}
+	os.WriteFile(terminationLog, data, 0o644)
+}
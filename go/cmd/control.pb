@@ -0,0 +1,54 @@
+
+	
+control.protodbx.v1"
+ExportRequest
+table (	Rtable
+query (	Rquery
+output (	Routput
+format (	Rformat 
+compression (	Rcompression
+force (Rforce
+params (	Rparams"
+ImportRequest
+file (	Rfile
+table (	RtableC
+
+column_map (2$.dbx.v1.ImportRequest.ColumnMapEntryR	columnMap<
+ColumnMapEntry
+key (	Rkey
+value (	Rvalue:8")
+GetStatusRequest
+job_id (	RjobId"
+Job
+id (	Rid
+kind (	Rkind
+state (	Rstate
+error (	Rerror!
+rows_written (RrowsWritten
+location (	Rlocation!
+output_bytes (RoutputBytes
+
+created_at (	R	createdAt
+
+updated_at	 (	R	updatedAt
+result_json
+ (	R
+resultJson"B
+StreamLogsRequest
+job_id (	RjobId
+follow (Rfollow"
+LogEntry
+time (	Rtime
+level (	Rlevel
+message (	Rmessage1
+attrs (2.dbx.v1.LogEntry.AttrsEntryRattrs8
+
+AttrsEntry
+key (	Rkey
+value (	Rvalue:82
+Control1
+StartExport.dbx.v1.ExportRequest.dbx.v1.Job1
+StartImport.dbx.v1.ImportRequest.dbx.v1.Job2
+	GetStatus.dbx.v1.GetStatusRequest.dbx.v1.Job;
+
+StreamLogs.dbx.v1.StreamLogsRequest.dbx.v1.LogEntry0BZgithub.com/TFMV/dbX/go/dbxv1bproto3
\ No newline at end of file
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/decimal256"
+)
+
+// cursorTracker keeps the largest value seen in a column so an incremental
+// export can resume after the last row it exported.
+type cursorTracker struct {
+	column string
+	index  int
+	max    any
+}
+
+// newCursorTracker tracks column of schema, which must be of a type whose
+// values can be ordered: a number, a string, a date or a timestamp.
+func newCursorTracker(schema *arrow.Schema, column string) (*cursorTracker, error) {
+	indices := schema.FieldIndices(column)
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("cursor column %q not found in result", column)
+	}
+	switch dt := schema.Field(indices[0]).Type; dt.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64, arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+		arrow.FLOAT32, arrow.FLOAT64, arrow.DECIMAL128, arrow.DECIMAL256,
+		arrow.STRING, arrow.LARGE_STRING, arrow.TIMESTAMP, arrow.DATE32, arrow.DATE64, arrow.NULL:
+	default:
+		return nil, fmt.Errorf("cursor column %q is of type %s, whose values cannot be ordered", column, dt)
+	}
+	return &cursorTracker{column: column, index: indices[0]}, nil
+}
+
+// observe updates the tracked maximum from a record.
+func (c *cursorTracker) observe(rec arrow.Record) {
+	col := rec.Column(c.index)
+	for i := 0; i < col.Len(); i++ {
+		v := c.value(col, i)
+		if v != nil && (c.max == nil || cursorLess(c.max, v)) {
+			c.max = v
+		}
+	}
+}
+
+// value returns row i of col, the cursor column, as it is compared.
+func (c *cursorTracker) value(col arrow.Array, i int) any {
+	if col.IsNull(i) {
+		return nil
+	}
+	switch col := col.(type) {
+	case *array.Decimal128:
+		return decimalValue{decimal256.FromDecimal128(col.Value(i)), col.DataType().(*arrow.Decimal128Type).Scale}
+	case *array.Decimal256:
+		return decimalValue{col.Value(i), col.DataType().(*arrow.Decimal256Type).Scale}
+	}
+	return arrowValue(col, i)
+}
+
+// decimalValue is a decimal cursor, compared as a number rather than as
+// its text, which would put 9 after 10.
+type decimalValue struct {
+	n     decimal256.Num
+	scale int32
+}
+
+// literal returns the tracked maximum as a SQL literal, or "" if no rows were
+// seen.
+func (c *cursorTracker) literal() string {
+	switch v := c.max.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case decimalValue:
+		return v.n.ToString(v.scale)
+	case time.Time:
+		return quoteLiteral(v.UTC().Format(time.RFC3339Nano))
+	default:
+		return quoteLiteral(asString(v))
+	}
+}
+
+func cursorLess(a, b any) bool {
+	switch a := a.(type) {
+	case int64:
+		return a < b.(int64)
+	case uint64:
+		return a < b.(uint64)
+	case float64:
+		return a < b.(float64)
+	case decimalValue:
+		return a.n.Less(b.(decimalValue).n)
+	case time.Time:
+		return a.Before(b.(time.Time))
+	default:
+		return asString(a) < asString(b)
+	}
+}
+
+// incrementalQuery restricts query to the rows whose cursor column is past
+// the given literal. An empty cursor means the first run, which exports all
+// rows.
+func incrementalQuery(opts connOptions, query, column, cursor string) string {
+	if cursor == "" {
+		return query
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) AS src WHERE %s > %s", query, opts.quoteIdent(column), cursor)
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"gopkg.in/yaml.v3"
+)
+
+// loadedAtFields are the column names taken, in this order, as the
+// loaded_at_field of a table's freshness check when -loaded-at-field is not
+// given.
+var loadedAtFields = []string{"_loaded_at", "loaded_at", "updated_at", "modified_at", "last_modified", "created_at"}
+
+// dbtSources is a dbt sources.yml file.
+type dbtSources struct {
+	Version int         `yaml:"version"`
+	Sources []dbtSource `yaml:"sources"`
+}
+
+type dbtSource struct {
+	Name        string     `yaml:"name"`
+	Description string     `yaml:"description,omitempty"`
+	Tables      []dbtTable `yaml:"tables"`
+}
+
+type dbtTable struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	// Meta holds external_location, which dbt-duckdb reads the table from,
+	// and what dbX knows of the export.
+	Meta          map[string]any `yaml:"meta,omitempty"`
+	LoadedAtField string         `yaml:"loaded_at_field,omitempty"`
+	Freshness     *dbtFreshness  `yaml:"freshness,omitempty"`
+	Columns       []dbtColumn    `yaml:"columns,omitempty"`
+}
+
+type dbtFreshness struct {
+	WarnAfter  *dbtPeriod `yaml:"warn_after,omitempty"`
+	ErrorAfter *dbtPeriod `yaml:"error_after,omitempty"`
+}
+
+type dbtPeriod struct {
+	Count  int    `yaml:"count"`
+	Period string `yaml:"period"`
+}
+
+type dbtColumn struct {
+	Name        string `yaml:"name"`
+	DataType    string `yaml:"data_type,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// runEmit implements `dbx emit dbt-sources`, which describes exported
+// datasets as a dbt source, so dbt projects can select from them without
+// hand-written YAML. The datasets are the files and directories given, or
+// else those of the catalog.
+func runEmit(cfg config, args []string) error {
+	usage := classify(errUsage, fmt.Errorf("usage: dbx emit dbt-sources [flags] [exported files or directories]"))
+	if len(args) == 0 || args[0] != "dbt-sources" {
+		return usage
+	}
+	fs := flag.NewFlagSet("emit dbt-sources", flag.ExitOnError)
+	name := fs.String("name", "dbx", "Name of the dbt source")
+	search := fs.String("search", "", "Describe the datasets of the catalog whose location, source or schema contain this (default all)")
+	loadedAt := fs.String("loaded-at-field", "", "Timestamp column freshness is checked on (default the first of "+strings.Join(loadedAtFields, ", ")+" a table has)")
+	warnAfter := fs.Duration("warn-after", 24*time.Hour, "Freshness after which dbt warns, for tables with a loaded_at_field (0 for none)")
+	errorAfter := fs.Duration("error-after", 0, "Freshness after which dbt fails, for tables with a loaded_at_field (0 for none)")
+	output := fs.String("output", "", "Write the sources file here rather than to stdout, e.g. models/sources.yml")
+	parseFlags(fs, args[1:])
+
+	var datasets []dataset
+	if fs.NArg() > 0 {
+		if *search != "" {
+			return classify(errUsage, fmt.Errorf("-search selects datasets of the catalog, not given paths"))
+		}
+		for _, p := range fs.Args() {
+			location, err := filepath.Abs(p)
+			if err != nil {
+				return err
+			}
+			datasets = append(datasets, dataset{Location: location})
+		}
+	} else {
+		cat, err := openCatalog(cfg.catalog)
+		if err != nil {
+			return err
+		}
+		defer cat.Close()
+		if datasets, err = cat.search(*search); err != nil {
+			return err
+		}
+		if len(datasets) == 0 {
+			return fmt.Errorf("no datasets in the catalog to describe")
+		}
+	}
+
+	src := dbtSource{Name: *name, Description: "Datasets exported by dbX"}
+	names := make(map[string]int)
+	for _, ds := range datasets {
+		t, err := describeDBTTable(ds, *loadedAt, *warnAfter, *errorAfter)
+		if err != nil {
+			return err
+		}
+		// Tables exported to several locations need distinct names.
+		if n := names[t.Name]; n > 0 {
+			names[t.Name]++
+			t.Name = fmt.Sprintf("%s_%d", t.Name, n+1)
+		} else {
+			names[t.Name] = 1
+		}
+		src.Tables = append(src.Tables, t)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return classify(errWrite, fmt.Errorf("failed to create %s: %w", *output, err))
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(dbtSources{Version: 2, Sources: []dbtSource{src}}); err != nil {
+		return classify(errWrite, err)
+	}
+	return enc.Close()
+}
+
+// describeDBTTable describes the dataset ds, with a freshness check on the
+// loadedAt column, or else on a column named like one, if it has one.
+func describeDBTTable(ds dataset, loadedAt string, warnAfter, errorAfter time.Duration) (dbtTable, error) {
+	m, err := readManifest(ds.Location)
+	if err != nil {
+		return dbtTable{}, err
+	}
+	source := ds.Source
+	if source == "" && m != nil && m.Lineage != nil {
+		source = m.Lineage.Table
+	}
+	t := dbtTable{Meta: map[string]any{"external_location": ds.Location}}
+	if source != "" {
+		parts := strings.Split(source, ".")
+		t.Name = parts[len(parts)-1]
+	} else {
+		t.Name = strings.TrimSuffix(filepath.Base(ds.Location), filepath.Ext(ds.Location))
+	}
+	t.Name = avroSafeName(strings.ToLower(t.Name))
+
+	var desc []string
+	if source != "" {
+		desc = append(desc, "Exported from "+source)
+	}
+	if ds.Origin != "" {
+		desc = append(desc, "of "+ds.Origin)
+	}
+	t.Description = strings.Join(desc, " ")
+	rows, exported := ds.Rows, ds.UpdatedAt
+	if m != nil {
+		rows, exported = m.Rows, m.CreatedAt
+	}
+	if !exported.IsZero() {
+		t.Meta["dbx_rows"] = rows
+		t.Meta["dbx_exported_at"] = exported.UTC().Format(time.RFC3339)
+	}
+
+	schema, partitions, err := datasetSchema(ds.Location)
+	if err != nil {
+		return dbtTable{}, err
+	}
+	if partitions {
+		// dbt-duckdb reads a Hive-partitioned directory through a glob.
+		t.Meta["external_location"] = filepath.Join(ds.Location, "**", "*.parquet")
+	}
+	sources := make(map[string]string)
+	if m != nil && m.Lineage != nil {
+		for _, c := range m.Lineage.Columns {
+			sources[c.Name] = c.Source
+		}
+	}
+	if schema != nil {
+		for _, f := range schema.Fields() {
+			c := dbtColumn{Name: f.Name, DataType: duckdbType(f.Type)}
+			if s := sources[f.Name]; s != "" {
+				c.Description = "From " + s
+			}
+			t.Columns = append(t.Columns, c)
+		}
+	} else if m != nil && m.Lineage != nil {
+		// Outputs other than Parquet are described by their manifest.
+		for _, c := range m.Lineage.Columns {
+			col := dbtColumn{Name: c.Name}
+			if c.Source != "" {
+				col.Description = "From " + c.Source
+			}
+			t.Columns = append(t.Columns, col)
+		}
+	}
+
+	candidates := loadedAtFields
+	if loadedAt != "" {
+		candidates = []string{loadedAt}
+	}
+	for _, name := range candidates {
+		if schema != nil && len(schema.FieldIndices(name)) > 0 {
+			t.LoadedAtField = name
+			break
+		}
+	}
+	if t.LoadedAtField != "" && (warnAfter > 0 || errorAfter > 0) {
+		t.Freshness = &dbtFreshness{WarnAfter: dbtDuration(warnAfter), ErrorAfter: dbtDuration(errorAfter)}
+	}
+	return t, nil
+}
+
+// datasetSchema returns the schema of the Parquet dataset at location, a
+// file or a Hive-partitioned directory, whose partition columns are read as
+// strings. It returns a nil schema for outputs in other formats.
+func datasetSchema(location string) (*arrow.Schema, bool, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot describe %s: %w", location, err)
+	}
+	if !info.IsDir() {
+		if filepath.Ext(location) != ".parquet" {
+			return nil, false, nil
+		}
+		schema, err := parquetSchema(location)
+		return schema, false, err
+	}
+
+	var first string
+	errFound := errors.New("found")
+	err = filepath.WalkDir(location, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".parquet" {
+			first = path
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, false, fmt.Errorf("cannot describe %s: %w", location, err)
+	}
+	if first == "" {
+		return nil, true, nil
+	}
+	schema, err := parquetSchema(first)
+	if err != nil {
+		return nil, true, err
+	}
+	rel, err := filepath.Rel(location, filepath.Dir(first))
+	if err != nil || rel == "." {
+		return schema, true, nil
+	}
+	fields := schema.Fields()
+	for _, seg := range strings.Split(filepath.ToSlash(rel), "/") {
+		if col, _, ok := strings.Cut(seg, "="); ok {
+			fields = append(fields, arrow.Field{Name: col, Type: arrow.BinaryTypes.String, Nullable: true})
+		}
+	}
+	return arrow.NewSchema(fields, nil), true, nil
+}
+
+// dbtDuration returns d in the largest dbt period that holds it whole, or
+// nil for 0.
+func dbtDuration(d time.Duration) *dbtPeriod {
+	switch {
+	case d <= 0:
+		return nil
+	case d%(24*time.Hour) == 0:
+		return &dbtPeriod{Count: int(d / (24 * time.Hour)), Period: "day"}
+	case d%time.Hour == 0:
+		return &dbtPeriod{Count: int(d / time.Hour), Period: "hour"}
+	default:
+		return &dbtPeriod{Count: int((d + time.Minute - 1) / time.Minute), Period: "minute"}
+	}
+}
@@ -0,0 +1,282 @@
+// Package dbxtest helps write integration tests against dbX. It starts
+// database engines in containers (via testcontainers), loads SQL fixtures,
+// and runs the dbx binary to assert that exports and imports round-trip.
+//
+// Tests are skipped when Docker is unavailable. The dbx binary is taken from
+// $DBX_BINARY, or looked up as "dbx" on $PATH.
+//
+//	func TestOrdersRoundTrip(t *testing.T) {
+//		db := dbxtest.Start(t, dbxtest.Postgres)
+//		db.LoadFixture("testdata/orders.sql")
+//		db.RoundTrip("orders")
+//	}
+package dbxtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Engine describes how to run a database and connect to it.
+type Engine struct {
+	Name string
+	// Image is the container image to start. An empty Image means the
+	// engine runs in-process on a file in the test's temporary directory.
+	Image string
+	// Port is the container port to expose, e.g. "5432/tcp".
+	Port string
+	Env  map[string]string
+	// WaitFor decides when the started container accepts connections.
+	WaitFor wait.Strategy
+	// URI returns the connection URI given the mapped host and port, or, for
+	// in-process engines, the database file.
+	URI func(host, port string) string
+	// SQLDriver is the database/sql driver the helpers use to set up and
+	// inspect the database.
+	SQLDriver string
+	// DbxArgs returns the dbx connection flags for uri.
+	DbxArgs func(uri string) []string
+}
+
+// Postgres runs PostgreSQL 16. dbx connects with the ADBC PostgreSQL driver
+// at $DBX_ADBC_POSTGRES_DRIVER, or the dbx default when unset.
+var Postgres = Engine{
+	Name:  "postgres",
+	Image: "postgres:16-alpine",
+	Port:  "5432/tcp",
+	Env: map[string]string{
+		"POSTGRES_USER":     "dbx",
+		"POSTGRES_PASSWORD": "dbx",
+		"POSTGRES_DB":       "dbx",
+	},
+	// The server logs readiness twice: once for the init scripts' temporary
+	// server and once for the real one.
+	WaitFor: wait.ForLog("database system is ready to accept connections").WithOccurrence(2).WithStartupTimeout(time.Minute),
+	URI: func(host, port string) string {
+		return fmt.Sprintf("postgresql://dbx:dbx@%s:%s/dbx?sslmode=disable", host, port)
+	},
+	SQLDriver: "pgx",
+	DbxArgs: func(uri string) []string {
+		args := []string{"-uri", uri}
+		if driver := os.Getenv("DBX_ADBC_POSTGRES_DRIVER"); driver != "" {
+			args = append(args, "-driver", driver)
+		}
+		return args
+	},
+}
+
+// SQLite runs in-process through dbx's database/sql fallback, for tests that
+// need no container.
+var SQLite = Engine{
+	Name: "sqlite",
+	URI: func(dir, _ string) string {
+		return filepath.Join(dir, "dbxtest.db")
+	},
+	SQLDriver: "sqlite3",
+	DbxArgs: func(uri string) []string {
+		return []string{"-sql-driver", "sqlite3", "-uri", uri}
+	},
+}
+
+// Database is a running engine with an open database/sql handle. It is
+// stopped when the test ends.
+type Database struct {
+	Engine Engine
+	URI    string
+	DB     *sql.DB
+
+	t *testing.T
+}
+
+// Start runs engine for the duration of t, skipping t if Docker is not
+// available for container engines.
+func Start(t *testing.T, engine Engine) *Database {
+	t.Helper()
+	ctx := context.Background()
+
+	var uri string
+	if engine.Image == "" {
+		uri = engine.URI(t.TempDir(), "")
+	} else {
+		testcontainers.SkipIfProviderIsNotHealthy(t)
+		c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+			ContainerRequest: testcontainers.ContainerRequest{
+				Image:        engine.Image,
+				ExposedPorts: []string{engine.Port},
+				Env:          engine.Env,
+				WaitingFor:   engine.WaitFor,
+			},
+			Started: true,
+		})
+		if err != nil {
+			t.Fatalf("dbxtest: failed to start %s: %v", engine.Name, err)
+		}
+		t.Cleanup(func() {
+			if err := c.Terminate(context.Background()); err != nil {
+				t.Logf("dbxtest: failed to stop %s: %v", engine.Name, err)
+			}
+		})
+
+		host, err := c.Host(ctx)
+		if err != nil {
+			t.Fatalf("dbxtest: failed to get %s host: %v", engine.Name, err)
+		}
+		port, err := c.MappedPort(ctx, nat.Port(engine.Port))
+		if err != nil {
+			t.Fatalf("dbxtest: failed to get %s port: %v", engine.Name, err)
+		}
+		uri = engine.URI(host, port.Port())
+	}
+
+	db, err := sql.Open(engine.SQLDriver, uri)
+	if err != nil {
+		t.Fatalf("dbxtest: failed to open %s: %v", engine.Name, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatalf("dbxtest: failed to connect to %s: %v", engine.Name, err)
+	}
+	return &Database{Engine: engine, URI: uri, DB: db, t: t}
+}
+
+// Exec runs a statement, failing the test on error.
+func (d *Database) Exec(query string, args ...any) {
+	d.t.Helper()
+	if _, err := d.DB.Exec(query, args...); err != nil {
+		d.t.Fatalf("dbxtest: %s: %v", query, err)
+	}
+}
+
+// LoadFixture runs the SQL statements in the file at path.
+func (d *Database) LoadFixture(path string) {
+	d.t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		d.t.Fatalf("dbxtest: failed to read fixture: %v", err)
+	}
+	if _, err := d.DB.Exec(string(data)); err != nil {
+		d.t.Fatalf("dbxtest: failed to load fixture %s: %v", path, err)
+	}
+}
+
+// Dbx runs the dbx binary against the database with args after the
+// connection flags, and returns its standard output. The test fails if dbx
+// exits with an error.
+func (d *Database) Dbx(args ...string) string {
+	d.t.Helper()
+	bin := os.Getenv("DBX_BINARY")
+	if bin == "" {
+		var err error
+		if bin, err = exec.LookPath("dbx"); err != nil {
+			d.t.Fatalf("dbxtest: dbx binary not found; set DBX_BINARY")
+		}
+	}
+
+	cmd := exec.Command(bin, append(d.Engine.DbxArgs(d.URI), args...)...)
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		d.t.Fatalf("dbxtest: dbx %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String()
+}
+
+// Export exports table to a Parquet file at output.
+func (d *Database) Export(table, output string) {
+	d.t.Helper()
+	d.Dbx("-table", table, "-output", output, "-force")
+}
+
+// Import appends the Parquet file at path to table.
+func (d *Database) Import(path, table string) {
+	d.t.Helper()
+	d.Dbx("import", "-file", path, "-table", table)
+}
+
+// RoundTrip exports table, imports the file into an empty copy of the table
+// and asserts that both hold the same rows.
+func (d *Database) RoundTrip(table string) {
+	d.t.Helper()
+	output := filepath.Join(d.t.TempDir(), table+".parquet")
+	d.Export(table, output)
+
+	copyTable := table + "_roundtrip"
+	d.Exec("DROP TABLE IF EXISTS " + copyTable)
+	d.Exec(fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s WHERE 1 = 0", copyTable, table))
+	d.Import(output, copyTable)
+
+	d.AssertTablesEqual(table, copyTable)
+}
+
+// AssertTablesEqual fails the test unless tables a and b contain the same
+// rows, in any order.
+func (d *Database) AssertTablesEqual(a, b string) {
+	d.t.Helper()
+	rowsA, rowsB := d.rows(a), d.rows(b)
+	if len(rowsA) != len(rowsB) {
+		d.t.Fatalf("dbxtest: %s has %d rows, %s has %d", a, len(rowsA), b, len(rowsB))
+	}
+	for i := range rowsA {
+		if rowsA[i] != rowsB[i] {
+			d.t.Fatalf("dbxtest: %s and %s differ:\n  %s\n  %s", a, b, rowsA[i], rowsB[i])
+		}
+	}
+}
+
+// rows returns every row of table rendered as a string, sorted.
+func (d *Database) rows(table string) []string {
+	d.t.Helper()
+	rows, err := d.DB.Query("SELECT * FROM " + table)
+	if err != nil {
+		d.t.Fatalf("dbxtest: failed to query %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		d.t.Fatalf("dbxtest: failed to read columns of %s: %v", table, err)
+	}
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var out []string
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			d.t.Fatalf("dbxtest: failed to scan %s: %v", table, err)
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			if t, ok := v.(time.Time); ok {
+				v = t.UTC()
+			}
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			fields[i] = fmt.Sprintf("%v", v)
+		}
+		out = append(out, "("+strings.Join(fields, ", ")+")")
+	}
+	if err := rows.Err(); err != nil {
+		d.t.Fatalf("dbxtest: failed to read %s: %v", table, err)
+	}
+	sort.Strings(out)
+	return out
+}
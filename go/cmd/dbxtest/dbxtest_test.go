@@ -0,0 +1,73 @@
+package dbxtest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain builds the dbx binary for the round trips, unless $DBX_BINARY
+// names one.
+func TestMain(m *testing.M) {
+	if os.Getenv("DBX_BINARY") == "" {
+		dir, err := os.MkdirTemp("", "dbxtest")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		bin := filepath.Join(dir, "dbx")
+		if out, err := exec.Command("go", "build", "-o", bin, "..").CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to build dbx: %v\n%s", err, out)
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+		os.Setenv("DBX_BINARY", bin)
+		code := m.Run()
+		os.RemoveAll(dir)
+		os.Exit(code)
+	}
+	os.Exit(m.Run())
+}
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	db := Start(t, SQLite)
+	db.LoadFixture("testdata/orders.sql")
+	db.RoundTrip("orders")
+
+	if got := db.rows("orders_roundtrip"); len(got) != 3 {
+		t.Errorf("the round trip copied %d rows, want 3: %v", len(got), got)
+	}
+}
+
+func TestPostgresRoundTrip(t *testing.T) {
+	db := Start(t, Postgres)
+	db.Exec("CREATE TABLE orders (id bigint NOT NULL, customer text, amount numeric(10, 2), placed_at timestamptz)")
+	db.Exec("INSERT INTO orders VALUES (1, 'acme', 12.5, '2024-01-02 03:04:05Z'), (2, 'globex', NULL, '2024-02-03 04:05:06Z'), (3, NULL, 0.25, NULL)")
+	db.RoundTrip("orders")
+}
+
+// TestRows checks that tables are compared by their rows in any order, and
+// that different rows tell them apart.
+func TestRows(t *testing.T) {
+	db := Start(t, SQLite)
+	db.Exec("CREATE TABLE a (id INTEGER, name TEXT, data BLOB)")
+	db.Exec("INSERT INTO a VALUES (1, 'x', X'6869'), (2, NULL, NULL)")
+	db.Exec("CREATE TABLE b (id INTEGER, name TEXT, data BLOB)")
+	db.Exec("INSERT INTO b VALUES (2, NULL, NULL), (1, 'x', X'6869')")
+	db.Exec("CREATE TABLE c (id INTEGER, name TEXT, data BLOB)")
+	db.Exec("INSERT INTO c VALUES (1, 'y', X'6869'), (2, NULL, NULL)")
+
+	want := "[(1, x, hi) (2, <nil>, <nil>)]"
+	if got := fmt.Sprint(db.rows("a")); got != want {
+		t.Errorf("rows of a = %s, want %s", got, want)
+	}
+	if fmt.Sprint(db.rows("a")) != fmt.Sprint(db.rows("b")) {
+		t.Errorf("a and b differ: %v, %v", db.rows("a"), db.rows("b"))
+	}
+	if fmt.Sprint(db.rows("a")) == fmt.Sprint(db.rows("c")) {
+		t.Errorf("a and c are the same: %v", db.rows("a"))
+	}
+	db.AssertTablesEqual("a", "b")
+}
@@ -0,0 +1,11 @@
+CREATE TABLE orders (
+    id INTEGER NOT NULL,
+    customer TEXT,
+    amount REAL,
+    placed_at TEXT
+);
+
+INSERT INTO orders VALUES
+    (1, 'acme', 12.5, '2024-01-02 03:04:05'),
+    (2, 'globex', NULL, '2024-02-03 04:05:06'),
+    (3, NULL, 0.25, NULL);
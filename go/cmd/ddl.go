@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+// runDDL implements `dbx ddl -file data.parquet -engine postgres`, printing
+// the CREATE TABLE statement for the file's schema so it can be reviewed and
+// adjusted before importing.
+func runDDL(cfg config, args []string) error {
+	fs := flag.NewFlagSet("ddl", flag.ExitOnError)
+	path := fs.String("file", "", "Parquet file whose schema to use")
+	engine := fs.String("engine", "", "Target engine: postgres, sqlite, mysql, duckdb or snowflake (default detected from -uri)")
+	table := fs.String("table", "", "Table name (default the file name without extension)")
+	var types stringList
+	fs.Var(&types, "type", "Override a column type as column=TYPE (repeatable)")
+	parseFlags(fs, args)
+
+	if *path == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if *engine == "" {
+		*engine = cfg.conn.Engine
+	}
+	if *engine == "" {
+		if *engine = detectEngine(cfg.conn); *engine == "" {
+			return fmt.Errorf("-engine is required")
+		}
+	}
+	d, err := lookupDialect(*engine)
+	if err != nil {
+		return err
+	}
+	name := tableIdent{parts: []identPart{{name: strings.TrimSuffix(filepath.Base(*path), filepath.Ext(*path))}}}
+	if *table != "" {
+		if name, err = parseTableIdent(*table); err != nil {
+			return classify(errUsage, err)
+		}
+	}
+
+	overrides := make(map[string]string)
+	for _, t := range types {
+		col, typ, ok := strings.Cut(t, "=")
+		if !ok || col == "" || typ == "" {
+			return fmt.Errorf("invalid -type %q, expected column=TYPE", t)
+		}
+		overrides[col] = typ
+	}
+
+	schema, err := parquetSchema(*path)
+	if err != nil {
+		return err
+	}
+	ddl, err := d.createTableSQL(name, schema, overrides, cfg.conn.IdentifierCase, tableDef{})
+	if err != nil {
+		return err
+	}
+	fmt.Println(ddl + ";")
+	return nil
+}
+
+// parquetSchema returns the Arrow schema of the Parquet file at path.
+func parquetSchema(path string) (*arrow.Schema, error) {
+	pqFile, err := openParquetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer pqFile.Close()
+
+	pqReader, err := pqarrow.NewFileReader(pqFile, pqarrow.ArrowReadProperties{}, allocator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Parquet file reader: %w", err)
+	}
+	schema, err := pqReader.Schema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Parquet schema: %w", err)
+	}
+	return schema, nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A pipeline or named export can deliver its output when it finishes, so
+// small recurring extracts reach the people who read them without a trip to
+// the bucket:
+//
+//	delivery:
+//	  max_size: 5MB
+//	  email:
+//	    smtp: smtp://reports%40example.com@smtp.example.com:587
+//	    from: reports@example.com
+//	    to: [ops@example.com]
+//	  slack:
+//	    channel: C0123456789 # with a bot token in $DBX_SLACK_TOKEN
+//
+// Output files up to max_size together are attached; larger ones are linked
+// by location instead.
+
+const (
+	// defaultDeliveryMaxSize caps the attachments of a delivery without
+	// max_size.
+	defaultDeliveryMaxSize = 10 << 20
+	// deliveryTimeout bounds each email or Slack request, attachments
+	// included.
+	deliveryTimeout = 2 * time.Minute
+	slackAPI        = "https://slack.com/api/"
+)
+
+type pipelineDelivery struct {
+	// MaxSize is the most output, such as 10MB, attached rather than
+	// linked.
+	MaxSize string         `yaml:"max_size"`
+	Email   *emailDelivery `yaml:"email"`
+	Slack   *slackDelivery `yaml:"slack"`
+}
+
+type emailDelivery struct {
+	// SMTP is the server's smtp:// (STARTTLS when offered) or smtps://
+	// URL. Its password, if the URL holds a user without one, is read from
+	// $DBX_SMTP_PASSWORD.
+	SMTP    string   `yaml:"smtp"`
+	From    string   `yaml:"from"`
+	To      []string `yaml:"to"`
+	Subject string   `yaml:"subject"`
+}
+
+// slackDelivery posts to a channel with a bot token, from Token or
+// $DBX_SLACK_TOKEN, which can upload attachments; or to an incoming
+// webhook, which can only link them.
+type slackDelivery struct {
+	Webhook string `yaml:"webhook"`
+	Token   string `yaml:"token"`
+	Channel string `yaml:"channel"`
+}
+
+func (d *pipelineDelivery) validate() error {
+	if d == nil {
+		return nil
+	}
+	if d.MaxSize != "" {
+		if _, err := parseByteSize(d.MaxSize); err != nil {
+			return err
+		}
+	}
+	if d.Email == nil && d.Slack == nil {
+		return fmt.Errorf("email or slack is required")
+	}
+	if e := d.Email; e != nil {
+		u, err := url.Parse(e.SMTP)
+		switch {
+		case err != nil || (u.Scheme != "smtp" && u.Scheme != "smtps") || u.Host == "":
+			return fmt.Errorf("email: smtp must be an smtp:// or smtps:// URL")
+		case e.From == "" || len(e.To) == 0:
+			return fmt.Errorf("email: from and to are required")
+		}
+	}
+	if s := d.Slack; s != nil && (s.Webhook == "") == (s.Channel == "") {
+		return fmt.Errorf("slack: exactly one of webhook or channel is required")
+	}
+	return nil
+}
+
+// check reports missing credentials, before the export runs: a failed
+// delivery is not retried by a run that finds the output up to date.
+func (d *pipelineDelivery) check() error {
+	if d == nil || d.Slack == nil || d.Slack.Webhook != "" {
+		return nil
+	}
+	if d.Slack.token() == "" {
+		return fmt.Errorf("delivery: slack: a bot token is required, in token or $DBX_SLACK_TOKEN")
+	}
+	return nil
+}
+
+// deliver sends the output of export name, described by resp, by email and
+// to Slack.
+func (d *pipelineDelivery) deliver(ctx context.Context, name string, resp *response) error {
+	maxSize := int64(defaultDeliveryMaxSize)
+	if d.MaxSize != "" {
+		maxSize, _ = parseByteSize(d.MaxSize)
+	}
+	attach, link := deliveryFiles(resp, maxSize)
+	summary := fmt.Sprintf("dbX export %s finished: %d rows in %s to %s", name, resp.RowsWritten, formatDuration(resp.Duration), resp.Location)
+	if d.Email != nil {
+		if err := d.Email.send(ctx, summary+deliveryLinks(link, "\n"), attach); err != nil {
+			return fmt.Errorf("failed to email the output of %s: %w", name, err)
+		}
+		slog.Info("Emailed export", "export", name, "to", strings.Join(d.Email.To, ","), "attached", len(attach))
+	}
+	if s := d.Slack; s != nil {
+		var err error
+		if s.Webhook != "" {
+			// Incoming webhooks take text only, so everything is linked.
+			for _, a := range attach {
+				link = append(link, a.outputFile)
+			}
+			attach = nil
+			msg, _ := json.Marshal(map[string]string{"text": summary + deliveryLinks(link, "\n")})
+			err = postWebhook(ctx, s.Webhook, msg)
+		} else {
+			err = s.post(ctx, summary+deliveryLinks(link, "\n"), attach)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to post the output of %s to Slack: %w", name, err)
+		}
+		slog.Info("Posted export to Slack", "export", name, "attached", len(attach))
+	}
+	return nil
+}
+
+// attachment is an output file attached under name, its path within the
+// output so that the parts of a partitioned export stay apart.
+type attachment struct {
+	outputFile
+	name string
+}
+
+// deliveryFiles splits the files of resp into local ones that fit in
+// maxSize together, to attach, and the rest, to link.
+func deliveryFiles(resp *response, maxSize int64) (attach []attachment, link []outputFile) {
+	files := resp.Files
+	if len(files) == 0 {
+		files = resp.Outputs
+	}
+	if len(files) == 0 {
+		files = []outputFile{{Path: resp.Location, Rows: resp.RowsWritten, Bytes: resp.OutputFileSize}}
+	}
+	var total int64
+	for _, f := range files {
+		if fi, err := os.Stat(f.Path); err == nil && fi.Mode().IsRegular() && total+fi.Size() <= maxSize {
+			total += fi.Size()
+			name := filepath.Base(f.Path)
+			if rel, err := filepath.Rel(resp.Location, f.Path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+				name = strings.ReplaceAll(filepath.ToSlash(rel), "/", "_")
+			}
+			attach = append(attach, attachment{f, name})
+			continue
+		}
+		link = append(link, f)
+	}
+	return attach, link
+}
+
+// deliveryLinks lists files by location, each line starting with sep.
+func deliveryLinks(files []outputFile, sep string) string {
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s%s (%d rows, %s)", sep, f.Path, f.Rows, formatBytes(f.Bytes))
+	}
+	return b.String()
+}
+
+// send emails text with attach attached.
+func (e *emailDelivery) send(ctx context.Context, text string, attach []attachment) error {
+	msg, err := e.message(text, attach)
+	if err != nil {
+		return err
+	}
+	u, _ := url.Parse(e.SMTP)
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = map[string]string{"smtp": "587", "smtps": "465"}[u.Scheme]
+	}
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if u.Scheme == "smtps" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if u.Scheme == "smtp" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return err
+			}
+		}
+	}
+	if u.User != nil {
+		pass, ok := u.User.Password()
+		if !ok {
+			pass = os.Getenv("DBX_SMTP_PASSWORD")
+		}
+		if err := c.Auth(smtp.PlainAuth("", u.User.Username(), pass, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message returns the MIME message of text and attach.
+func (e *emailDelivery) message(text string, attach []attachment) ([]byte, error) {
+	subject := e.Subject
+	if subject == "" {
+		subject, _, _ = strings.Cut(text, "\n")
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		e.From, strings.Join(e.To, ", "), mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z), mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(part, strings.ReplaceAll(text, "\n", "\r\n")+"\r\n")
+	for _, f := range attach {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		ctype := mime.TypeByExtension(filepath.Ext(f.name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {ctype},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": f.name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		enc := base64.StdEncoding.EncodeToString(data)
+		for len(enc) > 76 {
+			io.WriteString(part, enc[:76]+"\r\n")
+			enc = enc[76:]
+		}
+		io.WriteString(part, enc+"\r\n")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *slackDelivery) token() string {
+	if s.Token != "" {
+		return s.Token
+	}
+	return os.Getenv("DBX_SLACK_TOKEN")
+}
+
+// post posts text to the channel, with attach uploaded to it.
+func (s *slackDelivery) post(ctx context.Context, text string, attach []attachment) error {
+	token := s.token()
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	if len(attach) == 0 {
+		body, _ := json.Marshal(map[string]string{"channel": s.Channel, "text": text})
+		return slackCall(ctx, token, "chat.postMessage", "application/json", body, nil)
+	}
+	type slackFile struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	var files []slackFile
+	for _, f := range attach {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		var upload struct {
+			UploadURL string `json:"upload_url"`
+			FileID    string `json:"file_id"`
+		}
+		form := url.Values{"filename": {f.name}, "length": {strconv.Itoa(len(data))}}
+		if err := slackCall(ctx, token, "files.getUploadURLExternal", "application/x-www-form-urlencoded", []byte(form.Encode()), &upload); err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, upload.UploadURL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", f.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to upload %s: %s", f.name, resp.Status)
+		}
+		files = append(files, slackFile{ID: upload.FileID, Title: f.name})
+	}
+	body, _ := json.Marshal(map[string]any{"files": files, "channel_id": s.Channel, "initial_comment": text})
+	return slackCall(ctx, token, "files.completeUploadExternal", "application/json", body, nil)
+}
+
+// slackCall calls method of the Slack Web API with body, decoding the
+// response into out if it is not nil.
+func slackCall(ctx context.Context, token, method, contentType string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackAPI+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType+"; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("slack %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("slack %s: %w", method, err)
+	}
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("slack %s: %s", method, resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("slack %s: %s", method, result.Error)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
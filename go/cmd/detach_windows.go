@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// detachedProcAttr starts detached jobs in their own process group so they
+// don't receive the console's Ctrl+C.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+const (
+	processQueryLimitedInformation = 0x1000
+	// stillActive is the exit code GetExitCodeProcess reports for a
+	// process that has not exited.
+	stillActive = 259
+)
+
+// processAlive reports whether a process with the given PID is running.
+// Windows cannot signal a process to probe it, so its exit code is read
+// instead.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Processes of other users cannot be opened, but exist.
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// dialect holds the engine-specific parts of the SQL dbX generates.
+type dialect struct {
+	name string
+	// identQuote is the character delimiting quoted identifiers.
+	identQuote string
+	// explain is the statement prefix returning a query's plan.
+	explain string
+	// deferConstraints is the statement postponing foreign key checks to
+	// the end of the current transaction, or "" if the engine cannot.
+	deferConstraints string
+	// generated is the keyword storing generated columns: STORED or
+	// VIRTUAL.
+	generated string
+	// columnType returns the column type for an Arrow type, or "" if the
+	// engine has no equivalent.
+	columnType func(dt arrow.DataType) string
+	// replicaLag is the query returning how many seconds a replica is
+	// behind its primary, 0 on a primary, or "" if dbX cannot tell.
+	replicaLag string
+	// statementTimeout returns the statement capping the run time of the
+	// session's statements, or is nil if the engine has none.
+	statementTimeout func(d time.Duration) string
+	// applicationName returns the statement naming the session's client
+	// in the database's activity views, or is nil if the engine has none.
+	applicationName func(name string) string
+}
+
+var dialects = map[string]dialect{
+	// Postgres only defers constraints declared DEFERRABLE. MySQL cannot
+	// defer checks, so they are switched off for the session instead.
+	"postgres":  {name: "postgres", identQuote: `"`, explain: "EXPLAIN", deferConstraints: "SET CONSTRAINTS ALL DEFERRED", generated: "STORED", columnType: postgresType, replicaLag: postgresReplicaLag, statementTimeout: postgresStatementTimeout, applicationName: postgresApplicationName},
+	"sqlite":    {name: "sqlite", identQuote: `"`, explain: "EXPLAIN QUERY PLAN", deferConstraints: "PRAGMA defer_foreign_keys = ON", generated: "STORED", columnType: sqliteType},
+	"mysql":     {name: "mysql", identQuote: "`", explain: "EXPLAIN FORMAT=TREE", deferConstraints: "SET FOREIGN_KEY_CHECKS = 0", generated: "STORED", columnType: mysqlType, statementTimeout: mysqlStatementTimeout},
+	"duckdb":    {name: "duckdb", identQuote: `"`, explain: "EXPLAIN", generated: "VIRTUAL", columnType: duckdbType},
+	"snowflake": {name: "snowflake", identQuote: `"`, explain: "EXPLAIN USING TEXT", columnType: snowflakeType, statementTimeout: snowflakeStatementTimeout, applicationName: snowflakeQueryTag},
+}
+
+// postgresReplicaLag is the time since the last transaction replayed from
+// the primary, on a standby.
+const postgresReplicaLag = "SELECT CASE WHEN pg_is_in_recovery() THEN COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) ELSE 0 END::float8"
+
+func postgresStatementTimeout(d time.Duration) string {
+	return fmt.Sprintf("SET statement_timeout = %d", d.Milliseconds())
+}
+
+// mysqlStatementTimeout only limits SELECT statements, the only ones MySQL
+// can time out.
+func mysqlStatementTimeout(d time.Duration) string {
+	return fmt.Sprintf("SET SESSION max_execution_time = %d", d.Milliseconds())
+}
+
+func snowflakeStatementTimeout(d time.Duration) string {
+	return fmt.Sprintf("ALTER SESSION SET STATEMENT_TIMEOUT_IN_SECONDS = %d", int64(math.Ceil(d.Seconds())))
+}
+
+func postgresApplicationName(name string) string {
+	return "SET application_name = " + quoteLiteral(name)
+}
+
+// snowflakeQueryTag tags the session's queries in QUERY_HISTORY, Snowflake
+// having no application name settable per session.
+func snowflakeQueryTag(name string) string {
+	return "ALTER SESSION SET QUERY_TAG = " + quoteLiteral(name)
+}
+
+// ansiDialect is used for engines dbX does not know. It quotes identifiers
+// the standard SQL way and has no type mapping.
+var ansiDialect = dialect{
+	name:       "ansi",
+	identQuote: `"`,
+	explain:    "EXPLAIN",
+	columnType: func(arrow.DataType) string { return "" },
+}
+
+// engineAliases maps URI schemes and driver names to dialect names.
+var engineAliases = map[string]string{
+	"postgresql": "postgres",
+	"pgx":        "postgres",
+	"sqlite3":    "sqlite",
+	"file":       "sqlite",
+}
+
+// lookupDialect returns the dialect for an engine name or alias.
+func lookupDialect(engine string) (dialect, error) {
+	name := strings.ToLower(engine)
+	if alias, ok := engineAliases[name]; ok {
+		name = alias
+	}
+	d, ok := dialects[name]
+	if !ok {
+		return dialect{}, fmt.Errorf("unknown engine %q (known: postgres, sqlite, mysql, duckdb, snowflake)", engine)
+	}
+	return d, nil
+}
+
+// quoteIdent quotes a single identifier, so mixed-case names, reserved words
+// and special characters are passed through literally.
+func (d dialect) quoteIdent(name string) string {
+	return d.identQuote + strings.ReplaceAll(name, d.identQuote, d.identQuote+d.identQuote) + d.identQuote
+}
+
+// foldIdent applies an -identifier-case mode to name.
+func foldIdent(name, mode string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(name)
+	case "upper":
+		return strings.ToUpper(name)
+	default:
+		return name
+	}
+}
+
+// validIdentifierCase checks an -identifier-case flag value.
+func validIdentifierCase(mode string) error {
+	switch mode {
+	case "", "preserve", "lower", "upper":
+		return nil
+	}
+	return fmt.Errorf("invalid identifier case %q, expected preserve, lower or upper", mode)
+}
+
+// detectEngine guesses the engine from the connection options: the
+// database/sql driver name if set, else the URI scheme. It returns "" if
+// neither identifies a known engine.
+func detectEngine(opts connOptions) string {
+	candidates := []string{opts.SQLDriver}
+	if u, err := url.Parse(opts.URI); err == nil {
+		candidates = append(candidates, u.Scheme)
+	}
+	for _, c := range candidates {
+		if d, err := lookupDialect(c); err == nil {
+			return d.name
+		}
+	}
+	return ""
+}
+
+func postgresType(dt arrow.DataType) string {
+	switch t := dt.(type) {
+	case *arrow.BooleanType:
+		return "BOOLEAN"
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Uint8Type:
+		return "SMALLINT"
+	case *arrow.Int32Type, *arrow.Uint16Type:
+		return "INTEGER"
+	case *arrow.Int64Type, *arrow.Uint32Type:
+		return "BIGINT"
+	case *arrow.Uint64Type:
+		return "NUMERIC(20, 0)"
+	case *arrow.Float16Type, *arrow.Float32Type:
+		return "REAL"
+	case *arrow.Float64Type:
+		return "DOUBLE PRECISION"
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("NUMERIC(%d, %d)", t.Precision, t.Scale)
+	case *arrow.Decimal256Type:
+		return fmt.Sprintf("NUMERIC(%d, %d)", t.Precision, t.Scale)
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		return "TEXT"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType, *arrow.BinaryViewType:
+		return "BYTEA"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "DATE"
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return "TIME"
+	case *arrow.TimestampType:
+		if t.TimeZone != "" {
+			return "TIMESTAMPTZ"
+		}
+		return "TIMESTAMP"
+	case *arrow.DurationType, *arrow.MonthDayNanoIntervalType:
+		return "INTERVAL"
+	case *arrow.ListType:
+		if elem := postgresType(t.Elem()); elem != "" {
+			return elem + "[]"
+		}
+	case *arrow.StructType, *arrow.MapType:
+		return "JSONB"
+	}
+	return ""
+}
+
+func sqliteType(dt arrow.DataType) string {
+	switch dt.(type) {
+	case *arrow.BooleanType:
+		return "BOOLEAN"
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Int64Type,
+		*arrow.Uint8Type, *arrow.Uint16Type, *arrow.Uint32Type, *arrow.Uint64Type:
+		return "INTEGER"
+	case *arrow.Float16Type, *arrow.Float32Type, *arrow.Float64Type:
+		return "REAL"
+	case *arrow.Decimal128Type, *arrow.Decimal256Type:
+		return "NUMERIC"
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		return "TEXT"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType, *arrow.BinaryViewType:
+		return "BLOB"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "DATE"
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return "TIME"
+	case *arrow.TimestampType:
+		return "TIMESTAMP"
+	}
+	return ""
+}
+
+func mysqlType(dt arrow.DataType) string {
+	switch t := dt.(type) {
+	case *arrow.BooleanType:
+		return "BOOLEAN"
+	case *arrow.Int8Type:
+		return "TINYINT"
+	case *arrow.Int16Type:
+		return "SMALLINT"
+	case *arrow.Int32Type:
+		return "INT"
+	case *arrow.Int64Type:
+		return "BIGINT"
+	case *arrow.Uint8Type:
+		return "TINYINT UNSIGNED"
+	case *arrow.Uint16Type:
+		return "SMALLINT UNSIGNED"
+	case *arrow.Uint32Type:
+		return "INT UNSIGNED"
+	case *arrow.Uint64Type:
+		return "BIGINT UNSIGNED"
+	case *arrow.Float16Type, *arrow.Float32Type:
+		return "FLOAT"
+	case *arrow.Float64Type:
+		return "DOUBLE"
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("DECIMAL(%d, %d)", t.Precision, t.Scale)
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		return "LONGTEXT"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType, *arrow.BinaryViewType:
+		return "LONGBLOB"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "DATE"
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return "TIME(6)"
+	case *arrow.TimestampType:
+		if t.TimeZone != "" {
+			return "TIMESTAMP(6)"
+		}
+		return "DATETIME(6)"
+	case *arrow.ListType, *arrow.StructType, *arrow.MapType:
+		return "JSON"
+	}
+	return ""
+}
+
+func duckdbType(dt arrow.DataType) string {
+	switch t := dt.(type) {
+	case *arrow.BooleanType:
+		return "BOOLEAN"
+	case *arrow.Int8Type:
+		return "TINYINT"
+	case *arrow.Int16Type:
+		return "SMALLINT"
+	case *arrow.Int32Type:
+		return "INTEGER"
+	case *arrow.Int64Type:
+		return "BIGINT"
+	case *arrow.Uint8Type:
+		return "UTINYINT"
+	case *arrow.Uint16Type:
+		return "USMALLINT"
+	case *arrow.Uint32Type:
+		return "UINTEGER"
+	case *arrow.Uint64Type:
+		return "UBIGINT"
+	case *arrow.Float16Type, *arrow.Float32Type:
+		return "FLOAT"
+	case *arrow.Float64Type:
+		return "DOUBLE"
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("DECIMAL(%d, %d)", t.Precision, t.Scale)
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		return "VARCHAR"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType, *arrow.BinaryViewType:
+		return "BLOB"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "DATE"
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return "TIME"
+	case *arrow.TimestampType:
+		if t.TimeZone != "" {
+			return "TIMESTAMPTZ"
+		}
+		return "TIMESTAMP"
+	case *arrow.DurationType, *arrow.MonthDayNanoIntervalType:
+		return "INTERVAL"
+	case *arrow.ListType:
+		if elem := duckdbType(t.Elem()); elem != "" {
+			return elem + "[]"
+		}
+	}
+	return ""
+}
+
+func snowflakeType(dt arrow.DataType) string {
+	switch t := dt.(type) {
+	case *arrow.BooleanType:
+		return "BOOLEAN"
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Int64Type,
+		*arrow.Uint8Type, *arrow.Uint16Type, *arrow.Uint32Type, *arrow.Uint64Type:
+		return "NUMBER(38, 0)"
+	case *arrow.Float16Type, *arrow.Float32Type, *arrow.Float64Type:
+		return "FLOAT"
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("NUMBER(%d, %d)", t.Precision, t.Scale)
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		return "VARCHAR"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType, *arrow.BinaryViewType:
+		return "BINARY"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "DATE"
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return "TIME"
+	case *arrow.TimestampType:
+		if t.TimeZone != "" {
+			return "TIMESTAMP_TZ"
+		}
+		return "TIMESTAMP_NTZ"
+	case *arrow.ListType:
+		return "ARRAY"
+	case *arrow.StructType, *arrow.MapType:
+		return "OBJECT"
+	}
+	return ""
+}
+
+// createTableSQL returns the CREATE TABLE statement for a table holding
+// records of schema. overrides maps column names to column types used
+// instead of the dialect's mapping, and def adds defaults, generated columns
+// and keys. Identifiers are folded according to identCase and quoted.
+func (d dialect) createTableSQL(table tableIdent, schema *arrow.Schema, overrides map[string]string, identCase string, def tableDef) (string, error) {
+	cols := make([]string, 0, schema.NumFields())
+	for _, f := range schema.Fields() {
+		typ, ok := overrides[f.Name]
+		if !ok {
+			if typ = d.columnType(f.Type); typ == "" {
+				return "", fmt.Errorf("column %s: no %s type for %s; pass -type %s=<type>", f.Name, d.name, f.Type, f.Name)
+			}
+		}
+		col := "\t" + d.quoteIdent(foldIdent(f.Name, identCase)) + " " + typ
+		if expr, ok := def.Generated[f.Name]; ok {
+			if d.generated == "" {
+				return "", fmt.Errorf("column %s: %s has no generated columns", f.Name, d.name)
+			}
+			cols = append(cols, col+" GENERATED ALWAYS AS ("+expr+") "+d.generated)
+			continue
+		}
+		if !f.Nullable {
+			col += " NOT NULL"
+		}
+		if expr, ok := def.Defaults[f.Name]; ok {
+			col += " DEFAULT " + expr
+		}
+		cols = append(cols, col)
+	}
+	for name := range overrides {
+		if !schema.HasField(name) {
+			return "", fmt.Errorf("type override for unknown column %q", name)
+		}
+	}
+	for _, c := range def.clauses(d, identCase) {
+		cols = append(cols, "\t"+c)
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n)", table.fold(identCase).quote(d), strings.Join(cols, ",\n")), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/arrow/flight"
+)
+
+// driftPolicy compares the schema read from the source to the one the
+// previous run recorded in a state file.
+type driftPolicy struct {
+	// State is the file the source schema of the last successful run is
+	// kept in.
+	State string
+	// OnDrift is fail, to stop the export; warn, to log the drift and
+	// export the new schema; or adapt, to keep the output's columns
+	// stable: columns that disappeared are written as nulls, those whose
+	// type changed are cast back to it, and new ones are appended.
+	OnDrift string
+}
+
+// validOnDrift checks an -on-drift value.
+func validOnDrift(mode string) error {
+	switch mode {
+	case "fail", "warn", "adapt":
+		return nil
+	}
+	return fmt.Errorf("invalid -on-drift %q, expected fail, warn or adapt", mode)
+}
+
+// driftEvent is a change of the source schema since the previous run.
+type driftEvent struct {
+	// Kind is added, removed or type_changed.
+	Kind         string    `json:"kind"`
+	Column       string    `json:"column"`
+	Type         string    `json:"type,omitempty"`
+	PreviousType string    `json:"previous_type,omitempty"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+func (e driftEvent) String() string {
+	switch e.Kind {
+	case "added":
+		return fmt.Sprintf("%s added (%s)", e.Column, e.Type)
+	case "removed":
+		return fmt.Sprintf("%s removed (was %s)", e.Column, e.PreviousType)
+	}
+	return fmt.Sprintf("%s changed from %s to %s", e.Column, e.PreviousType, e.Type)
+}
+
+// schemaState is the state file of a driftPolicy.
+type schemaState struct {
+	// Schema describes the columns for people; Arrow holds the schema
+	// itself, base64-encoded IPC, which adapt needs the types of.
+	Schema    []schemaField `json:"schema"`
+	Arrow     []byte        `json:"arrow"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// check compares schema, read from the source, to the state file. It
+// returns the drift found, and with adapt the transform to apply before any
+// others, or an error if the policy is fail. There is no drift on the first
+// run, when there is no state file yet.
+func (p *driftPolicy) check(ctx context.Context, schema *arrow.Schema) ([]driftEvent, transform, error) {
+	if p == nil {
+		return nil, nil, nil
+	}
+	prev, err := p.load()
+	if err != nil || prev == nil {
+		return nil, nil, err
+	}
+	events := detectDrift(prev, schema, time.Now().UTC())
+	if len(events) == 0 {
+		return nil, nil, nil
+	}
+	changes := make([]string, len(events))
+	for i, e := range events {
+		changes[i] = e.String()
+	}
+	switch p.OnDrift {
+	case "fail":
+		return events, nil, classify(errSchemaMismatch, fmt.Errorf("source schema drifted since the last run: %s", strings.Join(changes, "; ")))
+	case "adapt":
+		logger(ctx).Warn("Source schema drifted, adapting to the previous schema", "changes", changes)
+		return events, &adaptTransform{previous: prev}, nil
+	}
+	logger(ctx).Warn("Source schema drifted since the last run", "changes", changes)
+	return events, nil, nil
+}
+
+// load returns the schema recorded in the state file, or nil if there is
+// none.
+func (p *driftPolicy) load() (*arrow.Schema, error) {
+	data, err := os.ReadFile(p.State)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema state: %w", err)
+	}
+	var state schemaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse schema state %s: %w", p.State, err)
+	}
+	schema, err := flight.DeserializeSchema(state.Arrow, allocator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema state %s: %w", p.State, err)
+	}
+	return schema, nil
+}
+
+// save records schema as the one the next run is compared to. With adapt
+// it is the adapted schema, so columns that disappeared stay in it.
+func (p *driftPolicy) save(schema *arrow.Schema) error {
+	if p == nil {
+		return nil
+	}
+	state := schemaState{Schema: describeSchema(schema).Fields, Arrow: flight.SerializeSchema(schema, allocator), UpdatedAt: time.Now().UTC()}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema state: %w", err)
+	}
+	tmp := p.State + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write schema state: %w", err)
+	}
+	if err := os.Rename(tmp, p.State); err != nil {
+		return fmt.Errorf("failed to write schema state: %w", err)
+	}
+	return nil
+}
+
+// detectDrift lists the top-level columns added to, removed from or
+// changed in type between prev and cur, matched by name.
+func detectDrift(prev, cur *arrow.Schema, now time.Time) []driftEvent {
+	var events []driftEvent
+	for _, f := range prev.Fields() {
+		idx := cur.FieldIndices(f.Name)
+		if len(idx) == 0 {
+			events = append(events, driftEvent{Kind: "removed", Column: f.Name, PreviousType: f.Type.String(), DetectedAt: now})
+			continue
+		}
+		if t := cur.Field(idx[0]).Type; !arrow.TypeEqual(t, f.Type) {
+			events = append(events, driftEvent{Kind: "type_changed", Column: f.Name, Type: t.String(), PreviousType: f.Type.String(), DetectedAt: now})
+		}
+	}
+	for _, f := range cur.Fields() {
+		if !prev.HasField(f.Name) {
+			events = append(events, driftEvent{Kind: "added", Column: f.Name, Type: f.Type.String(), DetectedAt: now})
+		}
+	}
+	return events
+}
+
+// adaptTransform conforms records to the previous schema of the source,
+// with the columns it lacked appended.
+type adaptTransform struct {
+	previous *arrow.Schema
+}
+
+func (t *adaptTransform) String() string {
+	return fmt.Sprintf("adapt(%s)", t.previous)
+}
+
+func (t *adaptTransform) outputSchema(in *arrow.Schema) (*arrow.Schema, error) {
+	fields := make([]arrow.Field, 0, t.previous.NumFields())
+	for _, f := range t.previous.Fields() {
+		if idx := in.FieldIndices(f.Name); len(idx) == 0 || in.Field(idx[0]).Nullable {
+			// A column no longer read is all nulls.
+			f.Nullable = true
+		}
+		fields = append(fields, f)
+	}
+	for _, f := range in.Fields() {
+		if !t.previous.HasField(f.Name) {
+			fields = append(fields, f)
+		}
+	}
+	md := in.Metadata()
+	return arrow.NewSchema(fields, &md), nil
+}
+
+func (t *adaptTransform) apply(ctx context.Context, rec arrow.Record) (arrow.Record, error) {
+	schema, _ := t.outputSchema(rec.Schema())
+	cols := make([]arrow.Array, schema.NumFields())
+	defer func() {
+		for _, c := range cols {
+			if c != nil {
+				c.Release()
+			}
+		}
+	}()
+	for i, f := range schema.Fields() {
+		idx := rec.Schema().FieldIndices(f.Name)
+		switch {
+		case len(idx) == 0:
+			cols[i] = array.MakeArrayOfNull(allocator, f.Type, int(rec.NumRows()))
+		case arrow.TypeEqual(rec.Column(idx[0]).DataType(), f.Type):
+			cols[i] = rec.Column(idx[0])
+			cols[i].Retain()
+		default:
+			cast, err := compute.CastArray(ctx, rec.Column(idx[0]), compute.SafeCastOptions(f.Type))
+			if err != nil {
+				return nil, classify(errSchemaMismatch, fmt.Errorf("cannot adapt column %s back to %s: %w", f.Name, f.Type, err))
+			}
+			cols[i] = cast
+		}
+	}
+	return array.NewRecord(schema, cols, rec.NumRows()), nil
+}
@@ -0,0 +1,13 @@
+//go:build !static
+
+package main
+
+import (
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow-adbc/go/adbc/drivermgr"
+)
+
+// driverManager loads ADBC drivers from shared libraries.
+func driverManager() adbc.Driver {
+	return drivermgr.Driver{}
+}
@@ -0,0 +1,30 @@
+//go:build static
+
+package main
+
+// Building with -tags static leaves out the ADBC driver manager, which
+// loads drivers with dlopen, so dbX links into a single static binary:
+//
+//	CGO_ENABLED=1 go build -tags 'static osusergo netgo sqlite_omit_load_extension' \
+//	    -ldflags '-s -w -extldflags "-static"' -o dbx .
+//
+// Such a binary connects through the built-in database/sql drivers only:
+// pgx for Postgres, used automatically, and sqlite3. `dbx query` runs on
+// the embedded SQLite.
+
+import "github.com/apache/arrow-adbc/go/adbc"
+
+// driverManager is a stand-in for the ADBC driver manager that fails to
+// load any driver, the same way a missing library would.
+func driverManager() adbc.Driver {
+	return staticDriverManager{}
+}
+
+type staticDriverManager struct{}
+
+func (staticDriverManager) NewDatabase(map[string]string) (adbc.Database, error) {
+	return nil, adbc.Error{
+		Code: adbc.StatusNotImplemented,
+		Msg:  "[Driver Manager] this is a static build of dbx, which cannot load ADBC drivers; use -sql-driver",
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+)
+
+// adbcDriver is an ADBC driver dbX can find by name.
+type adbcDriver struct {
+	// name is the short name given to -driver, as in the library name
+	// libadbc_driver_<name>.so.
+	name string
+	// library overrides the library's base name, without prefix or suffix.
+	library string
+	// entrypoint is the driver's init function, for libraries not named
+	// after the ADBC convention.
+	entrypoint string
+	// engine is the engine of the URIs the driver connects to by default.
+	engine string
+}
+
+var adbcDrivers = []adbcDriver{
+	{name: "postgresql", engine: "postgres"},
+	{name: "sqlite", engine: "sqlite"},
+	{name: "snowflake", engine: "snowflake"},
+	{name: "flightsql"},
+	{name: "bigquery"},
+	{name: "duckdb", library: "duckdb", entrypoint: "duckdb_adbc_init", engine: "duckdb"},
+}
+
+// libraryFile returns the file name of the driver's shared library on this
+// platform.
+func (d adbcDriver) libraryFile() string {
+	base := d.library
+	if base == "" {
+		base = "adbc_driver_" + d.name
+	}
+	switch runtime.GOOS {
+	case "windows":
+		return base + ".dll"
+	case "darwin":
+		return "lib" + base + ".dylib"
+	}
+	return "lib" + base + ".so"
+}
+
+// driverSearchPath returns the directories searched for driver libraries,
+// in order: $ADBC_DRIVER_PATH, the platform's library path variable, the
+// active conda environment and the standard install locations.
+func driverSearchPath() []string {
+	var dirs []string
+	vars := map[string]string{"windows": "PATH", "darwin": "DYLD_LIBRARY_PATH"}
+	libVar, ok := vars[runtime.GOOS]
+	if !ok {
+		libVar = "LD_LIBRARY_PATH"
+	}
+	for _, v := range []string{"ADBC_DRIVER_PATH", libVar} {
+		dirs = append(dirs, filepath.SplitList(os.Getenv(v))...)
+	}
+	if prefix := os.Getenv("CONDA_PREFIX"); prefix != "" {
+		if runtime.GOOS == "windows" {
+			dirs = append(dirs, filepath.Join(prefix, "Library", "bin"))
+		} else {
+			dirs = append(dirs, filepath.Join(prefix, "lib"))
+		}
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if pf := os.Getenv("ProgramFiles"); pf != "" {
+			dirs = append(dirs, filepath.Join(pf, "ADBC", "bin"))
+		}
+	case "darwin":
+		dirs = append(dirs, "/opt/homebrew/lib", "/usr/local/lib")
+	default:
+		dirs = append(dirs, "/usr/local/lib", "/usr/local/lib64", "/usr/lib/"+linuxMultiarch(), "/usr/lib64", "/usr/lib")
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	return dirs
+}
+
+// linuxMultiarch returns the Debian multiarch directory name of this
+// platform, such as x86_64-linux-gnu.
+func linuxMultiarch() string {
+	arch := map[string]string{"amd64": "x86_64", "arm64": "aarch64", "386": "i386"}[runtime.GOARCH]
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	return arch + "-linux-gnu"
+}
+
+// locate returns the paths of the driver's library in the search path.
+func (d adbcDriver) locate() []string {
+	var found []string
+	seen := make(map[string]bool)
+	for _, dir := range driverSearchPath() {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, d.libraryFile())
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			found = append(found, path)
+		}
+	}
+	return found
+}
+
+// lookupDriver returns the driver with the given short name.
+func lookupDriver(name string) (adbcDriver, bool) {
+	for _, d := range adbcDrivers {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return adbcDriver{}, false
+}
+
+// resolveDriver returns the ADBC driver library to load for opts, and its
+// entrypoint if not the default. opts.Driver is a path, used as is, or a
+// short name such as postgresql; if empty, the driver is chosen from the
+// engine of the URI.
+func resolveDriver(opts connOptions) (path, entrypoint string, err error) {
+	name := opts.Driver
+	if strings.ContainsAny(name, `/\`) {
+		return name, "", nil
+	}
+	if name == "" {
+		engine := opts.Engine
+		if engine == "" {
+			engine = detectEngine(opts)
+		}
+		for _, d := range adbcDrivers {
+			if d.engine != "" && d.engine == engine {
+				name = d.name
+				break
+			}
+		}
+		if name == "" {
+			return "", "", classify(errUsage, fmt.Errorf("cannot tell which ADBC driver connects to %s, give -driver", redactURI(opts.URI)))
+		}
+	}
+	d, ok := lookupDriver(name)
+	if !ok {
+		// Left to the driver manager, which tries the loader's search path.
+		return name, "", nil
+	}
+	if found := d.locate(); len(found) > 0 {
+		return found[0], d.entrypoint, nil
+	}
+	// The loader may still find it, e.g. through ld.so.cache.
+	return d.libraryFile(), d.entrypoint, nil
+}
+
+// probeDriver reports whether the driver library at path loads. A driver
+// that loads but refuses to initialize without a URI still counts.
+func probeDriver(path, entrypoint string) error {
+	opts := map[string]string{"driver": path}
+	if entrypoint != "" {
+		opts["entrypoint"] = entrypoint
+	}
+	db, err := driverManager().NewDatabase(opts)
+	if err == nil {
+		return db.Close()
+	}
+	if !driverLoadFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// driverLoadFailed reports whether err is the driver manager failing to
+// load a driver library, rather than the driver failing.
+func driverLoadFailed(err error) bool {
+	var adbcErr adbc.Error
+	// The driver manager prefixes its own errors, such as failing to load
+	// the library or find its entrypoint.
+	return errors.As(err, &adbcErr) && strings.Contains(adbcErr.Msg, "[Driver Manager]")
+}
+
+// runDrivers implements `dbx drivers list`.
+func runDrivers(cfg config, args []string) error {
+	if len(args) != 1 || args[0] != "list" {
+		return classify(errUsage, fmt.Errorf("usage: dbx drivers list"))
+	}
+
+	type driverStatus struct {
+		Name     string `json:"name"`
+		Path     string `json:"path,omitempty"`
+		Loadable bool   `json:"loadable"`
+		Error    string `json:"error,omitempty"`
+	}
+	var statuses []driverStatus
+	for _, d := range adbcDrivers {
+		found := d.locate()
+		if len(found) == 0 {
+			// Try the loader's own search path too.
+			found = []string{d.libraryFile()}
+		}
+		for _, path := range found {
+			s := driverStatus{Name: d.name, Path: path}
+			if err := probeDriver(path, d.entrypoint); err != nil {
+				s.Error = err.Error()
+				if path == d.libraryFile() {
+					s.Path, s.Error = "", "not found"
+				}
+			} else {
+				s.Loadable = true
+			}
+			statuses = append(statuses, s)
+		}
+	}
+
+	if cfg.json {
+		printJSON(statuses)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DRIVER\tPATH\tSTATUS")
+	for _, s := range statuses {
+		status := "ok"
+		if !s.Loadable {
+			status, _, _ = strings.Cut(s.Error, "\n")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.Path, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nSearched: %s\n", strings.Join(driverSearchPath(), string(os.PathListSeparator)))
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v17/parquet"
+	"github.com/apache/arrow/go/v17/parquet/file"
+)
+
+// Key references name where an encryption key comes from:
+//
+//	file:/path/to/key   the contents of a file
+//	env:NAME            the value of an environment variable
+//	cmd:COMMAND         the output of a shell command, e.g. a KMS CLI
+//	                    call that decrypts a wrapped data key
+//
+// The key itself is 16, 24 or 32 bytes (AES-128, -192 or -256), given raw,
+// hex- or base64-encoded. Parquet files store the reference, never the key,
+// as key metadata, so a reader holding the same key sources can find the
+// keys again.
+
+// resolveKey returns the key named by the reference ref.
+func resolveKey(ref string) ([]byte, error) {
+	kind, arg, ok := strings.Cut(ref, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid key reference %q, use file:PATH, env:NAME or cmd:COMMAND", ref)
+	}
+	var (
+		raw []byte
+		err error
+	)
+	switch kind {
+	case "file":
+		raw, err = os.ReadFile(arg)
+	case "env":
+		v, ok := os.LookupEnv(arg)
+		if !ok {
+			err = fmt.Errorf("environment variable %s is not set", arg)
+		}
+		raw = []byte(v)
+	case "cmd":
+		var stderr bytes.Buffer
+		c := exec.Command("sh", "-c", arg)
+		c.Stderr = &stderr
+		if raw, err = c.Output(); err != nil && stderr.Len() > 0 {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+	default:
+		return nil, fmt.Errorf("invalid key reference %q, use file:PATH, env:NAME or cmd:COMMAND", ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", ref, err)
+	}
+	key, err := decodeKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", ref, err)
+	}
+	return key, nil
+}
+
+// decodeKey decodes key material given as hex, base64 or raw bytes.
+func decodeKey(raw []byte) ([]byte, error) {
+	validLen := func(b []byte) bool { return len(b) == 16 || len(b) == 24 || len(b) == 32 }
+	text := string(bytes.TrimSpace(raw))
+	if b, err := hex.DecodeString(text); err == nil && validLen(b) {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(text); err == nil && validLen(b) {
+		return b, nil
+	}
+	if validLen(raw) {
+		return raw, nil
+	}
+	return nil, fmt.Errorf("key must be 16, 24 or 32 bytes, raw, hex or base64")
+}
+
+// encryptionOptions select Parquet modular encryption of export output.
+type encryptionOptions struct {
+	// FooterKey references the key encrypting the footer, and every column
+	// without a key of its own.
+	FooterKey string
+	// ColumnKeys maps column names to references of the keys encrypting
+	// them, so readers holding only the footer key cannot read them.
+	ColumnKeys map[string]string
+	// PlaintextFooter leaves the footer readable without keys, so tools
+	// can list the schema, but signs it to detect tampering.
+	PlaintextFooter bool
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// parseColumnKeys parses -encrypt-column values of the form column=ref.
+func parseColumnKeys(values []string) (map[string]string, error) {
+	keys := make(map[string]string, len(values))
+	for _, v := range values {
+		col, ref, ok := strings.Cut(v, "=")
+		if !ok || col == "" || ref == "" {
+			return nil, fmt.Errorf("invalid column key %q, use column=ref", v)
+		}
+		keys[col] = ref
+	}
+	return keys, nil
+}
+
+// resolve reads every key referenced by e, once.
+func (e *encryptionOptions) resolve() error {
+	if e.FooterKey == "" {
+		return fmt.Errorf("encryption requires a footer key")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.keys != nil {
+		return nil
+	}
+	keys := make(map[string][]byte)
+	for _, ref := range append([]string{e.FooterKey}, e.columnKeyRefs()...) {
+		if _, ok := keys[ref]; ok {
+			continue
+		}
+		key, err := resolveKey(ref)
+		if err != nil {
+			return err
+		}
+		keys[ref] = key
+	}
+	e.keys = keys
+	return nil
+}
+
+func (e *encryptionOptions) columnKeyRefs() []string {
+	refs := make([]string, 0, len(e.ColumnKeys))
+	for _, ref := range e.ColumnKeys {
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// String describes the key references, not the keys, for fingerprints.
+func (e *encryptionOptions) String() string {
+	cols := make([]string, 0, len(e.ColumnKeys))
+	for col, ref := range e.ColumnKeys {
+		cols = append(cols, col+"="+ref)
+	}
+	sort.Strings(cols)
+	return fmt.Sprintf("footer=%s columns=%s plaintext_footer=%t", e.FooterKey, strings.Join(cols, ","), e.PlaintextFooter)
+}
+
+// writerProperty returns the Parquet writer property encrypting a file.
+// Encryption properties are consumed by the file they encrypt, so every
+// file needs its own.
+func (e *encryptionOptions) writerProperty() parquet.WriterProperty {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	opts := []parquet.EncryptOption{parquet.WithFooterKeyMetadata(e.FooterKey)}
+	if len(e.ColumnKeys) > 0 {
+		cols := make(parquet.ColumnPathToEncryptionPropsMap, len(e.ColumnKeys))
+		for col, ref := range e.ColumnKeys {
+			cols[col] = parquet.NewColumnEncryptionProperties(col,
+				parquet.WithKey(string(e.keys[ref])), parquet.WithKeyMetadata(ref))
+		}
+		opts = append(opts, parquet.WithEncryptedColumns(cols))
+	}
+	if e.PlaintextFooter {
+		opts = append(opts, parquet.WithPlaintextFooter())
+	}
+	return parquet.WithEncryptionProperties(parquet.NewFileEncryptionProperties(string(e.keys[e.FooterKey]), opts...))
+}
+
+// keyRetriever finds the keys of encrypted Parquet files from the key
+// references stored as their key metadata.
+type keyRetriever struct {
+	mu   sync.Mutex
+	keys map[string]string
+	// err is the first key that could not be read; the Parquet reader
+	// only learns that the key is empty.
+	err error
+}
+
+func (r *keyRetriever) GetKey(keyMetadata []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ref := string(keyMetadata)
+	if key, ok := r.keys[ref]; ok {
+		return key
+	}
+	key, err := resolveKey(ref)
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return ""
+	}
+	r.keys[ref] = string(key)
+	return string(key)
+}
+
+// openParquetFile opens the Parquet file at path, decrypting it if it was
+// written with modular encryption. Keys come from the references in the
+// file's key metadata, so the reader needs the same key sources as the
+// writer had.
+func openParquetFile(path string) (_ *file.Reader, err error) {
+	retriever := &keyRetriever{keys: make(map[string]string)}
+	var pqFile *file.Reader
+	// The Parquet reader panics on keys it cannot find, from the goroutines
+	// reading columns too; every key is looked up here first so that a
+	// missing one is an error instead.
+	defer func() {
+		if r := recover(); r != nil {
+			if pqFile != nil {
+				pqFile.Close()
+			}
+			err = retriever.err
+			if err == nil {
+				err = fmt.Errorf("%v", r)
+			}
+			err = fmt.Errorf("failed to decrypt Parquet file: %w", err)
+		}
+	}()
+	props := parquet.NewReaderProperties(allocator)
+	props.FileDecryptProps = parquet.NewFileDecryptionProperties(parquet.WithKeyRetriever(retriever), parquet.WithPlaintextAllowed())
+	pqFile, err = file.OpenParquetFile(path, false, file.WithReadProps(props))
+	if err != nil {
+		if retriever.err != nil {
+			err = retriever.err
+		}
+		return nil, fmt.Errorf("failed to open Parquet file: %w", err)
+	}
+	if pqFile.NumRowGroups() > 0 {
+		rg := pqFile.MetaData().RowGroup(0)
+		for i := 0; i < rg.NumColumns(); i++ {
+			if _, err := rg.ColumnChunk(i); err != nil {
+				pqFile.Close()
+				return nil, fmt.Errorf("failed to read Parquet metadata: %w", err)
+			}
+		}
+	}
+	return pqFile, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// errorKind classifies failures so wrappers can branch on the process exit
+// code or the "kind" field of -json output instead of parsing messages.
+type errorKind string
+
+const (
+	errUnknown        errorKind = "error"
+	errUsage          errorKind = "usage"
+	errConnection     errorKind = "connection"
+	errSchemaMismatch errorKind = "schema_mismatch"
+	errWrite          errorKind = "write"
+	errCancelled      errorKind = "cancelled"
+)
+
+// exitCodes maps each error kind to the exit status of the dbx process.
+var exitCodes = map[errorKind]int{
+	errUnknown:        1,
+	errUsage:          2,
+	errConnection:     3,
+	errSchemaMismatch: 4,
+	errWrite:          5,
+	errCancelled:      130,
+}
+
+// dbxError attaches an errorKind to an error.
+type dbxError struct {
+	Kind errorKind
+	Err  error
+}
+
+func (e *dbxError) Error() string { return e.Err.Error() }
+func (e *dbxError) Unwrap() error { return e.Err }
+
+// classify wraps err with kind, keeping any classification err already has.
+func classify(kind errorKind, err error) error {
+	var de *dbxError
+	if err == nil || errors.As(err, &de) {
+		return err
+	}
+	return &dbxError{Kind: kind, Err: err}
+}
+
+// kindOf returns the kind of err. Context cancellation counts as cancelled
+// even when it was not classified explicitly.
+func kindOf(err error) errorKind {
+	var de *dbxError
+	switch {
+	case errors.As(err, &de):
+		return de.Kind
+	case errors.Is(err, context.Canceled):
+		return errCancelled
+	default:
+		return errUnknown
+	}
+}
+
+// exitCode returns the process exit status for err.
+func exitCode(err error) int {
+	return exitCodes[kindOf(err)]
+}
+
+// errorReport is the JSON form of a failure, as printed by -json and
+// returned by the HTTP API.
+type errorReport struct {
+	Error    string    `json:"error"`
+	Kind     errorKind `json:"kind"`
+	ExitCode int       `json:"exit_code"`
+}
+
+func newErrorReport(err error) errorReport {
+	return errorReport{Error: err.Error(), Kind: kindOf(err), ExitCode: exitCode(err)}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+)
+
+// execResult reports one statement of `dbx exec`.
+type execResult struct {
+	Index     int           `json:"index"`
+	Statement string        `json:"statement"`
+	Duration  time.Duration `json:"duration"`
+	// Rows is the number of rows a query returned; it is omitted for
+	// statements that return none.
+	Rows *int64 `json:"rows,omitempty"`
+}
+
+// runExec implements `dbx exec`, which runs the statements of a SQL script,
+// such as the DDL a pipeline's exports depend on, in one transaction on the
+// connection of the global flags. Statements are separated by semicolons
+// outside of quotes, dollar quotes and comments. Note that some databases,
+// like MySQL, commit DDL implicitly, so only the statements after the last
+// DDL are rolled back on failure there.
+func runExec(cfg config, args []string) error {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	script := fs.String("script", "", "Path of the SQL script to run, or - for stdin")
+	sql := fs.String("sql", "", "SQL statements to run, instead of -script")
+	noTransaction := fs.Bool("no-transaction", false, "Run each statement on its own, for statements that cannot run in a transaction, such as VACUUM")
+	parseFlags(fs, args)
+
+	if (*script == "") == (*sql == "") || fs.NArg() != 0 {
+		return classify(errUsage, fmt.Errorf("usage: dbx exec -script <file.sql> | -sql <statements> [-no-transaction]"))
+	}
+	text := *sql
+	if *script != "" {
+		var data []byte
+		var err error
+		if *script == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*script)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read script: %w", err)
+		}
+		text = string(data)
+	}
+	stmts := splitStatements(text)
+	if len(stmts) == 0 {
+		return classify(errUsage, fmt.Errorf("the script holds no statements"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cnxn, err := openConnection(ctx, cfg.conn)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	start := time.Now()
+	results, err := execScript(ctx, cnxn, stmts, !*noTransaction, func(r execResult) {
+		if !cfg.json {
+			fmt.Printf("[%d/%d] %s  %s\n", r.Index, len(stmts), formatDuration(r.Duration), statementSummary(r.Statement))
+		}
+	})
+	if aerr := audit.record(ctx, newAuditRecord("exec", cfg.conn.URI, text, start, nil, err)); aerr != nil && err == nil {
+		err = aerr
+	}
+	if err != nil {
+		return err
+	}
+	if cfg.json {
+		printJSON(results)
+		return nil
+	}
+	var total time.Duration
+	for _, r := range results {
+		total += r.Duration
+	}
+	fmt.Printf("Ran %d statements in %s\n", len(results), formatDuration(total))
+	return nil
+}
+
+// execScript runs stmts on cnxn in order, in a transaction committed after
+// the last one unless transaction is false, calling done after each.
+func execScript(ctx context.Context, cnxn *connection, stmts []string, transaction bool, done func(execResult)) (results []execResult, err error) {
+	if transaction {
+		setter, ok := cnxn.Connection.(adbc.PostInitOptions)
+		if !ok {
+			return nil, classify(errUsage, fmt.Errorf("the driver does not support transactions, use -no-transaction"))
+		}
+		if err := setter.SetOption(adbc.OptionKeyAutoCommit, adbc.OptionValueDisabled); err != nil {
+			return nil, fmt.Errorf("failed to start transaction: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				cnxn.Rollback(context.WithoutCancel(ctx))
+				err = fmt.Errorf("%w; the script was rolled back", err)
+			}
+		}()
+	}
+
+	for i, stmt := range stmts {
+		start := time.Now()
+		r := execResult{Index: i + 1, Statement: stmt}
+		if rowStatements[statementKeyword(stmt)] {
+			rows, err := countQueryRows(ctx, cnxn, stmt)
+			if err != nil {
+				return nil, fmt.Errorf("statement %d: %w", i+1, err)
+			}
+			r.Rows = &rows
+		} else if err := execUpdate(ctx, cnxn, stmt); err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		r.Duration = time.Since(start)
+		results = append(results, r)
+		done(r)
+	}
+
+	if transaction {
+		if err := cnxn.Commit(ctx); err != nil {
+			return nil, classify(errWrite, fmt.Errorf("failed to commit script: %w", err))
+		}
+	}
+	return results, nil
+}
+
+// countQueryRows runs query on cnxn and returns how many rows it returned.
+func countQueryRows(ctx context.Context, cnxn *connection, query string) (int64, error) {
+	reader, err := executeQuery(ctx, cnxn, query)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Release()
+	var rows int64
+	for reader.Next() {
+		rows += reader.Record().NumRows()
+	}
+	if err := reader.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return rows, nil
+}
+
+// statementSummary shortens stmt to its first line past any comments, for
+// progress output.
+func statementSummary(stmt string) string {
+	const maxLen = 60
+	line, _, more := strings.Cut(trimLeadingComments(stmt), "\n")
+	line = strings.TrimSpace(line)
+	if len(line) > maxLen {
+		line, more = line[:maxLen], true
+	}
+	if more {
+		line += " ..."
+	}
+	return line
+}
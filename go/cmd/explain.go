@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// explainMode is the value of -explain: "" to export without a plan, "only"
+// to print the plan instead of exporting, or "before" to print it and then
+// export. A bare -explain means "only".
+type explainMode string
+
+func (m *explainMode) String() string { return string(*m) }
+
+func (m *explainMode) Set(v string) error {
+	switch v {
+	case "true", "only":
+		*m = "only"
+	case "false", "":
+		*m = ""
+	case "before":
+		*m = "before"
+	default:
+		return fmt.Errorf("invalid explain mode %q, expected only or before", v)
+	}
+	return nil
+}
+
+func (m *explainMode) IsBoolFlag() bool { return true }
+
+// explainQuery prints the engine's plan for query, with params bound as
+// they would be for the export, to w.
+func explainQuery(ctx context.Context, opts connOptions, query string, params []queryParam, w io.Writer) error {
+	query, bound, err := bindParams(opts, query, params)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	if bound != nil {
+		defer bound.Release()
+	}
+
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	reader, err := executeBound(ctx, cnxn, opts.dialect().explain+" "+query, bound)
+	if err != nil {
+		return fmt.Errorf("failed to explain query: %w", err)
+	}
+	defer reader.Release()
+
+	// Single-column plans (Postgres, MySQL's tree format) are printed as
+	// is; tabular ones with a header.
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	schema := reader.Schema()
+	if schema.NumFields() > 1 {
+		names := make([]string, schema.NumFields())
+		for i, f := range schema.Fields() {
+			names[i] = strings.ToUpper(f.Name)
+		}
+		fmt.Fprintln(tw, strings.Join(names, "\t"))
+	}
+	for reader.Next() {
+		rec := reader.Record()
+		for row := 0; row < int(rec.NumRows()); row++ {
+			cells := make([]string, rec.NumCols())
+			for i, col := range rec.Columns() {
+				if v := arrowValue(col, row); v != nil {
+					cells[i] = asString(v)
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return fmt.Errorf("failed to read query plan: %w", err)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+const (
+	// maxIdleSessions is how many open connections an exporter keeps
+	// between exports.
+	maxIdleSessions = 4
+	// maxPreparedStatements bounds the statements a session keeps prepared.
+	maxPreparedStatements = 32
+)
+
+// exporter runs many exports against one database, reusing open
+// connections and prepared statements between them, so long-running
+// processes such as `dbx serve http` don't pay connection setup for every
+// small export. It is safe for concurrent use: each export runs on its own
+// session (connection), and idle sessions are pooled.
+type exporter struct {
+	opts connOptions
+
+	mu     sync.Mutex
+	idle   []*exportSession
+	closed bool
+}
+
+// exportSession is a connection and the statements prepared on it. It is
+// used by one export at a time.
+type exportSession struct {
+	cnxn *connection
+	// stmts is nil for the session of a single export, which runs its
+	// query without preparing it.
+	stmts map[string]adbc.Statement
+	// order is the preparation order of stmts, oldest first.
+	order []string
+}
+
+func newExporter(opts connOptions) *exporter {
+	return &exporter{opts: opts}
+}
+
+// export runs spec as exportQuery does, on a pooled session.
+func (e *exporter) export(ctx context.Context, spec exportSpec) (*response, error) {
+	return exportWith(ctx, e.opts, spec, func(ctx context.Context) (*exportSession, func(error), error) {
+		s, err := e.acquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func(err error) { e.release(ctx, s, err) }, nil
+	})
+}
+
+// ping checks that the database is reachable, opening a session if none is
+// idle.
+func (e *exporter) ping(ctx context.Context) (err error) {
+	s, err := e.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { e.release(ctx, s, err) }()
+	return s.ping(ctx)
+}
+
+// Close closes every idle session. Sessions in use are closed when their
+// export finishes.
+func (e *exporter) Close() error {
+	e.mu.Lock()
+	idle := e.idle
+	e.idle, e.closed = nil, true
+	e.mu.Unlock()
+
+	var errs []error
+	for _, s := range idle {
+		errs = append(errs, s.close())
+	}
+	return errors.Join(errs...)
+}
+
+func (e *exporter) acquire(ctx context.Context) (*exportSession, error) {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, fmt.Errorf("exporter is closed")
+	}
+	if n := len(e.idle); n > 0 {
+		s := e.idle[n-1]
+		e.idle = e.idle[:n-1]
+		e.mu.Unlock()
+		return s, nil
+	}
+	e.mu.Unlock()
+
+	cnxn, err := openReadConnection(ctx, e.opts)
+	if err != nil {
+		return nil, err
+	}
+	return &exportSession{cnxn: cnxn, stmts: make(map[string]adbc.Statement)}, nil
+}
+
+// release returns s to the pool after a use that ended with err. A session
+// whose use failed is only kept if it still answers a ping, so a dropped
+// connection is replaced rather than handed to the next export.
+func (e *exporter) release(ctx context.Context, s *exportSession, err error) {
+	if err != nil && s.ping(context.WithoutCancel(ctx)) != nil {
+		logger(ctx).Warn("Discarding broken database session", "err", err)
+		s.close()
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed || len(e.idle) >= maxIdleSessions {
+		s.close()
+		return
+	}
+	e.idle = append(e.idle, s)
+}
+
+// query runs query with params bound on the session, on the statement
+// prepared for it if the session keeps them.
+func (s *exportSession) query(ctx context.Context, query string, params arrow.Record) (array.RecordReader, error) {
+	if s.stmts == nil {
+		return executeBound(ctx, s.cnxn, query, params)
+	}
+	stmt, err := s.statement(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return runStatement(ctx, s.cnxn, stmt, query, params)
+}
+
+// statement returns the prepared statement for query, preparing it on first
+// use. Drivers that cannot prepare statements still get the statement reused.
+func (s *exportSession) statement(ctx context.Context, query string) (adbc.Statement, error) {
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := newQueryStatement(ctx, s.cnxn, query)
+	if err != nil {
+		return nil, err
+	}
+	if err := stmt.Prepare(ctx); err != nil {
+		var adbcErr adbc.Error
+		if !errors.As(err, &adbcErr) || adbcErr.Code != adbc.StatusNotImplemented {
+			stmt.Close()
+			return nil, fmt.Errorf("failed to prepare query: %w", err)
+		}
+	}
+
+	if len(s.order) >= maxPreparedStatements {
+		oldest := s.order[0]
+		s.stmts[oldest].Close()
+		delete(s.stmts, oldest)
+		s.order = s.order[1:]
+	}
+	s.stmts[query] = stmt
+	s.order = append(s.order, query)
+	return stmt, nil
+}
+
+// ping runs a trivial query on the session's connection.
+func (s *exportSession) ping(ctx context.Context) error {
+	reader, err := executeQuery(ctx, s.cnxn, "SELECT 1")
+	if err != nil {
+		return classify(errConnection, err)
+	}
+	defer reader.Release()
+	for reader.Next() {
+	}
+	if err := reader.Err(); err != nil {
+		return classify(errConnection, err)
+	}
+	return nil
+}
+
+func (s *exportSession) close() error {
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	return s.cnxn.Close()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// foreignKey is a foreign key constraint: Columns of Child reference
+// RefColumns of Parent, pairwise.
+type foreignKey struct {
+	Name       string
+	Child      tableIdent
+	Columns    []string
+	Parent     tableIdent
+	RefColumns []string
+}
+
+// informationSchemaForeignKeys lists foreign key columns from the standard
+// information_schema views, one row per column pair.
+const informationSchemaForeignKeys = `
+SELECT c.constraint_name, c.table_schema, c.table_name, c.column_name,
+       p.table_schema, p.table_name, p.column_name
+FROM information_schema.referential_constraints rc
+JOIN information_schema.key_column_usage c
+  ON c.constraint_schema = rc.constraint_schema AND c.constraint_name = rc.constraint_name
+JOIN information_schema.key_column_usage p
+  ON p.constraint_schema = rc.unique_constraint_schema AND p.constraint_name = rc.unique_constraint_name
+ AND p.ordinal_position = c.position_in_unique_constraint
+ORDER BY c.table_schema, c.table_name, c.constraint_name, c.ordinal_position`
+
+// sqliteForeignKeys lists foreign key columns in the same shape from SQLite's
+// pragmas, which have no schema or constraint names.
+const sqliteForeignKeys = `
+SELECT m.name || '_fk' || p.id, '', m.name, p."from", '', p."table", p."to"
+FROM sqlite_master m JOIN pragma_foreign_key_list(m.name) p
+WHERE m.type = 'table'
+ORDER BY m.name, p.id, p.seq`
+
+// loadForeignKeys reads every foreign key of the database.
+func loadForeignKeys(ctx context.Context, cnxn *connection) ([]foreignKey, error) {
+	query, err := catalogQuery(cnxn, "foreign keys", map[string]string{
+		"sqlite":   sqliteForeignKeys,
+		"postgres": informationSchemaForeignKeys,
+		"mysql":    informationSchemaForeignKeys,
+		"duckdb":   informationSchemaForeignKeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queryStrings(ctx, cnxn, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+
+	var fks []foreignKey
+	byKey := make(map[string]int)
+	for _, v := range rows {
+		key := v[1] + "\x00" + v[2] + "\x00" + v[0]
+		i, ok := byKey[key]
+		if !ok {
+			i = len(fks)
+			byKey[key] = i
+			fks = append(fks, foreignKey{
+				Name:   v[0],
+				Child:  newTableIdent("", v[1], v[2]),
+				Parent: newTableIdent("", v[4], v[5]),
+			})
+		}
+		fks[i].Columns = append(fks[i].Columns, v[3])
+		fks[i].RefColumns = append(fks[i].RefColumns, v[6])
+	}
+	return fks, nil
+}
+
+// matchTable reports whether the table named by a user, which may leave out
+// the schema, is t.
+func matchTable(name, t tableIdent) bool {
+	if name.table() != t.table() {
+		return false
+	}
+	return name.schema() == "" || name.schema() == t.schema()
+}
+
+// resolveTable finds the table name refers to among the tables of fks. A
+// table that takes part in no foreign key resolves to name itself.
+func resolveTable(name tableIdent, fks []foreignKey) (tableIdent, error) {
+	found := make(map[string]tableIdent)
+	for _, fk := range fks {
+		for _, t := range []tableIdent{fk.Child, fk.Parent} {
+			if matchTable(name, t) {
+				found[t.String()] = t
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return name, nil
+	case 1:
+		for _, t := range found {
+			return t, nil
+		}
+	}
+	return tableIdent{}, fmt.Errorf("table %s is ambiguous: qualify it with its schema (%s)", name, strings.Join(sortedKeys(boolSet(found)), ", "))
+}
+
+func boolSet[V any](m map[string]V) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+// dependencyOrder sorts tables so every table comes after the tables it
+// references, which is the order to load them in. Self-references are
+// ignored. Tables in a reference cycle cannot be ordered; they are appended
+// in name order and returned as cyclic.
+func dependencyOrder(tables []tableIdent, fks []foreignKey) (ordered []tableIdent, cyclic []string) {
+	byName := make(map[string]tableIdent, len(tables))
+	deps := make(map[string]map[string]bool, len(tables))
+	for _, t := range tables {
+		byName[t.String()] = t
+		deps[t.String()] = make(map[string]bool)
+	}
+	for _, fk := range fks {
+		child, parent := fk.Child.String(), fk.Parent.String()
+		if _, ok := byName[parent]; ok && child != parent {
+			if d, ok := deps[child]; ok {
+				d[parent] = true
+			}
+		}
+	}
+
+	for len(deps) > 0 {
+		var ready []string
+		for t, d := range deps {
+			if len(d) == 0 {
+				ready = append(ready, t)
+			}
+		}
+		if len(ready) == 0 {
+			cyclic = sortedKeys(boolSet(deps))
+			for _, t := range cyclic {
+				ordered = append(ordered, byName[t])
+			}
+			break
+		}
+		sort.Strings(ready)
+		for _, t := range ready {
+			ordered = append(ordered, byName[t])
+			delete(deps, t)
+		}
+		for _, d := range deps {
+			for _, t := range ready {
+				delete(d, t)
+			}
+		}
+	}
+	return ordered, cyclic
+}
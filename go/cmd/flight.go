@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/apache/arrow/go/v17/arrow/flight"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// flightServer exposes configured export streams as Arrow Flight endpoints.
+// Each stream is addressed by name, both as the descriptor path and as the
+// ticket, and is read from a fresh connection per request.
+type flightServer struct {
+	flight.BaseFlightServer
+	cfg     config
+	streams map[string]string // stream name to SQL query
+}
+
+// serveFlight implements `dbx serve flight`.
+func serveFlight(cfg config, args []string) error {
+	fs := flag.NewFlagSet("serve flight", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8815", "Address to listen on")
+	var tables, queries stringList
+	fs.Var(&tables, "table", "Table to expose, named after the table (repeatable)")
+	fs.Var(&queries, "query", "Query to expose, as name=SQL (repeatable)")
+	parseFlags(fs, args)
+
+	streams := make(map[string]string)
+	for _, t := range tables {
+		query, err := tableQuery(cfg.conn, t)
+		if err != nil {
+			return err
+		}
+		streams[t] = query
+	}
+	for _, q := range queries {
+		name, query, ok := strings.Cut(q, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid -query %q, expected name=SQL", q)
+		}
+		streams[name] = query
+	}
+	if len(streams) == 0 {
+		return fmt.Errorf("at least one -table or -query is required")
+	}
+
+	srv := flight.NewServerWithMiddleware(nil)
+	srv.RegisterFlightService(&flightServer{cfg: cfg, streams: streams})
+	// The standard gRPC health service, for Kubernetes gRPC probes.
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	if err := srv.Init(*listen); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+	}
+	srv.SetShutdownOnSignals(os.Interrupt, syscall.SIGTERM)
+
+	slog.Info("Serving Flight streams", "streams", len(streams), "addr", srv.Addr().String())
+	return srv.Serve()
+}
+
+func (s *flightServer) ListFlights(_ *flight.Criteria, stream flight.FlightService_ListFlightsServer) error {
+	names := make([]string, 0, len(s.streams))
+	for name := range s.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		info, err := s.flightInfo(stream.Context(), name)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *flightServer) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	if desc.Type != flight.DescriptorPATH || len(desc.Path) != 1 {
+		return nil, status.Error(codes.InvalidArgument, "descriptor must be a path naming one stream")
+	}
+	return s.flightInfo(ctx, desc.Path[0])
+}
+
+func (s *flightServer) flightInfo(ctx context.Context, name string) (*flight.FlightInfo, error) {
+	query, ok := s.streams[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown stream %q", name)
+	}
+
+	cnxn, err := openConnection(ctx, s.cfg.conn)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	defer cnxn.Close()
+
+	schema, err := querySchema(ctx, cnxn, query)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(schema, allocator),
+		FlightDescriptor: &flight.FlightDescriptor{Type: flight.DescriptorPATH, Path: []string{name}},
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: []byte(name)}}},
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}, nil
+}
+
+func (s *flightServer) DoGet(tkt *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+	name := string(tkt.Ticket)
+	query, ok := s.streams[name]
+	if !ok {
+		return status.Errorf(codes.NotFound, "unknown stream %q", name)
+	}
+
+	ctx := stream.Context()
+	cnxn, err := openConnection(ctx, s.cfg.conn)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer cnxn.Close()
+
+	reader, err := executeQuery(ctx, cnxn, query)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer reader.Release()
+
+	w := flight.NewRecordWriter(stream, ipc.WithSchema(reader.Schema()))
+	defer w.Close()
+
+	for reader.Next() {
+		if err := w.Write(reader.Record()); err != nil {
+			return err
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/flight"
+	"github.com/apache/arrow/go/v17/arrow/flight/flightsql"
+	"github.com/apache/arrow/go/v17/arrow/flight/flightsql/schema_ref"
+	"github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// flightSQLServer answers Arrow Flight SQL queries over the Parquet datasets
+// exported under a directory, each a table of an in-memory engine reading
+// it in place, like `dbx query` does. BI tools with a Flight SQL driver can
+// then query exports without loading them back into a warehouse.
+type flightSQLServer struct {
+	flightsql.BaseServer
+	// mu serializes the use of cnxn, which runs one query at a time; it is
+	// held until a query's results are sent.
+	mu     sync.Mutex
+	cnxn   *connection
+	tables map[string]*arrow.Schema
+	// prepared holds the query of each prepared statement handle, which
+	// runs on cnxn like any other: prepared statements have no connection
+	// of their own to confine.
+	prepared sync.Map
+}
+
+// serveFlightSQL implements `dbx serve flightsql -data dir`.
+func serveFlightSQL(cfg config, args []string) error {
+	fs := flag.NewFlagSet("serve flightsql", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8816", "Address to listen on")
+	data := fs.String("data", ".", "Directory scanned for the manifests of exported Parquet datasets to serve")
+	driver := fs.String("duckdb-driver", defaultDuckDBDriver(), "Path to the DuckDB shared library, which provides its ADBC driver")
+	engine := fs.String("query-engine", "auto", "Engine running the SQL: duckdb, sqlite (built in, loading the datasets into memory) or auto, which uses DuckDB if it loads and SQLite otherwise")
+	unrestricted := fs.Bool("allow-unrestricted", false, "Serve even if queries cannot be confined to the datasets, letting clients read and write any file dbx can")
+	parseFlags(fs, args)
+
+	root, err := filepath.Abs(*data)
+	if err != nil {
+		return err
+	}
+	datasets, err := scanDatasets([]string{root})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	cnxn, err := openLocalEngine(ctx, *engine, *driver)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	s, err := newFlightSQLServer(ctx, cnxn, root, datasets, *unrestricted)
+	if err != nil {
+		return err
+	}
+
+	srv := flight.NewServerWithMiddleware(nil)
+	srv.RegisterFlightService(flightsql.NewFlightServerWithAllocator(s, allocator))
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	if err := srv.Init(*listen); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+	}
+	srv.SetShutdownOnSignals(os.Interrupt, syscall.SIGTERM)
+
+	slog.Info("Serving datasets over Flight SQL", "tables", len(s.tables), "engine", cnxn.opts.Engine, "data", root, "addr", srv.Addr().String())
+	return srv.Serve()
+}
+
+// newFlightSQLServer serves the Parquet datasets under root, loaded into
+// cnxn, which every query then runs on. It refuses to serve them if the
+// queries cannot be confined to them, unless unrestricted.
+func newFlightSQLServer(ctx context.Context, cnxn *connection, root string, datasets []dataset, unrestricted bool) (*flightSQLServer, error) {
+	s := &flightSQLServer{cnxn: cnxn, tables: make(map[string]*arrow.Schema)}
+	for _, ds := range datasets {
+		name := datasetTableName(root, ds.Location)
+		if !isParquetDataset(ds.Location) {
+			logger(ctx).Debug("Skipping dataset that is not Parquet", "location", ds.Location)
+			continue
+		}
+		if _, ok := s.tables[name]; ok {
+			slog.Warn("Skipping dataset whose table name is taken", "location", ds.Location, "table", name)
+			continue
+		}
+		if err := attachFiles(ctx, cnxn, name, []string{ds.Location}, nil); err != nil {
+			slog.Warn("Skipping dataset", "location", ds.Location, "err", err)
+			continue
+		}
+		schema, err := querySchema(ctx, cnxn, "SELECT * FROM "+cnxn.opts.quoteIdent(name))
+		if err != nil {
+			return nil, err
+		}
+		s.tables[name] = schema
+	}
+	if len(s.tables) == 0 {
+		return nil, classify(errUsage, fmt.Errorf("no Parquet datasets found under %s", root))
+	}
+	if err := confineEngine(ctx, cnxn, root); err != nil {
+		if !unrestricted {
+			return nil, fmt.Errorf("failed to confine queries to the datasets (pass -allow-unrestricted to serve anyway): %w", err)
+		}
+		slog.Warn("Queries are not confined to the datasets; they can read any file dbx can", "err", err)
+	}
+	s.RegisterSqlInfo(flightsql.SqlInfoFlightSqlServerName, "dbX")
+	s.RegisterSqlInfo(flightsql.SqlInfoFlightSqlServerVersion, version)
+	s.RegisterSqlInfo(flightsql.SqlInfoFlightSqlServerArrowVersion, "17")
+	s.RegisterSqlInfo(flightsql.SqlInfoFlightSqlServerReadOnly, true)
+	return s, nil
+}
+
+// confineEngine keeps the queries of clients to the datasets loaded into
+// cnxn. DuckDB (1.2 and later) is limited to reading files under root.
+// SQLite, which holds the datasets in memory, is made read-only and denied
+// ATTACH, the one way its SQL reaches files; it has no COPY.
+func confineEngine(ctx context.Context, cnxn *connection, root string) error {
+	if cnxn.opts.Engine == "duckdb" {
+		err := execUpdate(ctx, cnxn, fmt.Sprintf("SET allowed_directories = [%s]", quoteLiteral(root)))
+		if err == nil {
+			err = execUpdate(ctx, cnxn, "SET enable_external_access = false")
+		}
+		if err == nil {
+			err = execUpdate(ctx, cnxn, "SET lock_configuration = true")
+		}
+		return err
+	}
+	sc, ok := cnxn.Connection.(*sqlConnection)
+	if !ok {
+		return fmt.Errorf("cannot confine queries on the %s engine", cnxn.opts.Engine)
+	}
+	if err := execUpdate(ctx, cnxn, "PRAGMA query_only = true"); err != nil {
+		return err
+	}
+	return sc.conn.Raw(func(dc any) error {
+		c, ok := dc.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("cannot confine queries on the %s engine", cnxn.opts.Engine)
+		}
+		c.RegisterAuthorizer(func(op int, _, arg2, _ string) int {
+			switch {
+			case op == sqlite3.SQLITE_ATTACH || op == sqlite3.SQLITE_DETACH:
+				return sqlite3.SQLITE_DENY
+			case op == sqlite3.SQLITE_PRAGMA && arg2 != "":
+				// Setting pragmas, query_only among them, is denied;
+				// reading them is not.
+				return sqlite3.SQLITE_DENY
+			}
+			return sqlite3.SQLITE_OK
+		})
+		return nil
+	})
+}
+
+// datasetTableName names the table of the dataset at location, its path
+// under root without extension and with anything but letters, digits and
+// underscores replaced: sales/orders.parquet is sales_orders.
+func datasetTableName(root, location string) string {
+	rel, err := filepath.Rel(root, location)
+	if err != nil || rel == "." {
+		rel = filepath.Base(location)
+	}
+	rel = strings.TrimSuffix(rel, filepath.Ext(rel))
+	return strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, rel)
+}
+
+// isParquetDataset reports whether the dataset at location, a file or a
+// directory of parts, is Parquet.
+func isParquetDataset(location string) bool {
+	info, err := os.Stat(location)
+	if err != nil {
+		return false
+	}
+	if !info.IsDir() {
+		return strings.EqualFold(filepath.Ext(location), ".parquet")
+	}
+	found := false
+	filepath.WalkDir(location, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".parquet") {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// query runs sql, streaming its results until they are sent or the
+// request ends.
+func (s *flightSQLServer) query(ctx context.Context, sql string) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	s.mu.Lock()
+	reader, err := executeQuery(ctx, s.cnxn, sql)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ch := make(chan flight.StreamChunk)
+	go func() {
+		defer s.mu.Unlock()
+		defer reader.Release()
+		defer close(ch)
+		for reader.Next() {
+			rec := reader.Record()
+			rec.Retain()
+			select {
+			case ch <- flight.StreamChunk{Data: rec}:
+			case <-ctx.Done():
+				rec.Release()
+				return
+			}
+		}
+		if err := reader.Err(); err != nil {
+			select {
+			case ch <- flight.StreamChunk{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return reader.Schema(), ch, nil
+}
+
+// commandInfo is the flight of a metadata command, fetched with the
+// command itself as the ticket.
+func commandInfo(desc *flight.FlightDescriptor, schema *arrow.Schema) *flight.FlightInfo {
+	return &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(schema, allocator),
+		FlightDescriptor: desc,
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: desc.Cmd}}},
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}
+}
+
+// singleChunk streams rec alone.
+func singleChunk(rec arrow.Record) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	ch := make(chan flight.StreamChunk, 1)
+	ch <- flight.StreamChunk{Data: rec}
+	close(ch)
+	return rec.Schema(), ch, nil
+}
+
+func (s *flightSQLServer) GetFlightInfoStatement(_ context.Context, cmd flightsql.StatementQuery, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	ticket, err := flightsql.CreateStatementQueryTicket([]byte(cmd.GetQuery()))
+	if err != nil {
+		return nil, err
+	}
+	return &flight.FlightInfo{
+		FlightDescriptor: desc,
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: ticket}}},
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}, nil
+}
+
+func (s *flightSQLServer) DoGetStatement(ctx context.Context, tkt flightsql.StatementQueryTicket) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	return s.query(ctx, string(tkt.GetStatementHandle()))
+}
+
+func (s *flightSQLServer) CreatePreparedStatement(ctx context.Context, req flightsql.ActionCreatePreparedStatementRequest) (flightsql.ActionCreatePreparedStatementResult, error) {
+	s.mu.Lock()
+	schema, err := querySchema(ctx, s.cnxn, req.GetQuery())
+	s.mu.Unlock()
+	if err != nil {
+		return flightsql.ActionCreatePreparedStatementResult{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	handle := newJobID()
+	s.prepared.Store(handle, req.GetQuery())
+	return flightsql.ActionCreatePreparedStatementResult{Handle: []byte(handle), DatasetSchema: schema}, nil
+}
+
+func (s *flightSQLServer) ClosePreparedStatement(_ context.Context, req flightsql.ActionClosePreparedStatementRequest) error {
+	s.prepared.Delete(string(req.GetPreparedStatementHandle()))
+	return nil
+}
+
+func (s *flightSQLServer) GetFlightInfoPreparedStatement(_ context.Context, cmd flightsql.PreparedStatementQuery, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	if _, ok := s.prepared.Load(string(cmd.GetPreparedStatementHandle())); !ok {
+		return nil, status.Error(codes.NotFound, "unknown prepared statement")
+	}
+	return &flight.FlightInfo{
+		FlightDescriptor: desc,
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: desc.Cmd}}},
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}, nil
+}
+
+func (s *flightSQLServer) DoGetPreparedStatement(ctx context.Context, cmd flightsql.PreparedStatementQuery) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	query, ok := s.prepared.Load(string(cmd.GetPreparedStatementHandle()))
+	if !ok {
+		return nil, nil, status.Error(codes.NotFound, "unknown prepared statement")
+	}
+	return s.query(ctx, query.(string))
+}
+
+// The datasets are tables of the schema main, with no catalog.
+
+func (s *flightSQLServer) GetFlightInfoCatalogs(_ context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	return commandInfo(desc, schema_ref.Catalogs), nil
+}
+
+func (s *flightSQLServer) DoGetCatalogs(context.Context) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	b := array.NewRecordBuilder(allocator, schema_ref.Catalogs)
+	defer b.Release()
+	return singleChunk(b.NewRecord())
+}
+
+func (s *flightSQLServer) GetFlightInfoSchemas(_ context.Context, _ flightsql.GetDBSchemas, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	return commandInfo(desc, schema_ref.DBSchemas), nil
+}
+
+func (s *flightSQLServer) DoGetDBSchemas(_ context.Context, cmd flightsql.GetDBSchemas) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	b := array.NewRecordBuilder(allocator, schema_ref.DBSchemas)
+	defer b.Release()
+	if likeMatch(cmd.GetDBSchemaFilterPattern(), "main") {
+		b.Field(0).AppendNull()
+		b.Field(1).(*array.StringBuilder).Append("main")
+	}
+	return singleChunk(b.NewRecord())
+}
+
+func (s *flightSQLServer) GetFlightInfoTableTypes(_ context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	return commandInfo(desc, schema_ref.TableTypes), nil
+}
+
+func (s *flightSQLServer) DoGetTableTypes(context.Context) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	b := array.NewRecordBuilder(allocator, schema_ref.TableTypes)
+	defer b.Release()
+	b.Field(0).(*array.StringBuilder).Append("TABLE")
+	return singleChunk(b.NewRecord())
+}
+
+func (s *flightSQLServer) GetFlightInfoTables(_ context.Context, cmd flightsql.GetTables, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	if cmd.GetIncludeSchema() {
+		return commandInfo(desc, schema_ref.TablesWithIncludedSchema), nil
+	}
+	return commandInfo(desc, schema_ref.Tables), nil
+}
+
+func (s *flightSQLServer) DoGetTables(_ context.Context, cmd flightsql.GetTables) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	schema := schema_ref.Tables
+	if cmd.GetIncludeSchema() {
+		schema = schema_ref.TablesWithIncludedSchema
+	}
+	b := array.NewRecordBuilder(allocator, schema)
+	defer b.Release()
+
+	typed := len(cmd.GetTableTypes()) == 0
+	for _, t := range cmd.GetTableTypes() {
+		typed = typed || strings.EqualFold(t, "TABLE")
+	}
+	if c := cmd.GetCatalog(); (c != nil && *c != "") || !typed || !likeMatch(cmd.GetDBSchemaFilterPattern(), "main") {
+		return singleChunk(b.NewRecord())
+	}
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		if likeMatch(cmd.GetTableNameFilterPattern(), name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.Field(0).AppendNull()
+		b.Field(1).(*array.StringBuilder).Append("main")
+		b.Field(2).(*array.StringBuilder).Append(name)
+		b.Field(3).(*array.StringBuilder).Append("TABLE")
+		if cmd.GetIncludeSchema() {
+			b.Field(4).(*array.BinaryBuilder).Append(flight.SerializeSchema(s.tables[name], allocator))
+		}
+	}
+	return singleChunk(b.NewRecord())
+}
+
+// likeMatch reports whether s matches the SQL LIKE pattern, which matches
+// everything if it is nil.
+func likeMatch(pattern *string, s string) bool {
+	if pattern == nil {
+		return true
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	escaped := false
+	for _, r := range *pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			re.WriteString(".*")
+		case r == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	ok, _ := regexp.MatchString(re.String(), s)
+	return ok
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/flight"
+	"github.com/apache/arrow/go/v17/arrow/flight/flightsql"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TestFlightSQLConfined checks that the queries of Flight SQL clients,
+// whether run as statements or as prepared statements, can neither write
+// nor reach files outside the datasets on the SQLite engine.
+func TestFlightSQLConfined(t *testing.T) {
+	// Each path runs query and reads its results with fetch.
+	type fetchFunc func(*flight.FlightInfo) (int64, error)
+	paths := []struct {
+		name string
+		run  func(ctx context.Context, client *flightsql.Client, query string, fetch fetchFunc) (int64, error)
+	}{
+		{"statement", func(ctx context.Context, client *flightsql.Client, query string, fetch fetchFunc) (int64, error) {
+			info, err := client.Execute(ctx, query)
+			if err != nil {
+				return 0, err
+			}
+			return fetch(info)
+		}},
+		{"prepared statement", func(ctx context.Context, client *flightsql.Client, query string, fetch fetchFunc) (int64, error) {
+			prep, err := client.Prepare(ctx, query)
+			if err != nil {
+				return 0, err
+			}
+			defer prep.Close(ctx)
+			info, err := prep.Execute(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return fetch(info)
+		}},
+	}
+	for _, path := range paths {
+		t.Run(path.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			data := filepath.Join(dir, "data")
+			cnxn := flightSQLEngine(t, data)
+			datasets, err := scanDatasets([]string{data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := newFlightSQLServer(ctx, cnxn, data, datasets, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := flight.NewServerWithMiddleware(nil)
+			srv.RegisterFlightService(flightsql.NewFlightServerWithAllocator(s, allocator))
+			if err := srv.Init("localhost:0"); err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve()
+			defer srv.Shutdown()
+			client, err := flightsql.NewClient(srv.Addr().String(), nil, nil, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			fetch := func(info *flight.FlightInfo) (int64, error) {
+				r, err := client.DoGet(ctx, info.Endpoint[0].Ticket)
+				if err != nil {
+					return 0, err
+				}
+				defer r.Release()
+				var rows int64
+				for r.Next() {
+					rows += r.Record().NumRows()
+				}
+				return rows, r.Err()
+			}
+			run := func(query string) (int64, error) { return path.run(ctx, client, query, fetch) }
+
+			if rows, err := run("SELECT * FROM orders"); err != nil || rows != 3 {
+				t.Fatalf("read %d rows of orders (%v), want 3", rows, err)
+			}
+			outside := filepath.Join(dir, "outside.db")
+			for _, query := range []string{
+				fmt.Sprintf("ATTACH DATABASE %s AS outside", quoteLiteral(outside)),
+				fmt.Sprintf("VACUUM INTO %s", quoteLiteral(outside)),
+				"PRAGMA query_only = false",
+				"CREATE TABLE t (x INTEGER)",
+				"DELETE FROM orders",
+			} {
+				if _, err := run(query); err == nil {
+					t.Errorf("%s was run", query)
+				}
+				if _, err := os.Stat(outside); err == nil {
+					t.Fatalf("%s wrote %s", query, outside)
+				}
+			}
+			if rows, err := run("SELECT * FROM orders"); err != nil || rows != 3 {
+				t.Errorf("read %d rows of orders (%v) after the refused queries, want 3", rows, err)
+			}
+		})
+	}
+}
+
+// unconfinedConnection is a connection of an engine confineEngine does not
+// know how to confine.
+type unconfinedConnection struct{ adbc.Connection }
+
+// TestFlightSQLRefusesUnconfined checks that the datasets are not served
+// when queries cannot be confined to them, unless asked to.
+func TestFlightSQLRefusesUnconfined(t *testing.T) {
+	for _, unrestricted := range []bool{false, true} {
+		data := filepath.Join(t.TempDir(), "data")
+		cnxn := flightSQLEngine(t, data)
+		cnxn.Connection = unconfinedConnection{cnxn.Connection}
+		datasets, err := scanDatasets([]string{data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = newFlightSQLServer(context.Background(), cnxn, data, datasets, unrestricted)
+		switch {
+		case unrestricted && err != nil:
+			t.Errorf("with -allow-unrestricted: %v", err)
+		case !unrestricted && (err == nil || !strings.Contains(err.Error(), "-allow-unrestricted")):
+			t.Errorf("unconfined queries were served: %v", err)
+		}
+	}
+}
+
+// flightSQLEngine exports a dataset of 3 orders under data and returns the
+// SQLite engine to serve it from.
+func flightSQLEngine(t *testing.T, data string) *connection {
+	t.Helper()
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	if err := os.MkdirAll(data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(data, "orders.parquet")
+	out, err := newSink(ctx, output, schema, sinkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManifest(output, manifest{Rows: 3, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	cnxn, err := openLocalEngine(ctx, "sqlite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cnxn.Close() })
+	return cnxn
+}
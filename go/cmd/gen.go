@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"gopkg.in/yaml.v3"
+)
+
+// genSchema is the YAML document read by `dbx gen -schema`:
+//
+//	columns:
+//	  - name: id
+//	    generator: sequence
+//	  - name: customer_id
+//	    generator: zipf
+//	    max: 100000
+//	  - name: name
+//	    generator: name
+//	  - name: created_at
+//	    generator: timestamp
+//	    start: 2024-01-01
+//	    end: 2025-01-01
+//	  - name: status
+//	    generator: choice
+//	    values: [new, paid, shipped]
+//	    nulls: 0.05
+type genSchema struct {
+	Columns []genColumn `yaml:"columns"`
+}
+
+// genColumn describes how the values of one column are generated.
+type genColumn struct {
+	Name      string `yaml:"name"`
+	Generator string `yaml:"generator"`
+	// Min and Max bound int, float and zipf values.
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+	// S is the zipf exponent, which must be greater than 1 (default 1.1).
+	S float64 `yaml:"s"`
+	// Start and End bound timestamps, as RFC 3339 times or dates.
+	Start  string   `yaml:"start"`
+	End    string   `yaml:"end"`
+	Values []string `yaml:"values"`
+	// Nulls is the fraction of values that are null.
+	Nulls float64 `yaml:"nulls"`
+}
+
+// runGen implements `dbx gen`, generating synthetic data into a Parquet or
+// Arrow file (-output) or appending it to a table (-table) through the
+// normal ingest path.
+func runGen(cfg config, args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	schemaPath := fs.String("schema", "", "Path to the YAML column spec")
+	rowsFlag := fs.String("rows", "1000", "Rows to generate; accepts K, M and B suffixes (e.g. 10M)")
+	output := fs.String("output", "", "File to write")
+	table := fs.String("table", "", "Existing table to append to")
+	seed := fs.Int64("seed", 1, "Random seed; the same seed and spec generate the same data")
+	batchRows := fs.Int("batch-rows", 64*1024, "Rows per record batch")
+	format := fs.String("format", "parquet", "Output format: parquet or arrow")
+	compression := fs.String("compression", "", "Output compression, as for the global -compression flag")
+	parseFlags(fs, args)
+
+	if *schemaPath == "" {
+		return fmt.Errorf("-schema is required")
+	}
+	if (*output == "") == (*table == "") {
+		return fmt.Errorf("exactly one of -output or -table is required")
+	}
+	rows, err := parseCount(*rowsFlag)
+	if err != nil {
+		return err
+	}
+	spec, err := loadGenSchema(*schemaPath)
+	if err != nil {
+		return err
+	}
+	reader, err := newGenReader(spec, rows, *batchRows, *seed)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	ctx := context.Background()
+	start := time.Now()
+	if *table != "" {
+		target, err := cfg.conn.table(*table)
+		if err != nil {
+			return err
+		}
+		cnxn, err := openConnection(ctx, cfg.conn)
+		if err != nil {
+			return err
+		}
+		defer cnxn.Close()
+		n, err := ingestStream(ctx, cnxn, target, reader)
+		if err != nil {
+			return err
+		}
+		logger(ctx).Info("Generated rows", "rows", n, "table", *table, "duration", time.Since(start))
+		return nil
+	}
+
+	out, err := newSink(ctx, *output, reader.Schema(), sinkOptions{Format: *format, Compression: *compression})
+	if err != nil {
+		return err
+	}
+	for reader.Next() {
+		if err := out.write(reader.Record()); err != nil {
+			out.abort()
+			return err
+		}
+	}
+	size, err := out.close()
+	if err != nil {
+		return err
+	}
+	logger(ctx).Info("Generated rows", "rows", rows, "location", *output, "bytes", size, "duration", time.Since(start))
+	return nil
+}
+
+// parseCount parses a row count such as 500, 10K, 2.5M or 1B.
+func parseCount(text string) (int64, error) {
+	s, mult := text, 1.0
+	switch strings.ToUpper(s[max(len(s)-1, 0):]) {
+	case "K":
+		mult = 1e3
+	case "M":
+		mult = 1e6
+	case "B":
+		mult = 1e9
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid row count %q", text)
+	}
+	return int64(n * mult), nil
+}
+
+func loadGenSchema(path string) (*genSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	var spec genSchema
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", path, err)
+	}
+	if len(spec.Columns) == 0 {
+		return nil, fmt.Errorf("schema %s declares no columns", path)
+	}
+	return &spec, nil
+}
+
+// genReader is a record reader producing generated batches on demand, so
+// arbitrarily large data sets stream through the sinks and ingest without
+// being held in memory.
+type genReader struct {
+	refCount  int64
+	schema    *arrow.Schema
+	columns   []columnGenerator
+	remaining int64
+	batchRows int
+	row       int64
+	rng       *rand.Rand
+	bldr      *array.RecordBuilder
+	rec       arrow.Record
+}
+
+// columnGenerator appends the value for row to b.
+type columnGenerator func(b array.Builder, rng *rand.Rand, row int64)
+
+func newGenReader(spec *genSchema, rows int64, batchRows int, seed int64) (*genReader, error) {
+	rng := rand.New(rand.NewSource(seed))
+	r := &genReader{refCount: 1, remaining: rows, batchRows: batchRows, rng: rng}
+
+	fields := make([]arrow.Field, len(spec.Columns))
+	for i, col := range spec.Columns {
+		dt, gen, err := col.generator(rng)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		if col.Nulls > 0 {
+			gen = withNulls(gen, col.Nulls)
+		}
+		fields[i] = arrow.Field{Name: col.Name, Type: dt, Nullable: col.Nulls > 0}
+		r.columns = append(r.columns, gen)
+	}
+	r.schema = arrow.NewSchema(fields, nil)
+	r.bldr = array.NewRecordBuilder(allocator, r.schema)
+	return r, nil
+}
+
+func (r *genReader) Retain() { atomic.AddInt64(&r.refCount, 1) }
+
+func (r *genReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.rec != nil {
+			r.rec.Release()
+			r.rec = nil
+		}
+		r.bldr.Release()
+	}
+}
+
+func (r *genReader) Schema() *arrow.Schema { return r.schema }
+func (r *genReader) Record() arrow.Record  { return r.rec }
+func (r *genReader) Err() error            { return nil }
+
+func (r *genReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	if r.remaining <= 0 {
+		return false
+	}
+	n := min(int64(r.batchRows), r.remaining)
+	for i := int64(0); i < n; i++ {
+		for c, gen := range r.columns {
+			gen(r.bldr.Field(c), r.rng, r.row)
+		}
+		r.row++
+	}
+	r.remaining -= n
+	r.rec = r.bldr.NewRecord()
+	return true
+}
+
+func withNulls(gen columnGenerator, fraction float64) columnGenerator {
+	return func(b array.Builder, rng *rand.Rand, row int64) {
+		if rng.Float64() < fraction {
+			b.AppendNull()
+			return
+		}
+		gen(b, rng, row)
+	}
+}
+
+// generator returns the Arrow type of the column and the function producing
+// its values.
+func (c genColumn) generator(rng *rand.Rand) (arrow.DataType, columnGenerator, error) {
+	switch c.Generator {
+	case "sequence":
+		start := int64(c.Min)
+		return arrow.PrimitiveTypes.Int64, func(b array.Builder, _ *rand.Rand, row int64) {
+			b.(*array.Int64Builder).Append(start + row)
+		}, nil
+	case "int":
+		lo, hi := int64(c.Min), int64(c.Max)
+		if hi == 0 {
+			hi = 1_000_000
+		}
+		if hi < lo {
+			return nil, nil, fmt.Errorf("max is less than min")
+		}
+		return arrow.PrimitiveTypes.Int64, func(b array.Builder, rng *rand.Rand, _ int64) {
+			b.(*array.Int64Builder).Append(lo + rng.Int63n(hi-lo+1))
+		}, nil
+	case "zipf":
+		// Small ids are by far the most frequent, like hot keys in real
+		// data.
+		s := c.S
+		if s == 0 {
+			s = 1.1
+		}
+		hi := uint64(c.Max)
+		if hi == 0 {
+			hi = 1_000_000
+		}
+		if s <= 1 {
+			return nil, nil, fmt.Errorf("zipf exponent s must be greater than 1")
+		}
+		zipf := rand.NewZipf(rng, s, 1, hi-1)
+		lo := int64(c.Min)
+		return arrow.PrimitiveTypes.Int64, func(b array.Builder, _ *rand.Rand, _ int64) {
+			b.(*array.Int64Builder).Append(lo + int64(zipf.Uint64()))
+		}, nil
+	case "float":
+		lo, hi := c.Min, c.Max
+		if hi == 0 {
+			hi = 1
+		}
+		return arrow.PrimitiveTypes.Float64, func(b array.Builder, rng *rand.Rand, _ int64) {
+			b.(*array.Float64Builder).Append(lo + rng.Float64()*(hi-lo))
+		}, nil
+	case "bool":
+		return arrow.FixedWidthTypes.Boolean, func(b array.Builder, rng *rand.Rand, _ int64) {
+			b.(*array.BooleanBuilder).Append(rng.Intn(2) == 1)
+		}, nil
+	case "timestamp":
+		start, end, err := c.timeRange()
+		if err != nil {
+			return nil, nil, err
+		}
+		span := end.Sub(start).Microseconds()
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}, func(b array.Builder, rng *rand.Rand, _ int64) {
+			b.(*array.TimestampBuilder).Append(arrow.Timestamp(start.UnixMicro() + rng.Int63n(span)))
+		}, nil
+	case "first_name":
+		return stringGenerator(func(rng *rand.Rand) string { return pick(rng, firstNames) })
+	case "last_name":
+		return stringGenerator(func(rng *rand.Rand) string { return pick(rng, lastNames) })
+	case "name":
+		return stringGenerator(func(rng *rand.Rand) string { return pick(rng, firstNames) + " " + pick(rng, lastNames) })
+	case "email":
+		return stringGenerator(func(rng *rand.Rand) string {
+			return fmt.Sprintf("%s.%s%d@%s", strings.ToLower(pick(rng, firstNames)), strings.ToLower(pick(rng, lastNames)), rng.Intn(100), pick(rng, emailDomains))
+		})
+	case "city":
+		return stringGenerator(func(rng *rand.Rand) string { return pick(rng, cities) })
+	case "country":
+		return stringGenerator(func(rng *rand.Rand) string { return pick(rng, countries) })
+	case "uuid":
+		return stringGenerator(func(rng *rand.Rand) string {
+			var u [16]byte
+			rng.Read(u[:])
+			u[6] = u[6]&0x0f | 0x40
+			u[8] = u[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+		})
+	case "choice":
+		if len(c.Values) == 0 {
+			return nil, nil, fmt.Errorf("choice requires values")
+		}
+		return stringGenerator(func(rng *rand.Rand) string { return pick(rng, c.Values) })
+	case "":
+		return nil, nil, fmt.Errorf("generator is required")
+	default:
+		return nil, nil, fmt.Errorf("unknown generator %q", c.Generator)
+	}
+}
+
+func (c genColumn) timeRange() (time.Time, time.Time, error) {
+	start, end := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, bound := range []struct {
+		text string
+		t    *time.Time
+	}{{c.Start, &start}, {c.End, &end}} {
+		if bound.text == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.text)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, bound.text); err != nil {
+				return start, end, fmt.Errorf("invalid time %q", bound.text)
+			}
+		}
+		*bound.t = t
+	}
+	if !end.After(start) {
+		return start, end, fmt.Errorf("end must be after start")
+	}
+	return start, end, nil
+}
+
+func stringGenerator(fn func(rng *rand.Rand) string) (arrow.DataType, columnGenerator, error) {
+	return arrow.BinaryTypes.String, func(b array.Builder, rng *rand.Rand, _ int64) {
+		b.(*array.StringBuilder).Append(fn(rng))
+	}, nil
+}
+
+func pick(rng *rand.Rand, values []string) string {
+	return values[rng.Intn(len(values))]
+}
+
+var (
+	firstNames = []string{
+		"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda", "David", "Elizabeth",
+		"William", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Carlos", "Maria",
+		"Wei", "Mei", "Hiroshi", "Yuki", "Arjun", "Priya", "Mohammed", "Fatima", "Lars", "Ingrid",
+		"Pierre", "Camille", "Lukas", "Anna", "Mateo", "Sofia", "Oluwaseun", "Amara", "Dmitri", "Olga",
+	}
+	lastNames = []string{
+		"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
+		"Hernandez", "Lopez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson", "Martin", "Lee",
+		"Wang", "Li", "Zhang", "Tanaka", "Suzuki", "Patel", "Sharma", "Khan", "Ali", "Jensen",
+		"Dubois", "Moreau", "Müller", "Schmidt", "Rossi", "Silva", "Okafor", "Adeyemi", "Ivanov", "Novak",
+	}
+	emailDomains = []string{"example.com", "example.org", "example.net", "mail.test", "corp.test"}
+	cities       = []string{
+		"New York", "London", "Tokyo", "Paris", "Berlin", "São Paulo", "Mumbai", "Lagos", "Sydney", "Toronto",
+		"Mexico City", "Seoul", "Amsterdam", "Stockholm", "Madrid", "Chicago", "Singapore", "Cairo", "Istanbul", "Jakarta",
+	}
+	countries = []string{
+		"United States", "United Kingdom", "Japan", "France", "Germany", "Brazil", "India", "Nigeria", "Australia", "Canada",
+		"Mexico", "South Korea", "Netherlands", "Sweden", "Spain", "Singapore", "Egypt", "Turkey", "Indonesia", "Italy",
+	}
+)
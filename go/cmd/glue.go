@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// glueBatchSize is the most partitions BatchCreatePartition accepts at once.
+const glueBatchSize = 100
+
+// glueCatalog registers the Parquet output of an export as a table of the
+// AWS Glue Data Catalog, with its schema and Hive partitions, so Athena and
+// Trino can query it as soon as the files are in S3. dbX writes local files,
+// so Location is the S3 URI the output is synced or mounted to.
+type glueCatalog struct {
+	// Table is database.table; the database must exist.
+	Table string `yaml:"table"`
+	// Location is the S3 URI of the output directory, or, for an output of
+	// a single file, of the directory holding it.
+	Location string `yaml:"location"`
+	// Region defaults to AWS_REGION or AWS_DEFAULT_REGION.
+	Region string `yaml:"region"`
+}
+
+// validate checks c for an output in format.
+func (c *glueCatalog) validate(format string) error {
+	if c == nil {
+		return nil
+	}
+	if db, table, ok := strings.Cut(c.Table, "."); !ok || db == "" || table == "" || strings.Contains(table, ".") {
+		return fmt.Errorf("glue table %q must be database.table", c.Table)
+	}
+	if !strings.HasPrefix(c.Location, "s3://") {
+		return fmt.Errorf("glue location %q must be an s3:// URI", c.Location)
+	}
+	if format != "" && format != "parquet" {
+		return fmt.Errorf("glue tables can only be registered for Parquet output")
+	}
+	return nil
+}
+
+// glueColumn, glueStorage and glueTableInput are the parts of the Glue API's
+// TableInput and PartitionInput that dbX sets.
+type glueColumn struct {
+	Name string `json:"Name"`
+	Type string `json:"Type"`
+}
+
+type glueStorage struct {
+	Columns      []glueColumn `json:"Columns,omitempty"`
+	Location     string       `json:"Location"`
+	InputFormat  string       `json:"InputFormat"`
+	OutputFormat string       `json:"OutputFormat"`
+	SerdeInfo    struct {
+		SerializationLibrary string            `json:"SerializationLibrary"`
+		Parameters           map[string]string `json:"Parameters"`
+	} `json:"SerdeInfo"`
+}
+
+type glueTableInput struct {
+	Name              string            `json:"Name"`
+	TableType         string            `json:"TableType"`
+	Parameters        map[string]string `json:"Parameters"`
+	StorageDescriptor glueStorage       `json:"StorageDescriptor"`
+	PartitionKeys     []glueColumn      `json:"PartitionKeys"`
+}
+
+type gluePartitionInput struct {
+	Values            []string    `json:"Values"`
+	StorageDescriptor glueStorage `json:"StorageDescriptor"`
+}
+
+// glueError is the error body of the Glue API.
+type glueError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *glueError) Error() string {
+	// __type may be prefixed with the service's namespace and a #.
+	_, code, _ := strings.Cut(e.Type, "#")
+	if code == "" {
+		code = e.Type
+	}
+	return code + ": " + e.Message
+}
+
+// register creates or updates the table of c for the export of schema,
+// partitioned by partitionBy into the files written under output, and adds
+// the partitions of files. Partitions that already exist are left as they
+// are, so re-exports only add new ones.
+func (c *glueCatalog) register(ctx context.Context, output string, schema *arrow.Schema, partitionBy []string, files []outputFile) error {
+	database, name, _ := strings.Cut(c.Table, ".")
+	location := strings.TrimSuffix(c.Location, "/")
+
+	isKey := make(map[string]bool)
+	for _, col := range partitionBy {
+		isKey[col] = true
+	}
+	table := glueTableInput{
+		Name:       name,
+		TableType:  "EXTERNAL_TABLE",
+		Parameters: map[string]string{"classification": "parquet", "EXTERNAL": "TRUE"},
+		// Glue rejects a null list of partition keys.
+		PartitionKeys: []glueColumn{},
+	}
+	table.StorageDescriptor = parquetStorage(location + "/")
+	for _, f := range schema.Fields() {
+		col := glueColumn{Name: f.Name, Type: hiveType(f.Type)}
+		if isKey[f.Name] {
+			// Partition values are read from paths, where timestamps are
+			// not in a form Hive parses.
+			if f.Type.ID() == arrow.TIMESTAMP {
+				col.Type = "string"
+			}
+			table.PartitionKeys = append(table.PartitionKeys, col)
+		} else {
+			table.StorageDescriptor.Columns = append(table.StorageDescriptor.Columns, col)
+		}
+	}
+
+	exists := true
+	if err := c.call(ctx, "GetTable", map[string]string{"DatabaseName": database, "Name": name}, nil); err != nil {
+		var gerr *glueError
+		if !errors.As(err, &gerr) || !strings.HasSuffix(gerr.Type, "EntityNotFoundException") {
+			return err
+		}
+		exists = false
+	}
+	action := "CreateTable"
+	if exists {
+		action = "UpdateTable"
+	}
+	if err := c.call(ctx, action, map[string]any{"DatabaseName": database, "TableInput": table}, nil); err != nil {
+		return err
+	}
+	logger(ctx).Info("Registered Glue table", "table", c.Table, "action", action, "location", location)
+	if len(partitionBy) == 0 {
+		return nil
+	}
+
+	var partitions []gluePartitionInput
+	seen := make(map[string]bool)
+	for _, f := range files {
+		rel, err := filepath.Rel(output, filepath.Dir(f.Path))
+		if err != nil || seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		values, err := partitionValues(rel, partitionBy)
+		if err != nil {
+			return err
+		}
+		partitions = append(partitions, gluePartitionInput{
+			Values:            values,
+			StorageDescriptor: parquetStorage(location + "/" + filepath.ToSlash(rel) + "/"),
+		})
+	}
+	for len(partitions) > 0 {
+		batch := partitions[:min(glueBatchSize, len(partitions))]
+		partitions = partitions[len(batch):]
+		var result struct {
+			Errors []struct {
+				PartitionValues []string `json:"PartitionValues"`
+				ErrorDetail     struct {
+					ErrorCode    string `json:"ErrorCode"`
+					ErrorMessage string `json:"ErrorMessage"`
+				} `json:"ErrorDetail"`
+			} `json:"Errors"`
+		}
+		if err := c.call(ctx, "BatchCreatePartition", map[string]any{"DatabaseName": database, "TableName": name, "PartitionInputList": batch}, &result); err != nil {
+			return err
+		}
+		for _, e := range result.Errors {
+			if e.ErrorDetail.ErrorCode != "AlreadyExistsException" {
+				return fmt.Errorf("failed to add partition %s: %s: %s", strings.Join(e.PartitionValues, "/"), e.ErrorDetail.ErrorCode, e.ErrorDetail.ErrorMessage)
+			}
+		}
+	}
+	logger(ctx).Debug("Registered Glue partitions", "table", c.Table, "partitions", len(seen))
+	return nil
+}
+
+// call invokes action of the Glue API with the JSON of input, decoding the
+// response into output if it is not nil.
+func (c *glueCatalog) call(ctx context.Context, action string, input, output any) error {
+	region := c.Region
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(env)
+		}
+	}
+	if region == "" {
+		return fmt.Errorf("cannot tell the AWS region of the Glue catalog, set AWS_REGION")
+	}
+	creds, err := loadAWSCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	// AWS_ENDPOINT_URL_GLUE points at a compatible catalog, such as
+	// LocalStack, as it does for the AWS SDKs.
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_GLUE")
+	if endpoint == "" {
+		endpoint = "https://glue." + region + ".amazonaws.com"
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSGlue."+action)
+	signAWSRequest(req, body, creds, region, "glue", time.Now())
+
+	resp, err := (&http.Client{Timeout: notifyTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("glue %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("glue %s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		gerr := &glueError{}
+		if json.Unmarshal(data, gerr) != nil || gerr.Type == "" {
+			return fmt.Errorf("glue %s: %s: %s", action, resp.Status, bytes.TrimSpace(data))
+		}
+		return fmt.Errorf("glue %s: %w", action, gerr)
+	}
+	if output != nil {
+		if err := json.Unmarshal(data, output); err != nil {
+			return fmt.Errorf("glue %s: failed to parse response: %w", action, err)
+		}
+	}
+	return nil
+}
+
+// parquetStorage describes Parquet files under location to Hive.
+func parquetStorage(location string) glueStorage {
+	s := glueStorage{
+		Location:     location,
+		InputFormat:  "org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat",
+		OutputFormat: "org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat",
+	}
+	s.SerdeInfo.SerializationLibrary = "org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe"
+	s.SerdeInfo.Parameters = map[string]string{"serialization.format": "1"}
+	return s
+}
+
+// partitionValues returns the values of the partition columns cols encoded
+// in dir, a path of col=value segments written by partitionSegment.
+func partitionValues(dir string, cols []string) ([]string, error) {
+	segments := strings.Split(filepath.ToSlash(dir), "/")
+	if len(segments) != len(cols) {
+		return nil, fmt.Errorf("partition directory %s does not match the partition columns %s", dir, strings.Join(cols, ", "))
+	}
+	values := make([]string, len(cols))
+	for i, seg := range segments {
+		name, value, ok := strings.Cut(seg, "=")
+		if !ok || name != cols[i] {
+			return nil, fmt.Errorf("partition directory %s does not match the partition columns %s", dir, strings.Join(cols, ", "))
+		}
+		values[i] = unescapePartition.Replace(value)
+	}
+	return values, nil
+}
+
+// hiveType returns the Hive type Athena and Trino read a Parquet column of
+// Arrow type t as.
+func hiveType(t arrow.DataType) string {
+	switch t := t.(type) {
+	case *arrow.BooleanType:
+		return "boolean"
+	case *arrow.Int8Type, *arrow.Uint8Type:
+		return "tinyint"
+	case *arrow.Int16Type, *arrow.Uint16Type:
+		return "smallint"
+	case *arrow.Int32Type, *arrow.Uint32Type, *arrow.Time32Type:
+		return "int"
+	case *arrow.Int64Type, *arrow.Uint64Type, *arrow.Time64Type:
+		return "bigint"
+	case *arrow.Float16Type, *arrow.Float32Type:
+		return "float"
+	case *arrow.Float64Type:
+		return "double"
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("decimal(%d,%d)", t.Precision, t.Scale)
+	case *arrow.Decimal256Type:
+		return fmt.Sprintf("decimal(%d,%d)", t.Precision, t.Scale)
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "date"
+	case *arrow.TimestampType:
+		return "timestamp"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType:
+		return "binary"
+	case *arrow.DictionaryType:
+		return hiveType(t.ValueType)
+	case *arrow.ListType:
+		return "array<" + hiveType(t.Elem()) + ">"
+	case *arrow.LargeListType:
+		return "array<" + hiveType(t.Elem()) + ">"
+	case *arrow.MapType:
+		return "map<" + hiveType(t.KeyType()) + "," + hiveType(t.ItemType()) + ">"
+	case *arrow.StructType:
+		fields := make([]string, t.NumFields())
+		for i, f := range t.Fields() {
+			fields[i] = f.Name + ":" + hiveType(f.Type)
+		}
+		return "struct<" + strings.Join(fields, ",") + ">"
+	default:
+		return "string"
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// controlDescriptorSet is control.proto, which clients generate their stubs
+// from, compiled to a FileDescriptorSet. Messages are handled as dynamic
+// protobuf messages, so no generated code is needed here.
+//
+//go:generate protoc --descriptor_set_out=control.pb control.proto
+//go:embed control.pb
+var controlDescriptorSet []byte
+
+// controlFile returns the descriptor of control.proto, registered for
+// server reflection on first use.
+var controlFile = sync.OnceValues(func() (protoreflect.FileDescriptor, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(controlDescriptorSet, &set); err != nil {
+		return nil, err
+	}
+	if len(set.File) != 1 {
+		return nil, fmt.Errorf("control.pb holds %d files, want control.proto alone", len(set.File))
+	}
+	fd, err := protodesc.NewFile(set.File[0], protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, err
+	}
+	return fd, protoregistry.GlobalFiles.RegisterFile(fd)
+})
+
+// maxJobLogEntries caps the log entries kept per job for StreamLogs.
+const maxJobLogEntries = 1000
+
+// grpcServer implements the dbx.v1.Control service of `dbx serve grpc`.
+// Like the HTTP API, files are only read from and written to dir.
+type grpcServer struct {
+	cfg     config
+	dir     string
+	store   *jobStore
+	exports *exporter
+	logs    *jobLogs
+	notify  *notifier
+	file    protoreflect.FileDescriptor
+	// access, if set, restricts calls to the Control service to those of
+	// its tokens.
+	access *accessPolicy
+}
+
+// controlOperations are the operations a token must allow to call each
+// method of the Control service.
+var controlOperations = map[string]string{
+	"StartExport": "export",
+	"StartImport": "import",
+	"GetStatus":   "jobs",
+	"StreamLogs":  "jobs",
+}
+
+// serveGRPC implements `dbx serve grpc`.
+func serveGRPC(cfg config, args []string) error {
+	fs := flag.NewFlagSet("serve grpc", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:9090", "Address to listen on")
+	dir := fs.String("dir", ".", "Directory exports are written to and imports are read from")
+	tokens := fs.String("tokens", "", "YAML file of API tokens and the operations, tables and destinations each allows; calls without one of them are refused")
+	notify := notifyFlags(fs)
+	parseFlags(fs, args)
+
+	access, err := loadAccessPolicy(*tokens, cfg.conn.IdentifierCase)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	fd, err := controlFile()
+	if err != nil {
+		return fmt.Errorf("invalid control service descriptor: %w", err)
+	}
+	store, err := openJobStore(cfg.jobsDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	exports := newExporter(cfg.conn)
+	defer exports.Close()
+
+	logs := newJobLogs()
+	slog.SetDefault(slog.New(&jobLogHandler{next: slog.Default().Handler(), logs: logs}))
+
+	s := &grpcServer{cfg: cfg, dir: *dir, store: store, exports: exports, logs: logs, notify: notify, file: fd, access: access}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(s.authorizeUnary), grpc.StreamInterceptor(s.authorizeStream))
+	srv.RegisterService(s.serviceDesc(), s)
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	reflection.Register(srv)
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+
+	slog.Info("Serving gRPC control API", "addr", lis.Addr().String())
+	return srv.Serve(lis)
+}
+
+// authorize authenticates a call to the Control service method fullMethod
+// and returns ctx with its token. Health checks and reflection are open to
+// all.
+func (s *grpcServer) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if service != "dbx.v1.Control" {
+		return ctx, nil
+	}
+	var header string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	t, err := s.access.authenticate(header)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := t.allow(controlOperations[method]); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return withAccessToken(ctx, t), nil
+}
+
+func (s *grpcServer) authorizeUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) authorizeStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, authorizedStream{stream, ctx})
+}
+
+// authorizedStream is a server stream whose context carries its token.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// serviceDesc describes the Control service to gRPC, decoding requests into
+// dynamic messages of their type.
+func (s *grpcServer) serviceDesc() *grpc.ServiceDesc {
+	svc := s.file.Services().ByName("Control")
+	unary := func(name protoreflect.Name, fn func(ctx context.Context, req protoreflect.Message) (protoreflect.Message, error)) grpc.MethodDesc {
+		method := svc.Methods().ByName(name)
+		fullName := "/" + string(svc.FullName()) + "/" + string(name)
+		return grpc.MethodDesc{
+			MethodName: string(name),
+			Handler: func(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				req := dynamicpb.NewMessage(method.Input())
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handle := func(ctx context.Context, req any) (any, error) {
+					resp, err := fn(ctx, req.(*dynamicpb.Message))
+					if err != nil {
+						return nil, err
+					}
+					return resp.Interface(), nil
+				}
+				if interceptor == nil {
+					return handle(ctx, req)
+				}
+				return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: s, FullMethod: fullName}, handle)
+			},
+		}
+	}
+	return &grpc.ServiceDesc{
+		ServiceName: string(svc.FullName()),
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			unary("StartExport", s.startExport),
+			unary("StartImport", s.startImport),
+			unary("GetStatus", s.getStatus),
+		},
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "StreamLogs",
+			ServerStreams: true,
+			Handler: func(_ any, stream grpc.ServerStream) error {
+				req := dynamicpb.NewMessage(svc.Methods().ByName("StreamLogs").Input())
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return s.streamLogs(req, stream)
+			},
+		}},
+		Metadata: s.file.Path(),
+	}
+}
+
+func (s *grpcServer) startExport(ctx context.Context, req protoreflect.Message) (protoreflect.Message, error) {
+	query, err := requestQuery(s.cfg.conn, getString(req, "table"), getString(req, "query"))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if getString(req, "output") == "" {
+		return nil, status.Error(codes.InvalidArgument, "output is required")
+	}
+	params, err := parseQueryParams(getStrings(req, "params"))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	sink := sinkOptions{Format: getString(req, "format"), Compression: getString(req, "compression")}
+	// Exports write files under s.dir, as over HTTP, so outputs that are
+	// not files, like gsheet, are not served.
+	switch sink.Format {
+	case "", "parquet", "arrow", "avro", "csv":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported output format %q, use parquet, arrow, avro or csv", sink.Format)
+	}
+	if err := sink.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	output := inDir(s.dir, getString(req, "output"))
+	spec := exportRequest{Table: getString(req, "table"), Query: getString(req, "query"), Output: getString(req, "output"), Force: getBool(req, "force"), Params: getStrings(req, "params")}
+	token := tokenFrom(ctx)
+	if err := errors.Join(token.allowSource(spec.Table, spec.Query), token.allowDestination(spec.Output)); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return s.start(ctx, "export", spec, func(ctx context.Context) (*response, error) {
+		if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		return s.exports.export(ctx, exportSpec{Query: query, Output: output, Table: spec.Table, Force: spec.Force, Params: params, sinkOptions: sink})
+	})
+}
+
+func (s *grpcServer) startImport(ctx context.Context, req protoreflect.Message) (protoreflect.Message, error) {
+	spec := importRequest{File: getString(req, "file"), Table: getString(req, "table"), Map: getMap(req, "column_map")}
+	if spec.File == "" || spec.Table == "" {
+		return nil, status.Error(codes.InvalidArgument, "file and table are required")
+	}
+	if err := tokenFrom(ctx).allowSource(spec.Table, ""); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	path := inDir(s.dir, spec.File)
+	return s.start(ctx, "import", spec, func(ctx context.Context) (*response, error) {
+		return importFile(ctx, s.cfg.conn, path, spec.Table, spec.Map, importOptions{})
+	})
+}
+
+func (s *grpcServer) getStatus(ctx context.Context, req protoreflect.Message) (protoreflect.Message, error) {
+	j, err := ownedJob(s.store, tokenFrom(ctx), getString(req, "job_id"))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return s.jobMessage(j), nil
+}
+
+// start runs fn in the background as a new job, keeping its log entries
+// for StreamLogs. The job keeps the values of ctx, the call's context, but
+// not its cancellation.
+func (s *grpcServer) start(ctx context.Context, kind string, spec any, fn func(ctx context.Context) (*response, error)) (protoreflect.Message, error) {
+	j, err := s.store.create(kind, tokenFrom(ctx).name(), spec, os.Getpid())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.logs.open(j.ID)
+	run := func(ctx context.Context) (*response, error) {
+		logger(ctx).Info("Job started", "kind", kind)
+		resp, err := fn(ctx)
+		if err == nil {
+			logger(ctx).Info(resp.Message, "rows", resp.RowsWritten, "location", resp.Location, "duration", formatDuration(resp.Duration))
+		}
+		return resp, err
+	}
+	go func() {
+		defer s.logs.close(j.ID)
+		start := time.Now()
+		resp, err := s.store.run(context.WithoutCancel(ctx), j.ID, run)
+		if err != nil {
+			slog.Error("Job failed", "job_id", j.ID, "kind", kind, "err", err)
+		}
+		s.notify.send(context.Background(), newJobNotification(j.ID, kind, start, resp, err))
+	}()
+	return s.jobMessage(j), nil
+}
+
+func (s *grpcServer) jobMessage(j *job) protoreflect.Message {
+	m := dynamicpb.NewMessage(s.file.Messages().ByName("Job"))
+	setString(m, "id", j.ID)
+	setString(m, "kind", j.Kind)
+	setString(m, "state", j.State)
+	setString(m, "error", j.Error)
+	setString(m, "created_at", j.CreatedAt.Format(time.RFC3339Nano))
+	setString(m, "updated_at", j.UpdatedAt.Format(time.RFC3339Nano))
+	if r := j.Result; r != nil {
+		m.Set(field(m, "rows_written"), protoreflect.ValueOfInt64(r.RowsWritten))
+		m.Set(field(m, "output_bytes"), protoreflect.ValueOfInt64(r.OutputFileSize))
+		setString(m, "location", r.Location)
+		if data, err := json.Marshal(r); err == nil {
+			setString(m, "result_json", string(data))
+		}
+	}
+	return m
+}
+
+// streamLogs sends the log entries of a job, then with follow the entries
+// logged until it ends or the client goes away.
+func (s *grpcServer) streamLogs(req protoreflect.Message, stream grpc.ServerStream) error {
+	id := getString(req, "job_id")
+	if _, err := ownedJob(s.store, tokenFrom(stream.Context()), id); err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	backlog, entries, ok := s.logs.subscribe(id, getBool(req, "follow"))
+	if !ok {
+		return status.Errorf(codes.NotFound, "job %s was not started by this server", id)
+	}
+	defer s.logs.unsubscribe(id, entries)
+	send := func(e logEntry) error {
+		m := dynamicpb.NewMessage(s.file.Messages().ByName("LogEntry"))
+		setString(m, "time", e.Time.Format(time.RFC3339Nano))
+		setString(m, "level", e.Level)
+		setString(m, "message", e.Message)
+		attrs := m.Mutable(field(m, "attrs")).Map()
+		for k, v := range e.Attrs {
+			attrs.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(v))
+		}
+		return stream.SendMsg(m)
+	}
+	for _, e := range backlog {
+		if err := send(e); err != nil {
+			return err
+		}
+	}
+	if entries == nil {
+		return nil
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case e, ok := <-entries:
+			if !ok {
+				return nil
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// inDir resolves a client-supplied file name inside dir.
+func inDir(dir, name string) string {
+	return filepath.Join(dir, filepath.Clean("/"+name))
+}
+
+func field(m protoreflect.Message, name string) protoreflect.FieldDescriptor {
+	return m.Descriptor().Fields().ByName(protoreflect.Name(name))
+}
+
+func getString(m protoreflect.Message, name string) string {
+	return m.Get(field(m, name)).String()
+}
+
+func getBool(m protoreflect.Message, name string) bool {
+	return m.Get(field(m, name)).Bool()
+}
+
+func getStrings(m protoreflect.Message, name string) []string {
+	list := m.Get(field(m, name)).List()
+	values := make([]string, list.Len())
+	for i := range values {
+		values[i] = list.Get(i).String()
+	}
+	return values
+}
+
+func getMap(m protoreflect.Message, name string) map[string]string {
+	values := make(map[string]string)
+	m.Get(field(m, name)).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		values[k.String()] = v.String()
+		return true
+	})
+	return values
+}
+
+func setString(m protoreflect.Message, name, value string) {
+	m.Set(field(m, name), protoreflect.ValueOfString(value))
+}
+
+// logEntry is a log record of a job.
+type logEntry struct {
+	Time    time.Time
+	Level   string
+	Message string
+	Attrs   map[string]string
+}
+
+// jobLogs keeps the recent log entries of the jobs a server runs and
+// passes new ones to the clients following them.
+type jobLogs struct {
+	mu   sync.Mutex
+	jobs map[string]*jobLog
+}
+
+type jobLog struct {
+	entries     []logEntry
+	done        bool
+	subscribers map[chan logEntry]bool
+}
+
+func newJobLogs() *jobLogs {
+	return &jobLogs{jobs: make(map[string]*jobLog)}
+}
+
+// open starts keeping the entries of job id.
+func (l *jobLogs) open(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jobs[id] = &jobLog{subscribers: make(map[chan logEntry]bool)}
+}
+
+// close marks job id as ended, ending the streams following it.
+func (l *jobLogs) close(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	j := l.jobs[id]
+	j.done = true
+	for ch := range j.subscribers {
+		close(ch)
+	}
+	j.subscribers = nil
+}
+
+func (l *jobLogs) add(id string, e logEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	j, ok := l.jobs[id]
+	if !ok || j.done {
+		return
+	}
+	if len(j.entries) == maxJobLogEntries {
+		j.entries = j.entries[1:]
+	}
+	j.entries = append(j.entries, e)
+	for ch := range j.subscribers {
+		// A client too slow to keep up misses entries rather than holding
+		// up the job.
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns the entries of job id so far and, with follow and while
+// the job runs, a channel of the entries to come, closed when it ends. ok is
+// false for jobs the server does not know.
+func (l *jobLogs) subscribe(id string, follow bool) (backlog []logEntry, entries chan logEntry, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	j, ok := l.jobs[id]
+	if !ok {
+		return nil, nil, false
+	}
+	backlog = append(backlog, j.entries...)
+	if follow && !j.done {
+		entries = make(chan logEntry, 100)
+		j.subscribers[entries] = true
+	}
+	return backlog, entries, true
+}
+
+func (l *jobLogs) unsubscribe(id string, entries chan logEntry) {
+	if entries == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if j := l.jobs[id]; j.subscribers[entries] {
+		delete(j.subscribers, entries)
+		close(entries)
+	}
+}
+
+// jobLogHandler passes records on to next, and keeps those logged for a
+// job, as loggers from withLogAttrs(ctx, "job_id", id) do, in logs.
+type jobLogHandler struct {
+	next  slog.Handler
+	logs  *jobLogs
+	attrs []slog.Attr
+}
+
+func (h *jobLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *jobLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	e := logEntry{Time: r.Time, Level: r.Level.String(), Message: r.Message, Attrs: make(map[string]string)}
+	for _, a := range h.attrs {
+		e.Attrs[a.Key] = a.Value.String()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		e.Attrs[a.Key] = a.Value.String()
+		return true
+	})
+	if id := e.Attrs["job_id"]; id != "" {
+		delete(e.Attrs, "job_id")
+		h.logs.add(id, e)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *jobLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &jobLogHandler{next: h.next.WithAttrs(attrs), logs: h.logs, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+// WithGroup keeps attributes added after it under their own keys, which is
+// enough to find the job_id dbX logs at the top level.
+func (h *jobLogHandler) WithGroup(name string) slog.Handler {
+	return &jobLogHandler{next: h.next.WithGroup(name), logs: h.logs, attrs: h.attrs}
+}
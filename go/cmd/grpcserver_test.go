@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// TestControlDescriptor checks that the embedded control.pb describes
+// control.proto as it stands, so that an edit of one without the other,
+// which `go generate` would fix, fails.
+func TestControlDescriptor(t *testing.T) {
+	src, err := os.ReadFile("control.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := parseProto("control.proto", string(src))
+	if err != nil {
+		t.Fatalf("control.proto: %v", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(controlDescriptorSet, &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.File) != 1 || !proto.Equal(set.File[0], want) {
+		t.Errorf("control.pb is out of date with control.proto, run go generate:\n%v\nwant\n%v", &set, want)
+	}
+	if _, err := controlFile(); err != nil {
+		t.Errorf("controlFile: %v", err)
+	}
+}
+
+// protoScalars are the field types of the proto3 scalars.
+var protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":  descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+var protoToken = regexp.MustCompile(`"[^"]*"|[A-Za-z_][A-Za-z0-9_.]*|[0-9]+|[{}()<>;=,]`)
+
+// parseProto compiles src, a proto3 file of the subset control.proto uses
+// (scalar, message and map fields of top-level messages, unary and server
+// streaming methods), to its descriptor as protoc would.
+func parseProto(name, src string) (*descriptorpb.FileDescriptorProto, error) {
+	var lines []string
+	for _, line := range strings.Split(src, "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		lines = append(lines, line)
+	}
+	toks := protoToken.FindAllString(strings.Join(lines, "\n"), -1)
+	pos := 0
+	next := func() string {
+		if pos == len(toks) {
+			return ""
+		}
+		pos++
+		return toks[pos-1]
+	}
+	var errs []string
+	expect := func(want string) {
+		if got := next(); got != want {
+			errs = append(errs, fmt.Sprintf("got %q, want %q", got, want))
+		}
+	}
+	unquote := func(s string) string { return strings.Trim(s, `"`) }
+
+	fd := &descriptorpb.FileDescriptorProto{Name: proto.String(name)}
+	typeName := func(t string) string { return "." + fd.GetPackage() + "." + t }
+	for pos < len(toks) && len(errs) == 0 {
+		switch tok := next(); tok {
+		case "syntax":
+			expect("=")
+			fd.Syntax = proto.String(unquote(next()))
+			expect(";")
+		case "package":
+			fd.Package = proto.String(next())
+			expect(";")
+		case "option":
+			if opt := next(); opt != "go_package" {
+				return nil, fmt.Errorf("unsupported option %s", opt)
+			}
+			expect("=")
+			fd.Options = &descriptorpb.FileOptions{GoPackage: proto.String(unquote(next()))}
+			expect(";")
+		case "service":
+			svc := &descriptorpb.ServiceDescriptorProto{Name: proto.String(next())}
+			expect("{")
+			for len(errs) == 0 {
+				if tok := next(); tok == "}" {
+					break
+				} else if tok != "rpc" {
+					return nil, fmt.Errorf("unexpected %q in service %s", tok, svc.GetName())
+				}
+				m := &descriptorpb.MethodDescriptorProto{Name: proto.String(next())}
+				expect("(")
+				m.InputType = proto.String(typeName(next()))
+				expect(")")
+				expect("returns")
+				expect("(")
+				out := next()
+				if out == "stream" {
+					m.ServerStreaming = proto.Bool(true)
+					out = next()
+				}
+				m.OutputType = proto.String(typeName(out))
+				expect(")")
+				expect(";")
+				svc.Method = append(svc.Method, m)
+			}
+			fd.Service = append(fd.Service, svc)
+		case "message":
+			msg := &descriptorpb.DescriptorProto{Name: proto.String(next())}
+			expect("{")
+			for len(errs) == 0 {
+				tok := next()
+				if tok == "}" {
+					break
+				}
+				f := &descriptorpb.FieldDescriptorProto{Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+				var fieldType string
+				switch tok {
+				case "repeated":
+					f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+					fieldType = next()
+				case "map":
+					expect("<")
+					key := next()
+					expect(",")
+					value := next()
+					expect(">")
+					f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+					f.Name = proto.String(next())
+					camel := protoJSONName(f.GetName())
+					entry := strings.ToUpper(camel[:1]) + camel[1:] + "Entry"
+					fieldType = msg.GetName() + "." + entry
+					msg.NestedType = append(msg.NestedType, &descriptorpb.DescriptorProto{
+						Name: proto.String(entry),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							protoField("key", 1, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, key, typeName),
+							protoField("value", 2, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, value, typeName),
+						},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					})
+				default:
+					fieldType = tok
+				}
+				if f.Name == nil {
+					f.Name = proto.String(next())
+				}
+				expect("=")
+				number, err := strconv.Atoi(next())
+				if err != nil {
+					return nil, fmt.Errorf("field %s.%s: %w", msg.GetName(), f.GetName(), err)
+				}
+				expect(";")
+				msg.Field = append(msg.Field, protoField(f.GetName(), number, f.GetLabel(), fieldType, typeName))
+			}
+			fd.MessageType = append(fd.MessageType, msg)
+		default:
+			return nil, fmt.Errorf("unexpected %q", tok)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", errs[0])
+	}
+	return fd, nil
+}
+
+func protoField(name string, number int, label descriptorpb.FieldDescriptorProto_Label, typ string, typeName func(string) string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(int32(number)),
+		Label:    label.Enum(),
+		JsonName: proto.String(protoJSONName(name)),
+	}
+	if t, ok := protoScalars[typ]; ok {
+		f.Type = t.Enum()
+	} else {
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		f.TypeName = proto.String(typeName(typ))
+	}
+	return f
+}
+
+// protoJSONName is the lowerCamelCase JSON name protoc gives a field.
+func protoJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
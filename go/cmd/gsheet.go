@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// Small recurring extracts can land in a Google Sheets spreadsheet that
+// people already watch, with -format gsheet -sheet-id, or -output
+// gsheet:<id>. The export replaces the contents of one tab with a header row
+// and the exported rows. It is meant for extracts of thousands of rows, not
+// millions, so the rows are capped.
+
+const (
+	// sheetURLPrefix is that of spreadsheet URLs, which gsheet outputs are
+	// located by.
+	sheetURLPrefix = "https://docs.google.com/spreadsheets/d/"
+	sheetsEndpoint = "https://sheets.googleapis.com/v4/spreadsheets/"
+	// defaultSheetMaxRows caps the rows of gsheet output without
+	// -sheet-max-rows.
+	defaultSheetMaxRows = 10000
+	// sheetMaxColumns is the number of columns of a tab, through ZZZ.
+	sheetMaxColumns = 18278
+)
+
+// sheetLocation returns the URL of the spreadsheet id, which may be given
+// as its URL already.
+func sheetLocation(id string) string {
+	id, _, _ = strings.Cut(strings.TrimPrefix(id, sheetURLPrefix), "/")
+	return sheetURLPrefix + id
+}
+
+// sheetSink writes an export to a tab of a spreadsheet. Rows are held in
+// memory until the sink closes, so the tab is left as it was if the export
+// fails.
+type sheetSink struct {
+	ctx      context.Context
+	location string
+	tab      string
+	maxRows  int64
+	header   []any
+	values   [][]any
+	rows     int64
+	bytes    int64
+}
+
+func newSheetSink(ctx context.Context, location string, schema *arrow.Schema, opts sinkOptions) *sheetSink {
+	s := &sheetSink{ctx: ctx, location: location, tab: opts.SheetTab, maxRows: opts.SheetMaxRows}
+	if s.maxRows == 0 {
+		s.maxRows = defaultSheetMaxRows
+	}
+	for _, f := range schema.Fields() {
+		s.header = append(s.header, f.Name)
+	}
+	return s
+}
+
+func (s *sheetSink) write(rec arrow.Record) error {
+	if s.rows+rec.NumRows() > s.maxRows {
+		return fmt.Errorf("the export has more than the %d rows gsheet output is capped at; raise -sheet-max-rows or write a file", s.maxRows)
+	}
+	for i := 0; i < int(rec.NumRows()); i++ {
+		row := make([]any, rec.NumCols())
+		for j, col := range rec.Columns() {
+			row[j] = sheetCell(arrowValue(col, i))
+		}
+		s.values = append(s.values, row)
+	}
+	s.rows += rec.NumRows()
+	return nil
+}
+
+// sheetCell returns v as a cell value: numbers and booleans as themselves,
+// nulls as empty cells and anything else as text. Integers a spreadsheet
+// cannot hold exactly are written as text too, as are NaN and infinities,
+// which have no JSON number.
+func sheetCell(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return asString(v)
+		}
+		return v
+	case int64:
+		if v > 1<<53 || v < -1<<53 {
+			return asString(v)
+		}
+		return v
+	}
+	return asString(v)
+}
+
+// a1 returns the A1 notation of cells in the tab of s, the first tab if
+// none is set.
+func (s *sheetSink) a1(cells string) string {
+	if s.tab == "" {
+		return cells
+	}
+	return "'" + strings.ReplaceAll(s.tab, "'", "''") + "'!" + cells
+}
+
+// sheetColumn returns the A1 letters of column n, counted from 1.
+func sheetColumn(n int) string {
+	var col []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		col = append([]byte{byte('A' + (n-1)%26)}, col...)
+	}
+	return string(col)
+}
+
+// close writes the header and rows over the tab, then clears the cells past
+// them that an earlier export left behind. Writing first means a failed
+// export leaves the old rows in place rather than an empty tab. Values are
+// written as entered, unparsed, so text that looks like a formula stays
+// text.
+func (s *sheetSink) close() (int64, error) {
+	token, err := tokens.get(s.ctx, "google", googleAccessToken)
+	if err != nil {
+		return 0, fmt.Errorf("failed to authenticate to Google Sheets: %w", err)
+	}
+	spreadsheet := sheetsEndpoint + url.PathEscape(strings.TrimPrefix(s.location, sheetURLPrefix))
+	values := spreadsheet + "/values/"
+	body := struct {
+		Range          string  `json:"range"`
+		MajorDimension string  `json:"majorDimension"`
+		Values         [][]any `json:"values"`
+	}{s.a1("A1"), "ROWS", append([][]any{s.header}, s.values...)}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode rows for Google Sheets: %w", err)
+	}
+	if err := s.request(token, http.MethodPut, values+url.PathEscape(s.a1("A1"))+"?valueInputOption=RAW", data); err != nil {
+		return 0, err
+	}
+	// Clear the rows below those written and the columns right of them.
+	last := len(s.values) + 1
+	stale := struct {
+		Ranges []string `json:"ranges"`
+	}{[]string{s.a1(fmt.Sprintf("A%d:ZZZ", last+1))}}
+	if n := len(s.header); n < sheetMaxColumns {
+		stale.Ranges = append(stale.Ranges, s.a1(fmt.Sprintf("%s1:ZZZ%d", sheetColumn(n+1), last)))
+	}
+	clear, err := json.Marshal(stale)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.request(token, http.MethodPost, spreadsheet+"/values:batchClear", clear); err != nil {
+		return 0, fmt.Errorf("wrote rows but failed to clear the rest of the tab: %w", err)
+	}
+	logger(s.ctx).Debug("Wrote rows to spreadsheet", "location", s.location, "tab", s.tab, "rows", s.rows)
+	s.bytes = int64(len(data))
+	return s.bytes, nil
+}
+
+// request sends body, JSON, to endpoint of the Sheets API.
+func (s *sheetSink) request(token, method, endpoint string, body []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: notifyTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("google sheets: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		var gerr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &gerr) == nil && gerr.Error.Message != "" {
+			return fmt.Errorf("google sheets: %s: %s", resp.Status, gerr.Error.Message)
+		}
+		return fmt.Errorf("google sheets: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// abort leaves the tab as it was; nothing is sent before close.
+func (s *sheetSink) abort() {}
+
+func (s *sheetSink) files() []outputFile {
+	return []outputFile{{Path: s.location, Rows: s.rows, Bytes: s.bytes}}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+// runHead implements `dbx head -file x.parquet -n 20` and `dbx head -table t
+// -n 20`, printing the first rows as an aligned table, or as JSON objects
+// with -json.
+func runHead(cfg config, args []string) error {
+	fs := flag.NewFlagSet("head", flag.ExitOnError)
+	path := fs.String("file", "", "Parquet file, glob or Hive-partitioned directory to preview, or an sftp:// file")
+	table := fs.String("table", "", "Table to preview")
+	n := fs.Int("n", 10, "Number of rows to print")
+	tail := fs.Bool("tail", false, "Print the last rows of -file instead of the first")
+	mismatch := fs.String("schema-mismatch", mismatchFail, "When the files of -file differ in schema: fail, or preview the union of their columns")
+	asOf := fs.String("as-of", "", "Preview the -file dataset as of this snapshot tag")
+	parseFlags(fs, args)
+
+	if (*path == "") == (*table == "") {
+		return classify(errUsage, fmt.Errorf("exactly one of -file or -table is required"))
+	}
+	if *n < 0 {
+		return classify(errUsage, fmt.Errorf("-n must not be negative"))
+	}
+	if *tail && *table != "" {
+		return classify(errUsage, fmt.Errorf("-tail requires -file, tables have no defined order"))
+	}
+	if err := validSchemaMismatch(*mismatch); err != nil {
+		return classify(errUsage, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		reader array.RecordReader
+		skip   int64
+		err    error
+	)
+	if *path != "" {
+		if isSFTP(*path) && *asOf != "" {
+			return classify(errUsage, fmt.Errorf("-as-of requires a local dataset"))
+		}
+		local, cleanup, err := fetchInput(ctx, *path)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		*path = local
+		multi, err := isMultiFile(*path)
+		if err != nil {
+			return err
+		}
+		if (multi || *asOf != "") && *tail {
+			return classify(errUsage, fmt.Errorf("-tail requires -file to be a single file"))
+		}
+		var closeFile func()
+		if multi || *asOf != "" {
+			reader, closeFile, err = openParquetInput(ctx, *path, nil, nil, nil, *mismatch, *asOf)
+		} else {
+			reader, skip, closeFile, err = openParquetPreview(ctx, *path, int64(*n), *tail)
+		}
+		if err != nil {
+			return err
+		}
+		defer closeFile()
+	} else {
+		query, err := tableQuery(cfg.conn, *table)
+		if err != nil {
+			return classify(errUsage, err)
+		}
+		cnxn, err := openConnection(ctx, cfg.conn)
+		if err != nil {
+			return err
+		}
+		defer cnxn.Close()
+		if reader, err = executeQuery(ctx, cnxn, fmt.Sprintf("%s LIMIT %d", query, *n)); err != nil {
+			return err
+		}
+	}
+	defer reader.Release()
+
+	rows, err := previewRows(reader, skip, *n)
+	if err != nil {
+		return err
+	}
+	if cfg.json {
+		printJSON(rows)
+		return nil
+	}
+	return printRows(os.Stdout, reader.Schema(), rows)
+}
+
+// openParquetPreview opens the Parquet file at path for reading its first n
+// rows, or with tail its last n. To reach the end it only reads the trailing
+// row groups, and returns how many of their rows to skip. The returned
+// function closes the file once the reader is released.
+func openParquetPreview(ctx context.Context, path string, n int64, tail bool) (array.RecordReader, int64, func(), error) {
+	pqFile, err := openParquetFile(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	pqReader, err := pqarrow.NewFileReader(pqFile, pqarrow.ArrowReadProperties{BatchSize: parquetBatchRows}, allocator)
+	if err != nil {
+		pqFile.Close()
+		return nil, 0, nil, fmt.Errorf("failed to create Parquet file reader: %w", err)
+	}
+
+	var rowGroups []int
+	var skip int64
+	if tail {
+		var rows int64
+		first := pqFile.NumRowGroups()
+		for first > 0 && rows < n {
+			first--
+			rows += pqFile.RowGroup(first).NumRows()
+		}
+		for i := first; i < pqFile.NumRowGroups(); i++ {
+			rowGroups = append(rowGroups, i)
+		}
+		skip = max(rows-n, 0)
+	}
+	reader, err := pqReader.GetRecordReader(ctx, nil, rowGroups)
+	if err != nil {
+		pqFile.Close()
+		return nil, 0, nil, fmt.Errorf("failed to read Parquet file: %w", err)
+	}
+	return reader, skip, func() { pqFile.Close() }, nil
+}
+
+// previewRows returns up to n rows of reader, after skipping skip rows, as
+// maps from column name to value.
+func previewRows(reader array.RecordReader, skip int64, n int) ([]map[string]any, error) {
+	rows := make([]map[string]any, 0, n)
+	for len(rows) < n && reader.Next() {
+		rec := reader.Record()
+		start := min(skip, rec.NumRows())
+		skip -= start
+		for i := int(start); i < int(rec.NumRows()) && len(rows) < n; i++ {
+			row := make(map[string]any, rec.NumCols())
+			for j, col := range rec.Columns() {
+				row[rec.ColumnName(j)] = arrowValue(col, i)
+			}
+			rows = append(rows, row)
+		}
+	}
+	// pqarrow's reader reports io.EOF once exhausted.
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return rows, nil
+}
+
+// printRows writes rows as a table aligned on the columns of schema, with
+// NULL for missing values.
+func printRows(w io.Writer, schema *arrow.Schema, rows []map[string]any) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	names := make([]string, schema.NumFields())
+	for i, f := range schema.Fields() {
+		names[i] = f.Name
+	}
+	fmt.Fprintln(tw, strings.Join(names, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(names))
+		for i, name := range names {
+			cells[i] = "NULL"
+			if v := row[name]; v != nil {
+				cells[i] = strings.NewReplacer("\t", `\t`, "\n", `\n`).Replace(asString(v))
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
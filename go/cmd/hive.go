@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// hiveNull is the partition value standing for null, as in Hive.
+const hiveNull = "__HIVE_DEFAULT_PARTITION__"
+
+// unescapePartition reverses the escaping of partitionSegment.
+var unescapePartition = strings.NewReplacer("%2F", "/", "%5C", "\\", "%3D", "=", "%3A", ":")
+
+// hiveFile is a Parquet file of a Hive-partitioned directory, with the
+// values of the partition columns encoded in its path.
+type hiveFile struct {
+	Path string
+	// Columns and Values are the partition columns and their values, in
+	// path order; a nil value is null.
+	Columns []string
+	Values  []*string
+}
+
+// listHiveFiles returns the Parquet files under dir, a Hive-partitioned
+// directory, in the partitions matching the filters of where on partition
+// columns. Partition directories that fail them are skipped before any file
+// in them is opened. It also returns the filters left to apply to the rows
+// of the files, those on other columns.
+func listHiveFiles(dir string, where []*filterTransform) ([]hiveFile, []*filterTransform, error) {
+	var files []hiveFile
+	partitionCols := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if d.IsDir() {
+			name, value, ok := strings.Cut(d.Name(), "=")
+			if !ok {
+				return nil
+			}
+			partitionCols[name] = true
+			v := partitionValue(value)
+			for _, f := range where {
+				if f.column == name && !f.matchesPartition(v) {
+					return fs.SkipDir
+				}
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".parquet" {
+			return nil
+		}
+		files = append(files, partitionFile(dir, path))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	var rest []*filterTransform
+	for _, f := range where {
+		if !partitionCols[f.column] {
+			rest = append(rest, f)
+		}
+	}
+	return files, rest, nil
+}
+
+// partitionFile returns the file at path with the partition columns of the
+// directories between it and dir, a Hive-partitioned directory.
+func partitionFile(dir, path string) hiveFile {
+	file := hiveFile{Path: path}
+	rel, _ := filepath.Rel(dir, filepath.Dir(path))
+	if rel != "." {
+		for _, seg := range strings.Split(filepath.ToSlash(rel), "/") {
+			if name, value, ok := strings.Cut(seg, "="); ok {
+				file.Columns = append(file.Columns, name)
+				file.Values = append(file.Values, partitionValue(value))
+			}
+		}
+	}
+	return file
+}
+
+// partitionValue decodes a value of a partition directory name.
+func partitionValue(value string) *string {
+	if value == hiveNull {
+		return nil
+	}
+	v := unescapePartition.Replace(value)
+	return &v
+}
+
+// matchesPartition reports whether the partition with value v, nil for
+// null, matches the filter. Values are compared as numbers if both they and
+// the filter's value are numbers, and as strings otherwise.
+func (t *filterTransform) matchesPartition(v *string) bool {
+	switch t.fn {
+	case "is_null":
+		return v == nil
+	case "is_valid":
+		return v != nil
+	}
+	if v == nil {
+		return false
+	}
+	var a, b any = *v, t.value
+	if x, err := strconv.ParseFloat(*v, 64); err == nil {
+		if y, err := strconv.ParseFloat(t.value, 64); err == nil {
+			a, b = x, y
+		}
+	}
+	switch t.fn {
+	case "equal":
+		return !cursorLess(a, b) && !cursorLess(b, a)
+	case "not_equal":
+		return cursorLess(a, b) || cursorLess(b, a)
+	case "less":
+		return cursorLess(a, b)
+	case "less_equal":
+		return !cursorLess(b, a)
+	case "greater":
+		return cursorLess(b, a)
+	case "greater_equal":
+		return !cursorLess(a, b)
+	}
+	return true
+}
+
+// Policies for inputs of several files whose schemas differ: fail, or read
+// the union of their columns, as nulls where a file lacks one.
+const (
+	mismatchFail  = "fail"
+	mismatchUnion = "union"
+)
+
+// validSchemaMismatch checks a -schema-mismatch value.
+func validSchemaMismatch(policy string) error {
+	if policy != mismatchFail && policy != mismatchUnion {
+		return fmt.Errorf("invalid -schema-mismatch %q, expected fail or union", policy)
+	}
+	return nil
+}
+
+// isGlob reports whether path is a pattern rather than a file name.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// listParquetInputs returns the Parquet files of path: a file, a
+// Hive-partitioned directory, or a glob matching either, with the filters
+// of where left to apply to their rows, as for listHiveFiles. The
+// directories a glob matches below its fixed prefix are partitions too, so
+// exports/*/part-*.parquet reads the partition columns of exports. With
+// asOf, path is a dataset read as of that snapshot tag.
+func listParquetInputs(path string, where []*filterTransform, asOf string) ([]hiveFile, []*filterTransform, error) {
+	if asOf != "" {
+		files, err := snapshotFiles(path, asOf)
+		if err != nil {
+			return nil, nil, err
+		}
+		files, rest := prunePartitions(files, where)
+		return files, rest, nil
+	}
+	if !isGlob(path) {
+		if multi, err := isMultiFile(path); err != nil || multi {
+			return listHiveFiles(path, where)
+		}
+		return []hiveFile{{Path: path}}, where, nil
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, nil, classify(errUsage, fmt.Errorf("invalid file pattern %q: %w", path, err))
+	}
+	if len(matches) == 0 {
+		return nil, nil, classify(errUsage, fmt.Errorf("no files match %s", path))
+	}
+	base := globBase(path)
+	var files []hiveFile
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		found := []hiveFile{{Path: m}}
+		if info.IsDir() {
+			if found, _, err = listHiveFiles(m, where); err != nil {
+				return nil, nil, err
+			}
+		}
+		for _, f := range found {
+			files = append(files, partitionFile(base, f.Path))
+		}
+	}
+	files, rest := prunePartitions(files, where)
+	return files, rest, nil
+}
+
+// prunePartitions returns the files in partitions matching the filters of
+// where on partition columns, and the filters on other columns.
+func prunePartitions(files []hiveFile, where []*filterTransform) ([]hiveFile, []*filterTransform) {
+	var kept []hiveFile
+	partitionCols := make(map[string]bool)
+files:
+	for _, f := range files {
+		for i, c := range f.Columns {
+			partitionCols[c] = true
+			for _, w := range where {
+				if w.column == c && !w.matchesPartition(f.Values[i]) {
+					continue files
+				}
+			}
+		}
+		kept = append(kept, f)
+	}
+	var rest []*filterTransform
+	for _, w := range where {
+		if !partitionCols[w.column] {
+			rest = append(rest, w)
+		}
+	}
+	return kept, rest
+}
+
+// globBase returns the directory of pattern above its first component
+// holding a wildcard.
+func globBase(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for isGlob(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// openParquetInput is openParquetColumns for a Parquet file, a
+// Hive-partitioned directory of them, whose partition columns are read as
+// strings after the columns of the files, or a glob matching several. Files
+// whose schemas differ fail the read, or with mismatch union are read with
+// the union of their columns. With asOf, path is a dataset read as of that
+// snapshot tag.
+func openParquetInput(ctx context.Context, path string, columns, drop []string, where []*filterTransform, mismatch, asOf string) (array.RecordReader, func(), error) {
+	if multi, err := isMultiFile(path); err != nil {
+		return nil, nil, err
+	} else if !multi && asOf == "" {
+		return openParquetColumns(ctx, path, columns, drop, where)
+	}
+
+	files, rest, err := listParquetInputs(path, where, asOf)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger(ctx).Debug("Listed input files", "input", path, "files", len(files))
+	r := &hiveReader{refCount: 1, ctx: ctx, files: files, columns: columns, drop: drop, where: rest, mismatch: mismatch}
+	if len(files) == 0 {
+		// Every partition was pruned; the schema is still read from a
+		// file of one.
+		all, _, err := listParquetInputs(path, nil, asOf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(all) == 0 {
+			return nil, nil, classify(errUsage, fmt.Errorf("no Parquet files in %s", path))
+		}
+		r.files = all[:1]
+	}
+	if err := r.planSchema(); err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		empty, _ := array.NewRecordReader(r.schema, nil)
+		return empty, func() {}, nil
+	}
+	return r, func() {}, nil
+}
+
+// hiveReader reads Parquet files one after another, such as those of a
+// Hive-partitioned directory, conforming their records, with their
+// partition columns appended, to a single schema.
+type hiveReader struct {
+	refCount int64
+	ctx      context.Context
+	files    []hiveFile
+	columns  []string
+	drop     []string
+	where    []*filterTransform
+	mismatch string
+
+	schema *arrow.Schema
+	// fileColumns are the columns of each file, by path, once known.
+	fileColumns map[string]*arrow.Schema
+
+	next      int // index in files of the next file to open
+	cur       array.RecordReader
+	closeFile func()
+	rec       arrow.Record
+	err       error
+}
+
+// kept reports whether the column name is selected by -columns and -drop.
+func (r *hiveReader) kept(name string) bool {
+	for _, d := range r.drop {
+		if d == name {
+			return false
+		}
+	}
+	if len(r.columns) == 0 {
+		return true
+	}
+	for _, c := range r.columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fileSchema returns the schema of the columns of f kept, followed by its
+// partition columns kept.
+func (r *hiveReader) fileSchema(f hiveFile) (*arrow.Schema, error) {
+	schema, err := parquetSchema(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	var fields []arrow.Field
+	for _, field := range schema.Fields() {
+		if r.kept(field.Name) {
+			fields = append(fields, field)
+		}
+	}
+	for _, c := range f.Columns {
+		if r.kept(c) {
+			fields = append(fields, arrow.Field{Name: c, Type: arrow.BinaryTypes.String, Nullable: true})
+		}
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// planSchema sets the schema read: that of the first file or, with
+// mismatch union, the union of the columns of every file, in the order
+// they are first seen. A column must have the same type in every file
+// holding it.
+func (r *hiveReader) planSchema() error {
+	r.fileColumns = make(map[string]*arrow.Schema)
+	files := r.files[:1]
+	if r.mismatch == mismatchUnion {
+		files = r.files
+	}
+	var fields []arrow.Field
+	index := make(map[string]int)
+	seen := make(map[string]int)
+	for _, f := range files {
+		schema, err := r.fileSchema(f)
+		if err != nil {
+			return err
+		}
+		r.fileColumns[f.Path] = schema
+		for _, field := range schema.Fields() {
+			i, ok := index[field.Name]
+			if !ok {
+				index[field.Name] = len(fields)
+				fields = append(fields, field)
+				seen[field.Name] = 1
+				continue
+			}
+			if !arrow.TypeEqual(fields[i].Type, field.Type) {
+				return classify(errSchemaMismatch, fmt.Errorf("column %s is %s in %s but %s in %s", field.Name, fields[i].Type, files[0].Path, field.Type, f.Path))
+			}
+			fields[i].Nullable = fields[i].Nullable || field.Nullable
+			seen[field.Name]++
+		}
+	}
+	for i := range fields {
+		if seen[fields[i].Name] < len(files) {
+			fields[i].Nullable = true
+		}
+	}
+	for _, c := range append(append([]string{}, r.columns...), r.drop...) {
+		if _, ok := index[c]; !ok {
+			return classify(errUsage, fmt.Errorf("column %q not found in %s", c, files[0].Path))
+		}
+	}
+	if len(fields) == 0 {
+		return classify(errUsage, fmt.Errorf("no columns of %s left to read", files[0].Path))
+	}
+	r.schema = arrow.NewSchema(fields, nil)
+	return nil
+}
+
+// open opens the next file, or skips it if it cannot hold rows matching
+// the filters.
+func (r *hiveReader) open() error {
+	f := r.files[r.next]
+	r.next++
+	schema := r.fileColumns[f.Path]
+	if schema == nil {
+		var err error
+		if schema, err = r.fileSchema(f); err != nil {
+			return err
+		}
+	}
+	if r.mismatch != mismatchUnion {
+		for i, field := range schema.Fields() {
+			if i >= r.schema.NumFields() || r.schema.Field(i).Name != field.Name || !arrow.TypeEqual(r.schema.Field(i).Type, field.Type) {
+				return classify(errSchemaMismatch, fmt.Errorf("%s does not have the columns of %s; use -schema-mismatch union to read the union of their columns", f.Path, r.files[0].Path))
+			}
+		}
+		if schema.NumFields() != r.schema.NumFields() {
+			return classify(errSchemaMismatch, fmt.Errorf("%s does not have the columns of %s; use -schema-mismatch union to read the union of their columns", f.Path, r.files[0].Path))
+		}
+	}
+
+	isPart := make(map[string]bool)
+	for _, c := range f.Columns {
+		isPart[c] = true
+	}
+	var cols []string
+	for _, field := range schema.Fields() {
+		if !isPart[field.Name] {
+			cols = append(cols, field.Name)
+		}
+	}
+	if len(cols) == 0 {
+		return classify(errUsage, fmt.Errorf("%s has none of the columns read", f.Path))
+	}
+	// A file lacking a filter's column holds nulls in it, matching only
+	// is null.
+	var where []*filterTransform
+	for _, w := range r.where {
+		if len(schema.FieldIndices(w.column)) > 0 {
+			where = append(where, w)
+		} else if w.fn != "is_null" {
+			logger(r.ctx).Debug("Skipping file without the filtered column", "file", f.Path, "column", w.column)
+			return nil
+		}
+	}
+
+	reader, closeFile, err := openParquetColumns(r.ctx, f.Path, cols, nil, where)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Path, err)
+	}
+	r.cur, r.closeFile = reader, closeFile
+	return nil
+}
+
+func (r *hiveReader) close() {
+	if r.cur != nil {
+		r.cur.Release()
+		r.closeFile()
+		r.cur = nil
+	}
+}
+
+func (r *hiveReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *hiveReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.rec != nil {
+			r.rec.Release()
+			r.rec = nil
+		}
+		r.close()
+	}
+}
+
+func (r *hiveReader) Schema() *arrow.Schema { return r.schema }
+func (r *hiveReader) Record() arrow.Record  { return r.rec }
+func (r *hiveReader) Err() error            { return r.err }
+
+func (r *hiveReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	for r.err == nil {
+		if r.cur == nil {
+			if r.next == len(r.files) {
+				return false
+			}
+			if r.err = r.open(); r.err != nil || r.cur == nil {
+				continue
+			}
+		}
+		if r.cur.Next() {
+			r.rec = r.conform(r.cur.Record(), r.files[r.next-1])
+			return true
+		}
+		if err := r.cur.Err(); err != nil && !errors.Is(err, io.EOF) {
+			r.err = fmt.Errorf("failed to read %s: %w", r.files[r.next-1].Path, err)
+		}
+		r.close()
+	}
+	return false
+}
+
+// conform returns rec, read from f, in the schema of the reader: its
+// columns by name, the values of f's partition columns, and nulls for the
+// columns f lacks.
+func (r *hiveReader) conform(rec arrow.Record, f hiveFile) arrow.Record {
+	n := int(rec.NumRows())
+	cols := make([]arrow.Array, r.schema.NumFields())
+	for i, field := range r.schema.Fields() {
+		if idx := rec.Schema().FieldIndices(field.Name); len(idx) > 0 {
+			cols[i] = rec.Column(idx[0])
+			cols[i].Retain()
+			continue
+		}
+		part := -1
+		for j, c := range f.Columns {
+			if c == field.Name {
+				part = j
+			}
+		}
+		if part < 0 || f.Values[part] == nil {
+			cols[i] = array.MakeArrayOfNull(allocator, field.Type, n)
+			continue
+		}
+		bldr := array.NewStringBuilder(allocator)
+		for k := 0; k < n; k++ {
+			bldr.Append(*f.Values[part])
+		}
+		cols[i] = bldr.NewArray()
+		bldr.Release()
+	}
+	out := array.NewRecord(r.schema, cols, rec.NumRows())
+	for _, col := range cols {
+		col.Release()
+	}
+	return out
+}
+
+// isMultiFile reports whether path is a glob or directory rather than a
+// single Parquet file.
+func isMultiFile(path string) (bool, error) {
+	if isGlob(path) {
+		return true, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+// httpServer lets orchestration systems drive dbX over HTTP. Files are only
+// read from and written to dir.
+type httpServer struct {
+	cfg   config
+	dir   string
+	store *jobStore
+	// exports reuses database connections across export jobs.
+	exports *exporter
+	notify  *notifier
+	// access, if set, restricts requests to those of its tokens.
+	access *accessPolicy
+}
+
+type exportRequest struct {
+	Table  string `json:"table"`
+	Query  string `json:"query"`
+	Output string `json:"output"`
+	Force  bool   `json:"force"`
+	// Params bind :name placeholders in Query, as name[:type]=value.
+	Params []string `json:"params"`
+}
+
+type importRequest struct {
+	File  string `json:"file"`
+	Table string `json:"table"`
+	// Map renames file columns (src -> dest) before matching them to the
+	// table's columns by name.
+	Map map[string]string `json:"map"`
+}
+
+// serveHTTP implements `dbx serve http`.
+func serveHTTP(cfg config, args []string) error {
+	fs := flag.NewFlagSet("serve http", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8080", "Address to listen on")
+	dir := fs.String("dir", ".", "Directory exports are written to and imports are read from")
+	tokens := fs.String("tokens", "", "YAML file of API tokens and the operations, tables and destinations each allows; requests without one of them are refused")
+	notify := notifyFlags(fs)
+	parseFlags(fs, args)
+
+	access, err := loadAccessPolicy(*tokens, cfg.conn.IdentifierCase)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	store, err := openJobStore(cfg.jobsDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	exports := newExporter(cfg.conn)
+	defer exports.Close()
+
+	s := &httpServer{cfg: cfg, dir: *dir, store: store, exports: exports, notify: notify, access: access}
+	srv := &http.Server{Addr: *listen, Handler: s.routes()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	slog.Info("Serving HTTP API", "addr", *listen)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *httpServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /exports", s.authorize("export", s.handleExport))
+	mux.HandleFunc("POST /imports", s.authorize("import", s.handleImport))
+	mux.HandleFunc("GET /jobs", s.authorize("jobs", s.handleListJobs))
+	mux.HandleFunc("GET /jobs/{id}", s.authorize("jobs", s.handleGetJob))
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.authorize("jobs", s.handleCancelJob))
+	mux.HandleFunc("GET /stream", s.authorize("stream", s.handleStream))
+	mux.HandleFunc("GET /health", s.handleHealth)
+	// Kubernetes probes: the process is live while it answers, and ready
+	// while the database is reachable.
+	mux.HandleFunc("GET /livez", handleLive)
+	mux.HandleFunc("GET /readyz", s.handleHealth)
+	return mux
+}
+
+// authorize wraps h so that it only serves requests whose token allows op,
+// with the token in their context.
+func (s *httpServer) authorize(op string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := s.access.authenticate(r.Header.Get("Authorization"))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dbx"`)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if err := t.allow(op); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		h(w, r.WithContext(withAccessToken(r.Context(), t)))
+	}
+}
+
+// handleExport starts an export job writing a Parquet file under s.dir.
+func (s *httpServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	query, err := requestQuery(s.cfg.conn, req.Table, req.Query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Output == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("output is required"))
+		return
+	}
+	token := tokenFrom(r.Context())
+	if err := errors.Join(token.allowSource(req.Table, req.Query), token.allowDestination(req.Output)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	params, err := parseQueryParams(req.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	output := s.path(req.Output)
+	s.start(r.Context(), w, "export", req, func(ctx context.Context) (*response, error) {
+		if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		return s.exports.export(ctx, exportSpec{Query: query, Output: output, Table: req.Table, Force: req.Force, Params: params})
+	})
+}
+
+// handleImport starts a job appending a Parquet file under s.dir to a table.
+func (s *httpServer) handleImport(w http.ResponseWriter, r *http.Request) {
+	var req importRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.File == "" || req.Table == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("file and table are required"))
+		return
+	}
+
+	if err := tokenFrom(r.Context()).allowSource(req.Table, ""); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	path := s.path(req.File)
+	s.start(r.Context(), w, "import", req, func(ctx context.Context) (*response, error) {
+		return importFile(ctx, s.cfg.conn, path, req.Table, req.Map, importOptions{})
+	})
+}
+
+// handleListJobs lists the jobs started with the request's token.
+func (s *httpServer) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.store.list()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	token := tokenFrom(r.Context())
+	var owned []job
+	for _, j := range jobs {
+		if token.ownsJob(&j) {
+			owned = append(owned, j)
+		}
+	}
+	writeJSON(w, http.StatusOK, owned)
+}
+
+func (s *httpServer) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	j, err := ownedJob(s.store, tokenFrom(r.Context()), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *httpServer) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if _, err := ownedJob(s.store, tokenFrom(r.Context()), r.PathValue("id")); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	j, err := s.store.requestCancel(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// handleStream streams a table or query result in the response body, as
+// Arrow IPC (format=arrow, the default) or Parquet (format=parquet).
+func (s *httpServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	table, query := r.URL.Query().Get("table"), r.URL.Query().Get("query")
+	if err := tokenFrom(r.Context()).allowSource(table, query); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	query, err := requestQuery(s.cfg.conn, table, query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "arrow"
+	}
+	if format != "arrow" && format != "parquet" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q", format))
+		return
+	}
+
+	ctx := r.Context()
+	cnxn, err := openConnection(ctx, s.cfg.conn)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer cnxn.Close()
+
+	reader, err := executeQuery(ctx, cnxn, query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer reader.Release()
+
+	// Once the body has started, errors can only be reported by aborting
+	// the response so the client sees a truncated stream.
+	switch format {
+	case "arrow":
+		w.Header().Set("Content-Type", "application/vnd.apache.arrow.stream")
+		iw := ipc.NewWriter(w, ipc.WithSchema(reader.Schema()))
+		for reader.Next() {
+			if err := iw.Write(reader.Record()); err != nil {
+				panic(http.ErrAbortHandler)
+			}
+		}
+		if reader.Err() != nil || iw.Close() != nil {
+			panic(http.ErrAbortHandler)
+		}
+	case "parquet":
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		pw, err := pqarrow.NewFileWriter(reader.Schema(), w, nil, pqarrow.ArrowWriterProperties{})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for reader.Next() {
+			if err := pw.Write(reader.Record()); err != nil {
+				panic(http.ErrAbortHandler)
+			}
+		}
+		if reader.Err() != nil || pw.Close() != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// handleHealth reports whether the database is reachable.
+func (s *httpServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := s.exports.ping(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// start runs fn in the background as a new job and responds with it. The
+// job keeps the values of ctx, the request's context, but not its
+// cancellation.
+func (s *httpServer) start(ctx context.Context, w http.ResponseWriter, kind string, spec any, fn func(ctx context.Context) (*response, error)) {
+	j, err := s.store.create(kind, tokenFrom(ctx).name(), spec, os.Getpid())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	go func() {
+		start := time.Now()
+		resp, err := s.store.run(context.WithoutCancel(ctx), j.ID, fn)
+		if err != nil {
+			slog.Error("Job failed", "job_id", j.ID, "kind", kind, "err", err)
+		}
+		s.notify.send(context.Background(), newJobNotification(j.ID, kind, start, resp, err))
+	}()
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// path resolves a client-supplied file name inside s.dir.
+func (s *httpServer) path(name string) string {
+	return inDir(s.dir, name)
+}
+
+func requestQuery(opts connOptions, table, query string) (string, error) {
+	switch {
+	case table != "" && query != "":
+		return "", fmt.Errorf("table and query are mutually exclusive")
+	case table != "":
+		return tableQuery(opts, table)
+	case query != "":
+		return query, nil
+	default:
+		return "", fmt.Errorf("table or query is required")
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, newErrorReport(err))
+}
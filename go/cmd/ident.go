@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tableIdent is a parsed, possibly schema-qualified table name. Table names
+// given by users are parsed into a tableIdent and only ever reach SQL text
+// through quote, so they cannot change the shape of a query.
+type tableIdent struct {
+	// parts are [catalog.][schema.]table, outermost first.
+	parts []identPart
+}
+
+type identPart struct {
+	name string
+	// quoted parts were written in double quotes or backticks and keep
+	// their case under -identifier-case.
+	quoted bool
+}
+
+// maxIdentParts allows catalog.schema.table.
+const maxIdentParts = 3
+
+// parseTableIdent parses a table name of up to three dot-separated parts.
+// Each part is either bare or quoted with double quotes or backticks, with
+// the quote character doubled to include it literally, so
+// `sales."Order Items"` names the table Order Items in schema sales. Bare
+// parts may not contain whitespace, quotes, semicolons or control
+// characters.
+func parseTableIdent(s string) (tableIdent, error) {
+	var t tableIdent
+	rest := s
+	for {
+		part, n, err := parseIdentPart(rest)
+		if err != nil {
+			return tableIdent{}, fmt.Errorf("invalid table name %q: %w", s, err)
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[n:]
+		if rest == "" {
+			break
+		}
+		if rest[0] != '.' {
+			return tableIdent{}, fmt.Errorf("invalid table name %q: unexpected %q after %q", s, rest[0], part.name)
+		}
+		rest = rest[1:]
+	}
+	if len(t.parts) > maxIdentParts {
+		return tableIdent{}, fmt.Errorf("invalid table name %q: at most %d parts (catalog.schema.table) are allowed", s, maxIdentParts)
+	}
+	return t, nil
+}
+
+// parseIdentPart parses one part from the start of s, returning it and the
+// number of bytes consumed.
+func parseIdentPart(s string) (identPart, int, error) {
+	if s == "" || s[0] == '.' {
+		return identPart{}, 0, fmt.Errorf("empty name part")
+	}
+
+	if q := s[0]; q == '"' || q == '`' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch {
+			case s[i] != q:
+				b.WriteByte(s[i])
+			case i+1 < len(s) && s[i+1] == q:
+				b.WriteByte(q)
+				i++
+			default:
+				name := b.String()
+				if err := checkIdentChars(name, true); err != nil {
+					return identPart{}, 0, err
+				}
+				return identPart{name: name, quoted: true}, i + 1, nil
+			}
+		}
+		return identPart{}, 0, fmt.Errorf("unterminated quote")
+	}
+
+	n := strings.IndexByte(s, '.')
+	if n < 0 {
+		n = len(s)
+	}
+	name := s[:n]
+	if err := checkIdentChars(name, false); err != nil {
+		return identPart{}, 0, err
+	}
+	return identPart{name: name}, n, nil
+}
+
+func checkIdentChars(name string, quoted bool) error {
+	if name == "" {
+		return fmt.Errorf("empty name part")
+	}
+	for _, r := range name {
+		switch {
+		case r == 0 || unicode.IsControl(r):
+			return fmt.Errorf("control character %q in name", r)
+		case quoted:
+		case unicode.IsSpace(r) || strings.ContainsRune("\"`';", r):
+			return fmt.Errorf("%q is only allowed in a quoted name", r)
+		}
+	}
+	return nil
+}
+
+// newTableIdent builds a tableIdent from already separated parts, such as
+// the ADBC ingest target options. Every part is taken literally.
+func newTableIdent(catalog, schema, table string) tableIdent {
+	var t tableIdent
+	for _, p := range []string{catalog, schema, table} {
+		if p != "" {
+			t.parts = append(t.parts, identPart{name: p, quoted: true})
+		}
+	}
+	return t
+}
+
+// fold applies an -identifier-case mode to the unquoted parts.
+func (t tableIdent) fold(mode string) tableIdent {
+	parts := make([]identPart, len(t.parts))
+	for i, p := range t.parts {
+		if !p.quoted {
+			p.name = foldIdent(p.name, mode)
+		}
+		parts[i] = p
+	}
+	return tableIdent{parts: parts}
+}
+
+// quote renders the name for SQL text in dialect d.
+func (t tableIdent) quote(d dialect) string {
+	parts := make([]string, len(t.parts))
+	for i, p := range t.parts {
+		parts[i] = d.quoteIdent(p.name)
+	}
+	return strings.Join(parts, ".")
+}
+
+// table returns the unqualified table name.
+func (t tableIdent) table() string {
+	return t.parts[len(t.parts)-1].name
+}
+
+// schema returns the schema qualifying the table, or "".
+func (t tableIdent) schema() string {
+	if len(t.parts) < 2 {
+		return ""
+	}
+	return t.parts[len(t.parts)-2].name
+}
+
+// catalog returns the catalog qualifying the table, or "".
+func (t tableIdent) catalog() string {
+	if len(t.parts) < 3 {
+		return ""
+	}
+	return t.parts[0].name
+}
+
+// String returns the unquoted dotted name, for messages.
+func (t tableIdent) String() string {
+	parts := make([]string, len(t.parts))
+	for i, p := range t.parts {
+		parts[i] = p.name
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// unquoteIdent splits SQL text rendered by tableIdent.quote back into its
+// parts, failing unless the text is nothing but dot-separated identifiers
+// delimited by q. A table name that could change the shape of a query would
+// leave something else behind.
+func unquoteIdent(t *testing.T, s, q string) []string {
+	t.Helper()
+	var parts []string
+	for {
+		if !strings.HasPrefix(s, q) {
+			t.Fatalf("expected %s at %q", q, s)
+		}
+		s = s[len(q):]
+		var b strings.Builder
+		for {
+			i := strings.Index(s, q)
+			if i < 0 {
+				t.Fatalf("unterminated identifier in %q", s)
+			}
+			b.WriteString(s[:i])
+			s = s[i+len(q):]
+			if !strings.HasPrefix(s, q) {
+				break
+			}
+			b.WriteString(q)
+			s = s[len(q):]
+		}
+		parts = append(parts, b.String())
+		if s == "" {
+			return parts
+		}
+		if s[0] != '.' {
+			t.Fatalf("unexpected %q after identifier", s)
+		}
+		s = s[1:]
+	}
+}
+
+func TestTableIdentQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		parts []string
+		err   string
+	}{
+		{name: "bare", in: "orders", parts: []string{"orders"}},
+		{name: "qualified", in: "sales.public.orders", parts: []string{"sales", "public", "orders"}},
+		{name: "quoted with space", in: `sales."Order Items"`, parts: []string{"sales", "Order Items"}},
+		{name: "backticks", in: "`sales`.`orders`", parts: []string{"sales", "orders"}},
+		{name: "statement after semicolon", in: "orders;DROP TABLE users", err: `';' is only allowed in a quoted name`},
+		{name: "statement after space", in: "orders DROP TABLE users", err: `' ' is only allowed in a quoted name`},
+		{name: "semicolon in quotes", in: `"orders; DROP TABLE users"`, parts: []string{"orders; DROP TABLE users"}},
+		{name: "doubled quote", in: `"a""b"`, parts: []string{`a"b`}},
+		{name: "doubled quote closing early", in: `"orders""; DROP TABLE users; --"`, parts: []string{`orders"; DROP TABLE users; --`}},
+		{name: "quote closed then statement", in: `"orders"; DROP TABLE users`, err: `unexpected ';'`},
+		{name: "stray quote", in: `orders"; DROP TABLE users; --`, err: `'"' is only allowed in a quoted name`},
+		{name: "single quote", in: "'orders'", err: `'\'' is only allowed in a quoted name`},
+		{name: "doubled backtick", in: "`a``b`", parts: []string{"a`b"}},
+		{name: "backtick closed then statement", in: "`orders`; DROP TABLE users", err: `unexpected ';'`},
+		{name: "backtick in double quotes", in: "\"a`; DROP TABLE users; --\"", parts: []string{"a`; DROP TABLE users; --"}},
+		{name: "line comment", in: "orders--", parts: []string{"orders--"}},
+		{name: "block comment", in: "orders/*x*/", parts: []string{"orders/*x*/"}},
+		{name: "comment in quotes", in: `"orders -- x"`, parts: []string{"orders -- x"}},
+		{name: "newline", in: "\"orders\n; DROP TABLE users\"", err: "control character"},
+		{name: "nul", in: "orders\x00", err: "control character"},
+		{name: "four parts", in: "a.b.c.d", err: "at most 3 parts"},
+		{name: "four quoted parts", in: `"a"."b"."c"."d"`, err: "at most 3 parts"},
+		{name: "empty part", in: "a..b", err: "empty name part"},
+		{name: "trailing dot", in: "orders.", err: "empty name part"},
+		{name: "empty quotes", in: `""`, err: "empty name part"},
+		{name: "unterminated", in: `"orders`, err: "unterminated quote"},
+	}
+
+	names := []string{ansiDialect.name}
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d := ansiDialect
+		if name != ansiDialect.name {
+			d = dialects[name]
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				ident, err := parseTableIdent(tt.in)
+				if tt.err != "" {
+					if err == nil {
+						t.Fatalf("parseTableIdent(%q) = %q, want error containing %q", tt.in, ident.quote(d), tt.err)
+					}
+					if !strings.Contains(err.Error(), tt.err) {
+						t.Fatalf("parseTableIdent(%q) error = %q, want it to contain %q", tt.in, err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("parseTableIdent(%q): %v", tt.in, err)
+				}
+				if got := unquoteIdent(t, ident.quote(d), d.identQuote); !reflect.DeepEqual(got, tt.parts) {
+					t.Fatalf("%q quoted as %s, which names %q, want %q", tt.in, ident.quote(d), got, tt.parts)
+				}
+			})
+		}
+	}
+}
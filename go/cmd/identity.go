@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Queries listing the identity and generated columns of :table in :schema
+// (or the current schema). Postgres and DuckDB count serial columns, whose
+// default draws from a sequence, as identity columns too.
+const (
+	informationSchemaIdentityColumns = `
+SELECT column_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF(:schema, ''), current_schema())
+  AND table_name = :table
+  AND (is_identity = 'YES' OR column_default LIKE 'nextval(%')
+ORDER BY ordinal_position`
+
+	mysqlIdentityColumns = `
+SELECT column_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF(:schema, ''), DATABASE())
+  AND table_name = :table
+  AND extra LIKE '%auto_increment%'
+ORDER BY ordinal_position`
+
+	// Only a single-column INTEGER PRIMARY KEY aliases the rowid.
+	sqliteIdentityColumns = `
+SELECT name FROM pragma_table_info(:table)
+WHERE pk = 1 AND lower(type) = 'integer' AND :schema = ''
+  AND (SELECT count(*) FROM pragma_table_info(:table) WHERE pk > 0) = 1`
+
+	postgresGeneratedColumns = `
+SELECT column_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF(:schema, ''), current_schema())
+  AND table_name = :table
+  AND is_generated = 'ALWAYS'
+ORDER BY ordinal_position`
+
+	mysqlGeneratedColumns = `
+SELECT column_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF(:schema, ''), DATABASE())
+  AND table_name = :table
+  AND (extra LIKE '%VIRTUAL GENERATED%' OR extra LIKE '%STORED GENERATED%')
+ORDER BY ordinal_position`
+
+	// Hidden columns 2 and 3 are virtual and stored generated columns.
+	sqliteGeneratedColumns = `
+SELECT name FROM pragma_table_xinfo(:table)
+WHERE hidden IN (2, 3) AND :schema = ''`
+
+	informationSchemaDefaultColumns = `
+SELECT column_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF(:schema, ''), current_schema())
+  AND table_name = :table
+  AND column_default IS NOT NULL
+ORDER BY ordinal_position`
+
+	mysqlDefaultColumns = `
+SELECT column_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF(:schema, ''), DATABASE())
+  AND table_name = :table
+  AND column_default IS NOT NULL
+ORDER BY ordinal_position`
+
+	sqliteDefaultColumns = `
+SELECT name FROM pragma_table_info(:table)
+WHERE dflt_value IS NOT NULL AND :schema = ''`
+)
+
+// identityModes are the accepted values of -identity.
+var identityModes = map[string]bool{"": true, "insert": true, "generate": true}
+
+// identityColumns returns the columns of table whose values the database
+// generates unless they are given.
+func identityColumns(ctx context.Context, cnxn *connection, table tableIdent) ([]string, error) {
+	return queryColumns(ctx, cnxn, table, "identity columns", map[string]string{
+		"postgres": informationSchemaIdentityColumns,
+		"duckdb":   informationSchemaIdentityColumns,
+		"mysql":    mysqlIdentityColumns,
+		"sqlite":   sqliteIdentityColumns,
+	})
+}
+
+// generatedColumns returns the columns of table computed from other columns,
+// which cannot be written. Engines that cannot list them have none.
+func generatedColumns(ctx context.Context, cnxn *connection, table tableIdent) ([]string, error) {
+	cols, err := queryColumns(ctx, cnxn, table, "generated columns", map[string]string{
+		"postgres": postgresGeneratedColumns,
+		"mysql":    mysqlGeneratedColumns,
+		"sqlite":   sqliteGeneratedColumns,
+	})
+	if errors.Is(err, errNoCatalogQuery) {
+		return nil, nil
+	}
+	return cols, err
+}
+
+// defaultColumns returns the columns of table with a DEFAULT, which may be
+// left out of the rows written. Engines that cannot list them have none.
+func defaultColumns(ctx context.Context, cnxn *connection, table tableIdent) ([]string, error) {
+	cols, err := queryColumns(ctx, cnxn, table, "column defaults", map[string]string{
+		"postgres": informationSchemaDefaultColumns,
+		"duckdb":   informationSchemaDefaultColumns,
+		"mysql":    mysqlDefaultColumns,
+		"sqlite":   sqliteDefaultColumns,
+	})
+	if errors.Is(err, errNoCatalogQuery) {
+		return nil, nil
+	}
+	return cols, err
+}
+
+// queryColumns runs the engine's query from queries for table and returns
+// the column names it lists.
+func queryColumns(ctx context.Context, cnxn *connection, table tableIdent, what string, queries map[string]string) ([]string, error) {
+	query, err := catalogQuery(cnxn, what, queries)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queryStrings(ctx, cnxn, query,
+		queryParam{Name: "schema", Type: "string", Value: table.schema()},
+		queryParam{Name: "table", Type: "string", Value: table.table()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s of %s: %w", what, table, err)
+	}
+	cols := make([]string, len(rows))
+	for i, r := range rows {
+		cols[i] = r[0]
+	}
+	return cols, nil
+}
+
+// resetSequences moves the sequence behind each identity column of table
+// past the column's largest value, so rows inserted after an import that
+// wrote explicit values don't collide with them. SQLite and MySQL do this
+// on every insert, so only Postgres sequences are reset.
+func resetSequences(ctx context.Context, cnxn *connection, table tableIdent) error {
+	switch name := cnxn.opts.dialect().name; name {
+	case "postgres":
+	case "sqlite", "mysql":
+		return nil
+	default:
+		return fmt.Errorf("resetting sequences is not supported for engine %q", name)
+	}
+
+	cols, err := identityColumns(ctx, cnxn, table)
+	if err != nil {
+		return err
+	}
+	quoted := table.quote(cnxn.opts.dialect())
+	for _, col := range cols {
+		query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence(:table, :column), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+			cnxn.opts.quoteIdent(col), quoted)
+		if _, err := queryStrings(ctx, cnxn, query,
+			queryParam{Name: "table", Type: "string", Value: quoted},
+			queryParam{Name: "column", Type: "string", Value: col}); err != nil {
+			return fmt.Errorf("failed to reset sequence of %s.%s: %w", table, col, err)
+		}
+		logger(ctx).Debug("Reset sequence", "column", col)
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ingestStream appends every record in stream to table using ADBC bulk
+// ingest, returning the number of rows written. Records are sliced to
+// -write-batch-rows before they are bound.
+func ingestStream(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "ingest", attribute.String("db.table", table.String()))
+	defer func() { endSpan(span, err) }()
+
+	stmt, err := cnxn.NewStatement()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create statement: %w", err)
+	}
+	defer stmt.Close()
+
+	if err := stmt.SetOption(adbc.OptionKeyIngestTargetTable, table.table()); err != nil {
+		return 0, fmt.Errorf("failed to set ingest target: %w", err)
+	}
+	if schema := table.schema(); schema != "" {
+		if err := stmt.SetOption(adbc.OptionValueIngestTargetDBSchema, schema); err != nil {
+			return 0, fmt.Errorf("failed to set ingest target schema: %w", err)
+		}
+	}
+	if catalog := table.catalog(); catalog != "" {
+		if err := stmt.SetOption(adbc.OptionValueIngestTargetCatalog, catalog); err != nil {
+			return 0, fmt.Errorf("failed to set ingest target catalog: %w", err)
+		}
+	}
+	if err := stmt.SetOption(adbc.OptionKeyIngestMode, adbc.OptionValueIngestModeAppend); err != nil {
+		return 0, fmt.Errorf("failed to set ingest mode: %w", err)
+	}
+	if n := cnxn.opts.WriteBatchRows; n > 0 {
+		sliced := newSliceReader(stream, int64(n))
+		defer sliced.Release()
+		stream = sliced
+	}
+	if err := stmt.BindStream(ctx, stream); err != nil {
+		return 0, fmt.Errorf("failed to bind stream: %w", err)
+	}
+
+	n, err := stmt.ExecuteUpdate(ctx)
+	if err != nil {
+		return 0, classify(errWrite, fmt.Errorf("failed to ingest into %s: %w", table, err))
+	}
+	return n, nil
+}
+
+// parquetBatchRows is the number of rows read from Parquet files per batch.
+const parquetBatchRows = 64 * 1024
+
+// importOptions control how imported rows are written.
+type importOptions struct {
+	// Identity is "insert" (or "") to write the file's values into identity
+	// columns, or "generate" to leave those columns to the database.
+	Identity string
+	// ResetSequences moves the sequences of identity columns past their
+	// largest value after each table is loaded.
+	ResetSequences bool
+	// DeferConstraints loads every table in one transaction, checking
+	// foreign keys when it commits.
+	DeferConstraints bool
+	// LoadStrategy is how rows reach the table, one of loadStrategies; ""
+	// is bind.
+	LoadStrategy string
+	// Parallel, if above 1, is the number of connections the rows of each
+	// file are sharded across.
+	Parallel int
+	// Where restricts the rows imported from each file.
+	Where []*filterTransform
+	// SchemaMismatch is the policy for a glob or directory of files whose
+	// schemas differ, fail (or "") or union.
+	SchemaMismatch string
+	// AsOf, if set, imports a dataset as of this snapshot tag.
+	AsOf string
+}
+
+// importFile appends the contents of the Parquet file at path, or of the
+// files of a glob or directory, to table.
+// Columns are matched by name after renaming them through mapping
+// (src -> dest), so the file's column order does not matter.
+func importFile(ctx context.Context, opts connOptions, path, tableName string, mapping map[string]string, io importOptions) (*response, error) {
+	table, err := opts.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cnxn.Close()
+	return importInto(ctx, cnxn, path, table, mapping, io)
+}
+
+// importInto is importFile on an open connection.
+func importInto(ctx context.Context, cnxn *connection, path string, table tableIdent, mapping map[string]string, io importOptions) (resp *response, err error) {
+	startTime := time.Now()
+	defer func() {
+		r := newAuditRecord("import", cnxn.opts.URI, "", startTime, resp, err)
+		r.Source, r.Destinations = path, []string{table.String()}
+		if aerr := audit.record(ctx, r); aerr != nil && err == nil {
+			err = aerr
+		}
+	}()
+	skip, err := generatedColumns(ctx, cnxn, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(skip) > 0 {
+		logger(ctx).Debug("Leaving generated columns to the database", "columns", skip)
+	}
+	if io.Identity == "generate" {
+		cols, err := identityColumns(ctx, cnxn, table)
+		if err != nil {
+			return nil, err
+		}
+		logger(ctx).Debug("Leaving identity columns to the database", "columns", cols)
+		skip = append(skip, cols...)
+	}
+
+	reader, closeFile, err := openParquetInput(ctx, path, nil, nil, io.Where, io.SchemaMismatch, io.AsOf)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile()
+	defer reader.Release()
+
+	mapped, err := mapColumns(ctx, cnxn, table, reader, mapping, skip)
+	if err != nil {
+		return nil, err
+	}
+	defer mapped.Release()
+
+	rows, err := loadStream(ctx, cnxn, table, mapped, io)
+	if err != nil {
+		return nil, err
+	}
+	if io.ResetSequences {
+		if err := resetSequences(ctx, cnxn, table); err != nil {
+			return nil, err
+		}
+	}
+
+	return &response{
+		RowsWritten: rows,
+		Message:     "Data successfully imported from Parquet file",
+		Duration:    time.Since(startTime),
+		Location:    path,
+		schema:      reader.Schema(),
+	}, nil
+}
+
+// readImportDir lists the files to import from dir: the tables of its
+// subset.json if it has one, otherwise every Parquet file, into the table
+// named after it.
+func readImportDir(dir string) ([]subsetEntry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, subsetManifestName))
+	if err == nil {
+		var sf subsetFile
+		if err := json.Unmarshal(data, &sf); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", subsetManifestName, err)
+		}
+		return sf.Tables, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.parquet"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no Parquet files in %s", dir)
+	}
+	loads := make([]subsetEntry, len(paths))
+	for i, path := range paths {
+		file := filepath.Base(path)
+		loads[i] = subsetEntry{Table: strings.TrimSuffix(file, ".parquet"), File: file}
+	}
+	return loads, nil
+}
+
+// importTables imports each file of loads, relative to dir, into its table,
+// returning loads in the order they were imported with their Rows set.
+// Tables are loaded after the tables they reference, so foreign keys are
+// satisfied as rows arrive. With DeferConstraints every file is loaded in
+// one transaction with foreign key checks postponed to the commit, which is
+// needed for tables that reference each other; a failure then leaves every
+// table unchanged.
+func importTables(ctx context.Context, opts connOptions, dir string, loads []subsetEntry, io importOptions) (_ []subsetEntry, err error) {
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cnxn.Close()
+
+	tables := make([]tableIdent, len(loads))
+	for i, l := range loads {
+		if tables[i], err = opts.table(l.Table); err != nil {
+			return nil, err
+		}
+	}
+	if len(loads) > 1 {
+		if loads, tables, err = orderLoads(ctx, cnxn, loads, tables, io.DeferConstraints); err != nil {
+			return nil, err
+		}
+	}
+
+	if io.DeferConstraints {
+		stmt := opts.dialect().deferConstraints
+		if stmt == "" {
+			return nil, classify(errUsage, fmt.Errorf("deferring constraints is not supported for engine %q", opts.dialect().name))
+		}
+		setter, ok := cnxn.Connection.(adbc.PostInitOptions)
+		if !ok {
+			return nil, classify(errUsage, fmt.Errorf("the driver does not support transactions"))
+		}
+		if err := setter.SetOption(adbc.OptionKeyAutoCommit, adbc.OptionValueDisabled); err != nil {
+			return nil, fmt.Errorf("failed to start transaction: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				cnxn.Rollback(context.WithoutCancel(ctx))
+			}
+		}()
+		if err := execUpdate(ctx, cnxn, stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range loads {
+		ctx := withLogAttrs(ctx, "table", tables[i].String())
+		resp, err := importInto(ctx, cnxn, filepath.Join(dir, loads[i].File), tables[i], nil, io)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", tables[i], err)
+		}
+		loads[i].Rows = resp.RowsWritten
+		logger(ctx).Info(resp.Message, "rows", resp.RowsWritten, "duration", resp.Duration, "file", resp.Location)
+	}
+
+	if io.DeferConstraints {
+		if err := cnxn.Commit(ctx); err != nil {
+			return nil, classify(errWrite, fmt.Errorf("failed to commit import: %w", err))
+		}
+	}
+	return loads, nil
+}
+
+// orderLoads sorts loads, and their tables, into foreign key dependency
+// order. Without foreign key information the order is left as it is.
+func orderLoads(ctx context.Context, cnxn *connection, loads []subsetEntry, tables []tableIdent, deferConstraints bool) ([]subsetEntry, []tableIdent, error) {
+	fks, err := loadForeignKeys(ctx, cnxn)
+	if err != nil {
+		logger(ctx).Warn("Importing in file order", "reason", err)
+		return loads, tables, nil
+	}
+
+	byTable := make(map[string][]int)
+	var unique []tableIdent
+	for i, t := range tables {
+		t, err := resolveTable(t, fks)
+		if err != nil {
+			return nil, nil, classify(errUsage, err)
+		}
+		tables[i] = t
+		if _, ok := byTable[t.String()]; !ok {
+			unique = append(unique, t)
+		}
+		byTable[t.String()] = append(byTable[t.String()], i)
+	}
+	ordered, cyclic := dependencyOrder(unique, fks)
+	if len(cyclic) > 0 && !deferConstraints {
+		logger(ctx).Warn("Tables reference each other; use -defer-constraints if the import fails", "tables", cyclic)
+	}
+
+	sortedLoads := make([]subsetEntry, 0, len(loads))
+	sortedTables := make([]tableIdent, 0, len(tables))
+	for _, t := range ordered {
+		for _, i := range byTable[t.String()] {
+			sortedLoads = append(sortedLoads, loads[i])
+			sortedTables = append(sortedTables, tables[i])
+		}
+	}
+	return sortedLoads, sortedTables, nil
+}
+
+// runImport implements `dbx import -file data.parquet -table name` and
+// `dbx import -dir subset/`.
+func runImport(cfg config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	path := fs.String("file", "", "Parquet file, glob or Hive-partitioned directory to import, its files loaded as one stream, or an sftp:// file")
+	table := fs.String("table", "", "Existing table to append to")
+	dir := fs.String("dir", "", "Directory of Parquet files to import into the tables they are named after, or written by -follow-fks, loading referenced tables first")
+	deferConstraints := fs.Bool("defer-constraints", false, "Import in a single transaction, checking foreign keys only when it commits")
+	identity := fs.String("identity", "insert", "Identity and serial columns: insert the file's values, or generate new ones in the database")
+	resetSeqs := fs.Bool("reset-sequences", false, "After importing, move the sequences of identity and serial columns past their largest value")
+	var maps stringList
+	fs.Var(&maps, "map", "Import file column src_col into table column dest_col, as src_col=dest_col (repeatable)")
+	loadStrategy := fs.String("load-strategy", "bind", "How rows are loaded: bind, with ADBC bulk ingest; stage, uploading Parquet to a temporary Snowflake stage and running COPY INTO; copy, with Postgres binary COPY; or staging-swap, loading a staging table and inserting its rows in one transaction, so readers never see a partial import")
+	parallel := fs.Int("parallel", 1, "Shard the record batches of each file across this many connections, each loading in a statement of its own")
+	var where stringList
+	fs.Var(&where, "where", "Import only rows matching \"column op value\" or \"column is [not] null\", skipping row groups whose statistics rule them out (repeatable, all must hold)")
+	verify := fs.String("verify-manifest", "", "Before importing -file, check its size, checksum and row count against this export manifest")
+	mismatch := fs.String("schema-mismatch", mismatchFail, "When the files of -file differ in schema: fail, or import the union of their columns, as nulls where a file lacks one")
+	asOf := fs.String("as-of", "", "Import the -file dataset, written with -append-to, as of this snapshot tag")
+	parseFlags(fs, args)
+
+	if !identityModes[*identity] {
+		return fmt.Errorf("invalid -identity %q, expected insert or generate", *identity)
+	}
+	if err := validLoadStrategy(*loadStrategy, cfg.conn); err != nil {
+		return classify(errUsage, err)
+	}
+	if *loadStrategy == "copy" && *deferConstraints {
+		return classify(errUsage, fmt.Errorf("-load-strategy copy loads over a connection of its own, outside the -defer-constraints transaction"))
+	}
+	if *parallel < 1 {
+		return classify(errUsage, fmt.Errorf("-parallel must be positive"))
+	}
+	if *parallel > 1 && (*deferConstraints || *loadStrategy == "staging-swap") {
+		return classify(errUsage, fmt.Errorf("-parallel loads in a transaction per connection, so it cannot be combined with -defer-constraints or -load-strategy staging-swap"))
+	}
+	if err := validSchemaMismatch(*mismatch); err != nil {
+		return classify(errUsage, err)
+	}
+	filters, err := parseFilters(where)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	io := importOptions{Identity: *identity, ResetSequences: *resetSeqs, DeferConstraints: *deferConstraints, LoadStrategy: *loadStrategy, Parallel: *parallel, Where: filters, SchemaMismatch: *mismatch, AsOf: *asOf}
+	if *dir != "" {
+		if *path != "" || *table != "" || len(maps) > 0 || *verify != "" || *asOf != "" {
+			return fmt.Errorf("-dir cannot be combined with -file, -table, -map, -verify-manifest or -as-of")
+		}
+		return runImportDir(cfg, *dir, nil, io)
+	}
+	if *path == "" || *table == "" {
+		return fmt.Errorf("-file and -table, or -dir, are required")
+	}
+	mapping, err := parseColumnMap(maps)
+	if err != nil {
+		return err
+	}
+	input := *path
+	if isSFTP(*path) {
+		if *asOf != "" {
+			return classify(errUsage, fmt.Errorf("-as-of requires a local dataset"))
+		}
+		local, cleanup, err := fetchInput(context.Background(), *path)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		*path = local
+	}
+	if multi, err := isMultiFile(*path); err != nil {
+		return err
+	} else if (multi || *asOf != "") && (*verify != "" || *deferConstraints) {
+		return classify(errUsage, fmt.Errorf("-verify-manifest and -defer-constraints require -file to be a single file"))
+	}
+	if *verify != "" {
+		if err := verifyManifest(*path, *verify); err != nil {
+			return fmt.Errorf("refusing to import %s: %w", *path, err)
+		}
+		slog.Info("Verified file against manifest", "file", *path, "manifest", *verify)
+	}
+	if *deferConstraints {
+		if len(mapping) > 0 {
+			return fmt.Errorf("-defer-constraints cannot be combined with -map")
+		}
+		dir, file := filepath.Split(*path)
+		return runImportDir(cfg, dir, []subsetEntry{{Table: *table, File: file}}, io)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	resp, err := importFile(withLogAttrs(ctx, "table", *table), cfg.conn, *path, *table, mapping, io)
+	if err != nil {
+		return err
+	}
+	slog.Info(resp.Message, "table", *table, "rows", resp.RowsWritten, "duration", resp.Duration, "file", redactURI(input))
+	if cfg.json {
+		printJSON(resp)
+	}
+	return nil
+}
+
+// runImportDir imports loads, or the files listed by dir if loads is nil.
+func runImportDir(cfg config, dir string, loads []subsetEntry, io importOptions) error {
+	if loads == nil {
+		var err error
+		if loads, err = readImportDir(dir); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	loads, err := importTables(ctx, cfg.conn, dir, loads, io)
+	if err != nil {
+		return err
+	}
+	if cfg.json {
+		printJSON(loads)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// job states
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// jobPollInterval is how often a running job checks whether it was cancelled.
+const jobPollInterval = time.Second
+
+// job is a long-running export or import started by the HTTP server or a
+// detached CLI run.
+type job struct {
+	ID     string          `json:"id"`
+	Kind   string          `json:"kind"`
+	State  string          `json:"state"`
+	Spec   json.RawMessage `json:"spec,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Result *response       `json:"result,omitempty"`
+	PID    int             `json:"pid,omitempty"`
+	// Owner is the name of the API token that started the job on a
+	// server; only that token sees it there.
+	Owner           string    `json:"owner,omitempty"`
+	CancelRequested bool      `json:"cancel_requested,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// jobStore persists jobs in a local SQLite database shared by every dbX
+// process on the machine, so jobs can be inspected and cancelled from any of
+// them.
+type jobStore struct {
+	db *sql.DB
+}
+
+const jobsDDL = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id               TEXT PRIMARY KEY,
+	kind             TEXT NOT NULL,
+	state            TEXT NOT NULL,
+	spec             TEXT NOT NULL,
+	error            TEXT NOT NULL DEFAULT '',
+	result           TEXT NOT NULL DEFAULT '',
+	pid              INTEGER NOT NULL DEFAULT 0,
+	cancel_requested INTEGER NOT NULL DEFAULT 0,
+	owner            TEXT NOT NULL DEFAULT '',
+	created_at       TIMESTAMP NOT NULL,
+	updated_at       TIMESTAMP NOT NULL
+)`
+
+// jobsOwnerDDL adds the owner column to job stores created before jobs had
+// owners.
+const jobsOwnerDDL = `ALTER TABLE jobs ADD COLUMN owner TEXT NOT NULL DEFAULT ''`
+
+func openJobStore(path string) (*jobStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store: %w", err)
+	}
+	if _, err := db.Exec(jobsDDL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job store: %w", err)
+	}
+	if _, err := db.Exec(`SELECT owner FROM jobs LIMIT 0`); err != nil {
+		if _, err := db.Exec(jobsOwnerDDL); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize job store: %w", err)
+		}
+	}
+	return &jobStore{db: db}, nil
+}
+
+func (s *jobStore) Close() error {
+	return s.db.Close()
+}
+
+// create records a new running job of process pid, started with the API
+// token named owner, if any.
+func (s *jobStore) create(kind, owner string, spec any, pid int) (*job, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job spec: %w", err)
+	}
+
+	now := time.Now().UTC()
+	j := &job{ID: newJobID(), Kind: kind, State: jobRunning, Spec: data, PID: pid, Owner: owner, CreatedAt: now, UpdatedAt: now}
+	_, err = s.db.Exec(`
+	INSERT INTO jobs (id, kind, state, spec, pid, owner, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		j.ID, j.Kind, j.State, string(j.Spec), j.PID, j.Owner, j.CreatedAt, j.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	return j, nil
+}
+
+// setPID records the process that is executing a job.
+func (s *jobStore) setPID(id string, pid int) error {
+	if _, err := s.db.Exec(`UPDATE jobs SET pid = ?, updated_at = ? WHERE id = ?`, pid, time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// reopen marks job id running again, clearing its previous outcome, for
+// `dbx jobs resume`.
+func (s *jobStore) reopen(id string) error {
+	_, err := s.db.Exec(`
+	UPDATE jobs SET state = ?, result = '', error = '', pid = 0, cancel_requested = 0, updated_at = ? WHERE id = ?`,
+		jobRunning, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// finish records the outcome of a job.
+func (s *jobStore) finish(id, state string, resp *response, jobErr error) error {
+	var result, errMsg string
+	if resp != nil {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return fmt.Errorf("failed to encode job result: %w", err)
+		}
+		result = string(data)
+	}
+	if jobErr != nil {
+		errMsg = jobErr.Error()
+	}
+
+	_, err := s.db.Exec(`
+	UPDATE jobs SET state = ?, result = ?, error = ?, updated_at = ? WHERE id = ?`,
+		state, result, errMsg, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// requestCancel asks the process running a job to stop it. Jobs whose
+// process has already gone away are marked cancelled directly.
+func (s *jobStore) requestCancel(id string) (*job, error) {
+	j, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if j.State != jobRunning {
+		return nil, fmt.Errorf("job %s is already %s", id, j.State)
+	}
+
+	// A detached job has no PID until its process has started; it checks
+	// for the request once it runs.
+	if j.PID != 0 && !processAlive(j.PID) {
+		if err := s.finish(id, jobCancelled, nil, errors.New("process exited before the job finished")); err != nil {
+			return nil, err
+		}
+	} else if _, err := s.db.Exec(`UPDATE jobs SET cancel_requested = 1, updated_at = ? WHERE id = ?`, time.Now().UTC(), id); err != nil {
+		return nil, fmt.Errorf("failed to update job: %w", err)
+	}
+	return s.get(id)
+}
+
+func (s *jobStore) get(id string) (*job, error) {
+	jobs, err := s.query(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("unknown job %q", id)
+	}
+	return &jobs[0], nil
+}
+
+// ownedJob returns job id if token t may see it. The jobs of other tokens
+// are reported unknown, as missing ones are.
+func ownedJob(s *jobStore, t *accessToken, id string) (*job, error) {
+	j, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !t.ownsJob(j) {
+		return nil, fmt.Errorf("unknown job %q", id)
+	}
+	return j, nil
+}
+
+func (s *jobStore) list() ([]job, error) {
+	return s.query(`ORDER BY created_at DESC`)
+}
+
+func (s *jobStore) query(where string, args ...any) ([]job, error) {
+	rows, err := s.db.Query(`
+	SELECT id, kind, state, spec, error, result, pid, cancel_requested, owner, created_at, updated_at
+	FROM jobs `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []job
+	for rows.Next() {
+		var j job
+		var spec, result string
+		if err := rows.Scan(&j.ID, &j.Kind, &j.State, &spec, &j.Error, &result, &j.PID, &j.CancelRequested, &j.Owner, &j.CreatedAt, &j.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		j.Spec = json.RawMessage(spec)
+		if result != "" {
+			j.Result = new(response)
+			if err := json.Unmarshal([]byte(result), j.Result); err != nil {
+				return nil, fmt.Errorf("failed to decode result of job %s: %w", j.ID, err)
+			}
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+// run executes fn as job id and records its outcome. The context passed to
+// fn is cancelled when `dbx jobs cancel` is requested for the job.
+func (s *jobStore) run(ctx context.Context, id string, fn func(ctx context.Context) (*response, error)) (*response, error) {
+	ctx, cancel := context.WithCancel(withLogAttrs(ctx, "job_id", id))
+	defer cancel()
+
+	cancelled := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if j, err := s.get(id); err == nil && j.CancelRequested {
+					close(cancelled)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	resp, err := fn(ctx)
+	cancel()
+
+	state := jobSucceeded
+	select {
+	case <-cancelled:
+		state, err = jobCancelled, classify(errCancelled, errors.New("cancelled by request"))
+	default:
+		if err != nil {
+			state = jobFailed
+		}
+	}
+	if ferr := s.finish(id, state, resp, err); ferr != nil && err == nil {
+		err = ferr
+	}
+	return resp, err
+}
+
+// detachJob re-runs the current command in a background process tracked as a
+// new job, returning its ID. The child inherits every argument except
+// -detach, and logs to <jobs dir>/<id>.log.
+func detachJob(s *jobStore, kind, jobsDB string) (*job, error) {
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if name := strings.TrimLeft(arg, "-"); strings.HasPrefix(arg, "-") && (name == "detach" || name == "detach=true") {
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	j, err := s.create(kind, "", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return j, spawnJob(s, j.ID, args, jobsDB)
+}
+
+// resumeJob runs detached job id again, in a new background process, after
+// it failed, was cancelled or its process went away. It runs the command
+// as first given, so an export skips outputs whose manifest shows them up
+// to date and, with -cache, picks up the rows it had already read.
+func resumeJob(s *jobStore, id, jobsDB string) (*job, error) {
+	j, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case j.State == jobSucceeded:
+		return nil, fmt.Errorf("job %s already succeeded", id)
+	case j.State == jobRunning && processAlive(j.PID):
+		return nil, fmt.Errorf("job %s is still running", id)
+	}
+	var args []string
+	if err := json.Unmarshal(j.Spec, &args); err != nil {
+		return nil, fmt.Errorf("job %s was started by a server, which runs it again if asked", id)
+	}
+	if err := s.reopen(id); err != nil {
+		return nil, err
+	}
+	if err := spawnJob(s, id, args, jobsDB); err != nil {
+		return nil, err
+	}
+	return s.get(id)
+}
+
+// spawnJob starts the background process running job id, the dbx command
+// args, appending its output to <jobs dir>/<id>.log.
+func spawnJob(s *jobStore, id string, args []string, jobsDB string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate dbx executable: %w", err)
+	}
+	logFile, err := os.OpenFile(filepath.Join(filepath.Dir(jobsDB), id+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create job log: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(self, append([]string{"-job-id", id}, args...)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		s.finish(id, jobFailed, nil, err)
+		return fmt.Errorf("failed to start detached process: %w", err)
+	}
+	if err := s.setPID(id, cmd.Process.Pid); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// runAsJob runs fn under the job store: either by detaching it into a
+// background process (returning a nil response), or, inside that process, as
+// the job it was started for.
+func runAsJob(cfg config, detach bool, jobID, kind string, fn func(ctx context.Context) (*response, error)) (*response, error) {
+	store, err := openJobStore(cfg.jobsDB)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	if detach {
+		j, err := detachJob(store, kind, cfg.jobsDB)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Started job %s\n", j.ID)
+		return nil, nil
+	}
+	return store.run(context.Background(), jobID, fn)
+}
+
+// defaultJobsDB returns the job store location, $DBX_JOBS_DB or ~/.dbx/jobs.db.
+func defaultJobsDB() string {
+	if path := os.Getenv("DBX_JOBS_DB"); path != "" {
+		return path
+	}
+	return dbxPath("jobs.db")
+}
+
+// runJobs implements `dbx jobs list|status <id>|cancel <id>|resume <id>`.
+func runJobs(cfg config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: dbx jobs list | status <id> | cancel <id> | resume <id>")
+	}
+
+	store, err := openJobStore(cfg.jobsDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "list":
+		jobs, err := store.list()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tKIND\tSTATE\tCREATED\tUPDATED")
+		for _, j := range jobs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", j.ID, j.Kind, j.State,
+				j.CreatedAt.Local().Format(time.RFC3339), j.UpdatedAt.Local().Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "status", "cancel", "resume":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: dbx jobs %s <id>", args[0])
+		}
+		var j *job
+		switch args[0] {
+		case "status":
+			j, err = store.get(args[1])
+		case "cancel":
+			j, err = store.requestCancel(args[1])
+		default:
+			j, err = resumeJob(store, args[1], cfg.jobsDB)
+		}
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(j)
+	default:
+		return fmt.Errorf("unknown jobs command %q", args[0])
+	}
+}
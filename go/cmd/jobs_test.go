@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestJobsScopedToToken checks that, over HTTP, each token only lists,
+// inspects and cancels the jobs started with it.
+func TestJobsScopedToToken(t *testing.T) {
+	store, err := openJobStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	owners := map[string]string{}
+	for _, owner := range []string{"a", "a", "b"} {
+		j, err := store.create("export", owner, nil, os.Getpid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[j.ID] = owner
+	}
+
+	token := func(name string) *accessToken {
+		sum := sha256.Sum256([]byte("secret-" + name))
+		return &accessToken{Name: name, Operations: []string{"jobs"}, hash: sum[:]}
+	}
+	scoped := (&httpServer{store: store, access: &accessPolicy{tokens: []*accessToken{token("a"), token("b")}}}).routes()
+	open := (&httpServer{store: store}).routes()
+	do := func(h http.Handler, method, path, name string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if name != "" {
+			r.Header.Set("Authorization", "Bearer secret-"+name)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	list := func(h http.Handler, name string) []string {
+		w := do(h, "GET", "/jobs", name)
+		var jobs []job
+		if err := json.NewDecoder(w.Body).Decode(&jobs); err != nil {
+			t.Fatalf("GET /jobs as %q: %v", name, err)
+		}
+		var got []string
+		for _, j := range jobs {
+			got = append(got, j.Owner)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	if got := list(scoped, "a"); len(got) != 2 || got[0] != "a" || got[1] != "a" {
+		t.Errorf("token a lists jobs of %v, want [a a]", got)
+	}
+	if got := list(scoped, "b"); len(got) != 1 || got[0] != "b" {
+		t.Errorf("token b lists jobs of %v, want [b]", got)
+	}
+	if got := list(open, ""); len(got) != 3 {
+		t.Errorf("without tokens, jobs of %v are listed, want all 3", got)
+	}
+
+	for id, owner := range owners {
+		for _, name := range []string{"a", "b"} {
+			want := http.StatusNotFound
+			if name == owner {
+				want = http.StatusOK
+			}
+			if w := do(scoped, "GET", "/jobs/"+id, name); w.Code != want {
+				t.Errorf("GET job of %s as %s: status %d, want %d", owner, name, w.Code, want)
+			}
+			if name == owner {
+				continue
+			}
+			if w := do(scoped, "POST", "/jobs/"+id+"/cancel", name); w.Code != http.StatusNotFound {
+				t.Errorf("cancel job of %s as %s: status %d, want %d", owner, name, w.Code, http.StatusNotFound)
+			}
+			if j, err := store.get(id); err != nil || j.CancelRequested {
+				t.Errorf("job of %s was cancelled by %s", owner, name)
+			}
+		}
+		if w := do(scoped, "POST", "/jobs/"+id+"/cancel", owner); w.Code != http.StatusAccepted {
+			t.Errorf("cancel job of %s as %s: status %d, want %d", owner, owner, w.Code, http.StatusAccepted)
+		}
+	}
+}
+
+func TestResumeJob(t *testing.T) {
+	jobsDB := filepath.Join(t.TempDir(), "jobs.db")
+	store, err := openJobStore(jobsDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	newJob := func(spec any, pid int, state string) string {
+		j, err := store.create("export", "", spec, pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != jobRunning {
+			if err := store.finish(j.ID, state, nil, errors.New("failed")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return j.ID
+	}
+	// The detached process is this test binary, which exits at once on
+	// the -job-id flag it does not know.
+	args := []string{"export", "-table", "t"}
+
+	for _, tt := range []struct {
+		name string
+		id   string
+		err  string
+	}{
+		{"succeeded", newJob(args, 0, jobSucceeded), "already succeeded"},
+		{"running", newJob(args, os.Getpid(), jobRunning), "still running"},
+		{"server job", newJob(exportRequest{Table: "t"}, 0, jobFailed), "started by a server"},
+		{"unknown", "nope", "unknown job"},
+	} {
+		if _, err := resumeJob(store, tt.id, jobsDB); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: resumeJob error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	for _, state := range []string{jobFailed, jobCancelled} {
+		id := newJob(args, 0, state)
+		j, err := resumeJob(store, id, jobsDB)
+		if err != nil {
+			t.Fatalf("resuming a %s job: %v", state, err)
+		}
+		if j.State != jobRunning || j.Error != "" || j.PID == 0 {
+			t.Errorf("resumed %s job is %s (%q) with PID %d, want running with a new process", state, j.State, j.Error, j.PID)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// `dbx k8s render` turns a pipeline file into manifests for `kubectl apply`:
+// a ConfigMap holding the pipeline, and a Job running `dbx run` on it, or a
+// CronJob with -schedule. Connection settings and secrets reach the
+// container as DBX_* variables, from -secret and -env, and the outcome is
+// written to the termination log.
+
+const (
+	// k8sPipelineDir is where the pipeline ConfigMap is mounted.
+	k8sPipelineDir = "/etc/dbx"
+	// k8sDataDir is where the -pvc volume is mounted, and the working
+	// directory relative sink paths resolve against.
+	k8sDataDir = "/data"
+	// k8sMaxName is the longest CronJob name Kubernetes accepts, as it
+	// appends a timestamp to name the Jobs.
+	k8sMaxName = 52
+)
+
+var (
+	k8sNameRE    = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	k8sInvalidRE = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+type k8sObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	Spec       any               `yaml:"spec,omitempty"`
+}
+
+type k8sMetadata struct {
+	Name      string            `yaml:"name,omitempty"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type k8sCronJobSpec struct {
+	Schedule          string `yaml:"schedule"`
+	ConcurrencyPolicy string `yaml:"concurrencyPolicy"`
+	JobTemplate       struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     k8sJobSpec  `yaml:"spec"`
+	} `yaml:"jobTemplate"`
+}
+
+type k8sJobSpec struct {
+	BackoffLimit int `yaml:"backoffLimit"`
+	Template     struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     k8sPodSpec  `yaml:"spec"`
+	} `yaml:"template"`
+}
+
+type k8sPodSpec struct {
+	RestartPolicy string         `yaml:"restartPolicy"`
+	Containers    []k8sContainer `yaml:"containers"`
+	Volumes       []k8sVolume    `yaml:"volumes"`
+}
+
+type k8sContainer struct {
+	Name         string           `yaml:"name"`
+	Image        string           `yaml:"image"`
+	Args         []string         `yaml:"args"`
+	WorkingDir   string           `yaml:"workingDir,omitempty"`
+	Env          []k8sEnvVar      `yaml:"env,omitempty"`
+	EnvFrom      []k8sEnvFrom     `yaml:"envFrom,omitempty"`
+	Resources    *k8sResources    `yaml:"resources,omitempty"`
+	VolumeMounts []k8sVolumeMount `yaml:"volumeMounts"`
+}
+
+type k8sEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type k8sEnvFrom struct {
+	SecretRef struct {
+		Name string `yaml:"name"`
+	} `yaml:"secretRef"`
+}
+
+type k8sResources struct {
+	Requests map[string]string `yaml:"requests,omitempty"`
+	Limits   map[string]string `yaml:"limits,omitempty"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+type k8sVolume struct {
+	Name      string `yaml:"name"`
+	ConfigMap *struct {
+		Name string `yaml:"name"`
+	} `yaml:"configMap,omitempty"`
+	PersistentVolumeClaim *struct {
+		ClaimName string `yaml:"claimName"`
+	} `yaml:"persistentVolumeClaim,omitempty"`
+}
+
+// runK8s implements `dbx k8s render`.
+func runK8s(cfg config, args []string) error {
+	if len(args) == 0 || args[0] != "render" {
+		return classify(errUsage, fmt.Errorf("usage: dbx k8s render [flags] <pipeline.yaml>"))
+	}
+	fs := flag.NewFlagSet("k8s render", flag.ExitOnError)
+	image := fs.String("image", defaultImage(), "Container image of dbX to run")
+	name := fs.String("name", "", "Name of the Job or CronJob and its ConfigMap (default from the pipeline file name)")
+	namespace := fs.String("namespace", "", "Namespace of the manifests (default kubectl's)")
+	schedule := fs.String("schedule", "", "Render a CronJob running on this cron schedule, e.g. \"0 2 * * *\", instead of a Job")
+	var secrets stringList
+	fs.Var(&secrets, "secret", "Secret whose keys become environment variables, such as DBX_URI (repeatable)")
+	var env stringList
+	fs.Var(&env, "env", "Set an environment variable as NAME=value, such as DBX_LOG_FORMAT=json (repeatable)")
+	cpu := fs.String("cpu", "", "CPU request, e.g. 500m")
+	memory := fs.String("memory", "", "Memory request and limit, e.g. 2Gi")
+	pvc := fs.String("pvc", "", "PersistentVolumeClaim mounted at "+k8sDataDir+", where relative sink paths are written")
+	backoffLimit := fs.Int("backoff-limit", 2, "Retries of a failed run")
+	parseFlags(fs, args[1:])
+	args = fs.Args()
+
+	if len(args) != 1 {
+		return classify(errUsage, fmt.Errorf("usage: dbx k8s render [flags] <pipeline.yaml>"))
+	}
+	path := args[0]
+	p, err := loadPipeline(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read pipeline: %w", err)
+	}
+	warnPipelineSecrets(p, path)
+
+	if *name == "" {
+		*name = k8sName(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	}
+	if !k8sNameRE.MatchString(*name) || len(*name) > k8sMaxName {
+		return classify(errUsage, fmt.Errorf("invalid -name %q: use at most %d lowercase letters, digits and dashes", *name, k8sMaxName))
+	}
+	if *schedule != "" {
+		if _, err := cron.ParseStandard(*schedule); err != nil {
+			return classify(errUsage, fmt.Errorf("invalid -schedule: %w", err))
+		}
+	}
+
+	// Named after the object, as ConfigMap keys allow fewer characters than
+	// file names; `dbx run` names its queries' job after it too.
+	file := *name + ".yaml"
+	labels := map[string]string{"app.kubernetes.io/name": "dbx", "app.kubernetes.io/instance": *name}
+	c := k8sContainer{
+		Name:         "dbx",
+		Image:        *image,
+		Args:         []string{"run", k8sPipelineDir + "/" + file},
+		Env:          []k8sEnvVar{{Name: "DBX_TERMINATION_LOG", Value: "/dev/termination-log"}},
+		VolumeMounts: []k8sVolumeMount{{Name: "pipeline", MountPath: k8sPipelineDir, ReadOnly: true}},
+	}
+	for _, e := range env {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok || k == "" {
+			return classify(errUsage, fmt.Errorf("invalid -env %q, expected NAME=value", e))
+		}
+		c.Env = append(c.Env, k8sEnvVar{Name: k, Value: v})
+	}
+	for _, s := range secrets {
+		var ref k8sEnvFrom
+		ref.SecretRef.Name = s
+		c.EnvFrom = append(c.EnvFrom, ref)
+	}
+	if *cpu != "" || *memory != "" {
+		c.Resources = &k8sResources{Requests: make(map[string]string)}
+		if *cpu != "" {
+			c.Resources.Requests["cpu"] = *cpu
+		}
+		if *memory != "" {
+			// Arrow buffers whole record batches, so running out of memory
+			// is better caught as an OOM kill than as a starved node.
+			c.Resources.Requests["memory"] = *memory
+			c.Resources.Limits = map[string]string{"memory": *memory}
+		}
+	}
+
+	var pod k8sPodSpec
+	pod.RestartPolicy = "Never"
+	pod.Volumes = []k8sVolume{{Name: "pipeline", ConfigMap: &struct {
+		Name string `yaml:"name"`
+	}{Name: *name + "-pipeline"}}}
+	if *pvc != "" {
+		c.WorkingDir = k8sDataDir
+		c.VolumeMounts = append(c.VolumeMounts, k8sVolumeMount{Name: "data", MountPath: k8sDataDir})
+		pod.Volumes = append(pod.Volumes, k8sVolume{Name: "data", PersistentVolumeClaim: &struct {
+			ClaimName string `yaml:"claimName"`
+		}{ClaimName: *pvc}})
+	}
+	pod.Containers = []k8sContainer{c}
+
+	var job k8sJobSpec
+	job.BackoffLimit = *backoffLimit
+	job.Template.Metadata = k8sMetadata{Labels: labels}
+	job.Template.Spec = pod
+
+	meta := k8sMetadata{Name: *name, Namespace: *namespace, Labels: labels}
+	objects := []k8sObject{{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   k8sMetadata{Name: *name + "-pipeline", Namespace: *namespace, Labels: labels},
+		Data:       map[string]string{file: string(data)},
+	}}
+	if *schedule == "" {
+		objects = append(objects, k8sObject{APIVersion: "batch/v1", Kind: "Job", Metadata: meta, Spec: job})
+	} else {
+		// Like `dbx schedule`, a run still in progress is not overlapped.
+		spec := k8sCronJobSpec{Schedule: *schedule, ConcurrencyPolicy: "Forbid"}
+		spec.JobTemplate.Metadata = k8sMetadata{Labels: labels}
+		spec.JobTemplate.Spec = job
+		objects = append(objects, k8sObject{APIVersion: "batch/v1", Kind: "CronJob", Metadata: meta, Spec: spec})
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	for _, o := range objects {
+		if err := enc.Encode(o); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// defaultImage returns the dbX image matching this build.
+func defaultImage() string {
+	if version == "dev" {
+		return "dbx:latest"
+	}
+	return "dbx:" + version
+}
+
+// k8sName turns s into a valid Kubernetes object name.
+func k8sName(s string) string {
+	s = strings.ToLower(s)
+	s = k8sInvalidRE.ReplaceAllString(s, "-")
+	if len(s) > k8sMaxName {
+		s = s[:k8sMaxName]
+	}
+	s = strings.Trim(s, "-")
+	if s == "" {
+		return "dbx"
+	}
+	return s
+}
+
+// warnPipelineSecrets warns about credentials in the pipeline file, which
+// end up in a ConfigMap rather than a Secret.
+func warnPipelineSecrets(p *pipelineFile, path string) {
+	if u, err := url.Parse(p.Source.URI); err == nil {
+		if _, ok := u.User.Password(); ok {
+			slog.Warn("The pipeline's source.uri holds a password, which will be readable in the ConfigMap; remove it and pass DBX_URI through -secret", "pipeline", path)
+		}
+	}
+}
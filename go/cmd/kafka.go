@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
+	"github.com/segmentio/kafka-go"
+)
+
+// messageDecoder appends the row encoded in a Kafka message value to bldr.
+type messageDecoder func(bldr *array.RecordBuilder, value []byte) error
+
+// runKafka implements `dbx kafka`, which continuously ingests a Kafka topic
+// into a table. Offsets are committed to the consumer group only after the
+// batch containing them has been written, so delivery is at-least-once.
+//
+// A message that cannot be decoded stops the consumer by default, before
+// its offset is committed. With -on-decode-error skip it is logged and
+// committed past, and with dead-letter also copied to -dead-letter-topic,
+// so one bad message cannot hold the topic up.
+func runKafka(cfg config, args []string) error {
+	fs := flag.NewFlagSet("kafka", flag.ExitOnError)
+	brokers := fs.String("brokers", "localhost:9092", "Comma-separated list of Kafka brokers")
+	topic := fs.String("topic", "", "Topic to consume")
+	group := fs.String("group", "", "Consumer group used to checkpoint offsets (default dbx-<table>)")
+	tableName := fs.String("table", "", "Table to ingest into")
+	format := fs.String("format", "json", "Message format: json or avro")
+	avroSchema := fs.String("avro-schema", "", "Path to the Avro schema (.avsc) of the messages")
+	registryURL := fs.String("schema-registry", "", "Confluent-compatible schema registry to resolve the schema of each Avro message from, by the ID in its header")
+	batchRows := fs.Int("batch-rows", 10000, "Maximum number of messages per ingested batch")
+	flushInterval := fs.Duration("flush-interval", 5*time.Second, "Maximum time to wait before ingesting a partial batch")
+	onDecodeError := fs.String("on-decode-error", "fail", "What to do with a message that cannot be decoded: fail, stopping before its offset is committed; skip, logging it; or dead-letter, also copying it to -dead-letter-topic")
+	deadLetterTopic := fs.String("dead-letter-topic", "", "Topic undecodable messages are copied to with -on-decode-error dead-letter (default <topic>-dlq)")
+	parseFlags(fs, args)
+
+	if *topic == "" || *tableName == "" {
+		return fmt.Errorf("-topic and -table are required")
+	}
+	if *group == "" {
+		*group = "dbx-" + *tableName
+	}
+	switch *onDecodeError {
+	case "fail", "skip":
+		if *deadLetterTopic != "" {
+			return classify(errUsage, fmt.Errorf("-dead-letter-topic requires -on-decode-error dead-letter"))
+		}
+	case "dead-letter":
+		if *deadLetterTopic == "" {
+			*deadLetterTopic = *topic + "-dlq"
+		}
+	default:
+		return classify(errUsage, fmt.Errorf("invalid -on-decode-error %q, expected fail, skip or dead-letter", *onDecodeError))
+	}
+
+	var reg *schemaRegistry
+	if *registryURL != "" {
+		reg = &schemaRegistry{URL: *registryURL}
+	}
+	decode, err := newMessageDecoder(*format, *avroSchema, reg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	target, err := cfg.conn.table(*tableName)
+	if err != nil {
+		return err
+	}
+	cnxn, err := openConnection(ctx, cfg.conn)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	// The target table decides the Arrow schema messages are decoded into.
+	schema, err := getTableSchema(ctx, cnxn, target)
+	if err != nil {
+		return fmt.Errorf("failed to get schema of %s: %w", *tableName, err)
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: strings.Split(*brokers, ","),
+		Topic:   *topic,
+		GroupID: *group,
+	})
+	defer reader.Close()
+	var deadLetters *kafka.Writer
+	if *onDecodeError == "dead-letter" {
+		deadLetters = &kafka.Writer{Addr: kafka.TCP(strings.Split(*brokers, ",")...), Topic: *deadLetterTopic, RequiredAcks: kafka.RequireAll}
+		defer deadLetters.Close()
+	}
+
+	bldr := array.NewRecordBuilder(allocator, schema)
+	defer bldr.Release()
+
+	// rows are those of bldr. A message failing to decode can leave part of
+	// a row behind, so the rows before it are cut into a chunk of their
+	// own.
+	var rows int64
+	var chunks []arrow.Record
+	defer func() {
+		for _, c := range chunks {
+			c.Release()
+		}
+	}()
+	var pending []kafka.Message
+	skipped := 0
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if rows > 0 || len(chunks) == 0 {
+			chunks = append(chunks, builtRows(bldr, rows))
+		}
+		rows = 0
+		record, err := concatRecords(schema, chunks)
+		for _, c := range chunks {
+			c.Release()
+		}
+		chunks = chunks[:0]
+		if err != nil {
+			return err
+		}
+		defer record.Release()
+
+		stream, err := array.NewRecordReader(schema, []arrow.Record{record})
+		if err != nil {
+			return fmt.Errorf("failed to create record reader: %w", err)
+		}
+		defer stream.Release()
+
+		// Ingest with a fresh context so a shutdown signal still flushes
+		// the batch that was in flight.
+		if record.NumRows() > 0 {
+			if _, err := ingestStream(context.Background(), cnxn, target, stream); err != nil {
+				return err
+			}
+		}
+		if err := reader.CommitMessages(context.Background(), pending...); err != nil {
+			return fmt.Errorf("failed to commit offsets: %w", err)
+		}
+		slog.Info("Ingested messages", "messages", len(pending), "skipped", skipped, "topic", *topic, "table", *tableName)
+		pending, skipped = pending[:0], 0
+		return nil
+	}
+
+	deadline := time.Now().Add(*flushInterval)
+	for {
+		fetchCtx, cancel := context.WithDeadline(ctx, deadline)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+
+		switch {
+		case err == nil:
+			if err := decode(bldr, msg.Value); err != nil {
+				err = fmt.Errorf("failed to decode message at %s/%d offset %d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+				if *onDecodeError == "fail" {
+					return err
+				}
+				if rows > 0 {
+					chunks = append(chunks, builtRows(bldr, rows))
+				} else {
+					builtRows(bldr, 0).Release()
+				}
+				rows = 0
+				if deadLetters != nil {
+					if err := deadLetter(ctx, deadLetters, msg, err); err != nil {
+						return err
+					}
+				}
+				slog.Warn("Skipped undecodable message", "err", err, "dead_letter_topic", *deadLetterTopic)
+				skipped++
+			} else {
+				rows++
+			}
+			pending = append(pending, msg)
+			if len(pending) < *batchRows {
+				continue
+			}
+		case ctx.Err() != nil:
+			return flush()
+		case !errors.Is(err, context.DeadlineExceeded):
+			return fmt.Errorf("failed to fetch message: %w", err)
+		}
+
+		if err := flush(); err != nil {
+			return err
+		}
+		deadline = time.Now().Add(*flushInterval)
+	}
+}
+
+// builtRows finishes the arrays of bldr, which resets it, and returns their
+// first n rows; rows after them, such as the part of a row left by a
+// message that failed to decode, are dropped.
+func builtRows(bldr *array.RecordBuilder, n int64) arrow.Record {
+	cols := make([]arrow.Array, bldr.Schema().NumFields())
+	for i := range cols {
+		arr := bldr.Field(i).NewArray()
+		cols[i] = array.NewSlice(arr, 0, n)
+		arr.Release()
+	}
+	rec := array.NewRecord(bldr.Schema(), cols, n)
+	for _, col := range cols {
+		col.Release()
+	}
+	return rec
+}
+
+// deadLetter copies msg, which failed to decode with cause, to the topic
+// of w, with headers saying where it came from and why it was set aside.
+func deadLetter(ctx context.Context, w *kafka.Writer, msg kafka.Message, cause error) error {
+	headers := append(msg.Headers[:len(msg.Headers):len(msg.Headers)],
+		kafka.Header{Key: "dbx-source-topic", Value: []byte(msg.Topic)},
+		kafka.Header{Key: "dbx-source-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: "dbx-source-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: "dbx-error", Value: []byte(cause.Error())},
+	)
+	// Write with a fresh context so a shutdown signal does not lose the
+	// message whose offset is committed next.
+	err := w.WriteMessages(context.WithoutCancel(ctx), kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers})
+	if err != nil {
+		return fmt.Errorf("failed to write message at %s/%d offset %d to dead-letter topic %s: %w", msg.Topic, msg.Partition, msg.Offset, w.Topic, err)
+	}
+	return nil
+}
+
+// newMessageDecoder returns the decoder of messages in format. Avro
+// messages are decoded with the schema at schemaPath, or with reg set, the
+// schema registered under the ID of their Confluent wire-format header.
+func newMessageDecoder(format, schemaPath string, reg *schemaRegistry) (messageDecoder, error) {
+	switch format {
+	case "json":
+		return func(bldr *array.RecordBuilder, value []byte) error {
+			return bldr.UnmarshalJSON(value)
+		}, nil
+	case "avro":
+		if schemaPath == "" && reg == nil {
+			return nil, fmt.Errorf("-avro-schema or -schema-registry is required for avro messages")
+		}
+		var schema avro.Schema
+		if schemaPath != "" {
+			data, err := os.ReadFile(schemaPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Avro schema: %w", err)
+			}
+			if schema, err = avro.Parse(string(data)); err != nil {
+				return nil, fmt.Errorf("failed to parse Avro schema: %w", err)
+			}
+		}
+		var client *registry.Client
+		if reg != nil {
+			var err error
+			if client, err = reg.client(); err != nil {
+				return nil, err
+			}
+		}
+		return func(bldr *array.RecordBuilder, value []byte) error {
+			writer := schema
+			// Skip the Confluent wire-format header (magic byte and schema id).
+			if len(value) > 5 && value[0] == 0 {
+				if client != nil {
+					// The client caches schemas by ID.
+					id := int(binary.BigEndian.Uint32(value[1:5]))
+					s, err := client.GetSchema(context.Background(), id)
+					if err != nil {
+						return fmt.Errorf("failed to resolve schema %d: %w", id, err)
+					}
+					writer = s
+				}
+				value = value[5:]
+			}
+			if writer == nil {
+				return fmt.Errorf("message has no schema ID and no -avro-schema is given")
+			}
+			var row map[string]any
+			if err := avro.Unmarshal(writer, value, &row); err != nil {
+				return err
+			}
+			for i, f := range bldr.Schema().Fields() {
+				if err := appendValue(bldr.Field(i), unwrapUnion(row[f.Name])); err != nil {
+					return fmt.Errorf("field %s: %w", f.Name, err)
+				}
+			}
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported message format %q", format)
+	}
+}
+
+// unwrapUnion returns the value of a decoded Avro union, which is represented
+// as a single-entry map keyed by the branch type.
+func unwrapUnion(v any) any {
+	if m, ok := v.(map[string]any); ok && len(m) == 1 {
+		for _, inner := range m {
+			return inner
+		}
+	}
+	return v
+}
@@ -0,0 +1,298 @@
+//go:build lib
+
+package main
+
+// Building with -tags lib makes dbX a C shared library, for processes in
+// other languages that embed it rather than run the dbx binary:
+//
+//	go build -buildmode=c-shared -tags lib -o libdbx.so .
+//
+// This also writes libdbx.h. DbxExportStream runs a query and hands its
+// result over as an ArrowArrayStream of the Arrow C stream interface, so
+// pyarrow (RecordBatchReader._import_from_c), arrow-rs (FFI_ArrowArrayStream)
+// or nanoarrow read the record batches without copying them:
+//
+//	struct ArrowArrayStream stream;
+//	char *err = DbxExportStream("{\"uri\": \"file:orders.db\", \"sql_driver\": \"sqlite3\", \"table\": \"orders\"}", &stream);
+//	if (err != NULL) { fprintf(stderr, "%s\n", err); DbxFree(err); }
+//	...
+//	stream.release(&stream);
+//
+// Services running many small exports to files open an exporter once, which
+// keeps connections and prepared statements between exports, as
+// `dbx serve http` does:
+//
+//	uintptr_t exporter;
+//	char *result;
+//	char *err = DbxOpenExporter("{\"uri\": \"file:orders.db\", \"sql_driver\": \"sqlite3\"}", &exporter);
+//	err = DbxExport(exporter, "{\"table\": \"orders\", \"output\": \"orders.parquet\"}", &result);
+//	...
+//	DbxFree(result);
+//	err = DbxPingExporter(exporter);
+//	err = DbxCloseExporter(exporter);
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+#ifndef ARROW_C_DATA_INTERFACE
+#define ARROW_C_DATA_INTERFACE
+
+#define ARROW_FLAG_DICTIONARY_ORDERED 1
+#define ARROW_FLAG_NULLABLE 2
+#define ARROW_FLAG_MAP_KEYS_SORTED 4
+
+struct ArrowSchema {
+  const char* format;
+  const char* name;
+  const char* metadata;
+  int64_t flags;
+  int64_t n_children;
+  struct ArrowSchema** children;
+  struct ArrowSchema* dictionary;
+  void (*release)(struct ArrowSchema*);
+  void* private_data;
+};
+
+struct ArrowArray {
+  int64_t length;
+  int64_t null_count;
+  int64_t offset;
+  int64_t n_buffers;
+  int64_t n_children;
+  const void** buffers;
+  struct ArrowArray** children;
+  struct ArrowArray* dictionary;
+  void (*release)(struct ArrowArray*);
+  void* private_data;
+};
+
+#endif  // ARROW_C_DATA_INTERFACE
+
+#ifndef ARROW_C_STREAM_INTERFACE
+#define ARROW_C_STREAM_INTERFACE
+
+struct ArrowArrayStream {
+  int (*get_schema)(struct ArrowArrayStream*, struct ArrowSchema* out);
+  int (*get_next)(struct ArrowArrayStream*, struct ArrowArray* out);
+  const char* (*get_last_error)(struct ArrowArrayStream*);
+  void (*release)(struct ArrowArrayStream*);
+  void* private_data;
+};
+
+#endif  // ARROW_C_STREAM_INTERFACE
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/cgo"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/cdata"
+)
+
+// libraryConn is the database of a library call.
+type libraryConn struct {
+	// URI, Driver and SQLDriver are as the -uri, -driver and -sql-driver
+	// flags.
+	URI       string `json:"uri"`
+	Driver    string `json:"driver"`
+	SQLDriver string `json:"sql_driver"`
+	// ReadBatchRows asks the driver for batches of this many rows.
+	ReadBatchRows int `json:"read_batch_rows"`
+}
+
+func (c libraryConn) options() connOptions {
+	return connOptions{URI: c.URI, Driver: c.Driver, SQLDriver: c.SQLDriver, ReadBatchRows: c.ReadBatchRows, Job: "library"}
+}
+
+// libraryRequest is the JSON argument of DbxExportStream.
+type libraryRequest struct {
+	libraryConn
+	// Query is the SQL to run, or else Table the table to read.
+	Query string `json:"query"`
+	Table string `json:"table"`
+}
+
+// libraryExport is the JSON argument of DbxExport.
+type libraryExport struct {
+	// Query is the SQL to run, or else Table the table to read.
+	Query string `json:"query"`
+	Table string `json:"table"`
+	// Output is the file written, in Format with Compression, as the
+	// -output, -format and -compression flags.
+	Output      string `json:"output"`
+	Format      string `json:"format"`
+	Compression string `json:"compression"`
+	// Params bind :name placeholders of Query, as name[:type]=value.
+	Params []string `json:"params"`
+	// Force exports even if the output's manifest shows it is up to date.
+	Force bool `json:"force"`
+}
+
+// DbxExportStream runs the export described by the JSON request and moves
+// its result into out, which the caller releases once done with it; the
+// database connection stays open until then. It returns NULL on success, or
+// an error message to free with DbxFree.
+//
+//export DbxExportStream
+func DbxExportStream(request *C.char, out *C.struct_ArrowArrayStream) *C.char {
+	if err := exportStream(C.GoString(request), (*cdata.CArrowArrayStream)(unsafe.Pointer(out))); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+// DbxOpenExporter opens an exporter for the database of the JSON request,
+// whose fields are those of DbxExportStream but query and table, and stores
+// its handle in out. It returns NULL on success, or an error message to free
+// with DbxFree.
+//
+//export DbxOpenExporter
+func DbxOpenExporter(request *C.char, out *C.uintptr_t) *C.char {
+	var conn libraryConn
+	if err := json.Unmarshal([]byte(C.GoString(request)), &conn); err != nil {
+		return C.CString(fmt.Sprintf("invalid request: %v", err))
+	}
+	*out = C.uintptr_t(cgo.NewHandle(newExporter(conn.options())))
+	return nil
+}
+
+// DbxExport runs the export described by the JSON request on a session of
+// the exporter, and stores its result in result, unless NULL, as the JSON
+// printed by `dbx -json` (rows, bytes, batches, throughput, files and so
+// on), to free with DbxFree. It returns NULL on success, or an error
+// message to free with DbxFree.
+//
+//export DbxExport
+func DbxExport(handle C.uintptr_t, request *C.char, result **C.char) *C.char {
+	e := cgo.Handle(handle).Value().(*exporter)
+	resp, err := libraryExportRun(e, C.GoString(request))
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	if result != nil {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return C.CString(fmt.Sprintf("failed to encode result: %v", err))
+		}
+		*result = C.CString(string(data))
+	}
+	return nil
+}
+
+// DbxPingExporter checks that the database of the exporter is reachable.
+// It returns NULL if it is, or an error message to free with DbxFree.
+//
+//export DbxPingExporter
+func DbxPingExporter(handle C.uintptr_t) *C.char {
+	if err := cgo.Handle(handle).Value().(*exporter).ping(context.Background()); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+// DbxCloseExporter closes the exporter and its connections; the handle is
+// invalid afterwards. It returns NULL, or an error message to free with
+// DbxFree.
+//
+//export DbxCloseExporter
+func DbxCloseExporter(handle C.uintptr_t) *C.char {
+	h := cgo.Handle(handle)
+	err := h.Value().(*exporter).Close()
+	h.Delete()
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+// DbxFree frees a string returned by dbX.
+//
+//export DbxFree
+func DbxFree(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+func exportStream(request string, out *cdata.CArrowArrayStream) error {
+	var req libraryRequest
+	if err := json.Unmarshal([]byte(request), &req); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if (req.Query == "") == (req.Table == "") {
+		return fmt.Errorf("exactly one of query or table is required")
+	}
+	opts := req.options()
+	query := req.Query
+	if req.Table != "" {
+		var err error
+		if query, err = tableQuery(opts, req.Table); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	cnxn, err := openReadConnection(ctx, opts)
+	if err != nil {
+		return err
+	}
+	reader, err := executeQuery(ctx, cnxn, query)
+	if err != nil {
+		cnxn.Close()
+		return err
+	}
+	r := &connectionReader{RecordReader: reader, cnxn: cnxn}
+	r.refs.Store(1)
+	// The stream holds its own reference, dropped when the caller releases
+	// it.
+	cdata.ExportRecordReader(r, out)
+	r.Release()
+	return nil
+}
+
+func libraryExportRun(e *exporter, request string) (*response, error) {
+	var req libraryExport
+	if err := json.Unmarshal([]byte(request), &req); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	query, err := requestQuery(e.opts, req.Table, req.Query)
+	if err != nil {
+		return nil, err
+	}
+	if req.Output == "" {
+		return nil, fmt.Errorf("output is required")
+	}
+	params, err := parseQueryParams(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	sink := sinkOptions{Format: req.Format, Compression: req.Compression}
+	if err := sink.validate(); err != nil {
+		return nil, err
+	}
+	return e.export(context.Background(), exportSpec{Query: query, Output: req.Output, Table: req.Table, Force: req.Force, Params: params, sinkOptions: sink})
+}
+
+// connectionReader is a reader of query results that closes the connection
+// they come from once released.
+type connectionReader struct {
+	array.RecordReader
+	cnxn *connection
+	refs atomic.Int64
+}
+
+func (r *connectionReader) Retain() {
+	r.refs.Add(1)
+}
+
+func (r *connectionReader) Release() {
+	if r.refs.Add(-1) == 0 {
+		r.RecordReader.Release()
+		r.cnxn.Close()
+	}
+}
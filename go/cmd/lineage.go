@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// lineageKey is the Parquet key-value metadata key, and Arrow schema
+// metadata key, the lineage of an output is stored under as JSON.
+const lineageKey = "dbx.lineage"
+
+// lineage records where an output came from, so catalogs reading it can
+// trace it back to its source.
+type lineage struct {
+	// Table is the source table, as named to dbX; it is empty for queries.
+	Table       string    `json:"table,omitempty"`
+	Query       string    `json:"query"`
+	ExtractedAt time.Time `json:"extracted_at"`
+	DbxVersion  string    `json:"dbx_version"`
+	// PipelineVersion is `git describe` of the repository holding the
+	// pipeline or config file the export is defined in.
+	PipelineVersion string          `json:"pipeline_version,omitempty"`
+	Transforms      []string        `json:"transforms,omitempty"`
+	Columns         []columnLineage `json:"columns"`
+}
+
+// columnLineage maps an output column to the source column it is read
+// from. Source is only known for exports of a table.
+type columnLineage struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"`
+}
+
+// newLineage describes the export of spec, which reads columns of the
+// source schema in and writes the schema out.
+func newLineage(spec exportSpec, in, out *arrow.Schema) *lineage {
+	l := &lineage{
+		Table:           spec.Table,
+		Query:           spec.Query,
+		ExtractedAt:     time.Now().UTC(),
+		DbxVersion:      version,
+		PipelineVersion: spec.PipelineVersion,
+	}
+	for _, t := range spec.Transforms {
+		l.Transforms = append(l.Transforms, t.String())
+	}
+	// Transforms rename, mask and filter, but never add, drop or reorder
+	// columns, so output columns line up with the source's.
+	for i, f := range out.Fields() {
+		c := columnLineage{Name: f.Name}
+		if spec.Table != "" && i < in.NumFields() {
+			c.Source = spec.Table + "." + in.Field(i).Name
+		}
+		l.Columns = append(l.Columns, c)
+	}
+	return l
+}
+
+// annotate returns schema with the lineage added to its metadata, which the
+// Parquet and Arrow IPC writers store in the file.
+func (l *lineage) annotate(schema *arrow.Schema) *arrow.Schema {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return schema
+	}
+	md := schema.Metadata()
+	keys, values := append([]string{}, md.Keys()...), append([]string{}, md.Values()...)
+	if i := md.FindKey(lineageKey); i >= 0 {
+		values[i] = string(data)
+	} else {
+		keys, values = append(keys, lineageKey), append(values, string(data))
+	}
+	meta := arrow.NewMetadata(keys, values)
+	return arrow.NewSchema(schema.Fields(), &meta)
+}
+
+// pipelineVersion describes the version of the file at path with
+// `git describe`, or returns "" if it is not in a git repository.
+func pipelineVersion(path string) string {
+	if path == "" {
+		return ""
+	}
+	out, err := exec.Command("git", "-C", filepath.Dir(path), "describe", "--tags", "--always", "--dirty").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultDuckDBDriver returns the DuckDB library loaded by `dbx query`,
+// $DBX_DUCKDB_DRIVER or the platform's library, found in the driver search
+// path or else left to the loader's.
+func defaultDuckDBDriver() string {
+	if path := os.Getenv("DBX_DUCKDB_DRIVER"); path != "" {
+		return path
+	}
+	d, _ := lookupDriver("duckdb")
+	if found := d.locate(); len(found) > 0 {
+		return found[0]
+	}
+	return d.libraryFile()
+}
+
+// runQuery implements `dbx query -file 'data/*.parquet' -sql 'SELECT ...'`,
+// running SQL over exported files in an embedded, in-memory DuckDB. The
+// files are visible as the view named by -name; the SQL may also read other
+// files itself with DuckDB's read_parquet.
+//
+// Where DuckDB is not installed, or with -query-engine sqlite, the SQL runs
+// on the SQLite built into dbX instead, with the files loaded into a table
+// named by -name. That needs no library at all, but holds the files in
+// memory and speaks SQLite's SQL.
+func runQuery(cfg config, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	var files stringList
+	fs.Var(&files, "file", "Parquet file, glob or Hive-partitioned directory to query (repeatable); all must share a schema")
+	name := fs.String("name", "data", "Name of the view over the -file files")
+	sql := fs.String("sql", "", "SQL to run")
+	output := fs.String("output", "", "Write the result to this Parquet file instead of printing it")
+	limit := fs.Int("n", 1000, "Maximum rows to print")
+	driver := fs.String("duckdb-driver", defaultDuckDBDriver(), "Path to the DuckDB shared library, which provides its ADBC driver")
+	engine := fs.String("query-engine", "auto", "Engine running the SQL: duckdb, sqlite (built in) or auto, which uses DuckDB if it loads and SQLite otherwise")
+	var where stringList
+	fs.Var(&where, "where", "Only read rows of the -file files matching \"column op value\" or \"column is [not] null\", skipping row groups whose statistics rule them out (repeatable, all must hold)")
+	parseFlags(fs, args)
+
+	if *sql == "" {
+		return classify(errUsage, fmt.Errorf("-sql is required"))
+	}
+	filters, err := parseFilters(where)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	if len(filters) > 0 && len(files) == 0 {
+		return classify(errUsage, fmt.Errorf("-where filters the -file files"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cnxn, err := openLocalEngine(ctx, *engine, *driver)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	if len(files) > 0 {
+		if err := attachFiles(ctx, cnxn, *name, files, filters); err != nil {
+			return err
+		}
+	}
+
+	startTime := time.Now()
+	reader, err := executeQuery(ctx, cnxn, *sql)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	if *output == "" {
+		rows, err := previewRows(reader, 0, *limit)
+		if err != nil {
+			return err
+		}
+		if cfg.json {
+			printJSON(rows)
+			return nil
+		}
+		return printRows(os.Stdout, reader.Schema(), rows)
+	}
+
+	s, err := newSink(ctx, *output, reader.Schema(), sinkOptions{})
+	if err != nil {
+		return err
+	}
+	defer s.abort()
+	var rows int64
+	for reader.Next() {
+		rec := reader.Record()
+		if err := s.write(rec); err != nil {
+			return err
+		}
+		rows += rec.NumRows()
+	}
+	if err := reader.Err(); err != nil {
+		return fmt.Errorf("failed to read query results: %w", err)
+	}
+	size, err := s.close()
+	if err != nil {
+		return err
+	}
+	resp := &response{
+		RowsWritten:    rows,
+		Message:        "Query results written",
+		Duration:       time.Since(startTime),
+		OutputFileSize: size,
+		Location:       *output,
+	}
+	slog.Info(resp.Message, "rows", rows, "bytes", size, "location", *output, "duration", resp.Duration)
+	if cfg.json {
+		printJSON(resp)
+	}
+	return nil
+}
+
+// openLocalEngine opens the in-memory database `dbx query` runs on: DuckDB
+// through the library at driver, the built-in SQLite, or with engine auto,
+// DuckDB if its library loads and SQLite otherwise.
+func openLocalEngine(ctx context.Context, engine, driver string) (*connection, error) {
+	switch engine {
+	case "duckdb":
+		return openDuckDB(ctx, driver)
+	case "sqlite":
+		return openConnection(ctx, connOptions{SQLDriver: "sqlite3", URI: ":memory:", Engine: "sqlite"})
+	case "auto":
+		cnxn, err := openDuckDB(ctx, driver)
+		if err == nil || !driverLoadFailed(err) {
+			return cnxn, err
+		}
+		logger(ctx).Debug("DuckDB not available, querying with SQLite", "err", err)
+		return openLocalEngine(ctx, "sqlite", driver)
+	}
+	return nil, classify(errUsage, fmt.Errorf("unknown query engine %q, expected duckdb, sqlite or auto", engine))
+}
+
+// attachFiles makes the rows of the Parquet files matching patterns that
+// match where queryable as name: a view reading them in place on DuckDB,
+// which prunes row groups itself, or a table they are loaded into
+// elsewhere.
+func attachFiles(ctx context.Context, cnxn *connection, name string, patterns []string, where []*filterTransform) error {
+	if cnxn.opts.Engine == "duckdb" {
+		quoted := make([]string, len(patterns))
+		hive := ""
+		for i, f := range patterns {
+			// DuckDB prunes the partitions of directories itself.
+			if info, err := os.Stat(f); err == nil && info.IsDir() {
+				f, hive = filepath.Join(f, "**", "*.parquet"), ", hive_partitioning = true"
+			}
+			quoted[i] = quoteLiteral(f)
+		}
+		view := fmt.Sprintf("CREATE VIEW %s AS SELECT * FROM read_parquet([%s]%s)", cnxn.opts.quoteIdent(name), strings.Join(quoted, ", "), hive)
+		if len(where) > 0 {
+			conds := make([]string, len(where))
+			for i, f := range where {
+				conds[i] = f.sql(cnxn.opts)
+			}
+			view += " WHERE " + strings.Join(conds, " AND ")
+		}
+		if err := execUpdate(ctx, cnxn, view); err != nil {
+			return classify(errUsage, err)
+		}
+		return nil
+	}
+
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return classify(errUsage, fmt.Errorf("invalid file pattern %q: %w", pattern, err))
+		}
+		if len(matches) == 0 {
+			return classify(errUsage, fmt.Errorf("no files match %s", pattern))
+		}
+		for _, match := range matches {
+			// Directories contribute their Parquet files, at any depth.
+			if info, err := os.Stat(match); err != nil || !info.IsDir() {
+				paths = append(paths, match)
+				continue
+			}
+			err := filepath.WalkDir(match, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() && strings.HasSuffix(path, ".parquet") {
+					paths = append(paths, path)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	table, err := cnxn.opts.table(name)
+	if err != nil {
+		return err
+	}
+	for i, path := range paths {
+		reader, closeFile, err := openParquetInput(ctx, path, nil, nil, where, mismatchFail, "")
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if i == 0 {
+			ddl, err := cnxn.opts.dialect().createTableSQL(table, reader.Schema(), nil, "", tableDef{})
+			if err == nil {
+				err = execUpdate(ctx, cnxn, ddl)
+			}
+			if err != nil {
+				reader.Release()
+				closeFile()
+				return err
+			}
+		}
+		_, err = ingestStream(ctx, cnxn, table, reader)
+		reader.Release()
+		closeFile()
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+	}
+	logger(ctx).Debug("Loaded files into SQLite", "table", name, "files", len(paths))
+	return nil
+}
+
+// openDuckDB opens an in-memory DuckDB database through the ADBC driver
+// built into the DuckDB library at driver.
+func openDuckDB(ctx context.Context, driver string) (*connection, error) {
+	db, err := driverManager().NewDatabase(map[string]string{
+		"driver":     driver,
+		"entrypoint": "duckdb_adbc_init",
+		"path":       ":memory:",
+	})
+	if err != nil {
+		return nil, classify(errConnection, fmt.Errorf("failed to load DuckDB from %s: %w", driver, err))
+	}
+	cnxn, err := db.Open(ctx)
+	if err != nil {
+		db.Close()
+		return nil, classify(errConnection, fmt.Errorf("failed to open DuckDB: %w", err))
+	}
+	return &connection{Connection: cnxn, db: db, opts: connOptions{Driver: driver, Engine: "duckdb"}}, nil
+}
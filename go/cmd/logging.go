@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging installs the default slog logger, writing to stderr at level
+// ("debug", "info", "warn" or "error") in format ("text" or "json"). Output
+// of the standard log package goes through the same handler.
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	handler, err := newLogHandler(os.Stderr, format, lvl)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func newLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+}
+
+type loggerKey struct{}
+
+// withLogAttrs returns a context whose logger adds args (as in slog.With) to
+// every record, so log lines of concurrent exports can be told apart.
+func withLogAttrs(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger(ctx).With(args...))
+}
+
+// logger returns the logger carried by ctx, or the default logger.
+func logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// cronLogger adapts slog to the cron.Logger interface.
+type cronLogger struct {
+	*slog.Logger
+}
+
+func (l cronLogger) Info(msg string, keysAndValues ...any) {
+	l.Logger.Debug(msg, keysAndValues...)
+}
+
+func (l cronLogger) Error(err error, msg string, keysAndValues ...any) {
+	l.Logger.Error(msg, append([]any{"err", err}, keysAndValues...)...)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runMan implements `dbx man`, printing the dbx(1) man page built from the
+// commands and their flags, e.g. `dbx man > /usr/local/share/man/man1/dbx.1`.
+func runMan(cfg config, args []string) error {
+	if len(args) != 0 {
+		return classify(errUsage, fmt.Errorf("usage: dbx man"))
+	}
+	writeManPage(os.Stdout)
+	return nil
+}
+
+// exitDescriptions describe the exit status of each error kind.
+var exitDescriptions = map[errorKind]string{
+	errUnknown:        "Any other failure",
+	errUsage:          "Invalid flags or arguments",
+	errConnection:     "Failed to connect to the database",
+	errSchemaMismatch: "The data does not match the table's schema",
+	errWrite:          "Failed to write the output",
+	errCancelled:      "Interrupted",
+}
+
+func writeManPage(w io.Writer) {
+	fmt.Fprintf(w, ".TH DBX 1 \"\" \"dbX %s\" \"User Commands\"\n", roffEscape(version))
+	fmt.Fprint(w, `.SH NAME
+dbx \- exchange data between databases and Parquet or Arrow files over ADBC
+.SH SYNOPSIS
+.B dbx
+[\fIglobal options\fR] [\fIcommand\fR [\fIoptions\fR] [\fIarguments\fR]]
+.SH DESCRIPTION
+Without a command,
+.B dbx
+exports \fB\-table\fR to the file \fB\-output\fR, imports \fB\-file\fR, or
+checks the database connection. Global options go before the command and
+apply to every command.
+.SH GLOBAL OPTIONS
+`)
+	writeManFlags(w, commandFlags())
+
+	fmt.Fprint(w, ".SH COMMANDS\n")
+	for _, name := range commandNames() {
+		doc := commandDocs[name]
+		fmt.Fprintf(w, ".SS \"dbx %s\"\n%s.\n", name, roffEscape(doc.summary))
+		if doc.flagless {
+			if len(doc.subcommands) > 0 {
+				fmt.Fprintf(w, "Subcommands: %s.\n", strings.Join(doc.subcommands, ", "))
+			}
+			continue
+		}
+		if len(doc.subcommands) == 0 {
+			writeManFlags(w, commandFlags(name))
+			continue
+		}
+		for _, sub := range doc.subcommands {
+			fmt.Fprintf(w, ".TP\n.B dbx %s %s\n", name, sub)
+			fmt.Fprint(w, ".RS\n")
+			writeManFlags(w, commandFlags(name, sub))
+			fmt.Fprint(w, ".RE\n")
+		}
+	}
+
+	fmt.Fprint(w, `.SH ENVIRONMENT
+.TP
+.B DBX_\fIFLAG\fR, DBX_\fICOMMAND\fB_\fIFLAG\fR
+Set a flag not given on the command line, upper-cased with dashes as
+underscores: \fBDBX_URI\fR for \fB\-uri\fR, \fBDBX_SERVE_HTTP_LISTEN\fR for
+\fB\-listen\fR of \fBdbx serve http\fR.
+.TP
+.B DBX_\fIFLAG\fB_FILE
+Read the flag from a file, such as a mounted secret; repeatable flags take
+one value per line.
+.TP
+.B DBX_CONFIG, DBX_JOBS_DB, DBX_CATALOG, DBX_PROXY
+Defaults of \fB\-config\fR, \fB\-jobs\-db\fR, \fB\-catalog\fR and \fB\-proxy\fR.
+.TP
+.B ADBC_DRIVER_PATH
+Directories searched for ADBC driver libraries first.
+.SH EXIT STATUS
+`)
+	kinds := make([]errorKind, 0, len(exitCodes))
+	for kind := range exitCodes {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return exitCodes[kinds[i]] < exitCodes[kinds[j]] })
+	fmt.Fprint(w, ".TP\n.B 0\nSuccess.\n")
+	for _, kind := range kinds {
+		fmt.Fprintf(w, ".TP\n.B %d\n%s (kind %s in \\fB\\-json\\fR errors).\n", exitCodes[kind], exitDescriptions[kind], kind)
+	}
+	fmt.Fprint(w, ".SH SEE ALSO\nRun \\fBdbx completion\\fR \\fIshell\\fR for shell completions.\n")
+}
+
+// writeManFlags writes the flags of fs as a tagged paragraph each.
+func writeManFlags(w io.Writer, fs *flag.FlagSet) {
+	if fs == nil {
+		return
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(w, ".TP\n.B \\-%s", roffEscape(f.Name))
+		if name != "" {
+			fmt.Fprintf(w, " \\fI%s\\fR", roffEscape(name))
+		}
+		usage = roffEscape(usage)
+		if strings.HasPrefix(usage, ".") || strings.HasPrefix(usage, "'") {
+			usage = `\&` + usage
+		}
+		fmt.Fprintf(w, "\n%s", usage)
+		if def := f.DefValue; def != "" && def != "false" && def != "0" && def != "0s" && def != "[]" {
+			// Defaults under the home directory of whoever generated the
+			// page, like -jobs-db, are per user.
+			if home, err := os.UserHomeDir(); err == nil && strings.HasPrefix(def, home+string(os.PathSeparator)) {
+				def = "~" + def[len(home):]
+			}
+			fmt.Fprintf(w, " (default %s)", roffEscape(def))
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+// roffEscape escapes s for running text of a man page.
+func roffEscape(s string) string {
+	return strings.NewReplacer(`\`, `\e`, "-", `\-`, "\n", " ").Replace(s)
+}
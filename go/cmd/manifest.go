@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// manifest is written next to every export as <output>.manifest.json. Its
+// fingerprint identifies what was exported, so a retried export of the same
+// query, schema and cursor can be skipped.
+type manifest struct {
+	Fingerprint string `json:"fingerprint"`
+	Query       string `json:"query"`
+	Schema      string `json:"schema"`
+	Rows        int64  `json:"rows"`
+	Bytes       int64  `json:"bytes"`
+	// SHA256 is the hex digest of the output file; partitioned outputs,
+	// being directories, have none.
+	SHA256    string    `json:"sha256,omitempty"`
+	Cursor    string    `json:"cursor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Lineage is also stored in the metadata of Parquet and Arrow outputs.
+	Lineage *lineage `json:"lineage,omitempty"`
+	// Drift lists the columns that appeared, disappeared or changed type
+	// in the source since the previous run, with -state-file.
+	Drift []driftEvent `json:"drift,omitempty"`
+	// Parts lists the files of a dataset directory exports are appended
+	// to with -append-to, oldest first. Rows and Bytes are then their
+	// totals, and the other fields those of the latest export.
+	Parts []datasetPart `json:"parts,omitempty"`
+	// Tags name snapshots of such a dataset, which can be read as of the
+	// tag and which vacuum keeps.
+	Tags map[string]snapshot `json:"tags,omitempty"`
+}
+
+// datasetPart is a file added to a dataset by an export.
+type datasetPart struct {
+	// Path is relative to the dataset directory.
+	Path        string    `json:"path"`
+	Rows        int64     `json:"rows"`
+	Bytes       int64     `json:"bytes"`
+	SHA256      string    `json:"sha256"`
+	Fingerprint string    `json:"fingerprint"`
+	Cursor      string    `json:"cursor,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func manifestPath(output string) string {
+	return filepath.Clean(output) + ".manifest.json"
+}
+
+// exportFingerprint hashes everything that determines an export's content:
+// the query (which for incremental exports embeds the cursor position), the
+// schema of its result, and how the result is transformed and laid out.
+func exportFingerprint(spec exportSpec, schema *arrow.Schema) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "query=%s\nschema=%s\ncursor=%s\nformat=%s\ncompression=%s\nrow_group_size=%d\npartition_by=%s\n",
+		spec.Query, schema, spec.Cursor, spec.Format, spec.Compression, spec.RowGroupSize, strings.Join(spec.PartitionBy, ","))
+	if spec.Pagination != nil {
+		fmt.Fprintf(h, "pagination=%s\n", spec.Pagination)
+	}
+	if spec.Encryption != nil {
+		fmt.Fprintf(h, "encryption=%s\n", spec.Encryption)
+	}
+	for _, p := range spec.Params {
+		fmt.Fprintf(h, "param=%s\n", p)
+	}
+	for _, t := range spec.Transforms {
+		fmt.Fprintf(h, "transform=%s\n", t)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readManifest returns the manifest of output, or nil if there is none.
+func readManifest(output string) (*manifest, error) {
+	m, err := readManifestFile(manifestPath(output))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// readManifestFile parses the manifest at path.
+func readManifestFile(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// checkParts rejects a manifest listing parts outside its dataset
+// directory, as one read from a server could.
+func (m *manifest) checkParts() error {
+	for _, p := range m.Parts {
+		if !filepath.IsLocal(filepath.FromSlash(p.Path)) {
+			return fmt.Errorf("manifest lists part %q outside the dataset directory", p.Path)
+		}
+	}
+	return nil
+}
+
+// checksumFile returns the hex SHA-256 digest of the file at path.
+func checksumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyManifest checks the Parquet file at path against the export
+// manifest at manifestFile: its size, checksum and row count must all be
+// those recorded when it was written.
+func verifyManifest(path, manifestFile string) error {
+	m, err := readManifestFile(manifestFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.Size() != m.Bytes {
+		return fmt.Errorf("%s is %d bytes, the manifest records %d", path, info.Size(), m.Bytes)
+	}
+	if m.SHA256 == "" {
+		return fmt.Errorf("manifest %s records no checksum", manifestFile)
+	}
+	sum, err := checksumFile(path)
+	if err != nil {
+		return err
+	}
+	if sum != m.SHA256 {
+		return fmt.Errorf("%s has checksum %s, the manifest records %s", path, sum, m.SHA256)
+	}
+	pqFile, err := openParquetFile(path)
+	if err != nil {
+		return err
+	}
+	defer pqFile.Close()
+	if rows := pqFile.NumRows(); rows != m.Rows {
+		return fmt.Errorf("%s has %d rows, the manifest records %d", path, rows, m.Rows)
+	}
+	return nil
+}
+
+// upToDate reports whether output exists and was produced by an export with
+// the given fingerprint.
+func upToDate(output, fingerprint string) (*manifest, bool) {
+	m, err := readManifest(output)
+	if err != nil || m == nil || m.Fingerprint != fingerprint {
+		return nil, false
+	}
+	if _, err := os.Stat(output); err != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// appended reports whether an export with the given fingerprint was
+// already appended to the dataset at dir and its files are still there, as
+// upToDate does for other outputs. The manifest returned describes the
+// files of that export. Parts without rows, which leave the cursor where it
+// was, never make a later export up to date.
+func appended(dir, fingerprint string) (*manifest, bool) {
+	m, err := readManifest(dir)
+	if err != nil || m == nil {
+		return nil, false
+	}
+	var run *manifest
+	for _, p := range m.Parts {
+		if p.Fingerprint != fingerprint || p.Rows == 0 {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, p.Path)); err != nil {
+			return nil, false
+		}
+		if run == nil {
+			run = &manifest{Fingerprint: fingerprint, Query: m.Query, Schema: m.Schema, Cursor: p.Cursor, CreatedAt: p.CreatedAt}
+		}
+		run.Rows += p.Rows
+		run.Bytes += p.Bytes
+	}
+	return run, run != nil
+}
+
+// appendManifest adds the files of an export, described by run, to the
+// manifest of the dataset at dir, and tags the dataset with them as tag if
+// it is set. Files without rows are removed instead: the next export, from
+// the same cursor, would otherwise be found already appended.
+func appendManifest(dir string, run manifest, files []outputFile, tag string) error {
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	var parts []datasetPart
+	if m != nil {
+		parts, run.Tags = m.Parts, m.Tags
+		// An export without rows has no cursor, and resumes from where
+		// the dataset was.
+		if run.Cursor == "" {
+			run.Cursor = m.Cursor
+		}
+	}
+	for _, f := range files {
+		if f.Rows == 0 {
+			os.Remove(f.Path)
+			continue
+		}
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			return fmt.Errorf("failed to update manifest: %w", err)
+		}
+		parts = append(parts, datasetPart{Path: filepath.ToSlash(rel), Rows: f.Rows, Bytes: f.Bytes, SHA256: f.SHA256,
+			Fingerprint: run.Fingerprint, Cursor: run.Cursor, CreatedAt: run.CreatedAt})
+	}
+	run.Rows, run.Bytes, run.SHA256, run.Parts = 0, 0, "", parts
+	for _, p := range parts {
+		run.Rows += p.Rows
+		run.Bytes += p.Bytes
+	}
+	if tag != "" {
+		if err := run.tag(tag, run.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return writeManifest(dir, run)
+}
+
+// partName names the files an export appends to a dataset, unique to the
+// export: part-<time>-<fingerprint prefix>.
+func partName(fingerprint string, now time.Time) string {
+	return "part-" + now.UTC().Format("20060102T150405Z") + "-" + fingerprint[:8]
+}
+
+func writeManifest(output string, m manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	path := manifestPath(output)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+)
+
+// runMaterialize implements `dbx materialize -query <sql> -into <table>`,
+// writing the result of a query into a table, created if it does not exist.
+// On the database of the global flags, the table is written with CREATE
+// TABLE ... AS or INSERT ... SELECT, so the rows never leave the database;
+// with -into-uri, they are read from it and bulk ingested into the table of
+// that database.
+//
+// -refresh full replaces the rows of an existing table in one transaction,
+// so readers see the old rows or the new ones; -refresh incremental
+// appends the rows whose -cursor column is past its largest value in the
+// table.
+func runMaterialize(cfg config, args []string) error {
+	fs := flag.NewFlagSet("materialize", flag.ExitOnError)
+	query := fs.String("query", "", "Query whose result is materialized")
+	into := fs.String("into", "", "Table to write the result into, created if it does not exist")
+	refresh := fs.String("refresh", "full", "How an existing table is refreshed: full, replacing its rows, or incremental, appending the rows past the largest -cursor value in it")
+	cursor := fs.String("cursor", "", "Column the rows of -refresh incremental are ordered by")
+	intoURI := fs.String("into-uri", "", "URI of the database holding -into, if not the one of -uri")
+	intoDriver := fs.String("into-driver", "", "ADBC driver for -into-uri, as for -driver")
+	intoSQLDriver := fs.String("into-sql-driver", "", "database/sql driver for -into-uri, as for -sql-driver")
+	parseFlags(fs, args)
+
+	switch {
+	case *query == "" || *into == "" || fs.NArg() != 0:
+		return classify(errUsage, fmt.Errorf("usage: dbx materialize -query <sql> -into <table> [-refresh full|incremental] [-cursor <column>] [-into-uri <uri>]"))
+	case *refresh != "full" && *refresh != "incremental":
+		return classify(errUsage, fmt.Errorf("invalid -refresh %q, expected full or incremental", *refresh))
+	case (*refresh == "incremental") != (*cursor != ""):
+		return classify(errUsage, fmt.Errorf("-refresh incremental and -cursor go together"))
+	case *intoURI == "" && (*intoDriver != "" || *intoSQLDriver != ""):
+		return classify(errUsage, fmt.Errorf("-into-driver and -into-sql-driver require -into-uri"))
+	}
+
+	target := cfg.conn
+	if *intoURI != "" {
+		target = connOptions{
+			URI:              *intoURI,
+			Driver:           *intoDriver,
+			SQLDriver:        *intoSQLDriver,
+			IdentifierCase:   cfg.conn.IdentifierCase,
+			WriteBatchRows:   cfg.conn.WriteBatchRows,
+			StatementTimeout: cfg.conn.StatementTimeout,
+			Job:              cfg.conn.Job,
+			Tags:             cfg.conn.Tags,
+		}
+	}
+	table, err := target.table(*into)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	resp, err := materialize(ctx, cfg.conn, target, *query, table, *cursor, *intoURI != "")
+	r := newAuditRecord("materialize", cfg.conn.URI, *query, start, resp, err)
+	r.Destinations = []string{table.String()}
+	if *intoURI != "" {
+		r.Destinations = []string{redactURI(*intoURI) + " " + table.String()}
+	}
+	if aerr := audit.record(ctx, r); aerr != nil && err == nil {
+		err = aerr
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info(resp.Message, "table", table.String(), "rows", resp.RowsWritten, "duration", resp.Duration)
+	if cfg.json {
+		printJSON(resp)
+	}
+	return nil
+}
+
+// materialize writes the result of query on the database of source into
+// table of the database of target, a different one if remote. With cursor
+// set, only the rows past the table's largest cursor are appended;
+// otherwise the table's rows are replaced.
+func materialize(ctx context.Context, source, target connOptions, query string, table tableIdent, cursor string, remote bool) (*response, error) {
+	start := time.Now()
+	cnxn, err := openConnection(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer cnxn.Close()
+
+	// Failing to read the table's schema is taken to mean it does not
+	// exist; if it does, creating it fails.
+	_, err = getTableSchema(ctx, cnxn, table)
+	exists := err == nil
+	if exists && cursor != "" {
+		last, err := tableCursor(ctx, cnxn, table, cursor)
+		if err != nil {
+			return nil, err
+		}
+		logger(ctx).Debug("Materializing rows past the table's cursor", "cursor", cursor, "after", last)
+		query = incrementalQuery(source, query, cursor, last)
+	}
+
+	var n int64
+	switch {
+	case remote:
+		n, err = materializeRemote(ctx, source, cnxn, query, table, exists, cursor != "")
+	case !exists:
+		n, err = materializeTable(ctx, cnxn, query, table)
+	default:
+		n, err = materializeInto(ctx, cnxn, query, table, cursor == "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &response{
+		RowsWritten: n,
+		Message:     "Query materialized",
+		Duration:    time.Since(start),
+		Location:    table.String(),
+	}, nil
+}
+
+// materializeTable creates table from the result of query, on the same
+// database.
+func materializeTable(ctx context.Context, cnxn *connection, query string, table tableIdent) (int64, error) {
+	d := cnxn.opts.dialect()
+	if err := execUpdate(ctx, cnxn, "CREATE TABLE "+table.quote(d)+" AS "+query); err != nil {
+		return 0, classify(errWrite, err)
+	}
+	reader, err := executeQuery(ctx, cnxn, "SELECT count(*) FROM "+table.quote(d))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Release()
+	if !reader.Next() {
+		return 0, reader.Err()
+	}
+	n, _ := arrowValue(reader.Record().Column(0), 0).(int64)
+	return n, nil
+}
+
+// materializeInto inserts the result of query into table, on the same
+// database, deleting its rows first in the same transaction if replace is
+// set.
+func materializeInto(ctx context.Context, cnxn *connection, query string, table tableIdent, replace bool) (n int64, err error) {
+	schema, err := querySchema(ctx, cnxn, query)
+	if err != nil {
+		return 0, err
+	}
+	d := cnxn.opts.dialect()
+	columns := make([]string, schema.NumFields())
+	for i, f := range schema.Fields() {
+		columns[i] = d.quoteIdent(f.Name)
+	}
+	list := strings.Join(columns, ", ")
+	insert := "INSERT INTO " + table.quote(d) + " (" + list + ") SELECT " + list + " FROM (" + query + ") AS src"
+	if !replace {
+		n, err = execUpdateRows(ctx, cnxn, insert)
+		if err != nil {
+			return 0, classify(errWrite, err)
+		}
+		return n, nil
+	}
+
+	setter, ok := cnxn.Connection.(adbc.PostInitOptions)
+	if !ok {
+		return 0, classify(errUsage, fmt.Errorf("-refresh full requires a driver with transactions"))
+	}
+	if err := setter.SetOption(adbc.OptionKeyAutoCommit, adbc.OptionValueDisabled); err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer setter.SetOption(adbc.OptionKeyAutoCommit, adbc.OptionValueEnabled)
+	defer func() {
+		if err != nil {
+			cnxn.Rollback(context.WithoutCancel(ctx))
+		}
+	}()
+	if err := execUpdate(ctx, cnxn, "DELETE FROM "+table.quote(d)); err != nil {
+		return 0, classify(errWrite, err)
+	}
+	if n, err = execUpdateRows(ctx, cnxn, insert); err != nil {
+		return 0, classify(errWrite, err)
+	}
+	if err := cnxn.Commit(ctx); err != nil {
+		return 0, classify(errWrite, fmt.Errorf("failed to commit into %s: %w", table, err))
+	}
+	return n, nil
+}
+
+// materializeRemote streams the result of query on the database of source
+// into table of cnxn: into a table it creates, appended to the existing
+// one, or replacing its rows through a staging table.
+func materializeRemote(ctx context.Context, source connOptions, cnxn *connection, query string, table tableIdent, exists, incremental bool) (int64, error) {
+	src, err := openReadConnection(ctx, source)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	reader, err := executeQuery(ctx, src, query)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Release()
+
+	switch {
+	case exists && incremental:
+		return ingestStream(ctx, cnxn, table, reader)
+	case exists:
+		return stagingSwap(ctx, cnxn, table, reader, false, true)
+	}
+	d := cnxn.opts.dialect()
+	ddl, err := d.createTableSQL(table, reader.Schema(), nil, "", tableDef{})
+	if err != nil {
+		return 0, err
+	}
+	if err := execUpdate(ctx, cnxn, ddl); err != nil {
+		return 0, classify(errWrite, err)
+	}
+	n, err := ingestStream(ctx, cnxn, table, reader)
+	if err != nil {
+		// Leave no partly written table behind to be taken as complete.
+		if derr := execUpdate(context.WithoutCancel(ctx), cnxn, "DROP TABLE "+table.quote(d)); derr != nil {
+			logger(ctx).Warn("Failed to drop partly materialized table", "table", table.String(), "err", derr)
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// tableCursor returns the largest value of column in table as a SQL
+// literal, or "" if the table has no rows.
+func tableCursor(ctx context.Context, cnxn *connection, table tableIdent, column string) (string, error) {
+	d := cnxn.opts.dialect()
+	reader, err := executeQuery(ctx, cnxn, "SELECT max("+cnxn.opts.quoteIdent(column)+") FROM "+table.quote(d))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Release()
+	c, err := newCursorTracker(reader.Schema(), reader.Schema().Field(0).Name)
+	if err != nil {
+		return "", err
+	}
+	for reader.Next() {
+		c.observe(reader.Record())
+	}
+	if err := reader.Err(); err != nil {
+		return "", err
+	}
+	return c.literal(), nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Queries reading a database's catalog. Each returns strings in the column
+// order the loader using it expects; SQLite has no schemas, so it returns ''
+// where others return a schema name.
+
+const (
+	sqliteTables = `
+SELECT '', name FROM sqlite_master
+WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+ORDER BY name`
+
+	informationSchemaTables = `
+SELECT table_schema, table_name FROM information_schema.tables
+WHERE table_type = 'BASE TABLE'
+  AND table_schema NOT IN ('information_schema', 'pg_catalog', 'mysql', 'performance_schema', 'sys')
+  AND table_schema NOT LIKE 'pg_toast%'
+ORDER BY table_schema, table_name`
+
+	// Indexes backing constraints are created with their constraint, so
+	// only the others are listed.
+	postgresIndexes = `
+SELECT schemaname, tablename, indexname, indexdef FROM pg_indexes
+WHERE schemaname NOT IN ('information_schema', 'pg_catalog')
+  AND indexname NOT IN (SELECT conname FROM pg_constraint)
+ORDER BY schemaname, tablename, indexname`
+
+	sqliteIndexes = `
+SELECT '', tbl_name, name, sql FROM sqlite_master
+WHERE type = 'index' AND sql IS NOT NULL
+ORDER BY tbl_name, name`
+
+	duckdbIndexes = `
+SELECT schema_name, table_name, index_name, sql FROM duckdb_indexes()
+WHERE sql IS NOT NULL
+ORDER BY schema_name, table_name, index_name`
+
+	informationSchemaKeys = `
+SELECT tc.table_schema, tc.table_name, tc.constraint_type, tc.constraint_name, kcu.column_name
+FROM information_schema.table_constraints tc
+JOIN information_schema.key_column_usage kcu
+  ON kcu.constraint_schema = tc.constraint_schema AND kcu.constraint_name = tc.constraint_name
+ AND kcu.table_schema = tc.table_schema AND kcu.table_name = tc.table_name
+WHERE tc.constraint_type IN ('PRIMARY KEY', 'UNIQUE')
+ORDER BY tc.table_schema, tc.table_name, tc.constraint_type, tc.constraint_name, kcu.ordinal_position`
+
+	// The primary key is read from the table info rather than the index
+	// list, which leaves out INTEGER PRIMARY KEY columns.
+	sqliteKeys = `
+SELECT '', name, type, constraint_name, column_name FROM (
+  SELECT m.name, 'PRIMARY KEY' AS type, '' AS constraint_name, p.name AS column_name, p.pk AS position
+  FROM sqlite_master m JOIN pragma_table_info(m.name) p
+  WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND p.pk > 0
+  UNION ALL
+  SELECT m.name, 'UNIQUE', il.name, ii.name, ii.seqno
+  FROM sqlite_master m JOIN pragma_index_list(m.name) il JOIN pragma_index_info(il.name) ii
+  WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND il.origin = 'u'
+)
+ORDER BY name, type, constraint_name, position`
+
+	postgresColumnDefs = `
+SELECT table_schema, table_name, column_name, column_default, generation_expression
+FROM information_schema.columns
+WHERE table_schema NOT IN ('information_schema', 'pg_catalog')
+  AND (column_default IS NOT NULL OR generation_expression IS NOT NULL)
+ORDER BY table_schema, table_name, ordinal_position`
+
+	// MySQL reports literal defaults unquoted; only expression defaults are
+	// flagged DEFAULT_GENERATED.
+	mysqlColumnDefs = `
+SELECT table_schema, table_name, column_name,
+  CASE WHEN column_default IS NULL THEN NULL
+       WHEN extra LIKE '%DEFAULT_GENERATED%' THEN CONCAT('(', column_default, ')')
+       ELSE QUOTE(column_default) END,
+  NULLIF(generation_expression, '')
+FROM information_schema.columns
+WHERE table_schema = DATABASE()
+  AND (column_default IS NOT NULL OR generation_expression <> '')
+ORDER BY table_schema, table_name, ordinal_position`
+
+	// SQLite does not report generation expressions, so generated columns
+	// are recorded as plain columns.
+	sqliteColumnDefs = `
+SELECT '', m.name, p.name, p.dflt_value, NULL
+FROM sqlite_master m JOIN pragma_table_xinfo(m.name) p
+WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND p.dflt_value IS NOT NULL
+ORDER BY m.name, p.cid`
+
+	postgresViews = `
+SELECT schemaname, viewname, 'VIEW',
+  'CREATE VIEW ' || quote_ident(schemaname) || '.' || quote_ident(viewname) || ' AS ' || definition
+FROM pg_views WHERE schemaname NOT IN ('information_schema', 'pg_catalog')
+UNION ALL
+SELECT schemaname, matviewname, 'MATERIALIZED VIEW',
+  'CREATE MATERIALIZED VIEW ' || quote_ident(schemaname) || '.' || quote_ident(matviewname) || ' AS ' || definition
+FROM pg_matviews
+ORDER BY 1, 2`
+
+	mysqlViews = `
+SELECT table_schema, table_name, 'VIEW',
+  CONCAT('CREATE VIEW ', sys.quote_identifier(table_name), ' AS ', view_definition)
+FROM information_schema.views WHERE table_schema = DATABASE()
+ORDER BY table_name`
+
+	sqliteViews = `
+SELECT '', name, 'VIEW', sql FROM sqlite_master WHERE type = 'view'
+ORDER BY name`
+
+	duckdbViews = `
+SELECT schema_name, view_name, 'VIEW', sql FROM duckdb_views()
+WHERE NOT internal
+ORDER BY schema_name, view_name`
+
+	postgresSequences = `
+SELECT schemaname, sequencename, last_value FROM pg_sequences
+ORDER BY schemaname, sequencename`
+)
+
+// errNoCatalogQuery is returned by catalogQuery for engines it has no
+// query for.
+var errNoCatalogQuery = errors.New("not supported for this engine; set -engine")
+
+// catalogQuery returns the query for the connection's engine from queries,
+// or an error wrapping errNoCatalogQuery if there is none.
+func catalogQuery(cnxn *connection, what string, queries map[string]string) (string, error) {
+	name := cnxn.opts.dialect().name
+	query, ok := queries[name]
+	if !ok {
+		return "", fmt.Errorf("reading %s from %s: %w", what, name, errNoCatalogQuery)
+	}
+	return query, nil
+}
+
+// queryStrings runs query, with params bound to its :name placeholders, and
+// returns every row with its values as strings. NULL values are returned as
+// "".
+func queryStrings(ctx context.Context, cnxn *connection, query string, params ...queryParam) ([][]string, error) {
+	query, bound, err := bindParams(cnxn.opts, query, params)
+	if err != nil {
+		return nil, err
+	}
+	if bound != nil {
+		defer bound.Release()
+	}
+	reader, err := executeBound(ctx, cnxn, query, bound)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+
+	var rows [][]string
+	for reader.Next() {
+		rec := reader.Record()
+		for i := 0; i < int(rec.NumRows()); i++ {
+			row := make([]string, rec.NumCols())
+			for j := range row {
+				if v := arrowValue(rec.Column(j), i); v != nil {
+					row[j] = asString(v)
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// listTables returns every table of the database, leaving out views and
+// system tables.
+func listTables(ctx context.Context, cnxn *connection) ([]tableIdent, error) {
+	query, err := catalogQuery(cnxn, "tables", map[string]string{
+		"sqlite":   sqliteTables,
+		"postgres": informationSchemaTables,
+		"mysql":    informationSchemaTables,
+		"duckdb":   informationSchemaTables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queryStrings(ctx, cnxn, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	tables := make([]tableIdent, len(rows))
+	for i, r := range rows {
+		tables[i] = newTableIdent("", r[0], r[1])
+	}
+	return tables, nil
+}
+
+// tableKeys are the primary key and unique constraints of a table, as
+// column lists.
+type tableKeys struct {
+	PrimaryKey []string   `json:"primary_key,omitempty"`
+	Unique     [][]string `json:"unique,omitempty"`
+}
+
+// clauses returns the table constraint clauses declaring k.
+func (k tableKeys) clauses(d dialect, identCase string) []string {
+	cols := func(names []string) string {
+		quoted := make([]string, len(names))
+		for i, n := range names {
+			quoted[i] = d.quoteIdent(foldIdent(n, identCase))
+		}
+		return strings.Join(quoted, ", ")
+	}
+	var clauses []string
+	if len(k.PrimaryKey) > 0 {
+		clauses = append(clauses, "PRIMARY KEY ("+cols(k.PrimaryKey)+")")
+	}
+	for _, u := range k.Unique {
+		clauses = append(clauses, "UNIQUE ("+cols(u)+")")
+	}
+	return clauses
+}
+
+// tableDef is what a table declares beyond its columns' names and types.
+// Expressions are in the SQL of the engine they were read from.
+type tableDef struct {
+	tableKeys
+	// Defaults maps column names to their default expressions.
+	Defaults map[string]string `json:"defaults,omitempty"`
+	// Generated maps the names of generated columns to the expressions
+	// computing them.
+	Generated map[string]string `json:"generated,omitempty"`
+}
+
+// loadColumnDefs adds the column defaults and generation expressions of
+// every table to defs, keyed by table name.
+func loadColumnDefs(ctx context.Context, cnxn *connection, defs map[string]tableDef) error {
+	query, err := catalogQuery(cnxn, "column defaults", map[string]string{
+		"sqlite":   sqliteColumnDefs,
+		"postgres": postgresColumnDefs,
+		"mysql":    mysqlColumnDefs,
+	})
+	if err != nil {
+		return err
+	}
+	rows, err := queryStrings(ctx, cnxn, query)
+	if err != nil {
+		return fmt.Errorf("failed to read column defaults: %w", err)
+	}
+	for _, r := range rows {
+		table := newTableIdent("", r[0], r[1]).String()
+		def := defs[table]
+		switch {
+		case r[4] != "":
+			if def.Generated == nil {
+				def.Generated = make(map[string]string)
+			}
+			def.Generated[r[2]] = r[4]
+		case r[3] != "":
+			if def.Defaults == nil {
+				def.Defaults = make(map[string]string)
+			}
+			def.Defaults[r[2]] = r[3]
+		}
+		defs[table] = def
+	}
+	return nil
+}
+
+// loadTableKeys adds the primary keys and unique constraints of every table
+// to defs, keyed by table name.
+func loadTableKeys(ctx context.Context, cnxn *connection, defs map[string]tableDef) error {
+	query, err := catalogQuery(cnxn, "keys", map[string]string{
+		"sqlite":   sqliteKeys,
+		"postgres": informationSchemaKeys,
+		"mysql":    informationSchemaKeys,
+		"duckdb":   informationSchemaKeys,
+	})
+	if err != nil {
+		return err
+	}
+	rows, err := queryStrings(ctx, cnxn, query)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	var last string
+	for _, r := range rows {
+		table := newTableIdent("", r[0], r[1]).String()
+		k := defs[table]
+		switch id := table + "\x00" + r[3]; {
+		case r[2] == "PRIMARY KEY":
+			k.PrimaryKey = append(k.PrimaryKey, r[4])
+		case id != last:
+			k.Unique = append(k.Unique, []string{r[4]})
+			last = id
+		default:
+			k.Unique[len(k.Unique)-1] = append(k.Unique[len(k.Unique)-1], r[4])
+		}
+		defs[table] = k
+	}
+	return nil
+}
+
+// index is a secondary index and the engine's statement creating it.
+type index struct {
+	Table string `json:"table"`
+	Name  string `json:"name"`
+	SQL   string `json:"sql"`
+}
+
+// loadIndexes reads the indexes of the database that do not back a
+// constraint. Engines without a way to list them have none.
+func loadIndexes(ctx context.Context, cnxn *connection) ([]index, error) {
+	query, err := catalogQuery(cnxn, "indexes", map[string]string{
+		"sqlite":   sqliteIndexes,
+		"postgres": postgresIndexes,
+		"duckdb":   duckdbIndexes,
+	})
+	if err != nil {
+		return nil, nil
+	}
+	rows, err := queryStrings(ctx, cnxn, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes: %w", err)
+	}
+	indexes := make([]index, len(rows))
+	for i, r := range rows {
+		indexes[i] = index{Table: newTableIdent("", r[0], r[1]).String(), Name: r[2], SQL: r[3]}
+	}
+	return indexes, nil
+}
+
+// view is a view or materialized view and the statement creating it.
+type view struct {
+	Name         string `json:"name"`
+	Materialized bool   `json:"materialized,omitempty"`
+	SQL          string `json:"sql"`
+}
+
+// loadViews reads the view definitions of the database.
+func loadViews(ctx context.Context, cnxn *connection) ([]view, error) {
+	query, err := catalogQuery(cnxn, "views", map[string]string{
+		"sqlite":   sqliteViews,
+		"postgres": postgresViews,
+		"mysql":    mysqlViews,
+		"duckdb":   duckdbViews,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queryStrings(ctx, cnxn, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read views: %w", err)
+	}
+	views := make([]view, len(rows))
+	for i, r := range rows {
+		views[i] = view{Name: newTableIdent("", r[0], r[1]).String(), Materialized: r[2] == "MATERIALIZED VIEW", SQL: r[3]}
+	}
+	return views, nil
+}
+
+// sequence is a sequence and its current value; Value is 0 for a sequence
+// that was never used.
+type sequence struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value,omitempty"`
+}
+
+// loadSequences reads the sequences of a Postgres database. Other engines
+// have none.
+func loadSequences(ctx context.Context, cnxn *connection) ([]sequence, error) {
+	query, err := catalogQuery(cnxn, "sequences", map[string]string{"postgres": postgresSequences})
+	if err != nil {
+		return nil, nil
+	}
+	rows, err := queryStrings(ctx, cnxn, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sequences: %w", err)
+	}
+	seqs := make([]sequence, len(rows))
+	for i, r := range rows {
+		seqs[i].Name = newTableIdent("", r[0], r[1]).String()
+		if r[2] != "" {
+			if seqs[i].Value, err = strconv.ParseInt(r[2], 10, 64); err != nil {
+				return nil, fmt.Errorf("sequence %s: invalid value %q", seqs[i].Name, r[2])
+			}
+		}
+	}
+	return seqs, nil
+}
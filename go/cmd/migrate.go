@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+)
+
+// `dbx migrate` manages the schema of the database of the global flags with
+// versioned SQL files, so the schema an import or pipeline depends on can be
+// created in the same CI job:
+//
+//	migrations/
+//	  0001_create_orders.up.sql
+//	  0001_create_orders.down.sql
+//	  0002_add_orders_region.up.sql
+//
+// Each file holds statements run in one transaction, together with the
+// update of the migrations table recording it, unless its first line is
+// "-- dbx:no-transaction", for statements such as CREATE INDEX
+// CONCURRENTLY. As with `dbx exec`, databases that commit DDL implicitly
+// cannot roll all of a failed migration back.
+
+// migrationFile matches the names of migration files: the version, its
+// name and the direction.
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// noTransaction marks a migration file to run outside a transaction.
+const noTransaction = "-- dbx:no-transaction"
+
+// migration is a version of the schema, with the files moving the database
+// to and from it.
+type migration struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	// Up and Down are the paths of the migration's files; a migration
+	// without Down cannot be reverted.
+	Up   string `json:"-"`
+	Down string `json:"-"`
+	// Checksum is the SHA-256 of the up file, recorded when it is applied
+	// so later edits to it are noticed.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// migrationStatus is a migration as `dbx migrate status` reports it.
+type migrationStatus struct {
+	migration
+	// Status is applied, pending, modified (applied, but its up file has
+	// changed since) or missing (applied, but its files are gone).
+	Status    string     `json:"status"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// runMigrate implements `dbx migrate up|down|status`.
+func runMigrate(cfg config, args []string) error {
+	if len(args) == 0 {
+		return classify(errUsage, fmt.Errorf("usage: dbx migrate up [-to version] | down [-steps n | -to version] | status [-dir dir] [-table name] [-dry-run]"))
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", "migrations", "Directory of the <version>_<name>.up.sql and .down.sql migration files")
+	table := fs.String("table", "schema_migrations", "Table recording the applied migrations, created if it does not exist")
+	dryRun := fs.Bool("dry-run", false, "Print the statements that would run instead of running them")
+	to := fs.Int64("to", 0, "Migrate up to and including this version, or down to it, reverting the versions after it")
+	steps := fs.Int("steps", 1, "With down, the number of most recently applied migrations to revert")
+	parseFlags(fs, args[1:])
+	if fs.NArg() != 0 {
+		return classify(errUsage, fmt.Errorf("unexpected arguments %q", fs.Args()))
+	}
+	switch args[0] {
+	case "up", "down", "status":
+	default:
+		return classify(errUsage, fmt.Errorf("unknown migrate command %q", args[0]))
+	}
+
+	migrations, err := readMigrations(*dir)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cnxn, err := openConnection(ctx, cfg.conn)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+	tracking, err := cfg.conn.table(*table)
+	if err != nil {
+		return err
+	}
+	quoted := tracking.quote(cfg.conn.dialect())
+	applied, err := appliedMigrations(ctx, cnxn, tracking)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "status" {
+		return printMigrationStatus(cfg, migrationStatuses(migrations, applied))
+	}
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok && a.Checksum != "" && a.Checksum != m.Checksum {
+			return fmt.Errorf("migration %d (%s) was changed after it was applied; restore it, or add a new migration instead", m.Version, m.Name)
+		}
+	}
+
+	var plan []migration
+	if args[0] == "up" {
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; !ok && (*to == 0 || m.Version <= *to) {
+				plan = append(plan, m)
+			}
+		}
+	} else {
+		byVersion := make(map[int64]migration, len(migrations))
+		for _, m := range migrations {
+			byVersion[m.Version] = m
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		for i, v := range versions {
+			if (*to != 0 && v <= *to) || (*to == 0 && i == *steps) {
+				break
+			}
+			m, ok := byVersion[v]
+			if !ok || m.Down == "" {
+				return classify(errUsage, fmt.Errorf("migration %d has no down file to revert it with", v))
+			}
+			plan = append(plan, m)
+		}
+	}
+	if len(plan) == 0 {
+		if args[0] == "up" {
+			slog.Info("Database schema is up to date", "applied", len(applied))
+		} else {
+			slog.Info("No migrations to revert", "applied", len(applied))
+		}
+		if cfg.json {
+			printJSON([]migration{})
+		}
+		return nil
+	}
+
+	if !*dryRun {
+		create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, applied_at TIMESTAMP NOT NULL)", quoted)
+		if err := execUpdate(ctx, cnxn, create); err != nil {
+			return classify(errWrite, fmt.Errorf("failed to create the migrations table: %w", err))
+		}
+	}
+	for _, m := range plan {
+		path, record := m.Up, fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (%d, %s, %s, CURRENT_TIMESTAMP)", quoted, m.Version, quoteLiteral(m.Name), quoteLiteral(m.Checksum))
+		if args[0] == "down" {
+			path, record = m.Down, fmt.Sprintf("DELETE FROM %s WHERE version = %d", quoted, m.Version)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read migration: %w", err)
+		}
+		text := string(data)
+		stmts := append(splitStatements(text), record)
+		if *dryRun {
+			fmt.Printf("-- %s\n", filepath.Base(path))
+			for _, s := range stmts {
+				fmt.Printf("%s;\n", s)
+			}
+			fmt.Println()
+			continue
+		}
+		start := time.Now()
+		inTx := !strings.HasPrefix(strings.TrimSpace(text), noTransaction)
+		if _, err := execScript(ctx, cnxn, stmts, inTx, func(execResult) {}); err != nil {
+			return fmt.Errorf("migration %s: %w", filepath.Base(path), err)
+		}
+		slog.Info("Ran migration", "file", filepath.Base(path), "duration", time.Since(start))
+	}
+	if cfg.json && !*dryRun {
+		printJSON(plan)
+	}
+	return nil
+}
+
+// readMigrations returns the migrations of the files in dir, by version.
+func readMigrations(dir string) ([]migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, classify(errUsage, fmt.Errorf("failed to read migrations: %w", err))
+	}
+	byVersion := make(map[int64]*migration)
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, classify(errUsage, fmt.Errorf("invalid migration version in %s: %w", e.Name(), err))
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, classify(errUsage, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, match[2]))
+		}
+		path := filepath.Join(dir, e.Name())
+		if match[3] == "down" {
+			m.Down = path
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		m.Up, m.Checksum = path, hex.EncodeToString(sum[:])
+	}
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, classify(errUsage, fmt.Errorf("migration %d (%s) has a down file but no up file", m.Version, m.Name))
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedMigration is a row of the migrations table.
+type appliedMigration struct {
+	Name      string
+	Checksum  string
+	AppliedAt *time.Time
+}
+
+// appliedMigrations reads the migrations table, by version. A table the
+// driver reports not found holds none; drivers that cannot look tables up
+// have it read regardless.
+func appliedMigrations(ctx context.Context, cnxn *connection, table tableIdent) (map[int64]appliedMigration, error) {
+	applied := make(map[int64]appliedMigration)
+	if _, err := getTableSchema(ctx, cnxn, table); err != nil {
+		var aerr adbc.Error
+		switch {
+		case errors.As(err, &aerr) && aerr.Code == adbc.StatusNotFound:
+			logger(ctx).Debug("No migrations table", "table", table.String(), "err", err)
+			return applied, nil
+		case errors.As(err, &aerr) && aerr.Code == adbc.StatusNotImplemented:
+		default:
+			return nil, fmt.Errorf("failed to look up migrations table %s: %w", table, err)
+		}
+	}
+	reader, err := executeQuery(ctx, cnxn, "SELECT version, name, checksum, applied_at FROM "+table.quote(cnxn.opts.dialect()))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+	for reader.Next() {
+		rec := reader.Record()
+		for i := 0; i < int(rec.NumRows()); i++ {
+			version, err := strconv.ParseInt(asString(arrowValue(rec.Column(0), i)), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version in %s: %w", table, err)
+			}
+			a := appliedMigration{Name: asString(arrowValue(rec.Column(1), i)), Checksum: asString(arrowValue(rec.Column(2), i))}
+			switch v := arrowValue(rec.Column(3), i).(type) {
+			case time.Time:
+				a.AppliedAt = &v
+			case string:
+				// SQLite keeps timestamps as text.
+				if t, err := time.Parse(time.DateTime, v); err == nil {
+					a.AppliedAt = &t
+				}
+			}
+			applied[version] = a
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// migrationStatuses reports every migration of the files or the table.
+func migrationStatuses(migrations []migration, applied map[int64]appliedMigration) []migrationStatus {
+	var statuses []migrationStatus
+	seen := make(map[int64]bool)
+	for _, m := range migrations {
+		seen[m.Version] = true
+		s := migrationStatus{migration: m, Status: "pending"}
+		if a, ok := applied[m.Version]; ok {
+			s.Status, s.AppliedAt = "applied", a.AppliedAt
+			if a.Checksum != "" && a.Checksum != m.Checksum {
+				s.Status = "modified"
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	for v, a := range applied {
+		if !seen[v] {
+			statuses = append(statuses, migrationStatus{migration: migration{Version: v, Name: a.Name, Checksum: a.Checksum}, Status: "missing", AppliedAt: a.AppliedAt})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses
+}
+
+func printMigrationStatus(cfg config, statuses []migrationStatus) error {
+	if cfg.json {
+		printJSON(statuses)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED")
+	for _, s := range statuses {
+		at := ""
+		if s.AppliedAt != nil {
+			at = s.AppliedAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, s.Status, at)
+	}
+	return w.Flush()
+}
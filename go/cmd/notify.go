@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// notifyAttempts is how many times a webhook is tried before the
+	// notification is given up on.
+	notifyAttempts = 3
+	// notifyTimeout bounds each attempt.
+	notifyTimeout = 10 * time.Second
+)
+
+// jobNotification is the JSON body posted to webhooks when a scheduled or
+// served job finishes.
+type jobNotification struct {
+	Job    string `json:"job"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Rows   int64  `json:"rows"`
+	// Duration is in nanoseconds, as in the -json output of dbx.
+	Duration time.Duration `json:"duration"`
+	Location string        `json:"location,omitempty"`
+	// ManifestURL is a file URL of the output's manifest.
+	ManifestURL string    `json:"manifest_url,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	ErrorKind   errorKind `json:"error_kind,omitempty"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// newJobNotification describes how job id of kind, started at start,
+// ended: with resp, or with err.
+func newJobNotification(id, kind string, start time.Time, resp *response, err error) jobNotification {
+	n := jobNotification{Job: id, Kind: kind, Status: jobSucceeded, Duration: time.Since(start), FinishedAt: time.Now().UTC()}
+	if err != nil {
+		n.Status, n.Error, n.ErrorKind = jobFailed, err.Error(), kindOf(err)
+		if n.ErrorKind == errCancelled {
+			n.Status = jobCancelled
+		}
+		return n
+	}
+	if resp == nil {
+		return n
+	}
+	n.Rows, n.Location = resp.RowsWritten, resp.Location
+	if resp.Location != "" {
+		path := manifestPath(resp.Location)
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if _, err := os.Stat(path); err == nil {
+			n.ManifestURL = (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+		}
+	}
+	return n
+}
+
+// notifier posts notifications of finished jobs to webhooks and Slack, so
+// downstream systems are triggered without polling.
+type notifier struct {
+	webhooks stringList
+	slack    string
+}
+
+// notifyFlags adds the flags configuring a notifier to fs.
+func notifyFlags(fs *flag.FlagSet) *notifier {
+	n := &notifier{}
+	fs.Var(&n.webhooks, "webhook", "URL to POST a JSON notification to when a job finishes (repeatable)")
+	fs.StringVar(&n.slack, "slack-webhook", "", "Slack incoming webhook URL to post a message to when a job finishes")
+	return n
+}
+
+// send delivers n to every webhook, logging rather than returning failures
+// so they never fail the job itself.
+func (nt *notifier) send(ctx context.Context, n jobNotification) {
+	if len(nt.webhooks) == 0 && nt.slack == "" {
+		return
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		slog.Error("Failed to encode notification", "job", n.Job, "err", err)
+		return
+	}
+	for _, u := range nt.webhooks {
+		if err := postWebhook(ctx, u, body); err != nil {
+			// Webhook URLs often embed a secret, so only the host is logged.
+			host := u
+			if parsed, err := url.Parse(u); err == nil {
+				host = parsed.Host
+			}
+			slog.Warn("Failed to notify webhook", "job", n.Job, "host", host, "err", err)
+		}
+	}
+	if nt.slack != "" {
+		msg, _ := json.Marshal(map[string]string{"text": slackText(n)})
+		if err := postWebhook(ctx, nt.slack, msg); err != nil {
+			slog.Warn("Failed to notify Slack", "job", n.Job, "err", err)
+		}
+	}
+}
+
+// slackText summarizes n in a line.
+func slackText(n jobNotification) string {
+	switch n.Status {
+	case jobSucceeded:
+		return fmt.Sprintf(":white_check_mark: dbX %s job %s succeeded: %d rows in %s to %s", n.Kind, n.Job, n.Rows, formatDuration(n.Duration), n.Location)
+	case jobCancelled:
+		return fmt.Sprintf(":warning: dbX %s job %s was cancelled after %s", n.Kind, n.Job, formatDuration(n.Duration))
+	default:
+		return fmt.Sprintf(":x: dbX %s job %s failed after %s: %s", n.Kind, n.Job, formatDuration(n.Duration), n.Error)
+	}
+}
+
+// postWebhook POSTs body as JSON to u, retrying failed attempts with a
+// growing delay.
+func postWebhook(ctx context.Context, u string, body []byte) error {
+	var err error
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		if err = postOnce(ctx, u, body); err == nil {
+			return nil
+		}
+		if attempt < notifyAttempts {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return err
+}
+
+func postOnce(ctx context.Context, u string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dbX/"+version)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Leave out the URL the error quotes.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build odbc
+
+package main
+
+// Building with -tags odbc registers the "odbc" database/sql driver, so any
+// source reachable through an ODBC DSN can be exported via the database/sql
+// fallback:
+//
+//	dbx -sql-driver odbc -uri "DSN=legacy;UID=reader;PWD=..." -table orders
+//
+// This requires the unixODBC (or iODBC) development headers at build time.
+import _ "github.com/alexbrainman/odbc"
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// defaultPageRows is the page size of keyset pagination when none is given.
+const defaultPageRows = 1_000_000
+
+// pagination splits an export into bounded queries, for drivers that buffer
+// the whole result set of a query in memory, or to read a table over
+// several connections at once. With the keyset strategy each page selects
+// the rows whose Key is past the largest key of the previous page, in key
+// order. With the range strategy pages are ranges of an integer Key, up to
+// Parallel of them read concurrently.
+type pagination struct {
+	Strategy string
+	Key      string
+	PageRows int64
+	Parallel int
+}
+
+// newPagination validates the -paginate, -key, -page-rows and -parallel
+// flags. It returns nil if strategy is empty.
+func newPagination(strategy, key, pageRows string, parallel int) (*pagination, error) {
+	if strategy == "" {
+		if key != "" || parallel != 0 {
+			return nil, fmt.Errorf("-key and -parallel require -paginate")
+		}
+		return nil, nil
+	}
+	switch {
+	case strategy != "keyset" && strategy != "range":
+		return nil, fmt.Errorf("unknown pagination strategy %q, expected keyset or range", strategy)
+	case key == "":
+		return nil, fmt.Errorf("%s pagination requires a key column", strategy)
+	case parallel != 0 && strategy != "range":
+		return nil, fmt.Errorf("-parallel requires -paginate range")
+	case parallel < 0:
+		return nil, fmt.Errorf("-parallel must not be negative")
+	}
+	p := &pagination{Strategy: strategy, Key: key, PageRows: defaultPageRows, Parallel: parallel}
+	if strategy == "range" && parallel == 0 {
+		p.Parallel = defaultParallel
+	}
+	if pageRows != "" {
+		n, err := parseCount(pageRows)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid page size %q", pageRows)
+		}
+		p.PageRows = n
+	}
+	return p, nil
+}
+
+func (p *pagination) String() string {
+	if p.Strategy == "range" {
+		return fmt.Sprintf("range %s %d parallel %d", p.Key, p.PageRows, p.Parallel)
+	}
+	return fmt.Sprintf("keyset %s %d", p.Key, p.PageRows)
+}
+
+// keysetQuery returns the query for the page of query after the key literal
+// after, or the first page if after is empty.
+func keysetQuery(opts connOptions, query, key, after string, limit int64) string {
+	key = opts.quoteIdent(key)
+	where := ""
+	if after != "" {
+		where = fmt.Sprintf(" WHERE %s > %s", key, after)
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) AS src%s ORDER BY %s LIMIT %d", query, where, key, limit)
+}
+
+// keysetReader streams the result of a query page by page, running the
+// query for the next page once the previous one is exhausted. Only one page
+// is open at a time. Each page fetches one row more than it returns, to
+// tell whether another page follows and that it starts past the keys of
+// this one: a key repeated across the boundary, or a NULL key after it,
+// would otherwise be skipped by the next page's query, so both fail the
+// export.
+type keysetReader struct {
+	refCount int64
+	ctx      context.Context
+	cnxn     *connection
+	query    string
+	params   arrow.Record
+	page     *pagination
+
+	schema   *arrow.Schema
+	cur      array.RecordReader
+	rec      arrow.Record
+	keys     *cursorTracker
+	pageRows int64
+	// more is set once the row after the page is read, and next is its
+	// key.
+	more bool
+	next any
+	done bool
+	err  error
+}
+
+// newKeysetReader runs the first page of query and returns a reader over
+// all pages. params, if not nil, are bound to every page's query.
+func newKeysetReader(ctx context.Context, cnxn *connection, query string, params arrow.Record, page *pagination) (*keysetReader, error) {
+	r := &keysetReader{refCount: 1, ctx: ctx, cnxn: cnxn, query: query, params: params, page: page}
+	if params != nil {
+		params.Retain()
+	}
+	if err := r.openPage(""); err != nil {
+		r.Release()
+		return nil, err
+	}
+	r.schema = r.cur.Schema()
+	keys, err := newCursorTracker(r.schema, page.Key)
+	if err != nil {
+		r.Release()
+		return nil, fmt.Errorf("pagination: %w", err)
+	}
+	r.keys = keys
+	return r, nil
+}
+
+func (r *keysetReader) openPage(after string) error {
+	query := keysetQuery(r.cnxn.opts, r.query, r.page.Key, after, r.page.PageRows+1)
+	logger(r.ctx).Debug("Fetching page", "after", after, "rows", r.page.PageRows)
+	reader, err := executeBound(r.ctx, r.cnxn, query, r.params)
+	if err != nil {
+		return err
+	}
+	r.cur, r.pageRows, r.more, r.next = reader, 0, false, nil
+	return nil
+}
+
+func (r *keysetReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *keysetReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.rec != nil {
+			r.rec.Release()
+			r.rec = nil
+		}
+		if r.cur != nil {
+			r.cur.Release()
+			r.cur = nil
+		}
+		if r.params != nil {
+			r.params.Release()
+		}
+	}
+}
+
+func (r *keysetReader) Schema() *arrow.Schema { return r.schema }
+func (r *keysetReader) Err() error            { return r.err }
+func (r *keysetReader) Record() arrow.Record  { return r.rec }
+
+func (r *keysetReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	for !r.done && r.err == nil {
+		if r.cur.Next() {
+			rec := r.cur.Record()
+			if left := r.page.PageRows - r.pageRows; rec.NumRows() > left {
+				r.more, r.next = true, r.keys.value(rec.Column(r.keys.index), int(left))
+				if left == 0 {
+					continue
+				}
+				rec = rec.NewSlice(0, left)
+			} else {
+				rec.Retain()
+			}
+			r.rec = rec
+			r.keys.observe(rec)
+			r.pageRows += rec.NumRows()
+			return true
+		}
+		if err := r.cur.Err(); err != nil {
+			r.err = err
+			return false
+		}
+
+		if !r.more {
+			r.done = true
+			return false
+		}
+		after := r.keys.literal()
+		switch {
+		case after == "" || r.next == nil:
+			r.err = fmt.Errorf("pagination: key %s has NULL values that keyset pagination cannot page past; filter them out or use a NOT NULL key", r.page.Key)
+		case !cursorLess(r.keys.max, r.next):
+			r.err = fmt.Errorf("pagination: key %s is not unique: rows with %s = %s span pages; use a unique key or a larger -page-rows", r.page.Key, r.page.Key, after)
+		}
+		if r.err != nil {
+			return false
+		}
+		r.cur.Release()
+		r.cur = nil
+		if err := r.openPage(after); err != nil {
+			r.err = err
+			return false
+		}
+		if !r.cur.Schema().Equal(r.schema) {
+			r.err = fmt.Errorf("pagination: page schema changed to %s", r.cur.Schema())
+			return false
+		}
+	}
+	return false
+}
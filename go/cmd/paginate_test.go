@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestKeysetReader reads tables through keyset pagination: every row is
+// read exactly once, in key order, and keys the next page's query would
+// skip fail the export instead.
+func TestKeysetReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		typ      string
+		keys     []string
+		pageRows int64
+		// want is the keys read, or err the error they fail with.
+		want []string
+		err  string
+	}{
+		{name: "one page", typ: "INTEGER", keys: []string{"3", "1", "2"}, pageRows: 10, want: []string{"1", "2", "3"}},
+		{name: "partial last page", typ: "INTEGER", keys: []string{"5", "4", "3", "2", "1"}, pageRows: 2, want: []string{"1", "2", "3", "4", "5"}},
+		{name: "exact pages", typ: "INTEGER", keys: []string{"4", "3", "2", "1"}, pageRows: 2, want: []string{"1", "2", "3", "4"}},
+		{name: "page per row", typ: "INTEGER", keys: []string{"-7", "0", "7"}, pageRows: 1, want: []string{"-7", "0", "7"}},
+		{name: "text keys", typ: "TEXT", keys: []string{"'b'", "'it''s'", "'a'"}, pageRows: 1, want: []string{"a", "b", "it's"}},
+		{name: "repeated key within a page", typ: "INTEGER", keys: []string{"1", "1", "2", "3"}, pageRows: 3, want: []string{"1", "1", "2", "3"}},
+		{name: "repeated key across pages", typ: "INTEGER", keys: []string{"1", "2", "2", "3"}, pageRows: 2, err: "not unique"},
+		{name: "NULL keys across pages", typ: "INTEGER", keys: []string{"NULL", "NULL", "NULL", "1"}, pageRows: 2, err: "NULL values"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cnxn, err := openConnection(ctx, connOptions{SQLDriver: "sqlite3", URI: "file:" + filepath.Join(t.TempDir(), "keys.db")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cnxn.Close()
+			if err := execUpdate(ctx, cnxn, fmt.Sprintf("CREATE TABLE t (k %s)", tt.typ)); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range tt.keys {
+				if err := execUpdate(ctx, cnxn, "INSERT INTO t VALUES ("+k+")"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reader, err := openPaginated(ctx, cnxn, "SELECT k FROM t", nil, &pagination{Strategy: "keyset", Key: "k", PageRows: tt.pageRows})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Release()
+			var got []string
+			for reader.Next() {
+				col := reader.Record().Column(0)
+				for i := 0; i < col.Len(); i++ {
+					got = append(got, col.ValueStr(i))
+				}
+			}
+			err = reader.Err()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("read %v, error %v, want an error about %s", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("read keys %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
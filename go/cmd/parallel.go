@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+const (
+	// defaultParallel is the most concurrent range queries of -paginate
+	// range when -parallel is not given.
+	defaultParallel = 4
+	// rangeLatencySlack is how much a range's time to first batch may grow
+	// past twice the best seen before it counts as the database slowing
+	// down, so that jitter on fast queries does not.
+	rangeLatencySlack = 10 * time.Millisecond
+)
+
+// openPaginated returns a reader over every page of query, as page
+// describes.
+func openPaginated(ctx context.Context, cnxn *connection, query string, params arrow.Record, page *pagination) (array.RecordReader, error) {
+	if page.Strategy == "range" {
+		return newRangeReader(ctx, cnxn, query, params, page)
+	}
+	return newKeysetReader(ctx, cnxn, query, params, page)
+}
+
+// rangeReader runs a query as ranges of an integer key, several at a time
+// on their own connections, and streams their batches in no particular
+// order.
+//
+// How many ranges run at once adapts between 1 and page.Parallel. After
+// every range the reader adds a worker, unless the range showed that more
+// would not help: the sink not keeping up (workers spent most of the range
+// blocked handing batches over), or the database slowing down (the range's
+// time to first batch more than doubled the best seen), in which case it
+// removes one or halves them respectively.
+type rangeReader struct {
+	refCount int64
+	ctx      context.Context
+	cancel   context.CancelFunc
+	opts     connOptions
+	query    string
+	params   arrow.Record
+	page     *pagination
+	schema   *arrow.Schema
+	records  chan arrow.Record
+	cur      arrow.Record
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// next is the first key of the next range, up to last, the largest
+	// key; done is set once the range holding last has been taken.
+	next int64
+	last int64
+	done bool
+	// nulls is set while the range of the rows with a NULL key is yet to
+	// be taken.
+	nulls bool
+	// width is the number of keys in a range. Spans of keys are counted
+	// unsigned, as from the smallest int64 to the largest they do not fit
+	// in an int64.
+	width    uint64
+	limit    int
+	active   int
+	baseline time.Duration
+	err      error
+}
+
+// newRangeReader splits query into ranges of page.Key holding about
+// page.PageRows rows each and starts reading them. The first range runs on
+// cnxn, to learn the result's schema; later workers open connections of
+// their own.
+func newRangeReader(ctx context.Context, cnxn *connection, query string, params arrow.Record, page *pagination) (*rangeReader, error) {
+	lo, hi, count, nulls, err := keyBounds(ctx, cnxn, query, params, page.Key)
+	if err != nil {
+		return nil, fmt.Errorf("pagination: %w", err)
+	}
+	width := rangeWidth(lo, hi, count, page.PageRows)
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &rangeReader{
+		refCount: 1,
+		ctx:      ctx,
+		cancel:   cancel,
+		opts:     cnxn.opts,
+		query:    query,
+		params:   params,
+		page:     page,
+		records:  make(chan arrow.Record, page.Parallel),
+		next:     lo,
+		last:     hi,
+		done:     count == 0,
+		nulls:    nulls > 0,
+		width:    width,
+		limit:    1,
+		active:   1,
+	}
+	if params != nil {
+		params.Retain()
+	}
+	logger(ctx).Debug("Splitting export into ranges", "key", page.Key, "min", lo, "max", hi, "width", width, "nulls", nulls)
+
+	// With no keys and no NULL ones, the first query reads no rows but
+	// still gives the schema.
+	started := time.Now()
+	kr, _ := r.take()
+	first, err := executeBound(ctx, cnxn, r.rangeQuery(cnxn.opts, kr), params)
+	if err != nil {
+		r.Release()
+		return nil, err
+	}
+	r.schema = first.Schema()
+	r.wg.Add(1)
+	go r.work(cnxn, first, started, false)
+	go func() {
+		r.wg.Wait()
+		close(r.records)
+	}()
+	return r, nil
+}
+
+// keyBounds returns the smallest and largest value of key in the result of
+// query, the number of rows with a key, and the number with a NULL one.
+func keyBounds(ctx context.Context, cnxn *connection, query string, params arrow.Record, key string) (lo, hi, count, nulls int64, err error) {
+	k := cnxn.opts.quoteIdent(key)
+	reader, err := executeBound(ctx, cnxn, fmt.Sprintf("SELECT MIN(%s), MAX(%s), COUNT(%s), COUNT(*) - COUNT(%s) FROM (%s) AS src", k, k, k, k, query), params)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	defer reader.Release()
+	if !reader.Next() {
+		if err := reader.Err(); err != nil {
+			return 0, 0, 0, 0, err
+		}
+		return 0, 0, 0, 0, fmt.Errorf("no result for the bounds of %s", key)
+	}
+	rec := reader.Record()
+	values := make([]int64, 4)
+	for i := range values {
+		switch v := arrowValue(rec.Column(i), 0).(type) {
+		case nil:
+			// An empty result has no bounds.
+		case int64:
+			values[i] = v
+		case uint64:
+			if v > math.MaxInt64 {
+				return 0, 0, 0, 0, fmt.Errorf("range pagination requires keys up to %d, %s has %d", int64(math.MaxInt64), key, v)
+			}
+			values[i] = int64(v)
+		default:
+			return 0, 0, 0, 0, fmt.Errorf("range pagination requires an integer key, %s is %s", key, rec.Column(i).DataType())
+		}
+	}
+	return values[0], values[1], values[2], values[3], nil
+}
+
+// rangeWidth returns the number of keys of a range expected to hold
+// pageRows of the count rows with keys from lo to hi, at least 1.
+func rangeWidth(lo, hi, count, pageRows int64) uint64 {
+	if count <= 0 {
+		return 1
+	}
+	w := math.Ceil((float64(uint64(hi)-uint64(lo)) + 1) * float64(pageRows) / float64(count))
+	if w >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return max(uint64(w), 1)
+}
+
+// keyRange is the range of keys from start to end, both included, or the
+// rows whose key is NULL, which no range holds.
+type keyRange struct {
+	start, end int64
+	null       bool
+}
+
+// rangeQuery returns the query for the rows of kr.
+func (r *rangeReader) rangeQuery(opts connOptions, kr keyRange) string {
+	key := opts.quoteIdent(r.page.Key)
+	if kr.null {
+		return fmt.Sprintf("SELECT * FROM (%s) AS src WHERE %s IS NULL", r.query, key)
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) AS src WHERE %s >= %d AND %s <= %d", r.query, key, kr.start, key, kr.end)
+}
+
+// pending reports whether ranges are left to take.
+func (r *rangeReader) pending() bool {
+	return !r.done || r.nulls
+}
+
+// take returns the next range to read, or false once every range has been
+// taken. The last range of keys ends at the largest key, and is followed
+// by the rows with a NULL key, if there are any.
+func (r *rangeReader) take() (keyRange, bool) {
+	if r.done {
+		if r.nulls {
+			r.nulls = false
+			return keyRange{null: true}, true
+		}
+		return keyRange{}, false
+	}
+	start := r.next
+	// Keys left after start, which cannot overflow unsigned as last is
+	// not below start.
+	if uint64(r.last)-uint64(start) < r.width {
+		r.done = true
+		return keyRange{start: start, end: r.last}, true
+	}
+	end := int64(uint64(start) + r.width - 1)
+	r.next = end + 1
+	return keyRange{start: start, end: end}, true
+}
+
+// work reads ranges until none are left, the reader has more workers than
+// its limit, or something fails. It starts with reader, whose query was run
+// on cnxn at started, and closes cnxn at the end if it owns it.
+func (r *rangeReader) work(cnxn *connection, reader array.RecordReader, started time.Time, owned bool) {
+	defer r.wg.Done()
+	if owned {
+		defer cnxn.Close()
+	}
+	for {
+		latency, blocked, err := r.drain(reader, started)
+		reader.Release()
+		if err != nil {
+			r.fail(err)
+			return
+		}
+
+		r.mu.Lock()
+		r.adjust(latency, blocked, time.Since(started))
+		if r.active > r.limit || r.err != nil {
+			r.active--
+			r.mu.Unlock()
+			return
+		}
+		kr, ok := r.take()
+		if !ok {
+			r.active--
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+
+		started = time.Now()
+		if reader, err = executeBound(r.ctx, cnxn, r.rangeQuery(cnxn.opts, kr), r.params); err != nil {
+			r.fail(err)
+			return
+		}
+	}
+}
+
+// drain hands every batch of reader to Next. It returns the time from
+// start until the first batch arrived and the time spent waiting for Next
+// to take batches.
+func (r *rangeReader) drain(reader array.RecordReader, start time.Time) (latency, blocked time.Duration, err error) {
+	first := true
+	for reader.Next() {
+		if first {
+			latency, first = time.Since(start), false
+		}
+		rec := reader.Record()
+		if rec.NumRows() == 0 {
+			continue
+		}
+		if !rec.Schema().Equal(r.schema) {
+			return 0, 0, fmt.Errorf("pagination: range schema changed to %s", rec.Schema())
+		}
+		rec.Retain()
+		waitStart := time.Now()
+		select {
+		case r.records <- rec:
+		case <-r.ctx.Done():
+			rec.Release()
+			return 0, 0, r.ctx.Err()
+		}
+		blocked += time.Since(waitStart)
+	}
+	if first {
+		latency = time.Since(start)
+	}
+	return latency, blocked, reader.Err()
+}
+
+// adjust changes the worker limit after a range, starting a worker if it
+// grows. r.mu must be held.
+func (r *rangeReader) adjust(latency, blocked, elapsed time.Duration) {
+	if r.baseline == 0 || latency < r.baseline {
+		r.baseline = latency
+	}
+	limit := r.limit
+	switch {
+	case blocked > elapsed/2:
+		limit = max(limit-1, 1)
+	case latency > 2*r.baseline+rangeLatencySlack:
+		limit = max(limit/2, 1)
+	case limit < r.page.Parallel:
+		limit++
+	}
+	if limit != r.limit {
+		logger(r.ctx).Debug("Adjusting range parallelism", "from", r.limit, "to", limit,
+			"latency", latency, "baseline", r.baseline, "blocked", blocked, "elapsed", elapsed)
+		r.limit = limit
+	}
+	for r.active < r.limit && r.pending() && r.err == nil {
+		kr, _ := r.take()
+		r.active++
+		r.wg.Add(1)
+		go r.startWorker(kr)
+	}
+}
+
+// startWorker opens a connection for a new worker and runs it, starting
+// with the range kr.
+func (r *rangeReader) startWorker(kr keyRange) {
+	cnxn, err := openReadConnection(r.ctx, r.opts)
+	if err != nil {
+		r.fail(err)
+		r.wg.Done()
+		return
+	}
+	started := time.Now()
+	reader, err := executeBound(r.ctx, cnxn, r.rangeQuery(cnxn.opts, kr), r.params)
+	if err != nil {
+		cnxn.Close()
+		r.fail(err)
+		r.wg.Done()
+		return
+	}
+	r.work(cnxn, reader, started, true)
+}
+
+// fail records the first error and stops every worker.
+func (r *rangeReader) fail(err error) {
+	r.mu.Lock()
+	if r.err == nil && !errors.Is(err, context.Canceled) {
+		r.err = err
+	}
+	r.active--
+	r.mu.Unlock()
+	r.cancel()
+}
+
+func (r *rangeReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *rangeReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		r.cancel()
+		if r.cur != nil {
+			r.cur.Release()
+			r.cur = nil
+		}
+		if r.schema != nil {
+			for rec := range r.records {
+				rec.Release()
+			}
+		}
+		if r.params != nil {
+			r.params.Release()
+		}
+	}
+}
+
+func (r *rangeReader) Schema() *arrow.Schema { return r.schema }
+func (r *rangeReader) Record() arrow.Record  { return r.cur }
+
+func (r *rangeReader) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *rangeReader) Next() bool {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	rec, ok := <-r.records
+	if !ok {
+		return false
+	}
+	r.cur = rec
+	return true
+}
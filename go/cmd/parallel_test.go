@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+func TestRangeReaderTake(t *testing.T) {
+	tests := []struct {
+		name     string
+		lo, hi   int64
+		width    uint64
+		nulls    bool
+		want     []keyRange
+		wantNull bool
+	}{
+		{name: "small", lo: -5, hi: 5, width: 4, want: []keyRange{{start: -5, end: -2}, {start: -1, end: 2}, {start: 3, end: 5}}},
+		{name: "one key", lo: 7, hi: 7, width: 10, want: []keyRange{{start: 7, end: 7}}},
+		{name: "exact fit", lo: 0, hi: 7, width: 4, want: []keyRange{{start: 0, end: 3}, {start: 4, end: 7}}},
+		{name: "nulls last", lo: 0, hi: 1, width: 1, nulls: true, want: []keyRange{{start: 0, end: 0}, {start: 1, end: 1}}, wantNull: true},
+		{name: "top of int64", lo: math.MaxInt64 - 2, hi: math.MaxInt64, width: 1, want: []keyRange{
+			{start: math.MaxInt64 - 2, end: math.MaxInt64 - 2},
+			{start: math.MaxInt64 - 1, end: math.MaxInt64 - 1},
+			{start: math.MaxInt64, end: math.MaxInt64},
+		}},
+		{name: "top of int64 in one range", lo: math.MaxInt64 - 2, hi: math.MaxInt64, width: 100, want: []keyRange{{start: math.MaxInt64 - 2, end: math.MaxInt64}}},
+		{name: "bottom of int64", lo: math.MinInt64, hi: math.MinInt64 + 1, width: 1, want: []keyRange{
+			{start: math.MinInt64, end: math.MinInt64},
+			{start: math.MinInt64 + 1, end: math.MinInt64 + 1},
+		}},
+		{name: "whole int64", lo: math.MinInt64, hi: math.MaxInt64, width: 1 << 62, want: []keyRange{
+			{start: math.MinInt64, end: -1<<62 - 1},
+			{start: -1 << 62, end: -1},
+			{start: 0, end: 1<<62 - 1},
+			{start: 1 << 62, end: math.MaxInt64},
+		}},
+		// 2^64 keys are one more than the widest range holds.
+		{name: "whole int64 in widest range", lo: math.MinInt64, hi: math.MaxInt64, width: math.MaxUint64, want: []keyRange{
+			{start: math.MinInt64, end: math.MaxInt64 - 1},
+			{start: math.MaxInt64, end: math.MaxInt64},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &rangeReader{next: tt.lo, last: tt.hi, width: tt.width, nulls: tt.nulls}
+			var got []keyRange
+			gotNull := false
+			for i := 0; ; i++ {
+				if i > len(tt.want)+1 {
+					t.Fatalf("more ranges than expected: %v", got)
+				}
+				kr, ok := r.take()
+				if !ok {
+					break
+				}
+				if kr.null {
+					gotNull = true
+					continue
+				}
+				got = append(got, kr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) || gotNull != tt.wantNull {
+				t.Errorf("ranges = %v (null %v), want %v (null %v)", got, gotNull, tt.want, tt.wantNull)
+			}
+			if r.pending() {
+				t.Errorf("ranges pending after the last was taken")
+			}
+		})
+	}
+}
+
+func TestRangeWidth(t *testing.T) {
+	tests := []struct {
+		lo, hi, count, pageRows int64
+		want                    uint64
+	}{
+		{lo: 1, hi: 100, count: 100, pageRows: 10, want: 10},
+		{lo: 1, hi: 100, count: 100, pageRows: 1000, want: 1000},
+		{lo: 0, hi: 0, count: 0, pageRows: 10, want: 1},
+		{lo: -50, hi: 49, count: 10, pageRows: 1, want: 10},
+		{lo: math.MinInt64, hi: math.MaxInt64, count: 4, pageRows: 1, want: 1 << 62},
+		{lo: math.MinInt64, hi: math.MaxInt64, count: 1, pageRows: 2, want: math.MaxUint64},
+	}
+	for _, tt := range tests {
+		if got := rangeWidth(tt.lo, tt.hi, tt.count, tt.pageRows); got != tt.want {
+			t.Errorf("rangeWidth(%d, %d, %d, %d) = %d, want %d", tt.lo, tt.hi, tt.count, tt.pageRows, got, tt.want)
+		}
+	}
+}
+
+// TestRangeReaderExtremeKeys reads keys at both ends of int64, and NULL
+// ones, through range pagination, each exactly once.
+func TestRangeReaderExtremeKeys(t *testing.T) {
+	ctx := context.Background()
+	opts := connOptions{SQLDriver: "sqlite3", URI: "file:" + filepath.Join(t.TempDir(), "keys.db")}
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cnxn.Close()
+	keys := []int64{math.MinInt64, math.MinInt64 + 1, -1, 0, 1, math.MaxInt64 - 1, math.MaxInt64}
+	if err := execUpdate(ctx, cnxn, "CREATE TABLE t (k INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if err := execUpdate(ctx, cnxn, fmt.Sprintf("INSERT INTO t VALUES (%d)", k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := execUpdate(ctx, cnxn, "INSERT INTO t VALUES (NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pageRows := range []int64{1, 2, 100} {
+		reader, err := openPaginated(ctx, cnxn, "SELECT k FROM t", nil, &pagination{Strategy: "range", Key: "k", PageRows: pageRows, Parallel: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		nulls := 0
+		for reader.Next() {
+			col := reader.Record().Column(0).(*array.Int64)
+			for i := 0; i < col.Len(); i++ {
+				if col.IsNull(i) {
+					nulls++
+					continue
+				}
+				got = append(got, col.Value(i))
+			}
+		}
+		err = reader.Err()
+		reader.Release()
+		if err != nil {
+			t.Fatalf("page rows %d: %v", pageRows, err)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if fmt.Sprint(got) != fmt.Sprint(keys) || nulls != 1 {
+			t.Errorf("page rows %d: read keys %v and %d NULLs, want %v and 1", pageRows, got, nulls, keys)
+		}
+	}
+}
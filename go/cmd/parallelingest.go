@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// parallelLoad shards the records of stream across opts.Parallel
+// connections to the database of cnxn, cnxn being the first, each loading
+// the records it takes with the load strategy of opts in a statement, and transaction, of
+// its own. Connections take the next record as they finish the last, so
+// faster ones take more. If one fails the others stop, but what they have
+// loaded stays.
+func parallelLoad(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader, opts importOptions) (int64, error) {
+	n := opts.Parallel
+	opts.Parallel = 0
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		rows     atomic.Int64
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	records := make(chan arrow.Record, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := cnxn
+			if i > 0 {
+				var err error
+				if conn, err = openConnection(ctx, cnxn.opts); err != nil {
+					fail(err)
+					return
+				}
+				defer conn.Close()
+			}
+			shard := newChanReader(stream.Schema(), records)
+			defer shard.Release()
+			loaded, err := loadStream(ctx, conn, table, shard, opts)
+			rows.Add(loaded)
+			if err != nil {
+				fail(fmt.Errorf("connection %d: %w", i+1, err))
+			}
+		}()
+	}
+	func() {
+		defer close(records)
+		for stream.Next() {
+			rec := stream.Record()
+			rec.Retain()
+			select {
+			case records <- rec:
+			case <-ctx.Done():
+				rec.Release()
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && !errors.Is(err, io.EOF) {
+			fail(err)
+		}
+	}()
+	wg.Wait()
+	// Release what a failed load left unread.
+	for rec := range records {
+		rec.Release()
+	}
+	if firstErr != nil {
+		return rows.Load(), firstErr
+	}
+	logger(ctx).Debug("Loaded in parallel", "connections", n, "rows", rows.Load())
+	return rows.Load(), nil
+}
+
+// chanReader is a record reader over the records received from a channel,
+// which it takes ownership of.
+type chanReader struct {
+	refCount int64
+	schema   *arrow.Schema
+	records  <-chan arrow.Record
+	cur      arrow.Record
+}
+
+func newChanReader(schema *arrow.Schema, records <-chan arrow.Record) *chanReader {
+	return &chanReader{refCount: 1, schema: schema, records: records}
+}
+
+func (r *chanReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *chanReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.cur != nil {
+			r.cur.Release()
+			r.cur = nil
+		}
+	}
+}
+
+func (r *chanReader) Schema() *arrow.Schema { return r.schema }
+func (r *chanReader) Record() arrow.Record  { return r.cur }
+func (r *chanReader) Err() error            { return nil }
+
+func (r *chanReader) Next() bool {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	rec, ok := <-r.records
+	r.cur = rec
+	return ok
+}
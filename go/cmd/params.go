@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/scalar"
+)
+
+// queryParam is a typed value for a :name placeholder in an export query.
+type queryParam struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// paramTypes maps the types accepted by -param to the Arrow types values are
+// bound as.
+var paramTypes = map[string]arrow.DataType{
+	"string":    arrow.BinaryTypes.String,
+	"int":       arrow.PrimitiveTypes.Int64,
+	"float":     arrow.PrimitiveTypes.Float64,
+	"bool":      arrow.FixedWidthTypes.Boolean,
+	"date":      arrow.FixedWidthTypes.Date32,
+	"timestamp": &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"},
+}
+
+// parseQueryParam parses name[:type]=value, where type defaults to string.
+func parseQueryParam(s string) (queryParam, error) {
+	lhs, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return queryParam{}, fmt.Errorf("invalid parameter %q, expected name[:type]=value", s)
+	}
+	name, typ, _ := strings.Cut(lhs, ":")
+	if typ == "" {
+		typ = "string"
+	}
+	if !isParamName(name) {
+		return queryParam{}, fmt.Errorf("invalid parameter name %q", name)
+	}
+	dt, ok := paramTypes[typ]
+	if !ok {
+		return queryParam{}, fmt.Errorf("parameter %s: unknown type %q (expected string, int, float, bool, date or timestamp)", name, typ)
+	}
+	if _, err := scalar.ParseScalar(dt, value); err != nil {
+		return queryParam{}, fmt.Errorf("parameter %s: invalid %s %q: %w", name, typ, value, err)
+	}
+	return queryParam{Name: name, Type: typ, Value: value}, nil
+}
+
+// parseQueryParams parses a list of name[:type]=value parameters. Later
+// values replace earlier ones of the same name.
+func parseQueryParams(list []string) ([]queryParam, error) {
+	var params []queryParam
+	index := make(map[string]int)
+	for _, s := range list {
+		p, err := parseQueryParam(s)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[p.Name]; ok {
+			params[i] = p
+			continue
+		}
+		index[p.Name] = len(params)
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+func (p queryParam) String() string {
+	return p.Name + ":" + p.Type + "=" + p.Value
+}
+
+func isParamName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// bindParams replaces the :name placeholders of params in query with the
+// positional placeholders of the database opts points at, and returns the
+// single-row record to bind, holding one column per placeholder in order.
+// Text inside quotes and comments is left alone, as are :name sequences
+// that match no parameter (such as Postgres ::casts). With no params the
+// query is returned unchanged and the record is nil.
+func bindParams(opts connOptions, query string, params []queryParam) (string, arrow.Record, error) {
+	if len(params) == 0 {
+		return query, nil, nil
+	}
+	byName := make(map[string]queryParam, len(params))
+	for _, p := range params {
+		byName[p.Name] = p
+	}
+
+	var b strings.Builder
+	var fields []arrow.Field
+	var cols []arrow.Array
+	defer func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}()
+	used := make(map[string]bool)
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated quoted string in query")
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated comment in query")
+			}
+			b.WriteString(query[i : i+end+4])
+			i += end + 3
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+		case c == ':' && strings.HasPrefix(query[i:], "::"):
+			b.WriteString("::")
+			i++
+		case c == ':':
+			j := i + 1
+			for j < len(query) && isParamName(query[i+1:j+1]) {
+				j++
+			}
+			p, ok := byName[query[i+1:j]]
+			if !ok {
+				b.WriteByte(c)
+				continue
+			}
+			arr, err := paramArray(p)
+			if err != nil {
+				return "", nil, err
+			}
+			cols = append(cols, arr)
+			fields = append(fields, arrow.Field{Name: fmt.Sprintf("%s_%d", p.Name, len(fields)+1), Type: arr.DataType(), Nullable: true})
+			b.WriteString(opts.placeholder(len(fields)))
+			used[p.Name] = true
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	for _, p := range params {
+		if !used[p.Name] {
+			return "", nil, fmt.Errorf("parameter %s is not used in the query (reference it as :%s)", p.Name, p.Name)
+		}
+	}
+	return b.String(), array.NewRecord(arrow.NewSchema(fields, nil), cols, 1), nil
+}
+
+func paramArray(p queryParam) (arrow.Array, error) {
+	sc, err := scalar.ParseScalar(paramTypes[p.Type], p.Value)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+	}
+	return scalar.MakeArrayFromScalar(sc, 1, allocator)
+}
+
+// placeholder returns the n-th (1-based) positional parameter placeholder
+// for the database.
+func (o connOptions) placeholder(n int) string {
+	if o.SQLDriver != "" {
+		return sqlPlaceholder(o.SQLDriver, n)
+	}
+	if o.dialect().name == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// copyStream loads stream into the Postgres table with COPY FROM STDIN in
+// the binary format, over a pgx connection of its own, encoding the values
+// of each Arrow batch straight into COPY frames. It skips the row binding
+// of ADBC bulk ingest, but as the connection is separate, the rows are not
+// part of a transaction on cnxn. A catalog in table must be the database
+// connected to, as Postgres cannot copy into another.
+func copyStream(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "copy", attribute.String("db.table", table.String()))
+	defer func() { endSpan(span, err) }()
+
+	uri, err := authURI(ctx, cnxn.opts)
+	if err == nil {
+		uri, err = tunnelURI(ctx, cnxn.opts, uri)
+	}
+	if err != nil {
+		return 0, classify(errConnection, err)
+	}
+	conn, err := pgx.Connect(ctx, uri)
+	if err != nil {
+		return 0, classify(errConnection, fmt.Errorf("failed to connect to copy: %w", err))
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if catalog := table.catalog(); catalog != "" && catalog != conn.Config().Database {
+		return 0, classify(errUsage, fmt.Errorf("cannot copy into %s: connected to database %s, not %s", table, conn.Config().Database, catalog))
+	}
+	name := pgx.Identifier{table.table()}
+	if schema := table.schema(); schema != "" {
+		name = pgx.Identifier{schema, table.table()}
+	}
+	columns := make([]string, stream.Schema().NumFields())
+	for i, f := range stream.Schema().Fields() {
+		columns[i] = f.Name
+	}
+	n, err := conn.CopyFrom(ctx, name, columns, &arrowCopySource{stream: stream})
+	if err != nil {
+		return 0, classify(errWrite, fmt.Errorf("failed to copy into %s: %w", table, err))
+	}
+	return n, nil
+}
+
+// arrowCopySource feeds the rows of a record reader to pgx's CopyFrom.
+type arrowCopySource struct {
+	stream array.RecordReader
+	rec    arrow.Record
+	row    int
+	values []any
+}
+
+func (s *arrowCopySource) Next() bool {
+	for s.rec == nil || s.row+1 >= int(s.rec.NumRows()) {
+		if !s.stream.Next() {
+			return false
+		}
+		s.rec, s.row = s.stream.Record(), -1
+	}
+	s.row++
+	return true
+}
+
+func (s *arrowCopySource) Values() ([]any, error) {
+	if s.values == nil {
+		s.values = make([]any, s.rec.NumCols())
+	}
+	for i := range s.values {
+		s.values[i] = pgValue(s.rec.Column(i), s.row)
+	}
+	return s.values, nil
+}
+
+func (s *arrowCopySource) Err() error {
+	if err := s.stream.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// pgValue returns row i of arr as pgx encodes it in the binary COPY format.
+// Where arrowValue has text, which binary COPY only takes into text columns,
+// it has the Go or pgtype value of the Postgres type: numerics for decimals,
+// times, intervals, byte slices, slices for lists (arrays or json) and
+// maps for structs and maps (json).
+func pgValue(arr arrow.Array, i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
+	switch a := arr.(type) {
+	case *array.Float16:
+		return a.Value(i).Float32()
+	case *array.Decimal128:
+		return pgtype.Numeric{Int: a.Value(i).BigInt(), Exp: -a.DataType().(*arrow.Decimal128Type).Scale, Valid: true}
+	case *array.Decimal256:
+		return pgtype.Numeric{Int: a.Value(i).BigInt(), Exp: -a.DataType().(*arrow.Decimal256Type).Scale, Valid: true}
+	case *array.Time32:
+		d := time.Duration(a.Value(i)) * a.DataType().(*arrow.Time32Type).Unit.Multiplier()
+		return pgtype.Time{Microseconds: d.Microseconds(), Valid: true}
+	case *array.Time64:
+		d := time.Duration(a.Value(i)) * a.DataType().(*arrow.Time64Type).Unit.Multiplier()
+		return pgtype.Time{Microseconds: d.Microseconds(), Valid: true}
+	case *array.Duration:
+		d := time.Duration(a.Value(i)) * a.DataType().(*arrow.DurationType).Unit.Multiplier()
+		return pgtype.Interval{Microseconds: d.Microseconds(), Valid: true}
+	case *array.MonthInterval:
+		return pgtype.Interval{Months: int32(a.Value(i)), Valid: true}
+	case *array.DayTimeInterval:
+		v := a.Value(i)
+		return pgtype.Interval{Days: v.Days, Microseconds: int64(v.Milliseconds) * 1000, Valid: true}
+	case *array.MonthDayNanoInterval:
+		v := a.Value(i)
+		return pgtype.Interval{Months: v.Months, Days: v.Days, Microseconds: v.Nanoseconds / 1000, Valid: true}
+	case *array.LargeBinary:
+		return a.Value(i)
+	case *array.FixedSizeBinary:
+		return a.Value(i)
+	case *array.Dictionary:
+		return pgValue(a.Dictionary(), a.GetValueIndex(i))
+	case *array.Map:
+		start, end := a.ValueOffsets(i)
+		m := make(map[string]any, end-start)
+		for j := int(start); j < int(end); j++ {
+			m[fmt.Sprint(pgValue(a.Keys(), j))] = pgValue(a.Items(), j)
+		}
+		return m
+	case array.ListLike:
+		start, end := a.ValueOffsets(i)
+		values := make([]any, 0, end-start)
+		for j := int(start); j < int(end); j++ {
+			values = append(values, pgValue(a.ListValues(), j))
+		}
+		return values
+	case *array.Struct:
+		m := make(map[string]any, a.NumField())
+		for k, f := range a.DataType().(*arrow.StructType).Fields() {
+			m[f.Name] = pgValue(a.Field(k), i)
+		}
+		return m
+	}
+	return arrowValue(arr, i)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/decimal128"
+	"github.com/apache/arrow/go/v17/arrow/float16"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TestPgValueBinaryCopy checks that the values copyStream sends for each
+// Arrow type are encoded by pgx in the binary COPY format of the matching
+// Postgres type, and mean what the Arrow value did.
+func TestPgValueBinaryCopy(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	tests := []struct {
+		name  string
+		typ   arrow.DataType
+		build func(b array.Builder)
+		oid   uint32
+		// want is the copied value, as pgx writes it in text.
+		want string
+	}{
+		{"float16", arrow.FixedWidthTypes.Float16, func(b array.Builder) {
+			b.(*array.Float16Builder).Append(float16.New(1.5))
+		}, pgtype.Float4OID, "1.5"},
+		{"decimal", &arrow.Decimal128Type{Precision: 10, Scale: 2}, func(b array.Builder) {
+			b.(*array.Decimal128Builder).Append(decimal128.FromI64(-12345))
+		}, pgtype.NumericOID, "-123.45"},
+		{"time32", arrow.FixedWidthTypes.Time32ms, func(b array.Builder) {
+			b.(*array.Time32Builder).Append(arrow.Time32((12*3600+34*60+56)*1000 + 789))
+		}, pgtype.TimeOID, "12:34:56.789000"},
+		{"time64", arrow.FixedWidthTypes.Time64ns, func(b array.Builder) {
+			b.(*array.Time64Builder).Append(arrow.Time64(1_000_001_000))
+		}, pgtype.TimeOID, "00:00:01.000001"},
+		{"duration", arrow.FixedWidthTypes.Duration_s, func(b array.Builder) {
+			b.(*array.DurationBuilder).Append(arrow.Duration(90))
+		}, pgtype.IntervalOID, "00:01:30"},
+		{"month interval", arrow.FixedWidthTypes.MonthInterval, func(b array.Builder) {
+			b.(*array.MonthIntervalBuilder).Append(arrow.MonthInterval(14))
+		}, pgtype.IntervalOID, "14 mon"},
+		{"month day nano interval", arrow.FixedWidthTypes.MonthDayNanoInterval, func(b array.Builder) {
+			b.(*array.MonthDayNanoIntervalBuilder).Append(arrow.MonthDayNanoInterval{Months: 1, Days: 2, Nanoseconds: 3_000_000_000})
+		}, pgtype.IntervalOID, "1 mon 2 day 00:00:03"},
+		{"large binary", arrow.BinaryTypes.LargeBinary, func(b array.Builder) {
+			b.(*array.BinaryBuilder).Append([]byte{0xde, 0xad})
+		}, pgtype.ByteaOID, `\xdead`},
+		{"list", arrow.ListOf(arrow.PrimitiveTypes.Int64), func(b array.Builder) {
+			lb := b.(*array.ListBuilder)
+			lb.Append(true)
+			lb.ValueBuilder().(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+		}, pgtype.Int8ArrayOID, "{1,2,3}"},
+		{"list of decimals", arrow.ListOf(&arrow.Decimal128Type{Precision: 5, Scale: 1}), func(b array.Builder) {
+			lb := b.(*array.ListBuilder)
+			lb.Append(true)
+			lb.ValueBuilder().(*array.Decimal128Builder).Append(decimal128.FromI64(15))
+		}, pgtype.NumericArrayOID, "{1.5}"},
+		{"struct", arrow.StructOf(arrow.Field{Name: "a", Type: arrow.PrimitiveTypes.Int64}), func(b array.Builder) {
+			sb := b.(*array.StructBuilder)
+			sb.Append(true)
+			sb.FieldBuilder(0).(*array.Int64Builder).Append(1)
+		}, pgtype.JSONBOID, `{"a":1}`},
+		{"map", arrow.MapOf(arrow.BinaryTypes.String, arrow.PrimitiveTypes.Int64), func(b array.Builder) {
+			mb := b.(*array.MapBuilder)
+			mb.Append(true)
+			mb.KeyBuilder().(*array.StringBuilder).Append("k")
+			mb.ItemBuilder().(*array.Int64Builder).Append(2)
+		}, pgtype.JSONBOID, `{"k":2}`},
+	}
+	m := pgtype.NewMap()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := array.NewBuilder(mem, tt.typ)
+			defer b.Release()
+			tt.build(b)
+			arr := b.NewArray()
+			defer arr.Release()
+
+			buf, err := m.Encode(tt.oid, pgtype.BinaryFormatCode, pgValue(arr, 0), nil)
+			if err != nil {
+				t.Fatalf("binary encoding of %v: %v", pgValue(arr, 0), err)
+			}
+			typ, _ := m.TypeForOID(tt.oid)
+			decoded, err := typ.Codec.DecodeValue(m, tt.oid, pgtype.BinaryFormatCode, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := m.Encode(tt.oid, pgtype.TextFormatCode, decoded, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(text)); got != tt.want {
+				t.Errorf("copied %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkPostgresLoad compares loading rows with binary COPY to ADBC
+// bulk ingest, the bind load strategy, on the Postgres database at
+// $DBX_BENCH_POSTGRES_URI, connected to with the ADBC driver at
+// $DBX_ADBC_POSTGRES_DRIVER or the dbx default.
+func BenchmarkPostgresLoad(b *testing.B) {
+	uri := os.Getenv("DBX_BENCH_POSTGRES_URI")
+	if uri == "" {
+		b.Skip("DBX_BENCH_POSTGRES_URI is not set")
+	}
+	ctx := context.Background()
+	cnxn, err := openConnection(ctx, connOptions{URI: uri, Driver: os.Getenv("DBX_ADBC_POSTGRES_DRIVER")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cnxn.Close()
+	if err := execUpdate(ctx, cnxn, "DROP TABLE IF EXISTS dbx_bench_load"); err != nil {
+		b.Fatal(err)
+	}
+	if err := execUpdate(ctx, cnxn, "CREATE TABLE dbx_bench_load (id bigint, amount numeric(12, 2), at timestamptz, note text)"); err != nil {
+		b.Fatal(err)
+	}
+	defer execUpdate(context.Background(), cnxn, "DROP TABLE dbx_bench_load")
+
+	const rows = 100_000
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "amount", Type: &arrow.Decimal128Type{Precision: 12, Scale: 2}},
+		{Name: "at", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}},
+		{Name: "note", Type: arrow.BinaryTypes.String},
+	}, nil)
+	rb := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer rb.Release()
+	for i := 0; i < rows; i++ {
+		rb.Field(0).(*array.Int64Builder).Append(int64(i))
+		rb.Field(1).(*array.Decimal128Builder).Append(decimal128.FromI64(int64(i) * 101))
+		rb.Field(2).(*array.TimestampBuilder).Append(arrow.Timestamp(1_700_000_000_000_000 + int64(i)))
+		rb.Field(3).(*array.StringBuilder).Append("note " + strconv.Itoa(i))
+	}
+	rec := rb.NewRecord()
+	defer rec.Release()
+
+	table, err := parseTableIdent("dbx_bench_load")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, strategy := range []struct {
+		name string
+		load func(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader) (int64, error)
+	}{
+		{"copy", copyStream},
+		{"bind", ingestStream},
+	} {
+		b.Run(strategy.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := execUpdate(ctx, cnxn, "TRUNCATE dbx_bench_load"); err != nil {
+					b.Fatal(err)
+				}
+				stream, err := array.NewRecordReader(schema, []arrow.Record{rec})
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := strategy.load(ctx, cnxn, table, stream)
+				stream.Release()
+				if err != nil {
+					b.Fatal(err)
+				}
+				// Drivers that don't count ingested rows return -1.
+				if n >= 0 && n != rows {
+					b.Fatalf("loaded %d rows, want %d", n, rows)
+				}
+			}
+			b.ReportMetric(float64(rows)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
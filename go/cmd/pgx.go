@@ -0,0 +1,9 @@
+package main
+
+// The pgx database/sql driver is built in, so Postgres works without the
+// ADBC C driver, e.g. in minimal containers or on platforms without
+// prebuilt ADBC binaries: give -sql-driver pgx, or leave -driver unset and
+// dbX falls back to pgx when the ADBC driver cannot be loaded. Rows are
+// converted to Arrow in-process, which is slower than the ADBC driver's
+// COPY-based reads.
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pipelineFile is the YAML document read by `dbx run`. It describes one
+// export end to end so runs can be reproduced and reviewed in git:
+//
+//	vars:
+//	  region: eu
+//	source:
+//	  uri: postgresql://localhost:5432/shop
+//	  query: SELECT * FROM customers_{{ region }} WHERE signup_date < :before
+//	  params: ["before:date={{ ds }}"]
+//	transforms:
+//	  - filter: "country = 'NL'"
+//	  - mask: { columns: [email], method: hash }
+//	  - rename: { created_at: signup_ts }
+//	sink:
+//	  path: exports/customers-{{ ts }}
+//	  partition_by: [region]
+//	validation:
+//	  not_null: [id, email]
+//	  min_rows: 1
+type pipelineFile struct {
+	// Vars are custom macros for the query, params and sink path, in
+	// addition to built-in ones such as {{ ds }}.
+	Vars       map[string]string   `yaml:"vars"`
+	Source     pipelineSource      `yaml:"source"`
+	Transforms []pipelineTransform `yaml:"transforms"`
+	Sink       pipelineSink        `yaml:"sink"`
+	Validation pipelineValidation  `yaml:"validation"`
+	// Delivery sends the output by email or to Slack when the pipeline
+	// finishes.
+	Delivery *pipelineDelivery `yaml:"delivery"`
+
+	// file is the file the pipeline was read from, whose git version is
+	// recorded in the lineage of its outputs.
+	file string
+}
+
+// pipelineSource overrides the global connection flags for the fields it
+// sets.
+type pipelineSource struct {
+	URI       string `yaml:"uri"`
+	Driver    string `yaml:"driver"`
+	SQLDriver string `yaml:"sql_driver"`
+	// Auth is an -auth method for URI.
+	Auth string `yaml:"auth"`
+	// SSH and SSHKey reach URI through a jump host, like -ssh and
+	// -ssh-key.
+	SSH    string `yaml:"ssh"`
+	SSHKey string `yaml:"ssh_key"`
+	// Proxy routes the source's connections through a proxy, like
+	// -proxy.
+	Proxy string `yaml:"proxy"`
+	// Replicas and MaxReplicaLag route reads to replicas of URI, like
+	// -replica and -max-replica-lag.
+	Replicas      []string      `yaml:"replicas"`
+	MaxReplicaLag time.Duration `yaml:"max_replica_lag"`
+	// Session statements run on the source's connections after those
+	// of -session.
+	Session []string `yaml:"session"`
+	// Tags are attached to the source's queries after those of -tag.
+	Tags  map[string]string `yaml:"tags"`
+	Table string            `yaml:"table"`
+	Query string            `yaml:"query"`
+	// Params are name[:type]=value bindings for :name placeholders in
+	// Query. Values are templates like the sink path.
+	Params []string `yaml:"params"`
+	// Paginate, Key, PageRows and Parallel split the export into bounded
+	// queries, like -paginate, -key, -page-rows and -parallel.
+	Paginate string `yaml:"paginate"`
+	Key      string `yaml:"key"`
+	PageRows string `yaml:"page_rows"`
+	Parallel int    `yaml:"parallel"`
+	// MaxRowsPerSec and MaxMBPerSec limit the read rate, like
+	// -max-rows-per-sec and -max-mb-per-sec.
+	MaxRowsPerSec float64 `yaml:"max_rows_per_sec"`
+	MaxMBPerSec   float64 `yaml:"max_mb_per_sec"`
+	// StateFile and OnDrift compare the schema of each run to the
+	// previous run's, like -state-file and -on-drift.
+	StateFile string `yaml:"state_file"`
+	OnDrift   string `yaml:"on_drift"`
+}
+
+// pipelineTransform is one step of the transform list; exactly one field is
+// set.
+type pipelineTransform struct {
+	Filter string            `yaml:"filter"`
+	Rename map[string]string `yaml:"rename"`
+	Mask   *struct {
+		Columns []string `yaml:"columns"`
+		Method  string   `yaml:"method"`
+	} `yaml:"mask"`
+}
+
+type pipelineSink struct {
+	Format       string   `yaml:"format"`
+	Path         string   `yaml:"path"`
+	Compression  string   `yaml:"compression"`
+	RowGroupSize int64    `yaml:"row_group_size"`
+	PartitionBy  []string `yaml:"partition_by"`
+	EmitSchema   string   `yaml:"emit_schema"`
+	EmitStats    string   `yaml:"emit_stats"`
+	// Append treats Path as a dataset directory each run adds its files
+	// to, like -append-to.
+	Append bool `yaml:"append"`
+	// SheetTab and SheetMaxRows are -sheet-tab and -sheet-max-rows, for
+	// the gsheet format, whose path is the spreadsheet's ID or URL.
+	SheetTab     string `yaml:"sheet_tab"`
+	SheetMaxRows int64  `yaml:"sheet_max_rows"`
+	// EmitValidation is where the outcome of the validation rules is
+	// written, in the shape of a Great Expectations validation result.
+	EmitValidation string `yaml:"emit_validation"`
+	// SchemaRegistry registers the schema of Avro output.
+	SchemaRegistry *schemaRegistry `yaml:"schema_registry"`
+	// Glue registers the output as a table of the AWS Glue Data Catalog.
+	Glue       *glueCatalog `yaml:"glue"`
+	Encryption *struct {
+		FooterKey       string            `yaml:"footer_key"`
+		ColumnKeys      map[string]string `yaml:"column_keys"`
+		PlaintextFooter bool              `yaml:"plaintext_footer"`
+	} `yaml:"encryption"`
+}
+
+type pipelineValidation struct {
+	NotNull []string `yaml:"not_null"`
+	MinRows int64    `yaml:"min_rows"`
+	MaxRows int64    `yaml:"max_rows"`
+}
+
+// runPipeline implements `dbx run [-force] [-explain] [-param name=value] <pipeline.yaml>`.
+func runPipeline(cfg config, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	force := fs.Bool("force", false, "Run even if the sink's manifest shows it is already up to date")
+	var explain explainMode
+	fs.Var(&explain, "explain", "Print the engine's plan for the source query instead of running; -explain=before prints it and then runs")
+	var params stringList
+	fs.Var(&params, "param", "Bind a query placeholder as name[:type]=value, overriding the pipeline's params (repeatable); type is string, int, float, bool, date or timestamp")
+	var vars stringList
+	fs.Var(&vars, "var", "Set a template variable as name=value, overriding the pipeline's vars (repeatable)")
+	parseFlags(fs, args)
+	args = fs.Args()
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: dbx run [-force] [-explain] [-param name=value] [-var name=value] <pipeline.yaml>")
+	}
+	p, err := loadPipeline(args[0])
+	if err != nil {
+		return err
+	}
+	p.Source.Params = append(p.Source.Params, params...)
+	if p.Vars, err = parseVars(p.Vars, vars); err != nil {
+		return err
+	}
+	return p.run(cfg, args[0], time.Now(), *force, explain)
+}
+
+// run runs the pipeline, identified by name in logs, as of now.
+func (p *pipelineFile) run(cfg config, name string, now time.Time, force bool, explain explainMode) error {
+	opts := cfg.conn
+	opts.Job = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	if p.Source.URI != "" {
+		opts.URI = p.Source.URI
+	}
+	if p.Source.Driver != "" {
+		opts.Driver = p.Source.Driver
+	}
+	if p.Source.SQLDriver != "" {
+		opts.SQLDriver = p.Source.SQLDriver
+	}
+	if p.Source.Auth != "" {
+		opts.Auth = p.Source.Auth
+	}
+	if p.Source.SSH != "" {
+		opts.SSH, opts.SSHKey = p.Source.SSH, p.Source.SSHKey
+	}
+	if p.Source.Proxy != "" {
+		opts.Proxy = p.Source.Proxy
+	}
+	if len(p.Source.Replicas) > 0 {
+		opts.Replicas = p.Source.Replicas
+	}
+	if p.Source.MaxReplicaLag != 0 {
+		opts.MaxReplicaLag = p.Source.MaxReplicaLag
+	}
+	opts.Session = append(opts.Session, p.Source.Session...)
+	tags := make([]string, 0, len(p.Source.Tags))
+	for k, v := range p.Source.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	opts.Tags = append(opts.Tags, tags...)
+
+	spec, err := p.exportSpec(opts, now)
+	if err != nil {
+		return err
+	}
+	spec.Force = force
+	if err := p.Delivery.check(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if explain != "" {
+		if err := explainQuery(ctx, opts, spec.Query, spec.Params, os.Stdout); err != nil {
+			return err
+		}
+		if explain == "only" {
+			return nil
+		}
+	}
+
+	resp, err := exportQuery(withLogAttrs(ctx, "pipeline", name), opts, spec)
+	if err != nil {
+		return err
+	}
+	if cfg.catalog != "" {
+		cfg.conn = opts
+		if err := recordExport(cfg, spec.Query, resp); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Pipeline finished", "pipeline", name, "rows", resp.RowsWritten, "location", resp.Location, "duration", resp.Duration)
+	if p.Delivery != nil && !resp.Skipped {
+		if err := p.Delivery.deliver(ctx, opts.Job, resp); err != nil {
+			return err
+		}
+	}
+	if cfg.json {
+		printJSON(resp)
+	}
+	return nil
+}
+
+func loadPipeline(path string) (*pipelineFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline: %w", err)
+	}
+	var p pipelineFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline %s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	p.file = path
+	return &p, nil
+}
+
+// validate checks the fields of the pipeline that do not depend on the
+// time it runs at.
+func (p *pipelineFile) validate() error {
+	switch {
+	case (p.Source.Table == "") == (p.Source.Query == ""):
+		return fmt.Errorf("source: exactly one of table or query is required")
+	case validAuth(p.Source.Auth) != nil:
+		return fmt.Errorf("source: %w", validAuth(p.Source.Auth))
+	case p.Sink.Path == "":
+		return fmt.Errorf("sink: path is required")
+	case p.Sink.Format == "gsheet" && p.Sink.Append:
+		return fmt.Errorf("sink: gsheet output cannot be appended to")
+	case p.Validation.MaxRows > 0 && p.Validation.MaxRows < p.Validation.MinRows:
+		return fmt.Errorf("validation: max_rows is less than min_rows")
+	case p.Source.OnDrift != "" && p.Source.StateFile == "":
+		return fmt.Errorf("source: on_drift requires state_file")
+	case p.Source.OnDrift != "" && validOnDrift(p.Source.OnDrift) != nil:
+		return fmt.Errorf("source: %w", validOnDrift(p.Source.OnDrift))
+	case p.Delivery.validate() != nil:
+		return fmt.Errorf("delivery: %w", p.Delivery.validate())
+	}
+	return nil
+}
+
+// exportSpec builds the export described by the pipeline for a run at now.
+func (p *pipelineFile) exportSpec(opts connOptions, now time.Time) (exportSpec, error) {
+	spec := exportSpec{Query: p.Source.Query, Table: p.Source.Table, PipelineVersion: pipelineVersion(p.file), EmitSchema: p.Sink.EmitSchema, EmitStats: p.Sink.EmitStats, EmitValidation: p.Sink.EmitValidation, Glue: p.Sink.Glue, Append: p.Sink.Append, sinkOptions: sinkOptions{
+		Format:       p.Sink.Format,
+		Compression:  p.Sink.Compression,
+		RowGroupSize: p.Sink.RowGroupSize,
+		PartitionBy:  p.Sink.PartitionBy,
+		Registry:     p.Sink.SchemaRegistry,
+		SheetTab:     p.Sink.SheetTab,
+		SheetMaxRows: p.Sink.SheetMaxRows,
+	}}
+	if e := p.Sink.Encryption; e != nil {
+		spec.Encryption = &encryptionOptions{FooterKey: e.FooterKey, ColumnKeys: e.ColumnKeys, PlaintextFooter: e.PlaintextFooter}
+	}
+	if err := spec.validate(); err != nil {
+		return spec, fmt.Errorf("sink: %w", err)
+	}
+	if err := spec.Glue.validate(spec.Format); err != nil {
+		return spec, fmt.Errorf("sink: %w", err)
+	}
+	if p.Source.Table != "" {
+		query, err := tableQuery(opts, p.Source.Table)
+		if err != nil {
+			return spec, fmt.Errorf("source: %w", err)
+		}
+		spec.Query = query
+	} else {
+		query, err := renderTemplate(p.Source.Query, now, p.Vars)
+		if err != nil {
+			return spec, fmt.Errorf("source: %w", err)
+		}
+		spec.Query = query
+	}
+
+	output, err := renderTemplate(p.Sink.Path, now, p.Vars)
+	if err != nil {
+		return spec, err
+	}
+	spec.Output = output
+	if spec.Format == "gsheet" {
+		spec.Output = sheetLocation(output)
+	}
+
+	rendered := make([]string, len(p.Source.Params))
+	for i, param := range p.Source.Params {
+		if rendered[i], err = renderTemplate(param, now, p.Vars); err != nil {
+			return spec, fmt.Errorf("source: %w", err)
+		}
+	}
+	if spec.Params, err = parseQueryParams(rendered); err != nil {
+		return spec, classify(errUsage, fmt.Errorf("source: %w", err))
+	}
+	if spec.Pagination, err = newPagination(p.Source.Paginate, p.Source.Key, p.Source.PageRows, p.Source.Parallel); err != nil {
+		return spec, classify(errUsage, fmt.Errorf("source: %w", err))
+	}
+	if spec.Throttle, err = newThrottle(p.Source.MaxRowsPerSec, p.Source.MaxMBPerSec); err != nil {
+		return spec, classify(errUsage, fmt.Errorf("source: %w", err))
+	}
+	if p.Source.StateFile != "" {
+		spec.Drift = &driftPolicy{State: p.Source.StateFile, OnDrift: p.Source.OnDrift}
+		if spec.Drift.OnDrift == "" {
+			spec.Drift.OnDrift = "warn"
+		}
+	}
+
+	for i, t := range p.Transforms {
+		var steps []transform
+		if t.Filter != "" {
+			f, err := parseFilter(t.Filter)
+			if err != nil {
+				return spec, fmt.Errorf("transform %d: %w", i, err)
+			}
+			steps = append(steps, f)
+		}
+		if len(t.Rename) > 0 {
+			steps = append(steps, &renameTransform{names: t.Rename})
+		}
+		if t.Mask != nil {
+			m, err := newMaskTransform(t.Mask.Columns, t.Mask.Method)
+			if err != nil {
+				return spec, fmt.Errorf("transform %d: %w", i, err)
+			}
+			steps = append(steps, m)
+		}
+		if len(steps) != 1 {
+			return spec, fmt.Errorf("transform %d: exactly one of filter, rename or mask is required", i)
+		}
+		spec.Transforms = append(spec.Transforms, steps[0])
+	}
+
+	for _, col := range p.Validation.NotNull {
+		spec.Checks = append(spec.Checks, &notNullCheck{column: col})
+	}
+	if p.Validation.MinRows > 0 || p.Validation.MaxRows > 0 {
+		spec.Checks = append(spec.Checks, &rowCountCheck{min: p.Validation.MinRows, max: p.Validation.MaxRows})
+	}
+	return spec, nil
+}
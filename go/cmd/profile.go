@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+const (
+	// profileSampleSize is the number of values of each numeric column kept
+	// to draw its histogram.
+	profileSampleSize = 10000
+	// profileHistogramBins is the number of bins of each histogram.
+	profileHistogramBins = 20
+	// profileTopTracked is the number of candidate values tracked per
+	// column for its top values, of which profileTopValues are reported.
+	profileTopTracked = 100
+	profileTopValues  = 10
+)
+
+// histogramBin counts the values in [Low, High), or [Low, High] for the last
+// bin. Counts are scaled up from a sample of the column.
+type histogramBin struct {
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+	Count int64   `json:"count"`
+}
+
+// valueCount is one of the most frequent values of a column. Count may be
+// overestimated for columns with many distinct values.
+type valueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// columnDetail collects the parts of a column profile beyond `dbx stats` in
+// bounded memory: a reservoir sample for the histogram, a space-saving
+// counter for the top values, and which types every text value parses as.
+type columnDetail struct {
+	numeric bool
+	text    bool
+	seen    int64
+	sample  []float64
+	rng     *rand.Rand
+	top     map[string]*topCount
+	// parses maps a type name to whether every text value seen so far
+	// parses as it.
+	parses map[string]bool
+}
+
+func newColumnDetail(dt arrow.DataType) *columnDetail {
+	d := &columnDetail{top: make(map[string]*topCount), rng: rand.New(rand.NewSource(1))}
+	switch dt.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64,
+		arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+		arrow.FLOAT32, arrow.FLOAT64:
+		d.numeric = true
+	case arrow.STRING, arrow.LARGE_STRING:
+		d.text = true
+		d.parses = map[string]bool{"integer": true, "float": true, "boolean": true, "timestamp": true}
+	}
+	return d
+}
+
+// observe adds a non-null value of the column.
+func (d *columnDetail) observe(v any) {
+	d.seen++
+	if d.numeric {
+		var f float64
+		switch v := v.(type) {
+		case int64:
+			f = float64(v)
+		case uint64:
+			f = float64(v)
+		case float64:
+			f = v
+		}
+		if len(d.sample) < profileSampleSize {
+			d.sample = append(d.sample, f)
+		} else if i := d.rng.Int63n(d.seen); i < profileSampleSize {
+			d.sample[i] = f
+		}
+	}
+
+	s := asString(v)
+	if d.text {
+		for typ, ok := range d.parses {
+			if ok && !parsesAs(typ, s) {
+				d.parses[typ] = false
+			}
+		}
+	}
+	if t, ok := d.top[s]; ok {
+		t.count++
+		return
+	}
+	if len(d.top) < profileTopTracked {
+		d.top[s] = &topCount{count: 1}
+		return
+	}
+	// Space-saving: the new value replaces the least frequent one and
+	// inherits its count, which bounds its overestimate.
+	minKey, minCount := "", int64(math.MaxInt64)
+	for k, t := range d.top {
+		if t.count < minCount || (t.count == minCount && k < minKey) {
+			minKey, minCount = k, t.count
+		}
+	}
+	delete(d.top, minKey)
+	d.top[s] = &topCount{count: minCount + 1, overestimate: minCount}
+}
+
+// topCount is a space-saving counter entry.
+type topCount struct {
+	count        int64
+	overestimate int64
+}
+
+// finish fills the detailed fields of c.
+func (d *columnDetail) finish(c *columnStats) {
+	if d.numeric && len(d.sample) > 0 {
+		c.Histogram = histogram(d.sample, d.seen)
+	}
+
+	// Only values certainly seen more than once are worth showing.
+	for v, t := range d.top {
+		if t.count-t.overestimate > 1 {
+			c.TopValues = append(c.TopValues, valueCount{Value: v, Count: t.count})
+		}
+	}
+	sort.Slice(c.TopValues, func(i, j int) bool {
+		a, b := c.TopValues[i], c.TopValues[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Value < b.Value)
+	})
+	if len(c.TopValues) > profileTopValues {
+		c.TopValues = c.TopValues[:profileTopValues]
+	}
+
+	if d.text && d.seen > 0 {
+		for _, typ := range []string{"boolean", "integer", "float", "timestamp"} {
+			if d.parses[typ] {
+				c.InferredType = typ
+				break
+			}
+		}
+	}
+}
+
+// histogram bins sample into equal-width bins, scaling the counts up to the
+// seen values the sample was drawn from.
+func histogram(sample []float64, seen int64) []histogramBin {
+	lo, hi := sample[0], sample[0]
+	for _, v := range sample {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	n := profileHistogramBins
+	if lo == hi {
+		n = 1
+	}
+	width := (hi - lo) / float64(n)
+	bins := make([]histogramBin, n)
+	for i := range bins {
+		bins[i] = histogramBin{Low: lo + float64(i)*width, High: lo + float64(i+1)*width}
+	}
+	bins[n-1].High = hi
+	counts := make([]int64, n)
+	for _, v := range sample {
+		i := n - 1
+		if width > 0 {
+			i = min(int((v-lo)/width), n-1)
+		}
+		counts[i]++
+	}
+	scale := float64(seen) / float64(len(sample))
+	for i := range bins {
+		bins[i].Count = int64(math.Round(float64(counts[i]) * scale))
+	}
+	return bins
+}
+
+// parsesAs reports whether the text s is a value of typ.
+func parsesAs(typ, s string) bool {
+	s = strings.TrimSpace(s)
+	switch typ {
+	case "integer":
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	case "float":
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	case "boolean":
+		switch strings.ToLower(s) {
+		case "true", "false", "t", "f", "yes", "no":
+			return true
+		}
+		return false
+	case "timestamp":
+		for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+			if _, err := time.Parse(layout, s); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runProfile implements `dbx profile -table t -html report.html`, writing a
+// shareable data profile: the statistics of `dbx stats` plus histograms,
+// top values and text columns holding values of another type.
+func runProfile(cfg config, args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	path := fs.String("file", "", "Parquet file, glob or Hive-partitioned directory to profile, or an sftp:// file")
+	table := fs.String("table", "", "Table to profile")
+	htmlPath := fs.String("html", "", "Write the report as HTML to this file instead of printing it as JSON")
+	mismatch := fs.String("schema-mismatch", mismatchFail, "When the files of -file differ in schema: fail, or profile the union of their columns")
+	asOf := fs.String("as-of", "", "Profile the -file dataset as of this snapshot tag")
+	parseFlags(fs, args)
+
+	if (*path == "") == (*table == "") {
+		return classify(errUsage, fmt.Errorf("exactly one of -file or -table is required"))
+	}
+	if err := validSchemaMismatch(*mismatch); err != nil {
+		return classify(errUsage, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source := *path
+	if source == "" {
+		source = *table
+	}
+	reader, closeSource, err := openStatsSource(ctx, cfg, *path, *table, nil, *mismatch, *asOf)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	stats, err := profile(reader, true)
+	if err != nil {
+		return err
+	}
+	if *htmlPath == "" {
+		printJSON(stats)
+		return nil
+	}
+
+	f, err := os.Create(*htmlPath)
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	err = profileReport.Execute(f, map[string]any{
+		"Source":    source,
+		"CreatedAt": time.Now().UTC().Format(time.RFC3339),
+		"Stats":     stats,
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	slog.Info("Wrote profile", "source", source, "rows", stats.Rows, "location", *htmlPath)
+	return nil
+}
+
+var profileReport = template.Must(template.New("profile").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return strconv.FormatFloat(f*100, 'f', 1, 64) + "%" },
+	"barWidth": func(n int64, bins []histogramBin) float64 {
+		var peak int64
+		for _, b := range bins {
+			peak = max(peak, b.Count)
+		}
+		if peak == 0 {
+			return 0
+		}
+		return float64(n) / float64(peak) * 100
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Profile of {{ .Source }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.6em; text-align: left; }
+td.num { text-align: right; }
+.bar { background: #4a7fb5; height: 0.9em; }
+.issue { color: #a33; }
+section { margin-bottom: 2em; }
+</style>
+</head>
+<body>
+<h1>Profile of {{ .Source }}</h1>
+<p>{{ .Stats.Rows }} rows, {{ len .Stats.Columns }} columns, generated {{ .CreatedAt }}.</p>
+<table>
+<tr><th>Column</th><th>Type</th><th>Nulls</th><th>Distinct (est.)</th><th>Min</th><th>Max</th></tr>
+{{- range .Stats.Columns }}
+<tr><td><a href="#col-{{ .Name }}">{{ .Name }}</a></td><td>{{ .Type }}</td><td class="num">{{ percent .NullRatio }}</td><td class="num">{{ .Distinct }}</td><td>{{ .Min }}</td><td>{{ .Max }}</td></tr>
+{{- end }}
+</table>
+{{- range .Stats.Columns }}
+<section id="col-{{ .Name }}">
+<h2>{{ .Name }}</h2>
+{{- if .InferredType }}
+<p class="issue">Stored as {{ .Type }}, but every value parses as {{ .InferredType }}.</p>
+{{- end }}
+{{- if .Histogram }}
+{{- $bins := .Histogram }}
+<table>
+<tr><th>Range</th><th>Count</th><th></th></tr>
+{{- range .Histogram }}
+<tr><td>{{ printf "%.4g" .Low }} – {{ printf "%.4g" .High }}</td><td class="num">{{ .Count }}</td><td style="width: 20em"><div class="bar" style="width: {{ barWidth .Count $bins }}%"></div></td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- if .TopValues }}
+<table>
+<tr><th>Top value</th><th>Count</th></tr>
+{{- range .TopValues }}
+<tr><td>{{ .Value }}</td><td class="num">{{ .Count }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</section>
+{{- end }}
+</body>
+</html>
+`))
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc opens a connection to target, host:port.
+type dialFunc func(ctx context.Context, target string) (net.Conn, error)
+
+// parseProxy validates a -proxy URL: socks5:// (resolving host names
+// locally), socks5h:// (resolving them at the proxy), http:// or https://
+// (with CONNECT), with optional user:password.
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, use scheme://host:port", raw)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q, expected socks5, socks5h, http or https", u.Scheme)
+}
+
+// proxyDialer returns a dialFunc connecting through the proxy at raw.
+func proxyDialer(raw string) (dialFunc, error) {
+	u, err := parseProxy(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return func(ctx context.Context, target string) (net.Conn, error) {
+			return httpConnect(ctx, u, target)
+		}, nil
+	}
+	d, err := proxy.FromURL(u, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+	dial := d.(proxy.ContextDialer).DialContext
+	if u.Scheme == "socks5h" {
+		return func(ctx context.Context, target string) (net.Conn, error) {
+			return dial(ctx, "tcp", target)
+		}, nil
+	}
+	// x/net/proxy hands host names to the proxy for both schemes.
+	return func(ctx context.Context, target string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, "tcp", target)
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			var conn net.Conn
+			conn, err = dial(ctx, "tcp", net.JoinHostPort(addr.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}, nil
+}
+
+// useProxy routes dbX's own HTTP requests, such as for IAM tokens, through
+// the proxy at raw. Database connections are routed per connection; see
+// tunnelURI.
+func useProxy(raw string) error {
+	u, err := parseProxy(raw)
+	if err != nil {
+		return err
+	}
+	t := http.DefaultTransport.(*http.Transport)
+	if u.Scheme == "socks5" {
+		// net/http would have the proxy resolve host names.
+		dial, err := proxyDialer(raw)
+		if err != nil {
+			return err
+		}
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dial(ctx, addr)
+		}
+		return nil
+	}
+	t.Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// httpConnect opens a tunnel to target through the HTTP proxy at u.
+func httpConnect(ctx context.Context, u *url.URL, target string) (net.Conn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: target}, Host: target, Header: make(http.Header)}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", u.Host, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", u.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", u.Host, target, resp.Status)
+	}
+	// Servers such as MySQL speak first, so their greeting may already be
+	// buffered.
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// bufferedConn is a connection whose first bytes were read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
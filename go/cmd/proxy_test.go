@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// TestSOCKS5Resolution checks where the host names of connections through
+// a SOCKS5 proxy are resolved: by dbX for socks5://, by the proxy for
+// socks5h://.
+func TestSOCKS5Resolution(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// requested receives the address each connection asks the proxy for.
+	requested := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			requested <- socks5Request(conn)
+		}
+	}()
+
+	tests := []struct {
+		scheme string
+		// resolved is whether the proxy is asked for an IP address rather
+		// than the host name.
+		resolved bool
+	}{
+		{"socks5", true},
+		{"socks5h", false},
+	}
+	for _, tt := range tests {
+		dial, err := proxyDialer(tt.scheme + "://" + l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn, err := dial(context.Background(), "localhost:5432"); err == nil {
+			conn.Close()
+		}
+		host, port, err := net.SplitHostPort(<-requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved := net.ParseIP(host) != nil; resolved != tt.resolved || port != "5432" {
+			t.Errorf("%s: the proxy was asked for %s:%s, want resolved %v", tt.scheme, host, port, tt.resolved)
+		}
+	}
+}
+
+// socks5Request answers the SOCKS5 handshake on conn without
+// authentication, returning the address of its CONNECT request, and
+// closes it.
+func socks5Request(conn net.Conn) string {
+	defer conn.Close()
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return ""
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return ""
+	}
+	conn.Write([]byte{5, 0})
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return ""
+	}
+	var host string
+	switch buf[3] {
+	case 1, 4:
+		ip := make(net.IP, map[byte]int{1: 4, 4: 16}[buf[3]])
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return ""
+		}
+		host = ip.String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return ""
+		}
+		name := buf[1 : 1+buf[0]]
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return ""
+		}
+		host = string(name)
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return ""
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/scalar"
+	"github.com/apache/arrow/go/v17/parquet/file"
+	"github.com/apache/arrow/go/v17/parquet/metadata"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+	"github.com/apache/arrow/go/v17/parquet/schema"
+)
+
+// parseFilters parses -where conditions, each a filter like those of
+// pipelines, which all must hold.
+func parseFilters(exprs []string) ([]*filterTransform, error) {
+	filters := make([]*filterTransform, len(exprs))
+	for i, expr := range exprs {
+		f, err := parseFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = f
+	}
+	return filters, nil
+}
+
+// matchingRowGroups returns the row groups of pqFile that may hold rows
+// matching every filter, judged by the min, max and null count statistics
+// of their column chunks. Row groups of columns without statistics, or of
+// types whose statistics are not comparable to the filter's value, are
+// kept; the filters are still applied to the rows read.
+func matchingRowGroups(pqFile *file.Reader, manifest *pqarrow.SchemaManifest, filters []*filterTransform) ([]int, error) {
+	fields := make([]arrow.Field, len(manifest.Fields))
+	for i, f := range manifest.Fields {
+		fields[i] = *f.Field
+	}
+	schema := arrow.NewSchema(fields, nil)
+	for _, f := range filters {
+		if _, err := f.outputSchema(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	// An empty, rather than nil, list reads no row groups.
+	groups := make([]int, 0, pqFile.NumRowGroups())
+	md := pqFile.MetaData()
+	for rg := 0; rg < pqFile.NumRowGroups(); rg++ {
+		keep := true
+		for _, f := range filters {
+			field := manifest.Fields[f.index]
+			if !field.IsLeaf() {
+				continue
+			}
+			chunk, err := md.RowGroup(rg).ColumnChunk(field.ColIndex)
+			if err != nil {
+				return nil, err
+			}
+			if set, err := chunk.StatsSet(); err != nil || !set {
+				continue
+			}
+			stats, err := chunk.Statistics()
+			if err != nil || stats == nil {
+				continue
+			}
+			if !f.mayMatch(*field.Field, stats, md.RowGroup(rg).NumRows()) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			groups = append(groups, rg)
+		}
+	}
+	return groups, nil
+}
+
+// mayMatch reports whether a column chunk of field with stats, in a row
+// group of rows rows, may hold values matching the filter.
+func (t *filterTransform) mayMatch(field arrow.Field, stats metadata.TypedStatistics, rows int64) bool {
+	switch t.fn {
+	case "is_null":
+		return !stats.HasNullCount() || stats.NullCount() > 0
+	case "is_valid":
+		return !stats.HasNullCount() || stats.NullCount() < rows
+	}
+	if !stats.HasMinMax() {
+		return true
+	}
+	lo, hi, ok := statsRange(field, stats)
+	if !ok {
+		return true
+	}
+	v, ok := scalarValue(t.lit)
+	if !ok {
+		return true
+	}
+	switch t.fn {
+	case "equal":
+		return !cursorLess(v, lo) && !cursorLess(hi, v)
+	case "not_equal":
+		return cursorLess(lo, v) || cursorLess(v, hi)
+	case "less":
+		return cursorLess(lo, v)
+	case "less_equal":
+		return !cursorLess(v, lo)
+	case "greater":
+		return cursorLess(v, hi)
+	case "greater_equal":
+		return !cursorLess(hi, v)
+	}
+	return true
+}
+
+// statsRange returns the min and max of stats, a column chunk of field, as
+// values comparable with cursorLess, or false for types whose statistics
+// do not order like their Arrow values.
+func statsRange(field arrow.Field, stats metadata.TypedStatistics) (lo, hi any, ok bool) {
+	switch t := field.Type.(type) {
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Int64Type, *arrow.Date32Type,
+		*arrow.Float32Type, *arrow.Float64Type, *arrow.StringType, *arrow.LargeStringType:
+	case *arrow.TimestampType:
+		// Only timestamps stored in the unit they are read in.
+		lt, isTS := stats.Descr().LogicalType().(interface{ TimeUnit() schema.TimeUnitType })
+		if !isTS {
+			return nil, nil, false
+		}
+		units := map[schema.TimeUnitType]arrow.TimeUnit{schema.TimeUnitMillis: arrow.Millisecond, schema.TimeUnitMicros: arrow.Microsecond, schema.TimeUnitNanos: arrow.Nanosecond}
+		if unit, known := units[lt.TimeUnit()]; !known || unit != t.Unit {
+			return nil, nil, false
+		}
+	default:
+		return nil, nil, false
+	}
+	switch s := stats.(type) {
+	case *metadata.Int32Statistics:
+		return int64(s.Min()), int64(s.Max()), true
+	case *metadata.Int64Statistics:
+		return s.Min(), s.Max(), true
+	case *metadata.Float32Statistics:
+		return float64(s.Min()), float64(s.Max()), true
+	case *metadata.Float64Statistics:
+		return s.Min(), s.Max(), true
+	case *metadata.ByteArrayStatistics:
+		return string(s.Min()), string(s.Max()), true
+	}
+	return nil, nil, false
+}
+
+// scalarValue returns the value of a filter literal in the terms of
+// statsRange.
+func scalarValue(s scalar.Scalar) (any, bool) {
+	switch s := s.(type) {
+	case *scalar.Int8:
+		return int64(s.Value), true
+	case *scalar.Int16:
+		return int64(s.Value), true
+	case *scalar.Int32:
+		return int64(s.Value), true
+	case *scalar.Int64:
+		return s.Value, true
+	case *scalar.Date32:
+		return int64(s.Value), true
+	case *scalar.Timestamp:
+		return int64(s.Value), true
+	case *scalar.Float32:
+		return float64(s.Value), true
+	case *scalar.Float64:
+		return s.Value, true
+	case *scalar.String:
+		return string(s.Data()), true
+	case *scalar.LargeString:
+		return string(s.Data()), true
+	}
+	return nil, false
+}
+
+// filteredReader keeps the rows of a stream matching every filter, which
+// are bound to its schema.
+type filteredReader struct {
+	refCount int64
+	src      array.RecordReader
+	filters  []*filterTransform
+	ctx      context.Context
+	rec      arrow.Record
+	err      error
+}
+
+// newFilteredReader returns src restricted to the rows matching filters.
+// The returned reader takes a reference to src.
+func newFilteredReader(ctx context.Context, src array.RecordReader, filters []*filterTransform) (*filteredReader, error) {
+	for _, f := range filters {
+		if _, err := f.outputSchema(src.Schema()); err != nil {
+			return nil, err
+		}
+	}
+	src.Retain()
+	return &filteredReader{refCount: 1, src: src, filters: filters, ctx: ctx}, nil
+}
+
+func (r *filteredReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *filteredReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.rec != nil {
+			r.rec.Release()
+			r.rec = nil
+		}
+		r.src.Release()
+	}
+}
+
+func (r *filteredReader) Schema() *arrow.Schema { return r.src.Schema() }
+func (r *filteredReader) Record() arrow.Record  { return r.rec }
+
+func (r *filteredReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.src.Err()
+}
+
+func (r *filteredReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	for r.err == nil && r.src.Next() {
+		rec := r.src.Record()
+		rec.Retain()
+		for _, f := range r.filters {
+			out, err := f.apply(r.ctx, rec)
+			rec.Release()
+			if err != nil {
+				r.err = err
+				return false
+			}
+			rec = out
+		}
+		if rec.NumRows() > 0 {
+			r.rec = rec
+			return true
+		}
+		rec.Release()
+	}
+	return false
+}
+
+// filterSQL are the SQL operators of filter functions.
+var filterSQL = map[string]string{
+	"equal":         "=",
+	"not_equal":     "<>",
+	"less":          "<",
+	"less_equal":    "<=",
+	"greater":       ">",
+	"greater_equal": ">=",
+	"is_null":       "IS NULL",
+	"is_valid":      "IS NOT NULL",
+}
+
+// sql renders the filter as a SQL condition, for engines that push it down
+// themselves.
+func (t *filterTransform) sql(opts connOptions) string {
+	cond := opts.quoteIdent(t.column) + " " + filterSQL[t.fn]
+	if t.fn != "is_null" && t.fn != "is_valid" {
+		cond += " " + quoteLiteral(t.value)
+	}
+	return cond
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// tableQuery returns the query that exports every row of a table.
+func tableQuery(opts connOptions, tableName string) (string, error) {
+	table, err := opts.quoteTable(tableName)
+	if err != nil {
+		return "", err
+	}
+	return "SELECT * FROM " + table, nil
+}
+
+// getTableSchema returns the Arrow schema of table.
+func getTableSchema(ctx context.Context, cnxn adbc.Connection, table tableIdent) (*arrow.Schema, error) {
+	var catalog, dbSchema *string
+	if c := table.catalog(); c != "" {
+		catalog = &c
+	}
+	if s := table.schema(); s != "" {
+		dbSchema = &s
+	}
+	return cnxn.GetTableSchema(ctx, catalog, dbSchema, table.table())
+}
+
+// executeQuery runs query on cnxn and returns its result stream. The
+// statement stays open until the returned reader is released.
+func executeQuery(ctx context.Context, cnxn *connection, query string) (array.RecordReader, error) {
+	return executeBound(ctx, cnxn, query, nil)
+}
+
+// execUpdate runs a statement that returns no rows on cnxn.
+func execUpdate(ctx context.Context, cnxn *connection, query string) error {
+	_, err := execUpdateRows(ctx, cnxn, query)
+	return err
+}
+
+// execUpdateRows is execUpdate returning the number of rows the statement
+// affected, -1 if the driver does not know.
+func execUpdateRows(ctx context.Context, cnxn *connection, query string) (int64, error) {
+	stmt, err := cnxn.NewStatement()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create statement: %w", err)
+	}
+	defer stmt.Close()
+
+	if err := stmt.SetSqlQuery(cnxn.opts.tagged(query)); err != nil {
+		return 0, fmt.Errorf("failed to set SQL query: %w", err)
+	}
+	n, err := stmt.ExecuteUpdate(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute %q: %w", query, err)
+	}
+	return n, nil
+}
+
+// executeBound is executeQuery with params, if not nil, bound to the
+// query's placeholders.
+func executeBound(ctx context.Context, cnxn *connection, query string, params arrow.Record) (array.RecordReader, error) {
+	stmt, err := newQueryStatement(ctx, cnxn, query)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := runStatement(ctx, cnxn, stmt, query, params)
+	if err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	return &stmtReader{RecordReader: reader, stmt: stmt, refCount: 1}, nil
+}
+
+// newQueryStatement creates a statement for query on cnxn, set up with the
+// connection's read batch size. Without one, drivers that take a batch size
+// are asked for -write-batch-rows, so they hold no more rows at once than
+// are written.
+func newQueryStatement(ctx context.Context, cnxn *connection, query string) (adbc.Statement, error) {
+	stmt, err := cnxn.NewStatement()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create statement: %w", err)
+	}
+	if err := stmt.SetSqlQuery(cnxn.opts.tagged(query)); err != nil {
+		stmt.Close()
+		return nil, fmt.Errorf("failed to set SQL query: %w", err)
+	}
+	n := cnxn.opts.ReadBatchRows
+	if n == 0 {
+		n = cnxn.opts.WriteBatchRows
+	}
+	if n > 0 {
+		if key := readBatchOption(cnxn.opts); key == "" {
+			if cnxn.opts.ReadBatchRows > 0 {
+				logger(ctx).Warn("Driver has no batch size option, ignoring -read-batch-rows")
+			}
+		} else if err := stmt.SetOption(key, strconv.Itoa(n)); err != nil {
+			stmt.Close()
+			return nil, fmt.Errorf("failed to set read batch size: %w", err)
+		}
+	}
+	return stmt, nil
+}
+
+// runStatement executes stmt, whose SQL is query, with params bound if not
+// nil, and returns its result stream. Records larger than -write-batch-rows
+// are sliced, so writers and binders handle batches of a bounded size even
+// from drivers that return huge ones; the memory of such a batch is still
+// held until all of its slices are done with.
+func runStatement(ctx context.Context, cnxn *connection, stmt adbc.Statement, query string, params arrow.Record) (_ array.RecordReader, err error) {
+	ctx, span := startSpan(ctx, "query", attribute.String("db.statement", query))
+	defer func() { endSpan(span, err) }()
+
+	if params != nil {
+		if err := stmt.Bind(ctx, params); err != nil {
+			return nil, fmt.Errorf("failed to bind parameters: %w", err)
+		}
+	}
+
+	reader, _, err := stmt.ExecuteQuery(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	if n := cnxn.opts.WriteBatchRows; n > 0 {
+		sliced := newSliceReader(reader, int64(n))
+		reader.Release()
+		reader = sliced
+	}
+	return reader, nil
+}
+
+// querySchema returns the schema of query's result, without fetching any rows
+// when the driver supports it.
+func querySchema(ctx context.Context, cnxn adbc.Connection, query string) (*arrow.Schema, error) {
+	stmt, err := cnxn.NewStatement()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create statement: %w", err)
+	}
+	defer stmt.Close()
+
+	if err := stmt.SetSqlQuery(query); err != nil {
+		return nil, fmt.Errorf("failed to set SQL query: %w", err)
+	}
+	if es, ok := stmt.(adbc.StatementExecuteSchema); ok {
+		if schema, err := es.ExecuteSchema(ctx); err == nil {
+			return schema, nil
+		}
+	}
+
+	// Otherwise ask for none of its rows, so the database can plan the
+	// query without running it. Statements that cannot be wrapped, such as
+	// SHOW, are run as they are.
+	if err := stmt.SetSqlQuery(schemaProbe(query)); err != nil {
+		return nil, fmt.Errorf("failed to set SQL query: %w", err)
+	}
+	if reader, _, err := stmt.ExecuteQuery(ctx); err == nil {
+		defer reader.Release()
+		return reader.Schema(), nil
+	}
+	if err := stmt.SetSqlQuery(query); err != nil {
+		return nil, fmt.Errorf("failed to set SQL query: %w", err)
+	}
+	reader, _, err := stmt.ExecuteQuery(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer reader.Release()
+	return reader.Schema(), nil
+}
+
+// schemaProbe wraps query to return its columns and none of its rows.
+func schemaProbe(query string) string {
+	return "SELECT * FROM (" + strings.TrimRight(strings.TrimSpace(query), "; \t\n") + "\n) AS src WHERE 1=0"
+}
+
+// stmtReader closes the statement that produced a result stream once the
+// stream is released.
+type stmtReader struct {
+	array.RecordReader
+	stmt     adbc.Statement
+	refCount int64
+}
+
+func (r *stmtReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *stmtReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		r.RecordReader.Release()
+		r.stmt.Close()
+	}
+}
+
+// splitStatements splits text into the SQL statements separated by its
+// semicolons, leaving those inside quotes, dollar-quoted strings and
+// comments alone, and drops empty ones.
+func splitStatements(text string) []string {
+	stmts, rest := scanStatements(text)
+	if !onlyComments(rest) {
+		stmts = append(stmts, strings.TrimSpace(rest))
+	}
+	return stmts
+}
+
+// scanStatements returns the statements of text ended by semicolons, and
+// the rest of text after the last of them, which is unfinished if it holds
+// more than comments.
+func scanStatements(text string) (stmts []string, rest string) {
+	start := 0
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return stmts, text[start:]
+			}
+			i += end + 2
+		case strings.HasPrefix(text[i:], "--"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				return stmts, text[start:]
+			}
+			i += end + 1
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return stmts, text[start:]
+			}
+			i += end + 4
+		case c == '$' && dollarTag(text[i:]) != "":
+			// Postgres function bodies and the like: $$...$$ or $tag$...$tag$.
+			tag := dollarTag(text[i:])
+			end := strings.Index(text[i+len(tag):], tag)
+			if end < 0 {
+				return stmts, text[start:]
+			}
+			i += 2*len(tag) + end
+		case c == ';':
+			if stmt := text[start:i]; !onlyComments(stmt) {
+				stmts = append(stmts, strings.TrimSpace(stmt))
+			}
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	return stmts, text[start:]
+}
+
+// dollarTag returns the dollar quote opening s, such as $$ or $body$, or ""
+// if s does not start with one. Placeholders like $1 are not quotes.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// onlyComments reports whether s holds nothing but whitespace and
+// comments.
+func onlyComments(s string) bool {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+			return true
+		case strings.HasPrefix(s, "--"):
+			_, s, _ = strings.Cut(s, "\n")
+		case strings.HasPrefix(s, "/*"):
+			var ok bool
+			if _, s, ok = strings.Cut(s[2:], "*/"); !ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// statementKeyword returns the first word of stmt in lower case, past any
+// comments and opening parentheses, such as "select" or "create".
+func statementKeyword(stmt string) string {
+	fields := strings.Fields(strings.TrimLeft(trimLeadingComments(stmt), "( \t\r\n"))
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimRight(fields[0], "(;"))
+}
+
+// trimLeadingComments returns stmt without the whitespace and comments it
+// starts with.
+func trimLeadingComments(stmt string) string {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case strings.HasPrefix(stmt, "--"):
+			_, stmt, _ = strings.Cut(stmt, "\n")
+		case strings.HasPrefix(stmt, "/*"):
+			_, stmt, _ = strings.Cut(stmt[2:], "*/")
+		default:
+			return stmt
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestQuerySchemaMatchesResult checks that the schema announced for a query,
+// as by GetFlightInfo, is the one its results are then streamed with.
+func TestQuerySchemaMatchesResult(t *testing.T) {
+	ctx := context.Background()
+	cnxn, err := openConnection(ctx, connOptions{SQLDriver: "sqlite3", URI: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cnxn.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE t (id INTEGER, v TEXT, f REAL)",
+		"INSERT INTO t VALUES (1, 'a', 1.5), (2, NULL, 2.5)",
+	} {
+		if err := execUpdate(ctx, cnxn, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, query := range []string{
+		"SELECT * FROM t",
+		// Expressions have no declared type in SQLite, so their types
+		// come from the values.
+		"SELECT count(*) AS n, max(f) AS m, id + 1 AS x FROM t",
+		"SELECT id FROM t -- trailing comment",
+		"SELECT id FROM t;",
+		"PRAGMA table_info(t)",
+	} {
+		schema, err := querySchema(ctx, cnxn, query)
+		if err != nil {
+			t.Fatalf("querySchema(%q): %v", query, err)
+		}
+		reader, err := executeQuery(ctx, cnxn, query)
+		if err != nil {
+			t.Fatalf("executeQuery(%q): %v", query, err)
+		}
+		got := reader.Schema()
+		reader.Release()
+		if !schema.Equal(got) {
+			t.Errorf("%q: announced schema\n%s\nbut results have\n%s", query, schema, got)
+		}
+	}
+}
+
+// TestWriteBatchRowsBoundsDriverBatches checks that -write-batch-rows is
+// asked of the driver, so that it returns batches of that size rather than
+// larger ones sliced afterwards.
+func TestWriteBatchRowsBoundsDriverBatches(t *testing.T) {
+	ctx := context.Background()
+	cnxn, err := openConnection(ctx, connOptions{SQLDriver: "sqlite3", URI: ":memory:", WriteBatchRows: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cnxn.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE t (id INTEGER)",
+		"INSERT INTO t VALUES (1), (2), (3), (4), (5)",
+	} {
+		if err := execUpdate(ctx, cnxn, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stmt, err := newQueryStatement(ctx, cnxn, "SELECT id FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	reader, _, err := stmt.ExecuteQuery(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+	var rows int64
+	for reader.Next() {
+		if n := reader.Record().NumRows(); n > 2 {
+			t.Errorf("driver returned a batch of %d rows, want at most 2", n)
+		}
+		rows += reader.Record().NumRows()
+	}
+	if err := reader.Err(); err != nil || rows != 5 {
+		t.Errorf("read %d rows (%v), want 5", rows, err)
+	}
+}
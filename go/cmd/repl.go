@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"golang.org/x/term"
+)
+
+// rowStatements are the leading keywords of statements run as queries in
+// `dbx sql`; others run as updates.
+var rowStatements = map[string]bool{
+	"select": true, "with": true, "values": true, "table": true, "from": true,
+	"show": true, "explain": true, "describe": true, "desc": true, "pragma": true,
+}
+
+const replHelp = `Statements end with ; and may span lines.
+  \d              list tables
+  \export FILE    save the last result to FILE: .parquet, .arrow or .csv
+  \q              quit
+  \?              this help`
+
+// repl is a `dbx sql` session.
+type repl struct {
+	cnxn *connection
+	in   lineReader
+	out  io.Writer
+	// maxRows caps the rows kept of each result, and pageRows those shown
+	// at a time.
+	maxRows, pageRows int
+
+	// last is the last result, kept for \export.
+	last *replResult
+}
+
+// replResult is a query result held in memory.
+type replResult struct {
+	schema *arrow.Schema
+	recs   []arrow.Record
+	rows   int64
+	// truncated is set when the query returned more than maxRows rows.
+	truncated bool
+}
+
+func (r *replResult) release() {
+	for _, rec := range r.recs {
+		rec.Release()
+	}
+}
+
+// lineReader reads the input of `dbx sql` line by line.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// terminalReader edits lines with history on a terminal. The terminal is
+// only raw while a line is read, so Ctrl-C interrupts a running statement.
+type terminalReader struct {
+	fd int
+	t  *term.Terminal
+}
+
+func (r *terminalReader) readLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(r.fd, state)
+	if w, h, err := term.GetSize(r.fd); err == nil {
+		r.t.SetSize(w, h)
+	}
+	r.t.SetPrompt(prompt)
+	line, err := r.t.ReadLine()
+	if errors.Is(err, term.ErrPasteIndicator) {
+		err = nil
+	}
+	return line, err
+}
+
+// scanReader reads piped input, without prompts.
+type scanReader struct {
+	s *bufio.Scanner
+}
+
+func (r scanReader) readLine(string) (string, error) {
+	if !r.s.Scan() {
+		if err := r.s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.s.Text(), nil
+}
+
+// runSQL implements `dbx sql`, an interactive SQL session on the
+// connection of the global flags.
+func runSQL(cfg config, args []string) error {
+	fs := flag.NewFlagSet("sql", flag.ExitOnError)
+	maxRows := fs.Int("max-rows", 10000, "Rows of each result kept for display and \\export; the rest are not fetched")
+	pageRows := fs.Int("page-rows", 0, "Rows per page of results on a terminal (default the terminal height)")
+	parseFlags(fs, args)
+
+	if *maxRows <= 0 {
+		return classify(errUsage, fmt.Errorf("-max-rows must be positive"))
+	}
+	cnxn, err := openConnection(context.Background(), cfg.conn)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	r := &repl{cnxn: cnxn, out: os.Stdout, maxRows: *maxRows, pageRows: *pageRows}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		r.in = &terminalReader{fd: fd, t: term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")}
+		if r.pageRows == 0 {
+			if _, h, err := term.GetSize(fd); err == nil {
+				r.pageRows = max(h-4, 1)
+			}
+		}
+		fmt.Fprintf(r.out, "Connected to %s. Type \\? for help.\n", redactURI(cfg.conn.URI))
+	} else {
+		r.in = scanReader{bufio.NewScanner(os.Stdin)}
+		r.pageRows = 0
+	}
+	defer func() {
+		if r.last != nil {
+			r.last.release()
+		}
+	}()
+	return r.loop()
+}
+
+func (r *repl) loop() error {
+	var buf strings.Builder
+	for {
+		prompt := "dbx> "
+		if buf.Len() > 0 {
+			prompt = "  -> "
+		}
+		line, err := r.in.readLine(prompt)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Like psql, backslash commands run even amid a statement.
+		if strings.HasPrefix(strings.TrimSpace(line), `\`) {
+			if quit := r.meta(strings.Fields(strings.TrimSpace(line))); quit {
+				return nil
+			}
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if !statementComplete(buf.String()) {
+			continue
+		}
+		for _, stmt := range splitStatements(buf.String()) {
+			if err := r.run(stmt); err != nil {
+				fmt.Fprintf(r.out, "ERROR: %v\n", err)
+			}
+		}
+		buf.Reset()
+	}
+}
+
+// meta runs a backslash command and reports whether it quits.
+func (r *repl) meta(fields []string) bool {
+	switch fields[0] {
+	case `\q`:
+		return true
+	case `\?`, `\h`:
+		fmt.Fprintln(r.out, replHelp)
+	case `\d`:
+		tables, err := listTables(context.Background(), r.cnxn)
+		if err != nil {
+			fmt.Fprintf(r.out, "ERROR: %v\n", err)
+			break
+		}
+		for _, t := range tables {
+			fmt.Fprintln(r.out, t.String())
+		}
+	case `\export`:
+		if len(fields) != 2 {
+			fmt.Fprintln(r.out, `usage: \export FILE.parquet|FILE.arrow|FILE.csv`)
+			break
+		}
+		if err := r.export(fields[1]); err != nil {
+			fmt.Fprintf(r.out, "ERROR: %v\n", err)
+		}
+	default:
+		fmt.Fprintf(r.out, "unknown command %s, try \\?\n", fields[0])
+	}
+	return false
+}
+
+// run executes stmt, printing its result or how long it took.
+func (r *repl) run(stmt string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	start := time.Now()
+
+	if !rowStatements[statementKeyword(stmt)] {
+		if err := execUpdate(ctx, r.cnxn, stmt); err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "OK (%s)\n", formatDuration(time.Since(start)))
+		return nil
+	}
+
+	reader, err := executeQuery(ctx, r.cnxn, stmt)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+	res := &replResult{schema: reader.Schema()}
+	for reader.Next() {
+		rec := reader.Record()
+		if res.rows+rec.NumRows() > int64(r.maxRows) {
+			rec = rec.NewSlice(0, int64(r.maxRows)-res.rows)
+			res.truncated = true
+		} else {
+			rec.Retain()
+		}
+		res.recs = append(res.recs, rec)
+		res.rows += rec.NumRows()
+		if res.truncated {
+			break
+		}
+	}
+	if err := reader.Err(); err != nil && !res.truncated {
+		res.release()
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+	elapsed := time.Since(start)
+	if r.last != nil {
+		r.last.release()
+	}
+	r.last = res
+
+	if err := r.print(res); err != nil {
+		return err
+	}
+	note := ""
+	if res.truncated {
+		note = fmt.Sprintf(", stopped at -max-rows %d", r.maxRows)
+	}
+	fmt.Fprintf(r.out, "(%d rows%s, %s)\n", res.rows, note, formatDuration(elapsed))
+	return nil
+}
+
+// print writes res as aligned tables of pageRows rows, asking before each
+// next page.
+func (r *repl) print(res *replResult) error {
+	reader, err := array.NewRecordReader(res.schema, res.recs)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+	rows, err := previewRows(reader, 0, int(res.rows))
+	if err != nil {
+		return err
+	}
+	page := r.pageRows
+	if page <= 0 {
+		page = len(rows)
+	}
+	for start := 0; start == 0 || start < len(rows); start += page {
+		if err := printRows(r.out, res.schema, rows[start:min(start+page, len(rows))]); err != nil {
+			return err
+		}
+		if start+page >= len(rows) {
+			break
+		}
+		answer, err := r.in.readLine(fmt.Sprintf("-- %d of %d rows, Enter for more, q to stop -- ", start+page, len(rows)))
+		if err != nil || strings.TrimSpace(answer) == "q" {
+			break
+		}
+	}
+	return nil
+}
+
+// export writes the last result to path, in the format of its extension.
+func (r *repl) export(path string) error {
+	if r.last == nil {
+		return fmt.Errorf("no result to export yet")
+	}
+	res := r.last
+	var size int64
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".parquet", ".arrow", ".csv":
+		s, err := newSink(context.Background(), path, res.schema, sinkOptions{Format: strings.TrimPrefix(ext, ".")})
+		if err != nil {
+			return err
+		}
+		for _, rec := range res.recs {
+			if err := s.write(rec); err != nil {
+				s.abort()
+				return err
+			}
+		}
+		if size, err = s.close(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported file type %q, use .parquet, .arrow or .csv", ext)
+	}
+	fmt.Fprintf(r.out, "Wrote %d rows to %s (%s)\n", res.rows, path, formatBytes(size))
+	return nil
+}
+
+// statementComplete reports whether text ends with a finished statement,
+// as a statement typed over several lines does once its semicolon is in.
+func statementComplete(text string) bool {
+	_, rest := scanStatements(text)
+	return onlyComments(rest)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// openReadConnection opens the connection an export reads its rows from:
+// the first of opts.Replicas that is reachable and at most
+// opts.MaxReplicaLag behind, or the primary at opts.URI if there is none.
+// Lag is only checked on engines with a replicaLag query; elsewhere a
+// reachable replica is used as is.
+func openReadConnection(ctx context.Context, opts connOptions) (*connection, error) {
+	for _, uri := range opts.Replicas {
+		ropts := opts
+		ropts.URI, ropts.Replicas = uri, nil
+		cnxn, err := openConnection(ctx, ropts)
+		if err != nil {
+			logger(ctx).Warn("Replica unavailable", "replica", redactURI(uri), "err", err)
+			continue
+		}
+		lagQuery := ropts.dialect().replicaLag
+		if lagQuery == "" || opts.MaxReplicaLag == 0 {
+			logger(ctx).Debug("Reading from replica", "replica", redactURI(uri))
+			return cnxn, nil
+		}
+		lag, err := replicaLag(ctx, cnxn, lagQuery)
+		if err != nil {
+			cnxn.Close()
+			logger(ctx).Warn("Failed to check replica lag", "replica", redactURI(uri), "err", err)
+			continue
+		}
+		if lag > opts.MaxReplicaLag {
+			cnxn.Close()
+			logger(ctx).Warn("Replica is lagging", "replica", redactURI(uri), "lag", lag, "max", opts.MaxReplicaLag)
+			continue
+		}
+		logger(ctx).Debug("Reading from replica", "replica", redactURI(uri), "lag", lag)
+		return cnxn, nil
+	}
+	if len(opts.Replicas) > 0 {
+		logger(ctx).Warn("No replica is usable, reading from the primary")
+	}
+	return openConnection(ctx, opts)
+}
+
+// replicaLag runs query, which returns how many seconds the database is
+// behind its primary, on cnxn.
+func replicaLag(ctx context.Context, cnxn *connection, query string) (time.Duration, error) {
+	reader, err := executeQuery(ctx, cnxn, query)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Release()
+	if !reader.Next() {
+		if err := reader.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("lag query returned no rows")
+	}
+	switch v := arrowValue(reader.Record().Column(0), 0).(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	default:
+		return 0, fmt.Errorf("lag query returned %T", v)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"runtime/metrics"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// stageTimings splits the duration of an export by where it was spent, to
+// tell a slow database from a slow writer. Query runs until the first batch
+// arrives; Fetch is the wait for every later batch; Write covers the sink,
+// including the file footer. Time spent in transforms and checks is in none
+// of them.
+type stageTimings struct {
+	Connect time.Duration `json:"connect"`
+	Query   time.Duration `json:"query"`
+	Fetch   time.Duration `json:"fetch"`
+	Write   time.Duration `json:"write"`
+	// Throttled is the time spent holding back to -max-rows-per-sec or
+	// -max-mb-per-sec.
+	Throttled time.Duration `json:"throttled,omitempty"`
+}
+
+// setThroughput derives the rate and compression fields of r from its
+// counts and duration.
+func (r *response) setThroughput() {
+	if secs := r.Duration.Seconds(); secs > 0 {
+		r.RowsPerSecond = float64(r.RowsWritten) / secs
+		r.BytesPerSecond = float64(r.BytesRead) / secs
+	}
+	if r.OutputFileSize > 0 {
+		r.CompressionRatio = float64(r.BytesRead) / float64(r.OutputFileSize)
+	}
+}
+
+// recordSize returns the bytes held by the buffers of rec.
+func recordSize(rec arrow.Record) int64 {
+	var n int64
+	for _, col := range rec.Columns() {
+		n += arrayDataSize(col.Data())
+	}
+	return n
+}
+
+func arrayDataSize(d arrow.ArrayData) int64 {
+	var n int64
+	for _, b := range d.Buffers() {
+		if b != nil {
+			n += int64(b.Len())
+		}
+	}
+	for _, c := range d.Children() {
+		n += arrayDataSize(c)
+	}
+	if d.DataType().ID() == arrow.DICTIONARY {
+		n += arrayDataSize(d.Dictionary())
+	}
+	return n
+}
+
+// heapSampler tracks the peak Go heap size across calls to sample, which
+// are cheap enough to make once per batch.
+type heapSampler struct {
+	peak    int64
+	samples []metrics.Sample
+}
+
+func (h *heapSampler) sample() {
+	if h.samples == nil {
+		h.samples = []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	}
+	metrics.Read(h.samples)
+	if v := h.samples[0].Value; v.Kind() == metrics.KindUint64 {
+		h.peak = max(h.peak, int64(v.Uint64()))
+	}
+}
+
+// formatBytes returns n as a human-readable size with binary units, such
+// as 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDuration rounds d to a precision that suits its magnitude, such as
+// 1.2s or 3m4s rather than 1.234567891s.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(10 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+// rewriteOptions select what `dbx rewrite` changes about a file besides its
+// encoding.
+type rewriteOptions struct {
+	// Columns keeps only these columns; Drop removes these. Both are
+	// applied when reading, so pruned columns are never decoded.
+	Columns []string
+	Drop    []string
+	// Sort orders the rows by these keys, each "column" or "column desc".
+	Sort []string
+	// Where keeps only the rows matching every filter, skipping row groups
+	// whose statistics rule them out.
+	Where []*filterTransform
+}
+
+// runRewrite implements `dbx rewrite [flags] <file.parquet>...`, rewriting
+// Parquet files with new sink options without a database in the loop. An
+// input may be a Hive-partitioned directory, whose partitions failing -where
+// are skipped unread.
+func runRewrite(cfg config, args []string) error {
+	fs := flag.NewFlagSet("rewrite", flag.ExitOnError)
+	output := fs.String("output", "", "Write to this path instead of replacing the input (requires a single input)")
+	format := fs.String("format", "parquet", "Output format: parquet or arrow (Arrow IPC file)")
+	compression := fs.String("compression", "", "Output compression: snappy, gzip, zstd, brotli or none (Parquet); zstd or lz4 (Arrow)")
+	rowGroupSize := fs.Int64("row-group-size", 0, "Maximum rows per Parquet row group (0 for the writer default)")
+	partitionBy := fs.String("partition-by", "", "Comma-separated columns to partition the output by, Hive-style (requires -output)")
+	columns := fs.String("columns", "", "Comma-separated columns to keep, dropping the rest")
+	drop := fs.String("drop", "", "Comma-separated columns to drop")
+	sortBy := fs.String("sort", "", "Comma-separated sort keys, each column or \"column desc\"; sorting holds the whole file in memory")
+	var where stringList
+	fs.Var(&where, "where", "Keep only rows matching \"column op value\" or \"column is [not] null\", skipping row groups whose statistics rule them out (repeatable, all must hold)")
+	parseFlags(fs, args)
+	inputs := fs.Args()
+
+	if len(inputs) == 0 {
+		return classify(errUsage, fmt.Errorf("usage: dbx rewrite [flags] <file.parquet or partitioned directory>..."))
+	}
+	so := sinkOptions{Format: *format, Compression: *compression, RowGroupSize: *rowGroupSize, PartitionBy: splitList(*partitionBy)}
+	if err := so.validate(); err != nil {
+		return classify(errUsage, err)
+	}
+	if *output != "" && len(inputs) > 1 {
+		return classify(errUsage, fmt.Errorf("-output requires a single input file"))
+	}
+	if len(so.PartitionBy) > 0 && *output == "" {
+		return classify(errUsage, fmt.Errorf("-partition-by requires -output"))
+	}
+	ro := rewriteOptions{Columns: splitList(*columns), Drop: splitList(*drop), Sort: splitList(*sortBy)}
+	if len(ro.Columns) > 0 && len(ro.Drop) > 0 {
+		return classify(errUsage, fmt.Errorf("-columns and -drop are mutually exclusive"))
+	}
+	filters, err := parseFilters(where)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	ro.Where = filters
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results []*response
+	for _, in := range inputs {
+		out := *output
+		if out == "" {
+			if info, err := os.Stat(in); err == nil && info.IsDir() {
+				return classify(errUsage, fmt.Errorf("rewriting the partitioned directory %s requires -output", in))
+			}
+			out = in
+		}
+		resp, err := rewriteFile(withLogAttrs(ctx, "file", in), in, out, so, ro)
+		if err != nil {
+			return err
+		}
+		slog.Info(resp.Message, "file", in, "rows", resp.RowsWritten, "bytes", resp.OutputFileSize, "location", resp.Location, "duration", resp.Duration)
+		results = append(results, resp)
+	}
+	if cfg.json {
+		printJSON(results)
+	}
+	return nil
+}
+
+// rewriteFile reads the Parquet file in and writes its rows to out with the
+// given sink options. out may be in itself: the sink only replaces it once
+// the new file is complete.
+func rewriteFile(ctx context.Context, in, out string, so sinkOptions, ro rewriteOptions) (_ *response, err error) {
+	startTime := time.Now()
+	reader, closeFile, err := openParquetInput(ctx, in, ro.Columns, ro.Drop, ro.Where, mismatchFail, "")
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile()
+	defer reader.Release()
+
+	var records array.RecordReader = reader
+	if len(ro.Sort) > 0 {
+		sorted, err := sortRecords(ctx, reader, ro.Sort)
+		if err != nil {
+			return nil, err
+		}
+		defer sorted.Release()
+		records = sorted
+	}
+
+	s, err := newSink(ctx, out, records.Schema(), so)
+	if err != nil {
+		return nil, err
+	}
+	// A partitioned sink's abort removes committed files too.
+	defer func() {
+		if err != nil {
+			s.abort()
+		}
+	}()
+
+	var rows int64
+	for records.Next() {
+		rec := records.Record()
+		if err := s.write(rec); err != nil {
+			return nil, err
+		}
+		rows += rec.NumRows()
+	}
+	// pqarrow's reader reports io.EOF once exhausted.
+	if err := records.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read %s: %w", in, err)
+	}
+	size, err := s.close()
+	if err != nil {
+		return nil, err
+	}
+	return &response{
+		RowsWritten:    rows,
+		Message:        "Parquet file rewritten",
+		Duration:       time.Since(startTime),
+		OutputFileSize: size,
+		Location:       out,
+		schema:         records.Schema(),
+	}, nil
+}
+
+// openParquetColumns returns a reader of the Parquet file at path that only
+// decodes the given columns, or all but drop, and the rows matching where.
+// The returned function closes the file once the reader is released.
+func openParquetColumns(ctx context.Context, path string, columns, drop []string, where []*filterTransform) (array.RecordReader, func(), error) {
+	pqFile, err := openParquetFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	pqReader, err := pqarrow.NewFileReader(pqFile, pqarrow.ArrowReadProperties{BatchSize: parquetBatchRows}, allocator)
+	if err != nil {
+		pqFile.Close()
+		return nil, nil, fmt.Errorf("failed to create Parquet file reader: %w", err)
+	}
+
+	var leaves []int
+	if len(columns) > 0 || len(drop) > 0 {
+		keep := make(map[string]bool)
+		for _, c := range columns {
+			keep[c] = true
+		}
+		dropped := make(map[string]bool)
+		for _, c := range drop {
+			dropped[c] = true
+		}
+		found := make(map[string]bool)
+		for _, f := range pqReader.Manifest.Fields {
+			name := f.Field.Name
+			found[name] = true
+			if (len(columns) > 0 && !keep[name]) || dropped[name] {
+				continue
+			}
+			leaves = append(leaves, leafColumns(f)...)
+		}
+		for _, f := range where {
+			if found[f.column] && ((len(columns) > 0 && !keep[f.column]) || dropped[f.column]) {
+				pqFile.Close()
+				return nil, nil, classify(errUsage, fmt.Errorf("-where column %q must be kept to filter on", f.column))
+			}
+		}
+		for _, c := range append(columns, drop...) {
+			if !found[c] {
+				pqFile.Close()
+				return nil, nil, classify(errUsage, fmt.Errorf("column %q not found in %s", c, path))
+			}
+		}
+		if len(leaves) == 0 {
+			pqFile.Close()
+			return nil, nil, classify(errUsage, fmt.Errorf("no columns of %s left to write", path))
+		}
+	}
+
+	var groups []int
+	if len(where) > 0 {
+		if groups, err = matchingRowGroups(pqFile, pqReader.Manifest, where); err != nil {
+			pqFile.Close()
+			return nil, nil, classify(errUsage, fmt.Errorf("%s: %w", path, err))
+		}
+		logger(ctx).Debug("Skipping row groups by statistics", "skipped", pqFile.NumRowGroups()-len(groups), "row_groups", pqFile.NumRowGroups())
+	}
+	reader, err := pqReader.GetRecordReader(ctx, leaves, groups)
+	if err != nil {
+		pqFile.Close()
+		return nil, nil, fmt.Errorf("failed to read Parquet file: %w", err)
+	}
+	if len(where) == 0 {
+		return reader, func() { pqFile.Close() }, nil
+	}
+	filtered, err := newFilteredReader(ctx, reader, where)
+	reader.Release()
+	if err != nil {
+		pqFile.Close()
+		return nil, nil, classify(errUsage, err)
+	}
+	return filtered, func() { pqFile.Close() }, nil
+}
+
+// leafColumns returns the Parquet leaf column indices holding field.
+func leafColumns(field pqarrow.SchemaField) []int {
+	if field.IsLeaf() {
+		return []int{field.ColIndex}
+	}
+	var leaves []int
+	for _, child := range field.Children {
+		leaves = append(leaves, leafColumns(child)...)
+	}
+	return leaves
+}
+
+// sortRecords reads every record of reader and returns them as a single
+// record ordered by keys, each "column" or "column desc". Nulls sort last.
+func sortRecords(ctx context.Context, reader array.RecordReader, keys []string) (array.RecordReader, error) {
+	schema := reader.Schema()
+	type sortKey struct {
+		index int
+		desc  bool
+	}
+	sortKeys := make([]sortKey, len(keys))
+	for i, k := range keys {
+		fields := strings.Fields(k)
+		if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && !strings.EqualFold(fields[1], "asc") && !strings.EqualFold(fields[1], "desc")) {
+			return nil, classify(errUsage, fmt.Errorf("invalid sort key %q, expected column or \"column desc\"", k))
+		}
+		indices := schema.FieldIndices(fields[0])
+		if len(indices) == 0 {
+			return nil, classify(errUsage, fmt.Errorf("sort column %q not found", fields[0]))
+		}
+		sortKeys[i] = sortKey{index: indices[0], desc: len(fields) == 2 && strings.EqualFold(fields[1], "desc")}
+	}
+
+	var recs []arrow.Record
+	defer func() {
+		for _, rec := range recs {
+			rec.Release()
+		}
+	}()
+	for reader.Next() {
+		rec := reader.Record()
+		rec.Retain()
+		recs = append(recs, rec)
+	}
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read records to sort: %w", err)
+	}
+	all, err := concatRecords(schema, recs)
+	if err != nil {
+		return nil, err
+	}
+	defer all.Release()
+
+	n := int(all.NumRows())
+	values := make([][]any, len(sortKeys))
+	for k, key := range sortKeys {
+		col := all.Column(key.index)
+		values[k] = make([]any, n)
+		for i := range values[k] {
+			values[k][i] = arrowValue(col, i)
+		}
+	}
+	order := make([]int64, n)
+	for i := range order {
+		order[i] = int64(i)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		for k, key := range sortKeys {
+			a, b := values[k][order[i]], values[k][order[j]]
+			switch {
+			case a == nil && b == nil:
+				continue
+			case a == nil:
+				return false
+			case b == nil:
+				return true
+			}
+			if cursorLess(a, b) {
+				return !key.desc
+			}
+			if cursorLess(b, a) {
+				return key.desc
+			}
+		}
+		return false
+	})
+
+	bldr := array.NewInt64Builder(allocator)
+	defer bldr.Release()
+	bldr.AppendValues(order, nil)
+	indices := bldr.NewArray()
+	defer indices.Release()
+
+	out, err := compute.Take(ctx, *compute.DefaultTakeOptions(), compute.NewDatumWithoutOwning(all), compute.NewDatumWithoutOwning(indices))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sort records: %w", err)
+	}
+	sorted := out.(*compute.RecordDatum).Value
+	defer out.Release()
+	return array.NewRecordReader(schema, []arrow.Record{sorted})
+}
+
+// concatRecords returns recs, all of the given schema, as a single record.
+func concatRecords(schema *arrow.Schema, recs []arrow.Record) (arrow.Record, error) {
+	cols := make([]arrow.Array, schema.NumFields())
+	defer func() {
+		for _, col := range cols {
+			if col != nil {
+				col.Release()
+			}
+		}
+	}()
+	var rows int64
+	for _, rec := range recs {
+		rows += rec.NumRows()
+	}
+	for i := range cols {
+		chunks := make([]arrow.Array, len(recs))
+		for j, rec := range recs {
+			chunks[j] = rec.Column(i)
+		}
+		if len(chunks) == 0 {
+			cols[i] = array.MakeArrayOfNull(allocator, schema.Field(i).Type, 0)
+			continue
+		}
+		col, err := array.Concatenate(chunks, allocator)
+		if err != nil {
+			return nil, fmt.Errorf("failed to combine records: %w", err)
+		}
+		cols[i] = col
+	}
+	return array.NewRecord(schema, cols, rows), nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
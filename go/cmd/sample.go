@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/apache/arrow/go/v17/parquet"
+	"github.com/apache/arrow/go/v17/parquet/compress"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+// runSample implements `dbx sample -table t [-anonymize]`, writing the first
+// rows of a table together with its schema and CREATE TABLE statement to a
+// single zip bundle that can be attached to a bug report:
+//
+//	sample.parquet  the rows, with text scrambled by -anonymize
+//	schema.json     the Arrow schema, as written by `dbx schema`
+//	table.sql       the table's DDL, keys and defaults included
+func runSample(cfg config, args []string) error {
+	fs := flag.NewFlagSet("sample", flag.ExitOnError)
+	table := fs.String("table", "", "Table to sample")
+	n := fs.Int("n", 100, "Number of rows to include")
+	output := fs.String("output", "", "Path of the bundle (default <table>-sample.zip)")
+	anonymize := fs.Bool("anonymize", false, "Scramble the values of text columns, keeping their length and character classes")
+	var keep stringList
+	fs.Var(&keep, "keep", "With -anonymize, leave this column's values as they are, e.g. for status codes (repeatable)")
+	parseFlags(fs, args)
+
+	if *table == "" {
+		return classify(errUsage, fmt.Errorf("-table is required"))
+	}
+	if *n < 0 {
+		return classify(errUsage, fmt.Errorf("-n must not be negative"))
+	}
+	if len(keep) > 0 && !*anonymize {
+		return classify(errUsage, fmt.Errorf("-keep requires -anonymize"))
+	}
+	if *output == "" {
+		*output = *table + "-sample.zip"
+	}
+
+	var transforms []transform
+	if *anonymize {
+		t, err := newAnonymizeTransform(keep)
+		if err != nil {
+			return err
+		}
+		transforms = append(transforms, t)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rows, err := sampleTable(ctx, cfg.conn, *table, *n, transforms, *output)
+	if err != nil {
+		return err
+	}
+	slog.Info("Wrote sample", "table", *table, "rows", rows, "anonymized", *anonymize, "location", *output)
+	if cfg.json {
+		printJSON(&response{RowsWritten: rows, Message: "Sample written", Location: *output})
+	}
+	return nil
+}
+
+// sampleTable writes the bundle of runSample for the first n rows of
+// tableName to output.
+func sampleTable(ctx context.Context, opts connOptions, tableName string, n int, transforms []transform, output string) (int64, error) {
+	t, err := opts.table(tableName)
+	if err != nil {
+		return 0, err
+	}
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cnxn.Close()
+
+	schema, err := getTableSchema(ctx, cnxn, t)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema of %s: %w", t, err)
+	}
+	defs := make(map[string]tableDef)
+	if err := loadTableKeys(ctx, cnxn, defs); err != nil {
+		logger(ctx).Warn("Primary keys and unique constraints not included", "reason", err)
+	}
+	if err := loadColumnDefs(ctx, cnxn, defs); err != nil {
+		logger(ctx).Warn("Column defaults not included", "reason", err)
+	}
+	ddl, err := opts.dialect().createTableSQL(t, schema, nil, "", defs[t.String()])
+	if err != nil {
+		logger(ctx).Warn("Table DDL not included", "reason", err)
+	}
+
+	reader, err := executeQuery(ctx, cnxn, fmt.Sprintf("SELECT * FROM %s LIMIT %d", t.quote(opts.dialect()), n))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Release()
+	outSchema, err := transformSchema(transforms, reader.Schema())
+	if err != nil {
+		return 0, classify(errUsage, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	zw := zip.NewWriter(tmp)
+
+	w, err := zw.Create("sample.parquet")
+	if err != nil {
+		return 0, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	// The zip entry is compressed already.
+	props := parquet.NewWriterProperties(parquet.WithAllocator(allocator), parquet.WithCompression(compress.Codecs.Uncompressed))
+	pw, err := pqarrow.NewFileWriter(outSchema, w, props, pqarrow.NewArrowWriterProperties(pqarrow.WithAllocator(allocator)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create Parquet writer: %w", err)
+	}
+	var rows int64
+	for reader.Next() {
+		rec, err := applyTransforms(ctx, transforms, reader.Record())
+		if err != nil {
+			pw.Close()
+			return 0, err
+		}
+		err = pw.Write(rec)
+		rows += rec.NumRows()
+		rec.Release()
+		if err != nil {
+			pw.Close()
+			return 0, fmt.Errorf("failed to write sample: %w", err)
+		}
+	}
+	if err := reader.Err(); err != nil {
+		pw.Close()
+		return 0, fmt.Errorf("failed to read sample: %w", err)
+	}
+	if err := pw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write sample: %w", err)
+	}
+
+	schemaJSON, err := json.MarshalIndent(describeSchema(outSchema), "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode schema: %w", err)
+	}
+	writeEntry := func(name string, data []byte) error {
+		w, err := zw.Create(name)
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+		return nil
+	}
+	if err := writeEntry("schema.json", append(schemaJSON, '\n')); err != nil {
+		return 0, err
+	}
+	if ddl != "" {
+		if err := writeEntry("table.sql", []byte(ddl+";\n")); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), output); err != nil {
+		return 0, fmt.Errorf("failed to move bundle into place: %w", err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// scheduleFile is the YAML document read by `dbx schedule`:
+//
+//	jobs:
+//	  - name: orders
+//	    cron: "*/15 * * * *"
+//	    table: orders
+//	    output: exports/orders-{{ ts }}.parquet
+//	    cursor: updated_at
+//	    jitter: 30s
+type scheduleFile struct {
+	Jobs []scheduledJob `yaml:"jobs"`
+}
+
+// scheduledJob is a recurring export. When Cursor is set each run only
+// exports rows past the largest cursor value of the previous run.
+type scheduledJob struct {
+	Name   string   `yaml:"name"`
+	Cron   string   `yaml:"cron"`
+	Table  string   `yaml:"table"`
+	Query  string   `yaml:"query"`
+	Params []string `yaml:"params"`
+	// Vars are custom macros for Query, Params and Output.
+	Vars   map[string]string `yaml:"vars"`
+	Output string            `yaml:"output"`
+	Cursor string            `yaml:"cursor"`
+	Jitter time.Duration     `yaml:"jitter"`
+	// OnDrift, if set, compares the source schema of each run to the
+	// previous run's, kept in the state directory, like -on-drift.
+	OnDrift string `yaml:"on_drift"`
+	// AppendTo, instead of Output, is a dataset directory each run adds
+	// its files to, like -append-to.
+	AppendTo string `yaml:"append_to"`
+	// SnapshotTag, with AppendTo, tags the dataset after each run; it is a
+	// template like Output, such as v{{ ds }}, as tags are not reused.
+	SnapshotTag string `yaml:"snapshot_tag"`
+}
+
+// jobState is persisted between runs of a scheduled job.
+type jobState struct {
+	Cursor     string    `json:"cursor,omitempty"`
+	LastRun    time.Time `json:"last_run"`
+	LastOutput string    `json:"last_output"`
+	Rows       int64     `json:"rows"`
+}
+
+// runSchedule implements `dbx schedule`, running the jobs of a schedule file
+// until interrupted. A run that is still in progress when its job fires again
+// is not overlapped; the new run is skipped.
+func runSchedule(cfg config, args []string) error {
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to the YAML schedule file")
+	stateDir := fs.String("state-dir", dbxPath("state"), "Directory holding per-job state files")
+	notify := notifyFlags(fs)
+	parseFlags(fs, args)
+
+	if *configPath == "" {
+		return fmt.Errorf("-config is required")
+	}
+	jobs, err := loadSchedule(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*stateDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := cron.New(cron.WithChain(cron.SkipIfStillRunning(cronLogger{slog.Default()})))
+	for _, j := range jobs {
+		j := j
+		if _, err := c.AddFunc(j.Cron, func() {
+			start := time.Now()
+			resp, err := j.run(ctx, cfg, *stateDir)
+			if err != nil {
+				slog.Error("Job failed", "job", j.Name, "err", err)
+			}
+			notify.send(context.WithoutCancel(ctx), newJobNotification(j.Name, "export", start, resp, err))
+		}); err != nil {
+			return fmt.Errorf("job %s: invalid cron expression: %w", j.Name, err)
+		}
+	}
+
+	slog.Info("Scheduled jobs", "jobs", len(jobs), "config", *configPath)
+	c.Start()
+	<-ctx.Done()
+	<-c.Stop().Done()
+	return nil
+}
+
+func loadSchedule(path string) ([]scheduledJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %w", err)
+	}
+	var sf scheduleFile
+	if err := yaml.Unmarshal(data, &sf); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, j := range sf.Jobs {
+		switch {
+		case j.Name == "":
+			return nil, fmt.Errorf("job %d: name is required", i)
+		case seen[j.Name]:
+			return nil, fmt.Errorf("job %s: duplicate name", j.Name)
+		case j.Cron == "":
+			return nil, fmt.Errorf("job %s: cron is required", j.Name)
+		case (j.Table == "") == (j.Query == ""):
+			return nil, fmt.Errorf("job %s: exactly one of table or query is required", j.Name)
+		case (j.Output == "") == (j.AppendTo == ""):
+			return nil, fmt.Errorf("job %s: exactly one of output or append_to is required", j.Name)
+		case j.SnapshotTag != "" && j.AppendTo == "":
+			return nil, fmt.Errorf("job %s: snapshot_tag requires append_to", j.Name)
+		case j.OnDrift != "" && validOnDrift(j.OnDrift) != nil:
+			return nil, fmt.Errorf("job %s: %w", j.Name, validOnDrift(j.OnDrift))
+		}
+		if j.Table != "" {
+			if _, err := parseTableIdent(j.Table); err != nil {
+				return nil, fmt.Errorf("job %s: %w", j.Name, err)
+			}
+		}
+		seen[j.Name] = true
+	}
+	return sf.Jobs, nil
+}
+
+// run performs one scheduled export and updates the job's state file.
+func (j scheduledJob) run(ctx context.Context, cfg config, stateDir string) (*response, error) {
+	if j.Jitter > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(j.Jitter)))):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	ctx = withLogAttrs(ctx, "job", j.Name)
+	cfg.conn.Job = j.Name
+	statePath := filepath.Join(stateDir, j.Name+".json")
+	state, err := loadJobState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	query := j.Query
+	if j.Table != "" {
+		if query, err = tableQuery(cfg.conn, j.Table); err != nil {
+			return nil, err
+		}
+	}
+	if query, err = renderTemplate(query, now, j.Vars); err != nil {
+		return nil, err
+	}
+	if j.Cursor != "" {
+		query = incrementalQuery(cfg.conn, query, j.Cursor, state.Cursor)
+	}
+	output, err := renderTemplate(j.Output+j.AppendTo, now, j.Vars)
+	if err != nil {
+		return nil, err
+	}
+	rendered := make([]string, len(j.Params))
+	for i, p := range j.Params {
+		if rendered[i], err = renderTemplate(p, now, j.Vars); err != nil {
+			return nil, err
+		}
+	}
+	params, err := parseQueryParams(rendered)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(output)
+	if j.AppendTo != "" {
+		dir = output
+	}
+	// Directories on SFTP servers are created as files are uploaded.
+	if !isSFTP(output) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	tag, err := renderTemplate(j.SnapshotTag, now, j.Vars)
+	if err != nil {
+		return nil, err
+	}
+	spec := exportSpec{Query: query, Output: output, Cursor: j.Cursor, Params: params, Append: j.AppendTo != "", SnapshotTag: tag}
+	if j.OnDrift != "" {
+		spec.Drift = &driftPolicy{State: filepath.Join(stateDir, j.Name+".schema.json"), OnDrift: j.OnDrift}
+	}
+	resp, err := exportQuery(ctx, cfg.conn, spec)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.catalog != "" {
+		if err := recordExport(cfg, query, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	state.LastRun = now
+	state.LastOutput = output
+	state.Rows = resp.RowsWritten
+	if resp.Cursor != "" {
+		state.Cursor = resp.Cursor
+	}
+	if err := saveJobState(statePath, state); err != nil {
+		return nil, err
+	}
+
+	logger(ctx).Info("Job finished", "rows", resp.RowsWritten, "location", output, "duration", resp.Duration)
+	return resp, nil
+}
+
+func loadJobState(path string) (jobState, error) {
+	var state jobState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read job state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse job state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveJobState writes state through a temporary file so a crash never leaves
+// a truncated state file behind.
+func saveJobState(path string, state jobState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write job state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write job state: %w", err)
+	}
+	return nil
+}
+
+// renderTemplate expands macros in output paths, params and query text:
+// {{ ts }} is the run time as 20060102T150405Z, {{ ds }} and
+// {{ ds_nodash }} its date as 2006-01-02 and 20060102, and {{ yesterday }}
+// the date before it. Each of vars is a macro too, so a query can use
+// {{ region }} given region=eu.
+func renderTemplate(text string, now time.Time, vars map[string]string) (string, error) {
+	now = now.UTC()
+	funcs := template.FuncMap{
+		"ts":        func() string { return now.Format("20060102T150405Z") },
+		"ds":        func() string { return now.Format(time.DateOnly) },
+		"ds_nodash": func() string { return now.Format("20060102") },
+		"yesterday": func() string { return now.AddDate(0, 0, -1).Format(time.DateOnly) },
+	}
+	for name, value := range vars {
+		if _, ok := funcs[name]; ok {
+			return "", fmt.Errorf("variable %s shadows a built-in macro", name)
+		}
+		if !validVarName(name) {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+		funcs[name] = func() string { return value }
+	}
+	tmpl, err := template.New("").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %w", text, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", fmt.Errorf("failed to render %q: %w", text, err)
+	}
+	return b.String(), nil
+}
+
+// validVarName reports whether name can be used as a template macro: a
+// letter or underscore followed by letters, digits or underscores.
+func validVarName(name string) bool {
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// parseVars parses -var flags of the form name=value over base, returning a
+// new map.
+func parseVars(base map[string]string, flags []string) (map[string]string, error) {
+	vars := make(map[string]string, len(base)+len(flags))
+	for name, value := range base {
+		vars[name] = value
+	}
+	for _, f := range flags {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok || !validVarName(name) {
+			return nil, classify(errUsage, fmt.Errorf("invalid -var %q, expected name=value", f))
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// schemaField is the JSON form of an Arrow field, as written by -emit-schema
+// and `dbx schema`.
+type schemaField struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Nullable bool              `json:"nullable"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Children []schemaField     `json:"children,omitempty"`
+}
+
+type schemaDocument struct {
+	Fields   []schemaField     `json:"fields"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func describeSchema(schema *arrow.Schema) schemaDocument {
+	doc := schemaDocument{Metadata: metadataMap(schema.Metadata())}
+	for _, f := range schema.Fields() {
+		doc.Fields = append(doc.Fields, describeField(f))
+	}
+	return doc
+}
+
+func describeField(f arrow.Field) schemaField {
+	sf := schemaField{Name: f.Name, Type: f.Type.String(), Nullable: f.Nullable, Metadata: metadataMap(f.Metadata)}
+	if nested, ok := f.Type.(arrow.NestedType); ok {
+		for _, child := range nested.Fields() {
+			sf.Children = append(sf.Children, describeField(child))
+		}
+	}
+	return sf
+}
+
+func metadataMap(md arrow.Metadata) map[string]string {
+	if md.Len() == 0 {
+		return nil
+	}
+	m := make(map[string]string, md.Len())
+	for i, k := range md.Keys() {
+		m[k] = md.Values()[i]
+	}
+	return m
+}
+
+// writeSchemaFile writes the JSON description of schema to path.
+func writeSchemaFile(path string, schema *arrow.Schema) error {
+	data, err := json.MarshalIndent(describeSchema(schema), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write schema: %w", err)
+	}
+	return nil
+}
+
+// runSchema implements `dbx schema -table name | -query sql`, printing the
+// Arrow schema of the result without exporting any rows.
+func runSchema(cfg config, args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	table := fs.String("table", "", "Table to describe")
+	query := fs.String("query", "", "Query to describe")
+	output := fs.String("output", "", "Write the schema to this file instead of stdout")
+	parseFlags(fs, args)
+
+	q, err := requestQuery(cfg.conn, *table, *query)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	cnxn, err := openConnection(ctx, cfg.conn)
+	if err != nil {
+		return err
+	}
+	defer cnxn.Close()
+
+	schema, err := querySchema(ctx, cnxn, q)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return writeSchemaFile(*output, schema)
+	}
+	printJSON(describeSchema(schema))
+	return nil
+}
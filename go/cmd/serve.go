@@ -0,0 +1,22 @@
+package main
+
+import "fmt"
+
+// runServe implements `dbx serve <mode>`, running dbX as a long-lived server.
+func runServe(cfg config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: dbx serve flight|flightsql|grpc|http [flags]")
+	}
+	switch args[0] {
+	case "flight":
+		return serveFlight(cfg, args[1:])
+	case "flightsql":
+		return serveFlightSQL(cfg, args[1:])
+	case "grpc":
+		return serveGRPC(cfg, args[1:])
+	case "http":
+		return serveHTTP(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown server mode %q", args[0])
+	}
+}
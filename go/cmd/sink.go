@@ -0,0 +1,727 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/arrow/csv"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/parquet"
+	"github.com/apache/arrow/go/v17/parquet/compress"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+// sink receives the record batches of an export. Output only becomes
+// visible under its final name once close succeeds; until then it is staged
+// in temporary files, so readers never see a truncated dataset.
+type sink interface {
+	// write appends rec to the output. The sink does not keep rec.
+	write(rec arrow.Record) error
+	// close finishes the output and returns the number of bytes written.
+	close() (int64, error)
+	// abort discards everything written so far. It is a no-op after a
+	// successful close.
+	abort()
+	// files lists the files written, once closed.
+	files() []outputFile
+}
+
+// stagedSink is a sink whose close is split in two, for writing several
+// outputs at once: finish completes the output out of sight, and commit
+// moves it into place. rollback removes a committed output.
+type stagedSink interface {
+	sink
+	finish() (int64, error)
+	commit() error
+	rollback()
+}
+
+// outputFile is one file of an export's output.
+type outputFile struct {
+	Path  string `json:"path"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+	// SHA256 is the hex digest of the file, hashed as it was written.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// sinkOptions control the layout and encoding of export output.
+type sinkOptions struct {
+	// Format is "parquet" (the default), "arrow" for the Arrow IPC file
+	// format, "avro" for an Avro object container file, or "csv".
+	Format string
+	// Compression names the codec: snappy, gzip, zstd, brotli or none for
+	// Parquet; zstd, lz4 or none for Arrow IPC; deflate, snappy, zstd or
+	// none for Avro. Empty means uncompressed.
+	Compression string
+	// RowGroupSize caps the rows per Parquet row group; 0 keeps the writer
+	// default.
+	RowGroupSize int64
+	// PartitionBy turns the output into a directory of Hive-style partitions.
+	PartitionBy []string
+	// Encryption, if set, encrypts Parquet output with modular encryption.
+	Encryption *encryptionOptions
+	// Registry, if set, is where the schema of Avro output is registered.
+	Registry *schemaRegistry
+
+	// avroName names the Avro record, and schemaID and schemaSubject are
+	// the registered schema stamped into Avro output; newSink sets them.
+	avroName      string
+	schemaID      int
+	schemaSubject string
+	// SheetTab is the tab of the spreadsheet gsheet output replaces, the
+	// first if empty, and SheetMaxRows the most rows it may hold.
+	SheetTab     string
+	SheetMaxRows int64
+
+	// partName names the file of each partition, part-0 if empty.
+	partName string
+}
+
+// extension returns the file name extension of the output format.
+func (o sinkOptions) extension() string {
+	switch o.Format {
+	case "arrow":
+		return ".arrow"
+	case "csv":
+		return ".csv"
+	case "avro":
+		return ".avro"
+	}
+	return ".parquet"
+}
+
+func (o sinkOptions) validate() error {
+	codec := strings.ToLower(o.Compression)
+	switch o.Format {
+	case "", "parquet":
+		if _, ok := parquetCodecs[codec]; !ok && codec != "" {
+			return fmt.Errorf("unsupported Parquet compression %q", o.Compression)
+		}
+	case "arrow":
+		switch codec {
+		case "", "none", "uncompressed", "zstd", "lz4":
+		default:
+			return fmt.Errorf("unsupported Arrow IPC compression %q, use zstd or lz4", o.Compression)
+		}
+	case "csv":
+		if codec != "" && codec != "none" && codec != "uncompressed" {
+			return fmt.Errorf("CSV output is not compressed, got compression %q", o.Compression)
+		}
+	case "avro":
+		if _, ok := avroCodecs[codec]; !ok {
+			return fmt.Errorf("unsupported Avro compression %q, use deflate, snappy or zstd", o.Compression)
+		}
+	case "gsheet":
+		if codec != "" && codec != "none" && codec != "uncompressed" {
+			return fmt.Errorf("gsheet output is not compressed, got compression %q", o.Compression)
+		}
+		if len(o.PartitionBy) > 0 {
+			return fmt.Errorf("gsheet output cannot be partitioned")
+		}
+		if o.SheetMaxRows < 0 {
+			return fmt.Errorf("-sheet-max-rows must not be negative")
+		}
+	default:
+		return fmt.Errorf("unsupported output format %q", o.Format)
+	}
+	if o.Encryption != nil {
+		if o.Format != "" && o.Format != "parquet" {
+			return fmt.Errorf("encryption is only supported for Parquet output")
+		}
+		if err := o.Encryption.resolve(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var parquetCodecs = map[string]compress.Compression{
+	"none":         compress.Codecs.Uncompressed,
+	"uncompressed": compress.Codecs.Uncompressed,
+	"snappy":       compress.Codecs.Snappy,
+	"gzip":         compress.Codecs.Gzip,
+	"zstd":         compress.Codecs.Zstd,
+	"brotli":       compress.Codecs.Brotli,
+}
+
+// newSink returns a sink writing a single file to path, or, when
+// opts.PartitionBy is set, a Hive-partitioned directory rooted at path.
+func newSink(ctx context.Context, path string, schema *arrow.Schema, opts sinkOptions) (sink, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Format == "gsheet" {
+		return newSheetSink(ctx, path, schema, opts), nil
+	}
+	if opts.Format == "avro" {
+		// Every file of a partitioned output holds the same record.
+		opts.avroName = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if len(opts.PartitionBy) > 0 {
+		return newPartitionedSink(ctx, path, schema, opts)
+	}
+	if err := opts.registerSchema(ctx, schema); err != nil {
+		return nil, err
+	}
+	return newFileSink(path, schema, opts)
+}
+
+// registerSchema registers the Avro schema of files of schema with
+// o.Registry, for Avro output to a registry, and keeps its ID.
+func (o *sinkOptions) registerSchema(ctx context.Context, schema *arrow.Schema) error {
+	if o.Format != "avro" || o.Registry == nil {
+		return nil
+	}
+	s, err := avroSchema(schema, o.avroName)
+	if err != nil {
+		return err
+	}
+	o.schemaSubject = o.Registry.Subject
+	if o.schemaSubject == "" {
+		o.schemaSubject = o.avroName + "-value"
+	}
+	if o.schemaID, err = o.Registry.register(ctx, o.schemaSubject, s); err != nil {
+		return err
+	}
+	logger(ctx).Debug("Registered Avro schema", "subject", o.schemaSubject, "id", o.schemaID)
+	return nil
+}
+
+// recordWriter is the part of the Parquet and Arrow IPC file writers a
+// fileSink uses.
+type recordWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+// bufferedParquetWriter fills each row group up to the writer's maximum
+// row group length across records, rather than starting one per record,
+// so row groups keep the size asked for however records are sliced.
+type bufferedParquetWriter struct {
+	*pqarrow.FileWriter
+}
+
+func (b bufferedParquetWriter) Write(rec arrow.Record) error {
+	return b.WriteBuffered(rec)
+}
+
+// csvWriter adapts the Arrow CSV writer, which writes a header row and
+// leaves nulls empty, to recordWriter.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(rec arrow.Record) (err error) {
+	// The CSV writer panics on column types it cannot write, such as lists.
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("cannot write CSV: %v", p)
+		}
+	}()
+	return c.w.Write(rec)
+}
+
+// Close flushes the writer; the file is closed by the sink.
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// checksummedFile hashes the bytes written to f. The Arrow IPC writer
+// only seeks to learn its position, which keeps the hash that of the file.
+type checksummedFile struct {
+	f   *os.File
+	sum hash.Hash
+}
+
+func (c *checksummedFile) Write(p []byte) (int, error) {
+	n, err := c.f.Write(p)
+	c.sum.Write(p[:n])
+	return n, err
+}
+
+func (c *checksummedFile) Seek(offset int64, whence int) (int64, error) {
+	return c.f.Seek(offset, whence)
+}
+
+// fileSink writes a single Parquet, Arrow IPC, Avro or CSV file. Data goes to a hidden
+// temporary file next to path, which is renamed over path on close. The
+// file is checksummed on its way to disk rather than read back.
+type fileSink struct {
+	path      string
+	tmp       *os.File
+	sum       hash.Hash
+	w         recordWriter
+	rows      int64
+	size      int64
+	finished  bool
+	committed bool
+}
+
+func newFileSink(path string, schema *arrow.Schema, opts sinkOptions) (*fileSink, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	dst := &checksummedFile{f: tmp, sum: sha256.New()}
+	var w recordWriter
+	switch opts.Format {
+	case "csv":
+		w = &csvWriter{w: csv.NewWriter(dst, schema, csv.WithHeader(true), csv.WithNullWriter(""))}
+	case "avro":
+		w, err = newAvroWriter(dst, schema, opts.avroName, opts)
+		if err != nil {
+			err = fmt.Errorf("failed to create Avro writer: %w", err)
+		}
+	case "arrow":
+		ipcOpts := []ipc.Option{ipc.WithSchema(schema), ipc.WithAllocator(allocator)}
+		switch strings.ToLower(opts.Compression) {
+		case "zstd":
+			ipcOpts = append(ipcOpts, ipc.WithZstd())
+		case "lz4":
+			ipcOpts = append(ipcOpts, ipc.WithLZ4())
+		}
+		w, err = ipc.NewFileWriter(dst, ipcOpts...)
+		if err != nil {
+			err = fmt.Errorf("failed to create Arrow IPC writer: %w", err)
+		}
+	default:
+		props := []parquet.WriterProperty{parquet.WithAllocator(allocator)}
+		if opts.Compression != "" {
+			props = append(props, parquet.WithCompression(parquetCodecs[strings.ToLower(opts.Compression)]))
+		}
+		if opts.RowGroupSize > 0 {
+			props = append(props, parquet.WithMaxRowGroupLength(opts.RowGroupSize))
+		}
+		if opts.Encryption != nil {
+			props = append(props, opts.Encryption.writerProperty())
+		}
+		var pw *pqarrow.FileWriter
+		pw, err = pqarrow.NewFileWriter(schema, dst, parquet.NewWriterProperties(props...), pqarrow.NewArrowWriterProperties(pqarrow.WithAllocator(allocator)))
+		switch {
+		case err != nil:
+			err = fmt.Errorf("failed to create Parquet writer: %w", err)
+		case opts.RowGroupSize > 0:
+			w = bufferedParquetWriter{pw}
+		default:
+			w = pw
+		}
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &fileSink{path: path, tmp: tmp, sum: dst.sum, w: w}, nil
+}
+
+func (s *fileSink) write(rec arrow.Record) error {
+	if err := s.w.Write(rec); err != nil {
+		return fmt.Errorf("failed to write record to %s: %w", s.path, err)
+	}
+	s.rows += rec.NumRows()
+	return nil
+}
+
+func (s *fileSink) close() (int64, error) {
+	size, err := s.finish()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.commit(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// finish writes the file footer to the temporary file and returns its size.
+func (s *fileSink) finish() (int64, error) {
+	s.finished = true
+	err := s.w.Close()
+	if cerr := s.tmp.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to close %s: %w", s.path, err)
+	}
+	fileInfo, err := os.Stat(s.tmp.Name())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get output file info: %w", err)
+	}
+	s.size = fileInfo.Size()
+	return s.size, nil
+}
+
+// commit moves the finished temporary file to its final path.
+func (s *fileSink) commit() error {
+	if err := os.Rename(s.tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to move output into place: %w", err)
+	}
+	s.committed = true
+	return nil
+}
+
+func (s *fileSink) abort() {
+	if s.committed {
+		return
+	}
+	if !s.finished {
+		s.finished = true
+		s.w.Close()
+		s.tmp.Close()
+	}
+	os.Remove(s.tmp.Name())
+}
+
+// rollback removes the file moved into place by commit.
+func (s *fileSink) rollback() {
+	if s.committed {
+		os.Remove(s.path)
+		s.committed = false
+	}
+}
+
+func (s *fileSink) files() []outputFile {
+	return []outputFile{{Path: s.path, Rows: s.rows, Bytes: s.size, SHA256: hex.EncodeToString(s.sum.Sum(nil))}}
+}
+
+// sinkBytes returns the bytes written by s, once closed.
+func sinkBytes(s sink) int64 {
+	var n int64
+	for _, f := range s.files() {
+		n += f.Bytes
+	}
+	return n
+}
+
+// parseOutputs parses the -output values of an export, paths optionally
+// prefixed with their format as in csv:/tmp/x.csv, into the outputs to
+// write, output.parquet if there are none. Outputs in the format of def
+// take its options; those in another format its defaults.
+func parseOutputs(values []string, def sinkOptions) ([]exportOutput, error) {
+	if len(values) == 0 {
+		values = []string{"output.parquet"}
+	}
+	defFormat := def.Format
+	if defFormat == "" {
+		defFormat = "parquet"
+	}
+	var outputs []exportOutput
+	seen := make(map[string]bool)
+	for _, v := range values {
+		o := exportOutput{Path: v, sinkOptions: def}
+		if format, path, ok := strings.Cut(v, ":"); ok && (format == "parquet" || format == "arrow" || format == "avro" || format == "csv" || format == "gsheet") {
+			o.Path = path
+			if format != defFormat {
+				o.sinkOptions = sinkOptions{Format: format, Registry: def.Registry}
+			}
+		}
+		if o.Format == "gsheet" {
+			o.Path = sheetLocation(o.Path)
+		} else if strings.Contains(o.Path, "://") && !isSFTP(o.Path) {
+			return nil, fmt.Errorf("cannot write to %s: outputs must be local paths or sftp:// URIs", o.Path)
+		}
+		if o.Path == "" {
+			return nil, fmt.Errorf("empty -output %q", v)
+		}
+		if seen[o.Path] {
+			return nil, fmt.Errorf("-output %s is given twice", o.Path)
+		}
+		seen[o.Path] = true
+		if err := o.validate(); err != nil {
+			return nil, fmt.Errorf("-output %s: %w", v, err)
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs, nil
+}
+
+// multiSink fans the records of an export out to several sinks, so one
+// read of the source feeds outputs in different formats.
+type multiSink []sink
+
+func (m multiSink) write(rec arrow.Record) error {
+	for _, s := range m {
+		if err := s.write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close finishes every sink and returns their total size. Outputs are only
+// moved into place once all of them are written, so should one fail, none
+// is left behind. Sinks that cannot be staged, like gsheet, are closed
+// after the others are finished and before they are committed.
+func (m multiSink) close() (int64, error) {
+	var total int64
+	for _, s := range m {
+		if s, ok := s.(stagedSink); ok {
+			size, err := s.finish()
+			if err != nil {
+				m.abort()
+				return 0, err
+			}
+			total += size
+		}
+	}
+	for _, s := range m {
+		if _, ok := s.(stagedSink); !ok {
+			size, err := s.close()
+			if err != nil {
+				m.abort()
+				return 0, err
+			}
+			total += size
+		}
+	}
+	for i, s := range m {
+		if s, ok := s.(stagedSink); ok {
+			if err := s.commit(); err != nil {
+				for _, s := range m[:i] {
+					if s, ok := s.(stagedSink); ok {
+						s.rollback()
+					}
+				}
+				m.abort()
+				return 0, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (m multiSink) abort() {
+	for _, s := range m {
+		s.abort()
+	}
+}
+
+func (m multiSink) files() []outputFile {
+	var files []outputFile
+	for _, s := range m {
+		files = append(files, s.files()...)
+	}
+	return files
+}
+
+// partitionedSink splits rows by the values of the partition columns into
+// dir/col=value/.../part-0.parquet (or .arrow). As in Hive, partition columns are encoded
+// in the path and left out of the files themselves.
+type partitionedSink struct {
+	ctx        context.Context
+	dir        string
+	opts       sinkOptions
+	keys       []int
+	fileSchema *arrow.Schema
+	parts      map[string]*fileSink
+}
+
+func newPartitionedSink(ctx context.Context, dir string, schema *arrow.Schema, opts sinkOptions) (*partitionedSink, error) {
+	s := &partitionedSink{ctx: ctx, dir: dir, opts: opts, parts: make(map[string]*fileSink)}
+
+	isKey := make(map[int]bool)
+	for _, col := range opts.PartitionBy {
+		indices := schema.FieldIndices(col)
+		if len(indices) == 0 {
+			return nil, classify(errSchemaMismatch, fmt.Errorf("partition column %q not found", col))
+		}
+		s.keys = append(s.keys, indices[0])
+		isKey[indices[0]] = true
+	}
+
+	var fields []arrow.Field
+	for i, f := range schema.Fields() {
+		if !isKey[i] {
+			fields = append(fields, f)
+		}
+	}
+	// Keep the schema metadata, such as the export's lineage.
+	md := schema.Metadata()
+	s.fileSchema = arrow.NewSchema(fields, &md)
+	if err := s.opts.registerSchema(ctx, s.fileSchema); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *partitionedSink) write(rec arrow.Record) error {
+	// Group row indices by partition path, preserving row order.
+	groups := make(map[string][]int64)
+	var order []string
+	for i := 0; i < int(rec.NumRows()); i++ {
+		segments := make([]string, len(s.keys))
+		for j, k := range s.keys {
+			segments[j] = partitionSegment(rec.ColumnName(k), rec.Column(k), i)
+		}
+		key := filepath.Join(segments...)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], int64(i))
+	}
+
+	for _, key := range order {
+		if err := s.writePart(key, rec, groups[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *partitionedSink) writePart(key string, rec arrow.Record, rows []int64) error {
+	part, ok := s.parts[key]
+	if !ok {
+		var err error
+		name := s.opts.partName
+		if name == "" {
+			name = "part-0"
+		}
+		if part, err = newFileSink(filepath.Join(s.dir, key, name+s.opts.extension()), s.fileSchema, s.opts); err != nil {
+			return err
+		}
+		s.parts[key] = part
+	}
+
+	bldr := array.NewInt64Builder(allocator)
+	defer bldr.Release()
+	bldr.AppendValues(rows, nil)
+	indices := bldr.NewArray()
+	defer indices.Release()
+
+	cols := make([]arrow.Array, 0, s.fileSchema.NumFields())
+	defer func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}()
+	for i, col := range rec.Columns() {
+		if isPartitionKey(s.keys, i) {
+			continue
+		}
+		taken, err := compute.TakeArray(s.ctx, col, indices)
+		if err != nil {
+			return fmt.Errorf("failed to select partition rows: %w", err)
+		}
+		cols = append(cols, taken)
+	}
+
+	partRec := array.NewRecord(s.fileSchema, cols, int64(len(rows)))
+	defer partRec.Release()
+	return part.write(partRec)
+}
+
+// close finishes every partition before moving any into place, so a failure
+// to write one leaves none of them behind.
+func (s *partitionedSink) close() (int64, error) {
+	total, err := s.finish()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.commit(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// partKeys returns the keys of the partitions in order.
+func (s *partitionedSink) partKeys() []string {
+	keys := make([]string, 0, len(s.parts))
+	for key := range s.parts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// finish writes every partition to its temporary file.
+func (s *partitionedSink) finish() (int64, error) {
+	var total int64
+	for _, key := range s.partKeys() {
+		n, err := s.parts[key].finish()
+		if err != nil {
+			s.abort()
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// commit moves every partition into place.
+func (s *partitionedSink) commit() error {
+	for _, key := range s.partKeys() {
+		if err := s.parts[key].commit(); err != nil {
+			s.abort()
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback removes the partitions moved into place, as abort does.
+func (s *partitionedSink) rollback() {
+	s.abort()
+}
+
+// abort removes the files of every partition, including ones already moved
+// into place, and the partition directories left empty.
+func (s *partitionedSink) abort() {
+	root := filepath.Clean(s.dir)
+	for key, part := range s.parts {
+		part.abort()
+		if part.committed {
+			os.Remove(part.path)
+		}
+		for dir := filepath.Join(root, key); dir != root && dir != "."; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
+
+func (s *partitionedSink) files() []outputFile {
+	files := make([]outputFile, 0, len(s.parts))
+	for _, part := range s.parts {
+		files = append(files, part.files()...)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+func isPartitionKey(keys []int, i int) bool {
+	for _, k := range keys {
+		if k == i {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionSegment renders the path segment col=value for row i, escaping
+// characters that are not safe in file names.
+func partitionSegment(name string, col arrow.Array, i int) string {
+	value := "__HIVE_DEFAULT_PARTITION__"
+	if !col.IsNull(i) {
+		value = strings.NewReplacer("/", "%2F", "\\", "%5C", "=", "%3D", ":", "%3A").Replace(col.ValueStr(i))
+	}
+	return name + "=" + value
+}
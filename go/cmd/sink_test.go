@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// failingSink is an output that cannot be staged, like gsheet, and fails
+// to close.
+type failingSink struct{ aborted bool }
+
+func (s *failingSink) write(arrow.Record) error { return nil }
+func (s *failingSink) close() (int64, error)    { return 0, errors.New("closing failed") }
+func (s *failingSink) abort()                   { s.aborted = true }
+func (s *failingSink) files() []outputFile      { return nil }
+
+// TestMultiSinkClose checks that the outputs of an export are moved into
+// place together: when one of them fails, none is left behind.
+func TestMultiSinkClose(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	tests := []struct {
+		name string
+		// setup breaks the export to dir, returning another sink to
+		// write to, if any.
+		setup func(t *testing.T, dir string) sink
+		want  []string
+	}{
+		{name: "all written", want: []string{"a.csv", "b.parquet", "c/region=eu/part-0.parquet"}},
+		{name: "commit fails", setup: func(t *testing.T, dir string) sink {
+			// b.parquet cannot replace a directory that is not empty.
+			if err := os.MkdirAll(filepath.Join(dir, "b.parquet", "x"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			return nil
+		}},
+		{name: "unstaged sink fails", setup: func(t *testing.T, dir string) sink {
+			return &failingSink{}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var extra sink
+			if tt.setup != nil {
+				extra = tt.setup(t, dir)
+			}
+			var out multiSink
+			for _, o := range []struct {
+				path string
+				opts sinkOptions
+			}{
+				{"a.csv", sinkOptions{Format: "csv"}},
+				{"b.parquet", sinkOptions{}},
+				{"c", sinkOptions{PartitionBy: []string{"region"}}},
+			} {
+				s, err := newSink(context.Background(), filepath.Join(dir, o.path), partitionedSchema(schema), o.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out = append(out, s)
+			}
+			if extra != nil {
+				out = append(out, extra)
+			}
+			regioned := withRegion(rec)
+			defer regioned.Release()
+			if err := out.write(regioned); err != nil {
+				t.Fatal(err)
+			}
+			_, err := out.close()
+			if (err == nil) != (tt.setup == nil) {
+				t.Fatalf("close: %v", err)
+			}
+			if f, ok := extra.(*failingSink); ok && !f.aborted {
+				t.Errorf("the failing sink was not aborted")
+			}
+
+			var got []string
+			filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					rel, _ := filepath.Rel(dir, path)
+					got = append(got, filepath.ToSlash(rel))
+				}
+				return nil
+			})
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("files left %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// partitionedSchema adds the region column the partitioned output of
+// TestMultiSinkClose is split by.
+func partitionedSchema(schema *arrow.Schema) *arrow.Schema {
+	return arrow.NewSchema(append(schema.Fields(), arrow.Field{Name: "region", Type: arrow.BinaryTypes.String}), nil)
+}
+
+func withRegion(rec arrow.Record) arrow.Record {
+	b := array.NewStringBuilder(memory.DefaultAllocator)
+	defer b.Release()
+	for i := 0; i < int(rec.NumRows()); i++ {
+		b.Append("eu")
+	}
+	region := b.NewArray()
+	defer region.Release()
+	cols := append([]arrow.Array{}, rec.Columns()...)
+	return array.NewRecord(partitionedSchema(rec.Schema()), append(cols, region), rec.NumRows())
+}
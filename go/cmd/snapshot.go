@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// snapshot is a tagged state of a dataset appended to with -append-to: the
+// parts it held when tagged. Parts are only ever added to a dataset, and
+// vacuum keeps those of snapshots, so a snapshot reads the same rows for as
+// long as its tag exists.
+type snapshot struct {
+	// Parts are the paths of its files, relative to the dataset.
+	Parts     []string  `json:"parts"`
+	Rows      int64     `json:"rows"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// tag records the parts of m as the snapshot name. Tags are not moved once
+// set.
+func (m *manifest) tag(name string, now time.Time) error {
+	if _, ok := m.Tags[name]; ok {
+		return classify(errUsage, fmt.Errorf("snapshot tag %q already exists", name))
+	}
+	s := snapshot{Parts: []string{}, Rows: m.Rows, CreatedAt: now.UTC()}
+	for _, p := range m.Parts {
+		s.Parts = append(s.Parts, p.Path)
+	}
+	if m.Tags == nil {
+		m.Tags = make(map[string]snapshot)
+	}
+	m.Tags[name] = s
+	return nil
+}
+
+// tagged returns the paths of the parts some snapshot of m holds.
+func (m *manifest) tagged() map[string]bool {
+	paths := make(map[string]bool)
+	for _, s := range m.Tags {
+		for _, p := range s.Parts {
+			paths[p] = true
+		}
+	}
+	return paths
+}
+
+// readDataset returns the manifest of the dataset at dir, which must have
+// one.
+func readDataset(dir string) (*manifest, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, classify(errUsage, fmt.Errorf("%s has no manifest; only datasets written with -append-to have snapshots", dir))
+	}
+	return m, nil
+}
+
+// snapshotFiles returns the files of the dataset at dir as of the snapshot
+// tag, with the partition columns of their paths.
+func snapshotFiles(dir, tag string) ([]hiveFile, error) {
+	m, err := readDataset(dir)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := m.Tags[tag]
+	if !ok {
+		return nil, classify(errUsage, fmt.Errorf("%s has no snapshot tagged %q", dir, tag))
+	}
+	files := make([]hiveFile, len(s.Parts))
+	for i, p := range s.Parts {
+		files[i] = partitionFile(dir, filepath.Join(dir, filepath.FromSlash(p)))
+	}
+	return files, nil
+}
+
+// runSnapshot implements `dbx snapshot tag|list|drop <dataset-dir>`,
+// managing the tagged snapshots of a dataset appended to with -append-to.
+func runSnapshot(cfg config, args []string) error {
+	usage := classify(errUsage, fmt.Errorf("usage: dbx snapshot tag <dataset-dir> <tag> | list <dataset-dir> | drop <dataset-dir> <tag>"))
+	if len(args) < 2 {
+		return usage
+	}
+	dir := args[1]
+	m, err := readDataset(dir)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "tag", "drop":
+		if len(args) != 3 {
+			return usage
+		}
+		name := args[2]
+		if args[0] == "tag" {
+			if err := m.tag(name, time.Now()); err != nil {
+				return err
+			}
+		} else if _, ok := m.Tags[name]; !ok {
+			return classify(errUsage, fmt.Errorf("%s has no snapshot tagged %q", dir, name))
+		} else {
+			// Its parts are left to the next vacuum.
+			delete(m.Tags, name)
+		}
+		if err := writeManifest(dir, *m); err != nil {
+			return classify(errWrite, err)
+		}
+		if args[0] == "tag" {
+			s := m.Tags[name]
+			slog.Info("Tagged snapshot", "dataset", dir, "tag", name, "parts", len(s.Parts), "rows", s.Rows)
+		} else {
+			slog.Info("Dropped snapshot tag", "dataset", dir, "tag", name)
+		}
+		return nil
+	case "list":
+		if len(args) != 2 {
+			return usage
+		}
+		names := make([]string, 0, len(m.Tags))
+		for name := range m.Tags {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return m.Tags[names[i]].CreatedAt.Before(m.Tags[names[j]].CreatedAt) })
+		if cfg.json {
+			printJSON(m.Tags)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TAG\tPARTS\tROWS\tCREATED")
+		for _, name := range names {
+			s := m.Tags[name]
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", name, len(s.Parts), s.Rows, s.CreatedAt.Local().Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+	return usage
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+)
+
+const (
+	// spoolAhead is how many records are held in memory for a slow sink
+	// before spooling starts.
+	spoolAhead = 4
+	// spoolSegmentBytes is the Arrow data written to a spool file before
+	// the next one is started, so the sink can delete what it has read.
+	spoolSegmentBytes = 64 << 20
+)
+
+// spillOptions spool the records of an export to disk when the sink falls
+// behind the source.
+type spillOptions struct {
+	// Dir holds the spool files, each removed once it has been written out.
+	Dir string
+	// Limit caps the bytes of Arrow data spooled at once; when it is
+	// reached the source waits for the sink, as without spooling.
+	Limit int64
+}
+
+// spoolSegment is a spool file and the Arrow data it holds.
+type spoolSegment struct {
+	path  string
+	bytes int64
+}
+
+// spoolReader reads its source as fast as it delivers, so a slow sink does
+// not hold the source's query, and its locks or transaction, open for
+// longer than needed. Records the sink is not ready for are spooled to
+// zstd-compressed Arrow IPC files. Once spooling starts, every later record
+// is spooled too, which keeps them in order.
+type spoolReader struct {
+	refCount int64
+	src      array.RecordReader
+	opts     spillOptions
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	// mem carries records to the sink until spooling starts, when it is
+	// closed.
+	mem chan arrow.Record
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	segments []spoolSegment // written and not yet read
+	spooled  int64          // bytes spooled and not yet read back
+	finished bool           // no more segments will come
+	err      error
+	total    int64
+
+	// The consumer's: the record returned by Record, and the spool file
+	// being read.
+	cur  arrow.Record
+	seg  spoolSegment
+	file *os.File
+	ipcr *ipc.Reader
+}
+
+// newSpoolReader starts reading src. It takes a reference to src.
+func newSpoolReader(ctx context.Context, src array.RecordReader, opts spillOptions) (*spoolReader, error) {
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	src.Retain()
+	r := &spoolReader{refCount: 1, src: src, opts: opts, done: make(chan struct{}), mem: make(chan arrow.Record, spoolAhead)}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.cond = sync.NewCond(&r.mu)
+	go r.produce()
+	return r, nil
+}
+
+// produce reads the source until it ends, fails, or the reader is
+// released.
+func (r *spoolReader) produce() {
+	defer close(r.done)
+	var (
+		spooling bool
+		w        *ipc.Writer
+		f        *os.File
+		bytes    int64
+	)
+	// finish closes the spool file being written and hands it to the sink.
+	finish := func() error {
+		if w == nil {
+			return nil
+		}
+		err := w.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		w = nil
+		if err != nil {
+			os.Remove(f.Name())
+			return fmt.Errorf("failed to write spill file: %w", err)
+		}
+		r.mu.Lock()
+		r.segments = append(r.segments, spoolSegment{path: f.Name(), bytes: bytes})
+		r.cond.Broadcast()
+		r.mu.Unlock()
+		return nil
+	}
+
+	err := func() error {
+		for r.src.Next() {
+			rec := r.src.Record()
+			if !spooling {
+				rec.Retain()
+				select {
+				case r.mem <- rec:
+					continue
+				case <-r.ctx.Done():
+					rec.Release()
+					return r.ctx.Err()
+				default:
+					rec.Release()
+					spooling = true
+					close(r.mem)
+					logger(r.ctx).Info("Sink is falling behind, spooling to disk", "dir", r.opts.Dir)
+				}
+			}
+
+			// Wait for the sink to catch up if the limit would be passed,
+			// handing it the file being written first; a record larger
+			// than the limit is spooled on its own.
+			size := recordSize(rec)
+			r.mu.Lock()
+			over := r.spooled > 0 && r.spooled+size > r.opts.Limit
+			r.mu.Unlock()
+			if over {
+				if err := finish(); err != nil {
+					return err
+				}
+			}
+			r.mu.Lock()
+			for r.spooled > 0 && r.spooled+size > r.opts.Limit && r.ctx.Err() == nil {
+				r.cond.Wait()
+			}
+			r.spooled += size
+			r.mu.Unlock()
+			if err := r.ctx.Err(); err != nil {
+				return err
+			}
+
+			if w == nil {
+				var err error
+				if f, err = os.CreateTemp(r.opts.Dir, "dbx-spill-*.arrow"); err != nil {
+					return fmt.Errorf("failed to create spill file: %w", err)
+				}
+				w, bytes = ipc.NewWriter(f, ipc.WithSchema(r.src.Schema()), ipc.WithZstd(), ipc.WithAllocator(allocator)), 0
+			}
+			if err := w.Write(rec); err != nil {
+				return fmt.Errorf("failed to write spill file: %w", err)
+			}
+			bytes += size
+			atomic.AddInt64(&r.total, size)
+			if bytes >= spoolSegmentBytes {
+				if err := finish(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := r.src.Err(); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return finish()
+	}()
+
+	if w != nil {
+		w.Close()
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if !spooling {
+		close(r.mem)
+	}
+	r.mu.Lock()
+	r.err, r.finished = err, true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+func (r *spoolReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *spoolReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) != 0 {
+		return
+	}
+	r.cancel()
+	r.mu.Lock()
+	r.cond.Broadcast()
+	r.mu.Unlock()
+	for rec := range r.mem {
+		rec.Release()
+	}
+	<-r.done
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	r.closeSegment()
+	for _, s := range r.segments {
+		os.Remove(s.path)
+	}
+	if total := atomic.LoadInt64(&r.total); total > 0 {
+		logger(r.ctx).Debug("Spooled to disk", "bytes", total)
+	}
+	r.src.Release()
+}
+
+func (r *spoolReader) Schema() *arrow.Schema { return r.src.Schema() }
+func (r *spoolReader) Record() arrow.Record  { return r.cur }
+
+func (r *spoolReader) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *spoolReader) Next() bool {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	if rec, ok := <-r.mem; ok {
+		r.cur = rec
+		return true
+	}
+	for {
+		if r.ipcr != nil {
+			if r.ipcr.Next() {
+				r.cur = r.ipcr.Record()
+				r.cur.Retain()
+				return true
+			}
+			if err := r.ipcr.Err(); err != nil && !errors.Is(err, io.EOF) {
+				r.fail(fmt.Errorf("failed to read spill file: %w", err))
+				return false
+			}
+			r.closeSegment()
+		}
+
+		r.mu.Lock()
+		for len(r.segments) == 0 && !r.finished {
+			r.cond.Wait()
+		}
+		if len(r.segments) == 0 || r.err != nil {
+			r.mu.Unlock()
+			return false
+		}
+		r.seg, r.segments = r.segments[0], r.segments[1:]
+		r.mu.Unlock()
+
+		f, err := os.Open(r.seg.path)
+		if err == nil {
+			r.file = f
+			r.ipcr, err = ipc.NewReader(f, ipc.WithAllocator(allocator))
+		}
+		if err != nil {
+			r.closeSegment()
+			r.fail(fmt.Errorf("failed to read spill file: %w", err))
+			return false
+		}
+	}
+}
+
+func (r *spoolReader) fail(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+	r.cancel()
+}
+
+// closeSegment removes the spool file being read, freeing its share of the
+// limit for the source.
+func (r *spoolReader) closeSegment() {
+	if r.ipcr != nil {
+		r.ipcr.Release()
+		r.ipcr = nil
+	}
+	if r.seg.path == "" {
+		return
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	os.Remove(r.seg.path)
+	r.mu.Lock()
+	r.spooled -= r.seg.bytes
+	r.cond.Broadcast()
+	r.mu.Unlock()
+	r.seg = spoolSegment{}
+}
@@ -0,0 +1,1013 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/decimal128"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// sqlBatchRows is the number of rows the database/sql fallback collects into
+// each Arrow record batch.
+const sqlBatchRows = 64 * 1024
+
+// sqlDriver adapts a registered database/sql driver to adbc.Driver, so engines
+// without an ADBC driver can feed the same Arrow pipeline. Only the subset of
+// ADBC used by dbX is implemented; everything else reports NotImplemented.
+type sqlDriver struct {
+	name string
+}
+
+func (d sqlDriver) NewDatabase(opts map[string]string) (adbc.Database, error) {
+	if !slices.Contains(sql.Drivers(), d.name) {
+		return nil, adbc.Error{
+			Code: adbc.StatusInvalidArgument,
+			Msg:  fmt.Sprintf("database/sql driver %q is not compiled in (available: %s)", d.name, strings.Join(sql.Drivers(), ", ")),
+		}
+	}
+	db, err := sql.Open(d.name, opts[adbc.OptionKeyURI])
+	if err != nil {
+		return nil, adbc.Error{Code: adbc.StatusInvalidArgument, Msg: err.Error()}
+	}
+	return &sqlDatabase{db: db, driver: d.name, alloc: allocator}, nil
+}
+
+type sqlDatabase struct {
+	db     *sql.DB
+	driver string
+	alloc  memory.Allocator
+}
+
+func (d *sqlDatabase) SetOptions(opts map[string]string) error {
+	for k := range opts {
+		return notImplemented("database option " + k)
+	}
+	return nil
+}
+
+func (d *sqlDatabase) Open(ctx context.Context) (adbc.Connection, error) {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+	}
+	return &sqlConnection{conn: conn, driver: d.driver, alloc: d.alloc}, nil
+}
+
+func (d *sqlDatabase) Close() error {
+	return d.db.Close()
+}
+
+// sqlQueryer is satisfied by both *sql.Conn and *sql.Tx.
+type sqlQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type sqlConnection struct {
+	conn   *sql.Conn
+	tx     *sql.Tx // open transaction when autocommit is disabled
+	driver string
+	alloc  memory.Allocator
+}
+
+func (c *sqlConnection) queryer() sqlQueryer {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.conn
+}
+
+func (c *sqlConnection) SetOption(key, value string) error {
+	if key != adbc.OptionKeyAutoCommit {
+		return notImplemented("connection option " + key)
+	}
+	switch value {
+	case adbc.OptionValueDisabled:
+		if c.tx != nil {
+			return nil
+		}
+		tx, err := c.conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+		}
+		c.tx = tx
+	case adbc.OptionValueEnabled:
+		if c.tx == nil {
+			return nil
+		}
+		err := c.tx.Commit()
+		c.tx = nil
+		if err != nil {
+			return adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+		}
+	default:
+		return adbc.Error{Code: adbc.StatusInvalidArgument, Msg: "invalid autocommit value " + value}
+	}
+	return nil
+}
+
+func (c *sqlConnection) GetInfo(context.Context, []adbc.InfoCode) (array.RecordReader, error) {
+	return nil, notImplemented("GetInfo")
+}
+
+func (c *sqlConnection) GetObjects(context.Context, adbc.ObjectDepth, *string, *string, *string, *string, []string) (array.RecordReader, error) {
+	return nil, notImplemented("GetObjects")
+}
+
+func (c *sqlConnection) GetTableSchema(ctx context.Context, catalog, dbSchema *string, tableName string) (*arrow.Schema, error) {
+	var cat, sch string
+	if catalog != nil {
+		cat = *catalog
+	}
+	if dbSchema != nil {
+		sch = *dbSchema
+	}
+	name := newTableIdent(cat, sch, tableName).quote(sqlDialect(c.driver))
+
+	rows, err := c.queryer().QueryContext(ctx, "SELECT * FROM "+name+" WHERE 1=0")
+	if err != nil {
+		return nil, adbc.Error{Code: adbc.StatusNotFound, Msg: err.Error()}
+	}
+	defer rows.Close()
+
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+	}
+	return sqlSchema(c.driver, cols, nil), nil
+}
+
+func (c *sqlConnection) GetTableTypes(context.Context) (array.RecordReader, error) {
+	return nil, notImplemented("GetTableTypes")
+}
+
+func (c *sqlConnection) Commit(context.Context) error {
+	return c.endTx((*sql.Tx).Commit)
+}
+
+func (c *sqlConnection) Rollback(context.Context) error {
+	return c.endTx((*sql.Tx).Rollback)
+}
+
+// endTx finishes the open transaction and starts the next one, matching the
+// ADBC model where a connection with autocommit disabled is always in a
+// transaction.
+func (c *sqlConnection) endTx(end func(*sql.Tx) error) error {
+	if c.tx == nil {
+		return adbc.Error{Code: adbc.StatusInvalidState, Msg: "no active transaction"}
+	}
+	err := end(c.tx)
+	c.tx = nil
+	if err != nil {
+		return adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+	}
+	return c.SetOption(adbc.OptionKeyAutoCommit, adbc.OptionValueDisabled)
+}
+
+func (c *sqlConnection) NewStatement() (adbc.Statement, error) {
+	return &sqlStatement{cnxn: c}, nil
+}
+
+func (c *sqlConnection) Close() error {
+	if c.tx != nil {
+		_ = c.tx.Rollback()
+		c.tx = nil
+	}
+	return c.conn.Close()
+}
+
+func (c *sqlConnection) ReadPartition(context.Context, []byte) (array.RecordReader, error) {
+	return nil, notImplemented("ReadPartition")
+}
+
+type sqlStatement struct {
+	cnxn          *sqlConnection
+	query         string
+	ingestTable   string
+	ingestSchema  string
+	ingestCatalog string
+	ingestMode    string
+	batchRows     int
+	bound         array.RecordReader
+}
+
+func (s *sqlStatement) Close() error {
+	s.unbind()
+	return nil
+}
+
+func (s *sqlStatement) unbind() {
+	if s.bound != nil {
+		s.bound.Release()
+		s.bound = nil
+	}
+}
+
+func (s *sqlStatement) SetOption(key, val string) error {
+	switch key {
+	case adbc.OptionKeyIngestTargetTable:
+		s.ingestTable = val
+		s.query = ""
+	case adbc.OptionValueIngestTargetDBSchema:
+		s.ingestSchema = val
+	case adbc.OptionValueIngestTargetCatalog:
+		s.ingestCatalog = val
+	case adbc.OptionKeyIngestMode:
+		s.ingestMode = val
+	case optionSQLBatchRows:
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			return adbc.Error{Code: adbc.StatusInvalidArgument, Msg: fmt.Sprintf("invalid batch size %q", val)}
+		}
+		s.batchRows = n
+	default:
+		return notImplemented("statement option " + key)
+	}
+	return nil
+}
+
+func (s *sqlStatement) SetSqlQuery(query string) error {
+	s.query = query
+	s.ingestTable = ""
+	return nil
+}
+
+func (s *sqlStatement) ExecuteQuery(ctx context.Context) (array.RecordReader, int64, error) {
+	if s.query == "" {
+		return nil, -1, adbc.Error{Code: adbc.StatusInvalidState, Msg: "no query set"}
+	}
+
+	// Bound parameters are only supported for a single row when querying.
+	var args []any
+	if s.bound != nil {
+		var err error
+		if args, err = s.firstBoundRow(); err != nil {
+			return nil, -1, err
+		}
+	}
+
+	rows, err := s.cnxn.queryer().QueryContext(ctx, s.query, args...)
+	if err != nil {
+		return nil, -1, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+	}
+	batchRows := sqlBatchRows
+	if s.batchRows > 0 {
+		batchRows = s.batchRows
+	}
+	rdr, err := newSQLRecordReader(s.cnxn.driver, rows, s.cnxn.alloc, batchRows)
+	if err != nil {
+		rows.Close()
+		return nil, -1, err
+	}
+	return rdr, -1, nil
+}
+
+// ExecuteSchema returns the schema ExecuteQuery would. Where the driver
+// reports the type of every column, the query is asked for none of its
+// rows; otherwise it runs and the types are inferred from its first batch,
+// as ExecuteQuery infers them, so both agree.
+func (s *sqlStatement) ExecuteSchema(ctx context.Context) (*arrow.Schema, error) {
+	if s.query == "" {
+		return nil, adbc.Error{Code: adbc.StatusInvalidState, Msg: "no query set"}
+	}
+	if s.bound == nil {
+		if rows, err := s.cnxn.queryer().QueryContext(ctx, schemaProbe(s.query)); err == nil {
+			cols, err := rows.ColumnTypes()
+			rows.Close()
+			if err == nil && sqlTyped(s.cnxn.driver, cols) {
+				return sqlSchema(s.cnxn.driver, cols, nil), nil
+			}
+		}
+	}
+	rdr, _, err := s.ExecuteQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Release()
+	return rdr.Schema(), nil
+}
+
+func (s *sqlStatement) ExecuteUpdate(ctx context.Context) (int64, error) {
+	query := s.query
+	if s.ingestTable != "" {
+		if s.ingestMode != "" && s.ingestMode != adbc.OptionValueIngestModeAppend {
+			return -1, notImplemented("ingest mode " + s.ingestMode)
+		}
+		if s.bound == nil {
+			return -1, adbc.Error{Code: adbc.StatusInvalidState, Msg: "no data bound for ingest"}
+		}
+		query = s.insertQuery(s.bound.Schema())
+	}
+	if query == "" {
+		return -1, adbc.Error{Code: adbc.StatusInvalidState, Msg: "no query set"}
+	}
+
+	q := s.cnxn.queryer()
+	if s.bound == nil {
+		res, err := q.ExecContext(ctx, query)
+		if err != nil {
+			return -1, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+		}
+		return rowsAffected(res), nil
+	}
+
+	// Execute the statement once per bound row. In autocommit mode the rows
+	// are written in a single transaction, which is both atomic and far
+	// faster than committing every row.
+	defer s.unbind()
+	if s.cnxn.tx == nil {
+		tx, err := s.cnxn.conn.BeginTx(ctx, nil)
+		if err != nil {
+			return -1, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+		}
+		defer tx.Rollback()
+		q = tx
+		n, err := s.execBound(ctx, q, query)
+		if err != nil {
+			return n, err
+		}
+		if err := tx.Commit(); err != nil {
+			return n, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+		}
+		return n, nil
+	}
+	return s.execBound(ctx, q, query)
+}
+
+func (s *sqlStatement) execBound(ctx context.Context, q sqlQueryer, query string) (int64, error) {
+	var total int64
+	for s.bound.Next() {
+		rec := s.bound.Record()
+		for i := 0; i < int(rec.NumRows()); i++ {
+			res, err := q.ExecContext(ctx, query, recordRow(rec, i)...)
+			if err != nil {
+				return total, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+			}
+			if n := rowsAffected(res); n > 0 {
+				total += n
+			}
+		}
+	}
+	// Some readers, such as pqarrow's, report io.EOF once exhausted.
+	if err := s.bound.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return total, err
+	}
+	return total, nil
+}
+
+// sqlDialect returns the dialect used to quote identifiers for a
+// database/sql driver.
+func sqlDialect(driver string) dialect {
+	if d, err := lookupDialect(driver); err == nil {
+		return d
+	}
+	return ansiDialect
+}
+
+// insertQuery builds the INSERT statement used for bulk ingest, with the
+// placeholder style expected by the underlying driver.
+func (s *sqlStatement) insertQuery(schema *arrow.Schema) string {
+	d := sqlDialect(s.cnxn.driver)
+	cols := make([]string, schema.NumFields())
+	params := make([]string, schema.NumFields())
+	for i, f := range schema.Fields() {
+		cols[i] = d.quoteIdent(f.Name)
+		params[i] = sqlPlaceholder(s.cnxn.driver, i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		newTableIdent(s.ingestCatalog, s.ingestSchema, s.ingestTable).quote(d), strings.Join(cols, ", "), strings.Join(params, ", "))
+}
+
+func (s *sqlStatement) firstBoundRow() ([]any, error) {
+	defer s.unbind()
+	if !s.bound.Next() || s.bound.Record().NumRows() == 0 {
+		return nil, adbc.Error{Code: adbc.StatusInvalidState, Msg: "no parameters bound"}
+	}
+	return recordRow(s.bound.Record(), 0), nil
+}
+
+func (s *sqlStatement) Prepare(context.Context) error {
+	// database/sql prepares lazily on execution.
+	return nil
+}
+
+func (s *sqlStatement) SetSubstraitPlan([]byte) error {
+	return notImplemented("SetSubstraitPlan")
+}
+
+func (s *sqlStatement) Bind(_ context.Context, values arrow.Record) error {
+	rdr, err := array.NewRecordReader(values.Schema(), []arrow.Record{values})
+	if err != nil {
+		return adbc.Error{Code: adbc.StatusInvalidArgument, Msg: err.Error()}
+	}
+	s.unbind()
+	s.bound = rdr
+	return nil
+}
+
+func (s *sqlStatement) BindStream(_ context.Context, stream array.RecordReader) error {
+	stream.Retain()
+	s.unbind()
+	s.bound = stream
+	return nil
+}
+
+func (s *sqlStatement) GetParameterSchema() (*arrow.Schema, error) {
+	return nil, notImplemented("GetParameterSchema")
+}
+
+func (s *sqlStatement) ExecutePartitions(context.Context) (*arrow.Schema, adbc.Partitions, int64, error) {
+	return nil, adbc.Partitions{}, -1, notImplemented("ExecutePartitions")
+}
+
+// sqlRecordReader turns *sql.Rows into a stream of Arrow record batches.
+type sqlRecordReader struct {
+	refCount  int64
+	rows      *sql.Rows
+	schema    *arrow.Schema
+	bldr      *array.RecordBuilder
+	dest      []any
+	pending   [][]any // rows read ahead to infer column types
+	batchRows int
+	rec       arrow.Record
+	err       error
+}
+
+func newSQLRecordReader(driver string, rows *sql.Rows, alloc memory.Allocator, batchRows int) (*sqlRecordReader, error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+	}
+	dest := make([]any, len(cols))
+	for i := range dest {
+		dest[i] = new(any)
+	}
+	r := &sqlRecordReader{
+		refCount:  1,
+		rows:      rows,
+		dest:      dest,
+		batchRows: batchRows,
+	}
+
+	// Drivers such as ODBC don't report the Go type of their columns, so
+	// read the first batch ahead and infer the types from its values.
+	if !sqlTyped(driver, cols) {
+		for len(r.pending) < batchRows && rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				return nil, adbc.Error{Code: adbc.StatusIO, Msg: err.Error()}
+			}
+			row := make([]any, len(dest))
+			for i, d := range dest {
+				row[i] = *(d.(*any))
+			}
+			r.pending = append(r.pending, row)
+		}
+	}
+
+	r.schema = sqlSchema(driver, cols, r.pending)
+	r.bldr = array.NewRecordBuilder(alloc, r.schema)
+	return r, nil
+}
+
+func (r *sqlRecordReader) Retain() {
+	atomic.AddInt64(&r.refCount, 1)
+}
+
+func (r *sqlRecordReader) Release() {
+	if atomic.AddInt64(&r.refCount, -1) == 0 {
+		if r.rec != nil {
+			r.rec.Release()
+			r.rec = nil
+		}
+		r.bldr.Release()
+		r.rows.Close()
+	}
+}
+
+func (r *sqlRecordReader) Schema() *arrow.Schema { return r.schema }
+func (r *sqlRecordReader) Record() arrow.Record  { return r.rec }
+func (r *sqlRecordReader) Err() error            { return r.err }
+
+func (r *sqlRecordReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	if r.err != nil {
+		return false
+	}
+
+	n := 0
+	for ; n < r.batchRows && len(r.pending) > 0; n++ {
+		if !r.appendRow(r.pending[0]) {
+			return false
+		}
+		r.pending = r.pending[1:]
+	}
+	row := make([]any, len(r.dest))
+	for n < r.batchRows && r.rows.Next() {
+		if err := r.rows.Scan(r.dest...); err != nil {
+			r.err = fmt.Errorf("failed to scan row: %w", err)
+			return false
+		}
+		for i, d := range r.dest {
+			row[i] = *(d.(*any))
+		}
+		if !r.appendRow(row) {
+			return false
+		}
+		n++
+	}
+	if err := r.rows.Err(); err != nil {
+		r.err = fmt.Errorf("failed to read rows: %w", err)
+		return false
+	}
+	if n == 0 {
+		return false
+	}
+	r.rec = r.bldr.NewRecord()
+	return true
+}
+
+func (r *sqlRecordReader) appendRow(row []any) bool {
+	for i, v := range row {
+		if err := appendValue(r.bldr.Field(i), v); err != nil {
+			r.err = fmt.Errorf("column %s: %w", r.schema.Field(i).Name, err)
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	bytesType    = reflect.TypeOf([]byte(nil))
+	rawBytesType = reflect.TypeOf(sql.RawBytes(nil))
+)
+
+// sqlSchema derives an Arrow schema from database/sql column metadata.
+// Columns whose Go type the driver doesn't report are typed from the first
+// non-null value in sample, or carried as strings.
+func sqlSchema(driver string, cols []*sql.ColumnType, sample [][]any) *arrow.Schema {
+	fields := make([]arrow.Field, len(cols))
+	for i, ct := range cols {
+		typ := sqlColumnType(driver, ct)
+		if typ == nil {
+			typ = sqlArrowType(ct.ScanType())
+		}
+		if typ == nil {
+			typ = inferArrowType(sample, i)
+		}
+		nullable, ok := ct.Nullable()
+		fields[i] = arrow.Field{
+			Name:     ct.Name(),
+			Type:     typ,
+			Nullable: nullable || !ok,
+		}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// sqlTyped reports whether the driver reports the type of every column of
+// cols, so that sqlSchema needs no sample of their values.
+func sqlTyped(driver string, cols []*sql.ColumnType) bool {
+	for _, ct := range cols {
+		if sqlColumnType(driver, ct) == nil && sqlArrowType(ct.ScanType()) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// inferArrowType picks the Arrow type for column i from sampled values.
+func inferArrowType(sample [][]any, i int) arrow.DataType {
+	for _, row := range sample {
+		switch v := row[i].(type) {
+		case nil:
+			continue
+		case []byte:
+			if !utf8.Valid(v) {
+				return arrow.BinaryTypes.Binary
+			}
+			return arrow.BinaryTypes.String
+		default:
+			if typ := sqlArrowType(reflect.TypeOf(v)); typ != nil {
+				return typ
+			}
+			return arrow.BinaryTypes.String
+		}
+	}
+	return arrow.BinaryTypes.String
+}
+
+// sqlColumnType maps database types whose Go scan type loses information
+// onto an Arrow type, or returns nil to go by the scan type. For Postgres,
+// these are dates and timestamps without time zone, which scan into
+// time.Time like timestamptz, and NUMERIC, which pgx scans into float64;
+// NUMERIC without a precision is carried as a string, as the ADBC driver
+// does.
+func sqlColumnType(driver string, ct *sql.ColumnType) arrow.DataType {
+	if sqlDialect(driver).name != "postgres" {
+		return nil
+	}
+	switch ct.DatabaseTypeName() {
+	case "DATE":
+		return arrow.FixedWidthTypes.Date32
+	case "TIMESTAMP":
+		return &arrow.TimestampType{Unit: arrow.Microsecond}
+	case "NUMERIC":
+		if p, s, ok := ct.DecimalSize(); ok && p > 0 && p <= 38 && s >= 0 && s <= p {
+			return &arrow.Decimal128Type{Precision: int32(p), Scale: int32(s)}
+		}
+		return arrow.BinaryTypes.String
+	}
+	return nil
+}
+
+// sqlArrowType maps the Go type a driver scans a column into onto an Arrow
+// type. It returns nil when the driver doesn't report a concrete type, and
+// carries anything without an obvious mapping as a string.
+func sqlArrowType(t reflect.Type) arrow.DataType {
+	if t == nil || t.Kind() == reflect.Interface {
+		return nil
+	}
+	switch t {
+	case timeType, reflect.TypeOf(sql.NullTime{}):
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	case bytesType:
+		return arrow.BinaryTypes.Binary
+	case rawBytesType:
+		return arrow.BinaryTypes.String
+	case reflect.TypeOf(sql.NullInt64{}):
+		return arrow.PrimitiveTypes.Int64
+	case reflect.TypeOf(sql.NullInt32{}):
+		return arrow.PrimitiveTypes.Int32
+	case reflect.TypeOf(sql.NullInt16{}):
+		return arrow.PrimitiveTypes.Int16
+	case reflect.TypeOf(sql.NullByte{}):
+		return arrow.PrimitiveTypes.Uint8
+	case reflect.TypeOf(sql.NullFloat64{}):
+		return arrow.PrimitiveTypes.Float64
+	case reflect.TypeOf(sql.NullBool{}):
+		return arrow.FixedWidthTypes.Boolean
+	}
+
+	if t.Kind() == reflect.Pointer {
+		return sqlArrowType(t.Elem())
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return arrow.FixedWidthTypes.Boolean
+	case reflect.Int8:
+		return arrow.PrimitiveTypes.Int8
+	case reflect.Int16:
+		return arrow.PrimitiveTypes.Int16
+	case reflect.Int32:
+		return arrow.PrimitiveTypes.Int32
+	case reflect.Int, reflect.Int64:
+		return arrow.PrimitiveTypes.Int64
+	case reflect.Uint8:
+		return arrow.PrimitiveTypes.Uint8
+	case reflect.Uint16:
+		return arrow.PrimitiveTypes.Uint16
+	case reflect.Uint32:
+		return arrow.PrimitiveTypes.Uint32
+	case reflect.Uint, reflect.Uint64:
+		return arrow.PrimitiveTypes.Uint64
+	case reflect.Float32:
+		return arrow.PrimitiveTypes.Float32
+	case reflect.Float64:
+		return arrow.PrimitiveTypes.Float64
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+// appendValue appends a value scanned by database/sql to an Arrow builder,
+// converting between the driver's representation and the column type.
+func appendValue(b array.Builder, v any) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		switch v := v.(type) {
+		case bool:
+			b.Append(v)
+		default:
+			x, err := strconv.ParseBool(asString(v))
+			if err != nil {
+				return err
+			}
+			b.Append(x)
+		}
+	case *array.Int8Builder:
+		x, err := asIntIn(v, math.MinInt8, math.MaxInt8, b.Type())
+		if err != nil {
+			return err
+		}
+		b.Append(int8(x))
+	case *array.Int16Builder:
+		x, err := asIntIn(v, math.MinInt16, math.MaxInt16, b.Type())
+		if err != nil {
+			return err
+		}
+		b.Append(int16(x))
+	case *array.Int32Builder:
+		x, err := asIntIn(v, math.MinInt32, math.MaxInt32, b.Type())
+		if err != nil {
+			return err
+		}
+		b.Append(int32(x))
+	case *array.Int64Builder:
+		x, err := asInt64(v)
+		if err != nil {
+			return err
+		}
+		b.Append(x)
+	case *array.Uint8Builder:
+		x, err := asUintIn(v, math.MaxUint8, b.Type())
+		if err != nil {
+			return err
+		}
+		b.Append(uint8(x))
+	case *array.Uint16Builder:
+		x, err := asUintIn(v, math.MaxUint16, b.Type())
+		if err != nil {
+			return err
+		}
+		b.Append(uint16(x))
+	case *array.Uint32Builder:
+		x, err := asUintIn(v, math.MaxUint32, b.Type())
+		if err != nil {
+			return err
+		}
+		b.Append(uint32(x))
+	case *array.Uint64Builder:
+		x, err := asUintIn(v, math.MaxUint64, b.Type())
+		if err != nil {
+			return err
+		}
+		b.Append(x)
+	case *array.Float32Builder:
+		x, err := asFloat64(v)
+		if err != nil {
+			return err
+		}
+		if math.Abs(x) > math.MaxFloat32 && !math.IsInf(x, 0) {
+			return fmt.Errorf("value %g is out of range for %s", x, b.Type())
+		}
+		b.Append(float32(x))
+	case *array.Float64Builder:
+		x, err := asFloat64(v)
+		if err != nil {
+			return err
+		}
+		b.Append(x)
+	case *array.StringBuilder:
+		b.Append(asString(v))
+	case *array.BinaryBuilder:
+		switch v := v.(type) {
+		case []byte:
+			b.Append(v)
+		default:
+			b.AppendString(asString(v))
+		}
+	case *array.Decimal128Builder:
+		typ := b.Type().(*arrow.Decimal128Type)
+		x, err := decimal128.FromString(asString(v), typ.Precision, typ.Scale)
+		if err != nil {
+			return err
+		}
+		b.Append(x)
+	case *array.Date32Builder:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to date", v)
+		}
+		b.Append(arrow.Date32FromTime(t))
+	case *array.TimestampBuilder:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to timestamp", v)
+		}
+		ts, err := arrow.TimestampFromTime(t, b.Type().(*arrow.TimestampType).Unit)
+		if err != nil {
+			return err
+		}
+		b.Append(ts)
+	default:
+		return fmt.Errorf("unsupported Arrow type %s", b.Type())
+	}
+	return nil
+}
+
+func asInt64(v any) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	case uint64, uint:
+		x, err := asUintIn(v, math.MaxInt64, arrow.PrimitiveTypes.Int64)
+		return int64(x), err
+	case uint32:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return strconv.ParseInt(asString(v), 10, 64)
+	}
+}
+
+// asIntIn is asInt64 for a column of type dt, failing rather than letting
+// values outside [lo, hi] wrap around.
+func asIntIn(v any, lo, hi int64, dt arrow.DataType) (int64, error) {
+	x, err := asInt64(v)
+	if err != nil {
+		return 0, err
+	}
+	if x < lo || x > hi {
+		return 0, fmt.Errorf("value %d is out of range for %s", x, dt)
+	}
+	return x, nil
+}
+
+// asUintIn converts v to an unsigned integer of at most hi, for a column of
+// type dt, failing on negative values and values past hi.
+func asUintIn(v any, hi uint64, dt arrow.DataType) (uint64, error) {
+	var x uint64
+	switch v := v.(type) {
+	case uint64:
+		x = v
+	case uint:
+		x = uint64(v)
+	case uint32:
+		x = uint64(v)
+	case uint16:
+		x = uint64(v)
+	case uint8:
+		x = uint64(v)
+	case string, []byte:
+		n, err := strconv.ParseUint(asString(v), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		x = n
+	default:
+		n, err := asInt64(v)
+		if err != nil {
+			return 0, err
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("value %d is out of range for %s", n, dt)
+		}
+		x = uint64(n)
+	}
+	if x > hi {
+		return 0, fmt.Errorf("value %d is out of range for %s", x, dt)
+	}
+	return x, nil
+}
+
+func asFloat64(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case int64, int32, int16, int8, int, uint32, uint16, uint8:
+		n, err := asInt64(v)
+		return float64(n), err
+	default:
+		return strconv.ParseFloat(asString(v), 64)
+	}
+}
+
+func asString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// recordRow returns row i of rec as database/sql arguments.
+func recordRow(rec arrow.Record, i int) []any {
+	args := make([]any, rec.NumCols())
+	for j, col := range rec.Columns() {
+		args[j] = arrowValue(col, i)
+	}
+	return args
+}
+
+// arrowValue converts element i of arr to a value database/sql drivers accept.
+func arrowValue(arr arrow.Array, i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
+	switch arr := arr.(type) {
+	case *array.Boolean:
+		return arr.Value(i)
+	case *array.Int8:
+		return int64(arr.Value(i))
+	case *array.Int16:
+		return int64(arr.Value(i))
+	case *array.Int32:
+		return int64(arr.Value(i))
+	case *array.Int64:
+		return arr.Value(i)
+	case *array.Uint8:
+		return int64(arr.Value(i))
+	case *array.Uint16:
+		return int64(arr.Value(i))
+	case *array.Uint32:
+		return int64(arr.Value(i))
+	case *array.Uint64:
+		// Kept unsigned: values past MaxInt64 have no int64.
+		return arr.Value(i)
+	case *array.Float32:
+		return float64(arr.Value(i))
+	case *array.Float64:
+		return arr.Value(i)
+	case *array.String:
+		return arr.Value(i)
+	case *array.LargeString:
+		return arr.Value(i)
+	case *array.Binary:
+		return arr.Value(i)
+	case *array.Timestamp:
+		return arr.Value(i).ToTime(arr.DataType().(*arrow.TimestampType).Unit)
+	case *array.Date32:
+		return arr.Value(i).ToTime()
+	case *array.Date64:
+		return arr.Value(i).ToTime()
+	default:
+		return arr.ValueStr(i)
+	}
+}
+
+// sqlPlaceholder returns the n-th (1-based) bind placeholder for a driver.
+func sqlPlaceholder(driver string, n int) string {
+	switch driver {
+	case "pgx", "postgres", "pq":
+		return "$" + strconv.Itoa(n)
+	case "sqlserver", "mssql":
+		return "@p" + strconv.Itoa(n)
+	case "oracle", "godror":
+		return ":" + strconv.Itoa(n)
+	default:
+		return "?"
+	}
+}
+
+func rowsAffected(res sql.Result) int64 {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func notImplemented(what string) error {
+	return adbc.Error{Code: adbc.StatusNotImplemented, Msg: what + " is not supported by the database/sql fallback"}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestArrowValueRoundTrip(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	src := array.NewUint64Builder(mem)
+	defer src.Release()
+	src.AppendValues([]uint64{0, math.MaxInt64, math.MaxInt64 + 1, math.MaxUint64}, nil)
+	arr := src.NewArray()
+	defer arr.Release()
+
+	dst := array.NewUint64Builder(mem)
+	defer dst.Release()
+	for i := 0; i < arr.Len(); i++ {
+		if err := appendValue(dst, arrowValue(arr, i)); err != nil {
+			t.Fatalf("appendValue(%d): %v", arr.(*array.Uint64).Value(i), err)
+		}
+	}
+	got := dst.NewUint64Array()
+	defer got.Release()
+	if !array.Equal(arr, got) {
+		t.Fatalf("round trip of %v gave %v", arr, got)
+	}
+}
+
+func TestAppendValueRange(t *testing.T) {
+	tests := []struct {
+		typ arrow.DataType
+		v   any
+		err bool
+	}{
+		{arrow.PrimitiveTypes.Int8, int64(127), false},
+		{arrow.PrimitiveTypes.Int8, int64(128), true},
+		{arrow.PrimitiveTypes.Int8, int64(-129), true},
+		{arrow.PrimitiveTypes.Int16, int64(math.MinInt16), false},
+		{arrow.PrimitiveTypes.Int16, int64(math.MaxInt16 + 1), true},
+		{arrow.PrimitiveTypes.Int32, int64(math.MaxInt32 + 1), true},
+		{arrow.PrimitiveTypes.Int64, uint64(math.MaxInt64), false},
+		{arrow.PrimitiveTypes.Int64, uint64(math.MaxInt64 + 1), true},
+		{arrow.PrimitiveTypes.Uint8, int64(255), false},
+		{arrow.PrimitiveTypes.Uint8, int64(256), true},
+		{arrow.PrimitiveTypes.Uint8, int64(-1), true},
+		{arrow.PrimitiveTypes.Uint16, "65536", true},
+		{arrow.PrimitiveTypes.Uint32, int64(-1), true},
+		{arrow.PrimitiveTypes.Uint64, "18446744073709551615", false},
+		{arrow.PrimitiveTypes.Uint64, int64(-1), true},
+		{arrow.PrimitiveTypes.Float32, float64(math.MaxFloat32), false},
+		{arrow.PrimitiveTypes.Float32, float64(math.MaxFloat64), true},
+		{arrow.PrimitiveTypes.Float32, "x", true},
+	}
+	for _, tt := range tests {
+		b := array.NewBuilder(memory.DefaultAllocator, tt.typ)
+		err := appendValue(b, tt.v)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("appendValue(%s, %v) succeeded, want an error", tt.typ, tt.v)
+		case !tt.err && err != nil:
+			t.Errorf("appendValue(%s, %v): %v", tt.typ, tt.v, err)
+		case tt.err && !strings.Contains(err.Error(), "out of range") && tt.v != "x":
+			t.Errorf("appendValue(%s, %v) error = %q, want it to be out of range", tt.typ, tt.v, err)
+		}
+		// Nothing is appended for a value that is rejected.
+		if want := map[bool]int{false: 1, true: 0}[tt.err]; b.Len() != want {
+			t.Errorf("appendValue(%s, %v) left %d values, want %d", tt.typ, tt.v, b.Len(), want)
+		}
+		b.Release()
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// stageFileRows caps the rows of each Parquet file uploaded to a stage, so
+// COPY INTO loads large imports from several files in parallel.
+const stageFileRows = 1 << 20
+
+// loadStrategies are the accepted -load-strategy values, with the engine
+// they require: bind for ADBC bulk ingest, stage to upload Parquet to a
+// Snowflake stage and COPY it in, copy for Postgres binary COPY, and
+// staging-swap to load a staging table and move its rows over at once.
+var loadStrategies = map[string]string{"bind": "", "stage": "snowflake", "copy": "postgres", "staging-swap": ""}
+
+// validLoadStrategy checks that strategy can load into the engine of opts.
+func validLoadStrategy(strategy string, opts connOptions) error {
+	engine, ok := loadStrategies[strategy]
+	if !ok && strategy != "" {
+		return fmt.Errorf("invalid -load-strategy %q, expected bind, stage, copy or staging-swap", strategy)
+	}
+	if engine != "" && opts.dialect().name != engine {
+		return fmt.Errorf("-load-strategy %s requires %s, not %s", strategy, engine, opts.dialect().name)
+	}
+	return nil
+}
+
+// loadStream appends the records of stream to table with the load strategy
+// of io, returning the number of rows written.
+func loadStream(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader, io importOptions) (int64, error) {
+	if io.Parallel > 1 {
+		return parallelLoad(ctx, cnxn, table, stream, io)
+	}
+	switch io.LoadStrategy {
+	case "stage":
+		return stageCopy(ctx, cnxn, table, stream)
+	case "copy":
+		return copyStream(ctx, cnxn, table, stream)
+	case "staging-swap":
+		return stagingSwap(ctx, cnxn, table, stream, io.DeferConstraints, false)
+	}
+	return ingestStream(ctx, cnxn, table, stream)
+}
+
+// stageCopy loads stream into the Snowflake table by writing it to local
+// Parquet files, PUTting them to a temporary stage and loading them with
+// COPY INTO, which for large loads is far faster than binding rows. Columns
+// are matched by name, so those left out of stream take their defaults.
+func stageCopy(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "stage copy", attribute.String("db.table", table.String()))
+	defer func() { endSpan(span, err) }()
+
+	dir, err := os.MkdirTemp("", "dbx-stage-")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	files, err := writeStageFiles(ctx, dir, stream)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+
+	// A temporary stage is dropped with the session, even if dbX is not
+	// around to clean up.
+	stage := "dbx_stage_" + newJobID()
+	if err := execUpdate(ctx, cnxn, "CREATE TEMPORARY STAGE "+stage); err != nil {
+		return 0, classify(errWrite, err)
+	}
+	for _, f := range files {
+		put := "PUT " + quoteLiteral("file://"+filepath.ToSlash(f)) + " @" + stage + " AUTO_COMPRESS = FALSE"
+		if _, err := queryStrings(ctx, cnxn, put); err != nil {
+			return 0, classify(errWrite, fmt.Errorf("failed to upload %s to the stage: %w", filepath.Base(f), err))
+		}
+	}
+	logger(ctx).Debug("Staged Parquet files", "stage", stage, "files", len(files))
+
+	copyInto := "COPY INTO " + table.quote(cnxn.opts.dialect()) + " FROM @" + stage +
+		" FILE_FORMAT = (TYPE = PARQUET) MATCH_BY_COLUMN_NAME = CASE_INSENSITIVE PURGE = TRUE"
+	reader, err := executeBound(ctx, cnxn, copyInto, nil)
+	if err != nil {
+		return 0, classify(errWrite, fmt.Errorf("failed to copy into %s: %w", table, err))
+	}
+	defer reader.Release()
+	// COPY INTO returns a row per file loaded, with its rows_loaded.
+	var rows int64
+	for reader.Next() {
+		rec := reader.Record()
+		col := -1
+		for i, f := range rec.Schema().Fields() {
+			if strings.EqualFold(f.Name, "rows_loaded") {
+				col = i
+			}
+		}
+		if col < 0 {
+			continue
+		}
+		for i := 0; i < int(rec.NumRows()); i++ {
+			if v := arrowValue(rec.Column(col), i); v != nil {
+				n, _ := strconv.ParseInt(asString(v), 10, 64)
+				rows += n
+			}
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return 0, classify(errWrite, fmt.Errorf("failed to copy into %s: %w", table, err))
+	}
+	return rows, nil
+}
+
+// writeStageFiles writes stream to Parquet files of at most stageFileRows
+// rows in dir, returning their paths.
+func writeStageFiles(ctx context.Context, dir string, stream array.RecordReader) ([]string, error) {
+	var files []string
+	var out sink
+	var rows int64
+	defer func() {
+		if out != nil {
+			out.abort()
+		}
+	}()
+	for stream.Next() {
+		rec := stream.Record()
+		for off := int64(0); off < rec.NumRows(); {
+			if out == nil {
+				path := filepath.Join(dir, fmt.Sprintf("part-%d.parquet", len(files)))
+				s, err := newSink(ctx, path, stream.Schema(), sinkOptions{Compression: "snappy"})
+				if err != nil {
+					return nil, err
+				}
+				out, rows = s, 0
+				files = append(files, path)
+			}
+			n := min(rec.NumRows()-off, stageFileRows-rows)
+			slice := rec.NewSlice(off, off+n)
+			err := out.write(slice)
+			slice.Release()
+			if err != nil {
+				return nil, classify(errWrite, err)
+			}
+			off, rows = off+n, rows+n
+			if rows == stageFileRows {
+				if _, err := out.close(); err != nil {
+					return nil, classify(errWrite, err)
+				}
+				out = nil
+			}
+		}
+	}
+	if err := stream.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if out != nil {
+		if _, err := out.close(); err != nil {
+			return nil, classify(errWrite, err)
+		}
+		out = nil
+	}
+	return files, nil
+}
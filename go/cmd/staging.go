@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-adbc/go/adbc"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// stagingSwap loads stream into a staging table created like table, then
+// moves the rows into table with one INSERT ... SELECT in a transaction,
+// so readers of table see either none of the import or all of it. The
+// staging table is unlogged on Postgres, and dropped afterwards. inTx is
+// set when cnxn is already in a transaction, such as that of
+// -defer-constraints, which then makes the insert atomic. With replace, the
+// rows of table are deleted in the same transaction, before the insert.
+func stagingSwap(ctx context.Context, cnxn *connection, table tableIdent, stream array.RecordReader, inTx, replace bool) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "staging swap", attribute.String("db.table", table.String()))
+	defer func() { endSpan(span, err) }()
+
+	d := cnxn.opts.dialect()
+	staging := newTableIdent(table.catalog(), table.schema(), table.table()+"_dbx_staging_"+newJobID())
+	create := "CREATE TABLE "
+	if d.name == "postgres" {
+		create = "CREATE UNLOGGED TABLE "
+	}
+	if err := execUpdate(ctx, cnxn, create+staging.quote(d)+" AS SELECT * FROM "+table.quote(d)+" WHERE 1 = 0"); err != nil {
+		return 0, classify(errWrite, fmt.Errorf("failed to create staging table: %w", err))
+	}
+	dropped := false
+	drop := func() error {
+		dropped = true
+		return execUpdate(context.WithoutCancel(ctx), cnxn, "DROP TABLE "+staging.quote(d))
+	}
+	defer func() {
+		if !dropped {
+			if derr := drop(); derr != nil {
+				logger(ctx).Warn("Failed to drop staging table", "table", staging.String(), "err", derr)
+			}
+		}
+	}()
+	logger(ctx).Debug("Loading into staging table", "staging", staging.String())
+
+	n, err := ingestStream(ctx, cnxn, staging, stream)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := make([]string, stream.Schema().NumFields())
+	for i, f := range stream.Schema().Fields() {
+		columns[i] = d.quoteIdent(f.Name)
+	}
+	list := strings.Join(columns, ", ")
+	insert := "INSERT INTO " + table.quote(d) + " (" + list + ") SELECT " + list + " FROM " + staging.quote(d)
+	swap := func() error {
+		if replace {
+			if err := execUpdate(ctx, cnxn, "DELETE FROM "+table.quote(d)); err != nil {
+				return classify(errWrite, err)
+			}
+		}
+		if err := execUpdate(ctx, cnxn, insert); err != nil {
+			return classify(errWrite, err)
+		}
+		return nil
+	}
+	if inTx {
+		if err := swap(); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+
+	setter, ok := cnxn.Connection.(adbc.PostInitOptions)
+	if !ok {
+		return 0, classify(errUsage, fmt.Errorf("-load-strategy staging-swap requires a driver with transactions"))
+	}
+	if err := setter.SetOption(adbc.OptionKeyAutoCommit, adbc.OptionValueDisabled); err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	// Back in autocommit mode, the staging table is dropped outside the
+	// transaction, which some engines would otherwise commit early.
+	defer setter.SetOption(adbc.OptionKeyAutoCommit, adbc.OptionValueEnabled)
+	if err := swap(); err != nil {
+		cnxn.Rollback(context.WithoutCancel(ctx))
+		return 0, err
+	}
+	if err := cnxn.Commit(ctx); err != nil {
+		cnxn.Rollback(context.WithoutCancel(ctx))
+		return 0, classify(errWrite, fmt.Errorf("failed to commit into %s: %w", table, err))
+	}
+	return n, nil
+}
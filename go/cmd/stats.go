@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// tableStats is the profile printed by `dbx stats`.
+type tableStats struct {
+	Rows    int64         `json:"rows"`
+	Columns []columnStats `json:"columns"`
+}
+
+// columnStats profiles one column. Distinct is a HyperLogLog estimate,
+// typically within 1% of the true count.
+type columnStats struct {
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+	Nulls     int64   `json:"nulls"`
+	NullRatio float64 `json:"null_ratio"`
+	Distinct  int64   `json:"distinct"`
+	Min       any     `json:"min,omitempty"`
+	Max       any     `json:"max,omitempty"`
+	// Histogram, TopValues and InferredType are only filled by
+	// `dbx profile`.
+	Histogram    []histogramBin `json:"histogram,omitempty"`
+	TopValues    []valueCount   `json:"top_values,omitempty"`
+	InferredType string         `json:"inferred_type,omitempty"`
+
+	hll    *hyperLogLog
+	detail *columnDetail
+	// ordered is false for nested types, which have no min or max.
+	ordered bool
+}
+
+// runStats implements `dbx stats -table t` and `dbx stats -file x.parquet`,
+// printing row counts and per-column null ratios, distinct-count estimates
+// and min/max as JSON.
+func runStats(cfg config, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	path := fs.String("file", "", "Parquet file, glob or Hive-partitioned directory to profile, or an sftp:// file")
+	table := fs.String("table", "", "Table to profile")
+	var where stringList
+	fs.Var(&where, "where", "With -file, profile only rows matching \"column op value\" or \"column is [not] null\", skipping partitions and row groups that cannot match (repeatable, all must hold)")
+	mismatch := fs.String("schema-mismatch", mismatchFail, "When the files of -file differ in schema: fail, or profile the union of their columns")
+	asOf := fs.String("as-of", "", "Profile the -file dataset as of this snapshot tag")
+	parseFlags(fs, args)
+
+	if (*path == "") == (*table == "") {
+		return classify(errUsage, fmt.Errorf("exactly one of -file or -table is required"))
+	}
+	filters, err := parseFilters(where)
+	if err != nil {
+		return classify(errUsage, err)
+	}
+	if len(filters) > 0 && *path == "" {
+		return classify(errUsage, fmt.Errorf("-where requires -file"))
+	}
+	if err := validSchemaMismatch(*mismatch); err != nil {
+		return classify(errUsage, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reader, closeSource, err := openStatsSource(ctx, cfg, *path, *table, filters, *mismatch, *asOf)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	stats, err := profile(reader, false)
+	if err != nil {
+		return err
+	}
+	printJSON(stats)
+	return nil
+}
+
+// openStatsSource returns a reader of the rows of the Parquet files at path
+// matching where, read with the schema mismatch policy and as of the
+// snapshot tag asOf, or else of every row of table, and a function
+// releasing it.
+func openStatsSource(ctx context.Context, cfg config, path, table string, where []*filterTransform, mismatch, asOf string) (array.RecordReader, func(), error) {
+	if path != "" {
+		if isSFTP(path) && asOf != "" {
+			return nil, nil, classify(errUsage, fmt.Errorf("-as-of requires a local dataset"))
+		}
+		local, cleanup, err := fetchInput(ctx, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader, closeFile, err := openParquetInput(ctx, local, nil, nil, where, mismatch, asOf)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return reader, func() { reader.Release(); closeFile(); cleanup() }, nil
+	}
+	query, err := tableQuery(cfg.conn, table)
+	if err != nil {
+		return nil, nil, classify(errUsage, err)
+	}
+	cnxn, err := openConnection(ctx, cfg.conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := executeQuery(ctx, cnxn, query)
+	if err != nil {
+		cnxn.Close()
+		return nil, nil, err
+	}
+	return reader, func() { reader.Release(); cnxn.Close() }, nil
+}
+
+// profile reads every record of reader and returns its statistics, with the
+// histograms, top values and type inference of `dbx profile` if detailed.
+// Memory use does not grow with the number of rows.
+func profile(reader array.RecordReader, detailed bool) (*tableStats, error) {
+	p := newProfiler(reader.Schema(), detailed)
+	for reader.Next() {
+		p.observe(reader.Record())
+	}
+	// pqarrow's reader reports io.EOF once exhausted.
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return p.finish(), nil
+}
+
+// profiler gathers the statistics of the records of one schema.
+type profiler struct {
+	stats *tableStats
+}
+
+func newProfiler(schema *arrow.Schema, detailed bool) *profiler {
+	stats := &tableStats{Columns: make([]columnStats, schema.NumFields())}
+	for i, f := range schema.Fields() {
+		_, nested := f.Type.(arrow.NestedType)
+		stats.Columns[i] = columnStats{Name: f.Name, Type: f.Type.String(), hll: newHyperLogLog(), ordered: !nested}
+		if detailed {
+			stats.Columns[i].detail = newColumnDetail(f.Type)
+		}
+	}
+	return &profiler{stats: stats}
+}
+
+// observe adds the rows of rec.
+func (p *profiler) observe(rec arrow.Record) {
+	p.stats.Rows += rec.NumRows()
+	for i, col := range rec.Columns() {
+		c := &p.stats.Columns[i]
+		c.Nulls += int64(col.NullN())
+		for row := 0; row < col.Len(); row++ {
+			v := arrowValue(col, row)
+			if v == nil {
+				continue
+			}
+			c.hll.add(asString(v))
+			if c.detail != nil {
+				c.detail.observe(v)
+			}
+			if !c.ordered {
+				continue
+			}
+			if c.Min == nil || cursorLess(v, c.Min) {
+				c.Min = v
+			}
+			if c.Max == nil || cursorLess(c.Max, v) {
+				c.Max = v
+			}
+		}
+	}
+}
+
+// finish returns the statistics of the rows observed.
+func (p *profiler) finish() *tableStats {
+	stats := p.stats
+	for i := range stats.Columns {
+		c := &stats.Columns[i]
+		if stats.Rows > 0 {
+			c.NullRatio = float64(c.Nulls) / float64(stats.Rows)
+		}
+		c.Distinct = c.hll.count()
+		if c.detail != nil {
+			c.detail.finish(c)
+		}
+	}
+	return stats
+}
+
+// statsTee profiles the records of an export in a goroutine of its own
+// while they are written, so statistics of the output need no second pass
+// over it.
+type statsTee struct {
+	recs  chan arrow.Record
+	done  chan struct{}
+	once  sync.Once
+	stats *tableStats
+}
+
+func newStatsTee(schema *arrow.Schema) *statsTee {
+	t := &statsTee{recs: make(chan arrow.Record, 4), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		p := newProfiler(schema, false)
+		for rec := range t.recs {
+			p.observe(rec)
+			rec.Release()
+		}
+		t.stats = p.finish()
+	}()
+	return t
+}
+
+// observe queues rec to be profiled.
+func (t *statsTee) observe(rec arrow.Record) {
+	rec.Retain()
+	t.recs <- rec
+}
+
+// finish waits for the queued records and returns their statistics.
+// observe must not be called after it.
+func (t *statsTee) finish() *tableStats {
+	t.once.Do(func() { close(t.recs) })
+	<-t.done
+	return t.stats
+}
+
+// writeStatsFile writes stats as JSON to path.
+func writeStatsFile(path string, stats *tableStats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode statistics: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write statistics: %w", err)
+	}
+	return nil
+}
+
+// hllPrecision is the number of hash bits selecting a register; 2^14
+// registers give a standard error of about 0.8%.
+const hllPrecision = 14
+
+// hyperLogLog estimates the number of distinct strings added to it in
+// constant memory.
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(s string) {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	x := f.Sum64()
+	// FNV spreads short inputs poorly over the high bits; finish with
+	// the splitmix64 mixer.
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	idx := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// count returns the estimated number of distinct values, using linear
+// counting while many registers are still empty.
+func (h *hyperLogLog) count() int64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
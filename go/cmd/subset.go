@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// subsetFile describes a subset export. It is written to subset.json in the
+// output directory, listing the tables in the order they must be loaded.
+type subsetFile struct {
+	Root   string        `json:"root"`
+	Where  string        `json:"where,omitempty"`
+	Tables []subsetEntry `json:"tables"`
+}
+
+type subsetEntry struct {
+	Table string `json:"table"`
+	File  string `json:"file"`
+	Rows  int64  `json:"rows"`
+}
+
+const subsetManifestName = "subset.json"
+
+// maxSubsetPasses bounds how often parent conditions are recomputed. Only
+// foreign key cycles need more than a few passes.
+const maxSubsetPasses = 8
+
+// subsetTable is a table taking part in a subset and the conditions, OR-ed
+// together, selecting its rows, keyed by why they were added.
+type subsetTable struct {
+	table tableIdent
+	conds map[string]string
+}
+
+func (t *subsetTable) where() string {
+	reasons := sortedKeys(boolSet(t.conds))
+	conds := make([]string, len(reasons))
+	for i, r := range reasons {
+		conds[i] = "(" + t.conds[r] + ")"
+	}
+	return strings.Join(conds, " OR ")
+}
+
+// planSubset works out which rows of which tables make up a referentially
+// complete slice of the database around the rows of root matching where:
+//
+//   - tables referencing root, directly or transitively, contribute the rows
+//     that reference included rows;
+//   - every included row's referenced rows are included too, recursively.
+//
+// Self-referencing foreign keys are not followed. The returned warnings say
+// where the subset may be incomplete.
+func planSubset(d dialect, root tableIdent, where string, fks []foreignKey) (map[string]*subsetTable, []string) {
+	if where == "" {
+		where = "1=1"
+	}
+	tables := map[string]*subsetTable{
+		root.String(): {table: root, conds: map[string]string{"": where}},
+	}
+	var warnings []string
+
+	quote := func(cols []string) []string {
+		q := make([]string, len(cols))
+		for i, c := range cols {
+			q[i] = d.quoteIdent(c)
+		}
+		return q
+	}
+	// in returns the condition selecting the rows whose cols appear in
+	// fromCols of the rows of from selected so far.
+	in := func(cols []string, from *subsetTable, fromCols []string) string {
+		lhs := strings.Join(quote(cols), ", ")
+		if len(cols) > 1 {
+			lhs = "(" + lhs + ")"
+		}
+		return fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s)",
+			lhs, strings.Join(quote(fromCols), ", "), from.table.quote(d), from.where())
+	}
+	add := func(t tableIdent) *subsetTable {
+		st, ok := tables[t.String()]
+		if !ok {
+			st = &subsetTable{table: t, conds: make(map[string]string)}
+			tables[t.String()] = st
+		}
+		return st
+	}
+
+	// Referencing rows, breadth first from the root.
+	queue := []string{root.String()}
+	for len(queue) > 0 {
+		parent := tables[queue[0]]
+		queue = queue[1:]
+		for _, fk := range fks {
+			if fk.Parent.String() != parent.table.String() || fk.Child.String() == fk.Parent.String() {
+				continue
+			}
+			_, seen := tables[fk.Child.String()]
+			child := add(fk.Child)
+			child.conds["down:"+fk.Name] = in(fk.Columns, parent, fk.RefColumns)
+			if !seen {
+				queue = append(queue, fk.Child.String())
+			}
+		}
+	}
+
+	// Referenced rows, until no parent condition changes.
+	for pass := 0; ; pass++ {
+		if pass == maxSubsetPasses {
+			warnings = append(warnings, "foreign keys form a cycle; referenced rows may be incomplete")
+			break
+		}
+		changed := false
+		names := sortedKeys(boolSet(tables))
+		for _, name := range names {
+			child := tables[name]
+			for _, fk := range fks {
+				if fk.Child.String() != name || fk.Parent.String() == name {
+					continue
+				}
+				if _, down := child.conds["down:"+fk.Name]; down {
+					// The child's rows were selected through this key, so
+					// the rows they reference are already included.
+					continue
+				}
+				parent := add(fk.Parent)
+				cond := in(fk.RefColumns, child, fk.Columns)
+				if parent.conds["up:"+name+":"+fk.Name] != cond {
+					parent.conds["up:"+name+":"+fk.Name] = cond
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	for _, fk := range fks {
+		if _, ok := tables[fk.Child.String()]; ok && fk.Child.String() == fk.Parent.String() {
+			warnings = append(warnings, fmt.Sprintf("self-referencing foreign key %s on %s is not followed", fk.Name, fk.Child))
+		}
+	}
+	return tables, warnings
+}
+
+// exportSubset exports the rows of root matching where, and the rows related
+// to them through foreign keys, to one file per table in dir.
+func exportSubset(ctx context.Context, opts connOptions, rootName, where, dir string, sinkOpts sinkOptions, force bool) (*subsetFile, error) {
+	root, err := opts.table(rootName)
+	if err != nil {
+		return nil, err
+	}
+
+	cnxn, err := openConnection(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	fks, err := loadForeignKeys(ctx, cnxn)
+	cnxn.Close()
+	if err != nil {
+		return nil, err
+	}
+	if root, err = resolveTable(root, fks); err != nil {
+		return nil, classify(errUsage, err)
+	}
+
+	d := opts.dialect()
+	tables, warnings := planSubset(d, root, where, fks)
+	for _, w := range warnings {
+		logger(ctx).Warn("Subset may be incomplete", "reason", w)
+	}
+	list := make([]tableIdent, 0, len(tables))
+	for _, t := range tables {
+		list = append(list, t.table)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].String() < list[j].String() })
+	ordered, cyclic := dependencyOrder(list, fks)
+	if len(cyclic) > 0 {
+		logger(ctx).Warn("Tables reference each other; load them with constraints deferred", "tables", cyclic)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	sf := &subsetFile{Root: root.String(), Where: where}
+	for _, t := range ordered {
+		st := tables[t.String()]
+		file := t.String() + sinkOpts.extension()
+		resp, err := exportQuery(withLogAttrs(ctx, "table", t.String()), opts, exportSpec{
+			Query:       fmt.Sprintf("SELECT * FROM %s WHERE %s", t.quote(d), st.where()),
+			Output:      filepath.Join(dir, file),
+			Table:       t.String(),
+			Force:       force,
+			sinkOptions: sinkOpts,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", t, err)
+		}
+		sf.Tables = append(sf.Tables, subsetEntry{Table: t.String(), File: file, Rows: resp.RowsWritten})
+	}
+
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, subsetManifestName), append(data, '\n'), 0o644); err != nil {
+		return nil, classify(errWrite, fmt.Errorf("failed to write subset manifest: %w", err))
+	}
+	return sf, nil
+}
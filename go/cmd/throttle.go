@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// throttle paces an export's read loop to at most a number of rows and bytes
+// per second, so exports from a primary leave it room for its own traffic.
+// Batches are let through at the pace their size allows; the first is never
+// delayed. It is safe for concurrent use, so workers reading in parallel
+// share one budget.
+type throttle struct {
+	rowsPerSec  float64
+	bytesPerSec float64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newThrottle returns a throttle for the -max-rows-per-sec and
+// -max-mb-per-sec limits, or nil if neither is set.
+func newThrottle(rowsPerSec, mbPerSec float64) (*throttle, error) {
+	if rowsPerSec < 0 || mbPerSec < 0 {
+		return nil, fmt.Errorf("-max-rows-per-sec and -max-mb-per-sec must not be negative")
+	}
+	if rowsPerSec == 0 && mbPerSec == 0 {
+		return nil, nil
+	}
+	return &throttle{rowsPerSec: rowsPerSec, bytesPerSec: mbPerSec * (1 << 20)}, nil
+}
+
+// wait blocks until a batch of rows and bytes fits the limits, and returns
+// how long it waited. A nil throttle never waits.
+func (t *throttle) wait(ctx context.Context, rows, bytes int64) (time.Duration, error) {
+	if t == nil {
+		return 0, nil
+	}
+	var cost float64
+	if t.rowsPerSec > 0 {
+		cost = float64(rows) / t.rowsPerSec
+	}
+	if t.bytesPerSec > 0 {
+		cost = max(cost, float64(bytes)/t.bytesPerSec)
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	until := t.next
+	t.next = t.next.Add(time.Duration(cost * float64(time.Second)))
+	t.mu.Unlock()
+
+	delay := until.Sub(now)
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/arrow/scalar"
+)
+
+// transform rewrites record batches on their way from the source to the sink.
+type transform interface {
+	// String describes the transform and its settings, for fingerprinting.
+	String() string
+	// outputSchema returns the schema of the records produced for input
+	// records of schema in.
+	outputSchema(in *arrow.Schema) (*arrow.Schema, error)
+	// apply returns the transformed record, which the caller releases.
+	apply(ctx context.Context, rec arrow.Record) (arrow.Record, error)
+}
+
+// applyTransforms runs rec through every transform in order. The returned
+// record is owned by the caller; rec itself is not released.
+func applyTransforms(ctx context.Context, transforms []transform, rec arrow.Record) (arrow.Record, error) {
+	rec.Retain()
+	for _, t := range transforms {
+		out, err := t.apply(ctx, rec)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+		rec = out
+	}
+	return rec, nil
+}
+
+// transformSchema returns the schema produced by applying transforms to
+// records of schema in.
+func transformSchema(transforms []transform, in *arrow.Schema) (*arrow.Schema, error) {
+	schema := in
+	for _, t := range transforms {
+		var err error
+		if schema, err = t.outputSchema(schema); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// renameTransform renames columns without copying data.
+type renameTransform struct {
+	names  map[string]string
+	schema *arrow.Schema
+}
+
+func (t *renameTransform) outputSchema(in *arrow.Schema) (*arrow.Schema, error) {
+	fields := in.Fields()
+	for old := range t.names {
+		if !in.HasField(old) {
+			return nil, fmt.Errorf("rename: column %q not found", old)
+		}
+	}
+	for i, f := range fields {
+		if name, ok := t.names[f.Name]; ok {
+			fields[i].Name = name
+		}
+	}
+	md := in.Metadata()
+	t.schema = arrow.NewSchema(fields, &md)
+	return t.schema, nil
+}
+
+func (t *renameTransform) String() string {
+	return fmt.Sprintf("rename %v", t.names)
+}
+
+func (t *renameTransform) apply(_ context.Context, rec arrow.Record) (arrow.Record, error) {
+	return array.NewRecord(t.schema, rec.Columns(), rec.NumRows()), nil
+}
+
+// maskTransform replaces the values of sensitive columns, either with a
+// SHA-256 hash (so values stay joinable) or by redacting them entirely.
+// Masked columns become strings.
+type maskTransform struct {
+	columns map[string]bool
+	method  string
+	schema  *arrow.Schema
+}
+
+func newMaskTransform(columns []string, method string) (*maskTransform, error) {
+	switch method {
+	case "", "hash":
+		method = "hash"
+	case "redact":
+	default:
+		return nil, fmt.Errorf("mask: unknown method %q", method)
+	}
+	t := &maskTransform{columns: make(map[string]bool), method: method}
+	for _, c := range columns {
+		t.columns[c] = true
+	}
+	return t, nil
+}
+
+func (t *maskTransform) outputSchema(in *arrow.Schema) (*arrow.Schema, error) {
+	for c := range t.columns {
+		if !in.HasField(c) {
+			return nil, fmt.Errorf("mask: column %q not found", c)
+		}
+	}
+	fields := in.Fields()
+	for i, f := range fields {
+		if t.columns[f.Name] {
+			fields[i].Type = arrow.BinaryTypes.String
+			fields[i].Nullable = true
+		}
+	}
+	md := in.Metadata()
+	t.schema = arrow.NewSchema(fields, &md)
+	return t.schema, nil
+}
+
+func (t *maskTransform) String() string {
+	return fmt.Sprintf("mask %s %v", t.method, sortedKeys(t.columns))
+}
+
+func (t *maskTransform) apply(_ context.Context, rec arrow.Record) (arrow.Record, error) {
+	cols := make([]arrow.Array, rec.NumCols())
+	for i, col := range rec.Columns() {
+		if !t.columns[rec.ColumnName(i)] {
+			col.Retain()
+			cols[i] = col
+			continue
+		}
+		cols[i] = t.mask(col)
+	}
+	defer func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}()
+	return array.NewRecord(t.schema, cols, rec.NumRows()), nil
+}
+
+func (t *maskTransform) mask(col arrow.Array) arrow.Array {
+	bldr := array.NewStringBuilder(allocator)
+	defer bldr.Release()
+	for i := 0; i < col.Len(); i++ {
+		switch {
+		case col.IsNull(i):
+			bldr.AppendNull()
+		case t.method == "redact":
+			bldr.Append("***")
+		default:
+			sum := sha256.Sum256([]byte(col.ValueStr(i)))
+			bldr.Append(hex.EncodeToString(sum[:]))
+		}
+	}
+	return bldr.NewArray()
+}
+
+// anonymizeTransform scrambles the values of text columns while keeping
+// their shape: each letter becomes a letter of the same case, each digit a
+// digit, and everything else, like the @ and dots of an e-mail address, is
+// kept. Scrambling is keyed, so equal values stay equal within a run but
+// cannot be recovered by hashing guesses.
+type anonymizeTransform struct {
+	key     []byte
+	keep    map[string]bool
+	columns map[int]bool
+}
+
+func newAnonymizeTransform(keep []string) (*anonymizeTransform, error) {
+	t := &anonymizeTransform{key: make([]byte, 32), keep: make(map[string]bool)}
+	if _, err := rand.Read(t.key); err != nil {
+		return nil, fmt.Errorf("anonymize: failed to generate key: %w", err)
+	}
+	for _, c := range keep {
+		t.keep[c] = true
+	}
+	return t, nil
+}
+
+func (t *anonymizeTransform) outputSchema(in *arrow.Schema) (*arrow.Schema, error) {
+	for c := range t.keep {
+		if !in.HasField(c) {
+			return nil, fmt.Errorf("anonymize: column %q not found", c)
+		}
+	}
+	t.columns = make(map[int]bool)
+	for i, f := range in.Fields() {
+		if id := f.Type.ID(); (id == arrow.STRING || id == arrow.LARGE_STRING) && !t.keep[f.Name] {
+			t.columns[i] = true
+		}
+	}
+	return in, nil
+}
+
+func (t *anonymizeTransform) String() string {
+	return fmt.Sprintf("anonymize keep %v", sortedKeys(t.keep))
+}
+
+func (t *anonymizeTransform) apply(_ context.Context, rec arrow.Record) (arrow.Record, error) {
+	cols := make([]arrow.Array, rec.NumCols())
+	for i, col := range rec.Columns() {
+		if !t.columns[i] {
+			col.Retain()
+			cols[i] = col
+			continue
+		}
+		bldr := array.NewBuilder(allocator, col.DataType())
+		for j := 0; j < col.Len(); j++ {
+			if col.IsNull(j) {
+				bldr.AppendNull()
+				continue
+			}
+			bldr.(interface{ Append(string) }).Append(t.scramble(col.ValueStr(j)))
+		}
+		cols[i] = bldr.NewArray()
+		bldr.Release()
+	}
+	defer func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}()
+	return array.NewRecord(rec.Schema(), cols, rec.NumRows()), nil
+}
+
+// scramble maps s to a string of the same shape, drawing replacement
+// characters from a keyed hash of s.
+func (t *anonymizeTransform) scramble(s string) string {
+	var stream []byte
+	for block := 0; len(stream) < len(s); block++ {
+		mac := hmac.New(sha256.New, t.key)
+		fmt.Fprintf(mac, "%d:%s", block, s)
+		stream = mac.Sum(stream)
+	}
+	out := []rune(s)
+	for i, r := range out {
+		b := int(stream[i%len(stream)])
+		switch {
+		case r >= 'a' && r <= 'z':
+			out[i] = 'a' + rune(b%26)
+		case r >= 'A' && r <= 'Z':
+			out[i] = 'A' + rune(b%26)
+		case r >= '0' && r <= '9':
+			out[i] = '0' + rune(b%10)
+		case unicode.IsLetter(r):
+			out[i] = 'x'
+		}
+	}
+	return string(out)
+}
+
+// filterTransform keeps the rows matching a single comparison, written as
+// "column op value" (op is one of = != < <= > >=) or "column is [not] null".
+type filterTransform struct {
+	column string
+	fn     string
+	value  string
+	index  int
+	lit    scalar.Scalar
+}
+
+var filterOps = map[string]string{
+	"=":  "equal",
+	"!=": "not_equal",
+	"<>": "not_equal",
+	"<":  "less",
+	"<=": "less_equal",
+	">":  "greater",
+	">=": "greater_equal",
+}
+
+func parseFilter(expr string) (*filterTransform, error) {
+	fields := strings.Fields(expr)
+	switch {
+	case len(fields) == 3 && strings.EqualFold(fields[1]+" "+fields[2], "is null"):
+		return &filterTransform{column: fields[0], fn: "is_null"}, nil
+	case len(fields) == 4 && strings.EqualFold(strings.Join(fields[1:], " "), "is not null"):
+		return &filterTransform{column: fields[0], fn: "is_valid"}, nil
+	case len(fields) >= 3:
+		fn, ok := filterOps[fields[1]]
+		if !ok {
+			break
+		}
+		value := strings.TrimSpace(strings.SplitN(strings.TrimSpace(expr), fields[1], 2)[1])
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+		return &filterTransform{column: fields[0], fn: fn, value: value}, nil
+	}
+	return nil, fmt.Errorf("invalid filter %q, expected \"column op value\" or \"column is [not] null\"", expr)
+}
+
+func (t *filterTransform) outputSchema(in *arrow.Schema) (*arrow.Schema, error) {
+	indices := in.FieldIndices(t.column)
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("filter: column %q not found", t.column)
+	}
+	t.index = indices[0]
+
+	if t.fn != "is_null" && t.fn != "is_valid" {
+		lit, err := scalar.ParseScalar(in.Field(t.index).Type, t.value)
+		if err != nil {
+			return nil, fmt.Errorf("filter: invalid value %q for column %s: %w", t.value, t.column, err)
+		}
+		t.lit = lit
+	}
+	return in, nil
+}
+
+func (t *filterTransform) String() string {
+	return fmt.Sprintf("filter %s %s %q", t.column, t.fn, t.value)
+}
+
+func (t *filterTransform) apply(ctx context.Context, rec arrow.Record) (arrow.Record, error) {
+	maskArr, err := t.mask(ctx, rec.Column(t.index))
+	if err != nil {
+		return nil, fmt.Errorf("filter on %s: %w", t.column, err)
+	}
+	defer maskArr.Release()
+
+	out, err := compute.FilterRecordBatch(ctx, rec, maskArr, compute.DefaultFilterOptions())
+	if err != nil {
+		return nil, fmt.Errorf("filter on %s: %w", t.column, err)
+	}
+	return out, nil
+}
+
+// mask returns the rows of col matching the filter. The compute package has
+// no is_null or is_valid kernels, so those masks are built from the
+// validity of col.
+func (t *filterTransform) mask(ctx context.Context, col arrow.Array) (arrow.Array, error) {
+	if t.fn == "is_null" || t.fn == "is_valid" {
+		bldr := array.NewBooleanBuilder(allocator)
+		defer bldr.Release()
+		bldr.Reserve(col.Len())
+		for i := 0; i < col.Len(); i++ {
+			bldr.UnsafeAppend(col.IsNull(i) == (t.fn == "is_null"))
+		}
+		return bldr.NewArray(), nil
+	}
+	args := []compute.Datum{compute.NewDatumWithoutOwning(col)}
+	if t.lit != nil {
+		args = append(args, compute.NewDatum(t.lit))
+	}
+	mask, err := compute.CallFunction(ctx, t.fn, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer mask.Release()
+	return mask.(*compute.ArrayDatum).MakeArray(), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// `dbx tui` browses connections, their tables and rows in the terminal, and
+// runs exports chosen from menus. It draws with plain ANSI escapes on the
+// alternate screen, redrawing the whole screen on every key.
+
+// tuiPreviewRows is how many rows the preview fetches.
+const tuiPreviewRows = 100
+
+// errTUIQuit unwinds the screens when the user quits.
+var errTUIQuit = errors.New("quit")
+
+// Keys other than printable characters, as returned by readKey.
+const (
+	keyUp rune = -1 - iota
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyEnter
+	keyBackspace
+	keyEscape
+	keyQuit
+)
+
+type tui struct {
+	cfg config
+	in  *bufio.Reader
+	out *bufio.Writer
+	// keys delivers the keys read from in, and is closed when reading
+	// fails.
+	keys chan rune
+	// logs collects the warnings logged while the screen is up.
+	logs bytes.Buffer
+}
+
+// tuiConnection is a connection offered on the first screen.
+type tuiConnection struct {
+	label string
+	opts  connOptions
+}
+
+// runTUI implements `dbx tui`.
+func runTUI(cfg config, args []string) error {
+	if len(args) != 0 {
+		return classify(errUsage, fmt.Errorf("usage: dbx tui"))
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return classify(errUsage, fmt.Errorf("dbx tui requires a terminal"))
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	t := &tui{cfg: cfg, in: bufio.NewReader(os.Stdin), out: bufio.NewWriter(os.Stdout), keys: make(chan rune)}
+	go t.readKeys()
+	// Log lines would scribble over the screen.
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&t.logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	// Alternate screen, cursor hidden.
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		t.out.WriteString("\x1b[?25h\x1b[?1049l")
+		t.out.Flush()
+	}()
+
+	if err := t.browseConnections(); err != nil && !errors.Is(err, errTUIQuit) {
+		return err
+	}
+	return nil
+}
+
+// connections returns -uri and its replicas, and the distinct sources of
+// the config file's named exports.
+func (t *tui) connections() []tuiConnection {
+	conns := []tuiConnection{{label: redactURI(t.cfg.conn.URI) + "  (-uri)", opts: t.cfg.conn}}
+	for _, r := range t.cfg.conn.Replicas {
+		opts := t.cfg.conn
+		opts.URI, opts.Replicas = r, nil
+		conns = append(conns, tuiConnection{label: redactURI(r) + "  (-replica)", opts: opts})
+	}
+	c, err := loadConfig(t.cfg.configPath)
+	if err != nil {
+		return conns
+	}
+	names := make([]string, 0, len(c.Exports))
+	for name := range c.Exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seen := map[string]bool{t.cfg.conn.URI: true}
+	for _, name := range names {
+		src := c.Exports[name].Source
+		if src.URI == "" || seen[src.URI] {
+			continue
+		}
+		seen[src.URI] = true
+		opts := t.cfg.conn
+		opts.URI, opts.Replicas = src.URI, nil
+		if src.Driver != "" || src.SQLDriver != "" {
+			opts.Driver, opts.SQLDriver = src.Driver, src.SQLDriver
+		}
+		if src.Auth != "" {
+			opts.Auth = src.Auth
+		}
+		conns = append(conns, tuiConnection{label: redactURI(src.URI) + "  (export " + name + ")", opts: opts})
+	}
+	return conns
+}
+
+func (t *tui) browseConnections() error {
+	conns := t.connections()
+	labels := make([]string, len(conns))
+	for i, c := range conns {
+		labels[i] = c.label
+	}
+	for {
+		i, err := t.pick("Connections", labels)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			return errTUIQuit
+		}
+		if err := t.browseTables(conns[i]); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *tui) browseTables(conn tuiConnection) error {
+	t.status(conn.label, "Listing tables...")
+	ctx := context.Background()
+	var tables []tableIdent
+	cnxn, err := openConnection(ctx, conn.opts)
+	if err == nil {
+		tables, err = listTables(ctx, cnxn)
+		cnxn.Close()
+	}
+	if err != nil {
+		return t.message(conn.label, append([]string{"Error: " + err.Error()}, t.drainLogs()...))
+	}
+	names := make([]string, len(tables))
+	for i, tbl := range tables {
+		names[i] = tbl.String()
+	}
+	for {
+		i, err := t.pick(conn.label+" > tables", names)
+		if err != nil || i < 0 {
+			return err
+		}
+		if err := t.tableActions(conn, names[i]); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *tui) tableActions(conn tuiConnection, table string) error {
+	title := conn.label + " > " + table
+	for {
+		i, err := t.pick(title, []string{"Preview rows", "Export..."})
+		if err != nil || i < 0 {
+			return err
+		}
+		if i == 0 {
+			err = t.preview(conn, table, title)
+		} else {
+			err = t.export(conn, table, title)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *tui) preview(conn tuiConnection, table, title string) error {
+	t.status(title, "Reading rows...")
+	ctx := context.Background()
+	lines, err := func() ([]string, error) {
+		query, err := tableQuery(conn.opts, table)
+		if err != nil {
+			return nil, err
+		}
+		cnxn, err := openConnection(ctx, conn.opts)
+		if err != nil {
+			return nil, err
+		}
+		defer cnxn.Close()
+		reader, err := executeQuery(ctx, cnxn, fmt.Sprintf("%s LIMIT %d", query, tuiPreviewRows))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Release()
+		rows, err := previewRows(reader, 0, tuiPreviewRows)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := printRows(&buf, reader.Schema(), rows); err != nil {
+			return nil, err
+		}
+		return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), nil
+	}()
+	if err != nil {
+		lines = []string{"Error: " + err.Error()}
+	}
+	return t.message(title+" > preview", append(lines, t.drainLogs()...))
+}
+
+func (t *tui) export(conn tuiConnection, table, title string) error {
+	formats := []string{"parquet", "arrow"}
+	f, err := t.pick(title+" > format", formats)
+	if err != nil || f < 0 {
+		return err
+	}
+	codecs := map[string][]string{
+		"parquet": {"snappy", "zstd", "gzip", "brotli", "none"},
+		"arrow":   {"none", "zstd", "lz4"},
+	}[formats[f]]
+	c, err := t.pick(title+" > compression", codecs)
+	if err != nil || c < 0 {
+		return err
+	}
+	sink := sinkOptions{Format: formats[f], Compression: codecs[c]}
+	output, ok, err := t.prompt(title+" > output", "Write to: ", table+sink.extension())
+	if err != nil || !ok {
+		return err
+	}
+
+	t.status(title, "Exporting to "+output+"... (Ctrl-C cancels)")
+	query, err := tableQuery(conn.opts, table)
+	var resp *response
+	if err == nil {
+		// The terminal is raw, so Ctrl-C arrives as a key rather than a
+		// signal; watch for it while the export runs.
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM)
+		go t.cancelOnQuit(ctx, cancel)
+		resp, err = exportQuery(ctx, conn.opts, exportSpec{Query: query, Output: output, sinkOptions: sink})
+		stop()
+		cancel()
+	}
+	var lines []string
+	if err != nil {
+		lines = []string{"Error: " + err.Error()}
+	} else {
+		lines = []string{
+			resp.Message,
+			"",
+			fmt.Sprintf("Rows:      %d", resp.RowsWritten),
+			fmt.Sprintf("Size:      %s", formatBytes(resp.OutputFileSize)),
+			fmt.Sprintf("Duration:  %s", formatDuration(resp.Duration)),
+			fmt.Sprintf("Rows/sec:  %.0f", math.Round(resp.RowsPerSecond)),
+			fmt.Sprintf("Location:  %s", resp.Location),
+			"",
+			"Same export from the command line:",
+			"  " + exportCommand(conn.opts, table, output, sink),
+		}
+	}
+	return t.message(title+" > export", append(lines, t.drainLogs()...))
+}
+
+// exportCommand returns the dbx command line exporting table like the TUI
+// did, with the password left out.
+func exportCommand(opts connOptions, table, output string, sink sinkOptions) string {
+	args := []string{"dbx"}
+	if opts.SQLDriver != "" {
+		args = append(args, "-sql-driver", opts.SQLDriver)
+	} else if opts.Driver != "" {
+		args = append(args, "-driver", opts.Driver)
+	}
+	args = append(args, "-uri", "'"+strings.ReplaceAll(redactURI(opts.URI), "'", `'\''`)+"'", "-table", table, "-output", output,
+		"-format", sink.Format, "-compression", sink.Compression)
+	return strings.Join(args, " ")
+}
+
+// cancelOnQuit calls cancel if Ctrl-C is pressed before ctx is done.
+func (t *tui) cancelOnQuit(ctx context.Context, cancel func()) {
+	for {
+		select {
+		case k, ok := <-t.keys:
+			if !ok || k == keyQuit {
+				cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pick shows items as a menu and returns the index chosen, or -1 if the
+// user went back. Typing filters the items.
+func (t *tui) pick(title string, items []string) (int, error) {
+	var filter []rune
+	cursor, top := 0, 0
+	for {
+		var shown []int
+		for i, item := range items {
+			if strings.Contains(strings.ToLower(item), strings.ToLower(string(filter))) {
+				shown = append(shown, i)
+			}
+		}
+		cursor = min(max(cursor, 0), max(len(shown)-1, 0))
+
+		_, height := t.size()
+		rows := max(height-4, 1)
+		if cursor < top {
+			top = cursor
+		} else if cursor >= top+rows {
+			top = cursor - rows + 1
+		}
+		lines := make([]string, 0, rows)
+		for i := top; i < len(shown) && i < top+rows; i++ {
+			prefix := "  "
+			if i == cursor {
+				prefix = "\x1b[7m> "
+			}
+			lines = append(lines, prefix+items[shown[i]])
+		}
+		if len(shown) == 0 {
+			lines = append(lines, "  (nothing)")
+		}
+		hint := "↑↓ move  Enter select  type to filter  Esc back  Ctrl-C quit"
+		if len(filter) > 0 {
+			hint = "filter: " + string(filter) + "  |  " + hint
+		}
+		t.draw(title, lines, hint)
+
+		k, err := t.readKey()
+		if err != nil {
+			return 0, err
+		}
+		switch k {
+		case keyQuit:
+			return 0, errTUIQuit
+		case keyEscape:
+			if len(filter) > 0 {
+				filter = nil
+				continue
+			}
+			return -1, nil
+		case keyUp:
+			cursor--
+		case keyDown:
+			cursor++
+		case keyPageUp:
+			cursor -= rows
+		case keyPageDown:
+			cursor += rows
+		case keyBackspace:
+			if len(filter) > 0 {
+				filter = filter[:len(filter)-1]
+			}
+		case keyEnter:
+			if len(shown) > 0 {
+				return shown[cursor], nil
+			}
+		default:
+			if k > 0 && unicode.IsPrint(k) {
+				filter = append(filter, k)
+				cursor, top = 0, 0
+			}
+		}
+	}
+}
+
+// prompt asks for a line of text, starting from def, and reports whether
+// it was entered rather than abandoned.
+func (t *tui) prompt(title, label, def string) (string, bool, error) {
+	value := []rune(def)
+	for {
+		t.draw(title, []string{label + string(value) + "\x1b[7m \x1b[0m"}, "Enter accept  Esc back  Ctrl-C quit")
+		k, err := t.readKey()
+		if err != nil {
+			return "", false, err
+		}
+		switch k {
+		case keyQuit:
+			return "", false, errTUIQuit
+		case keyEscape:
+			return "", false, nil
+		case keyEnter:
+			if len(value) > 0 {
+				return string(value), true, nil
+			}
+		case keyBackspace:
+			if len(value) > 0 {
+				value = value[:len(value)-1]
+			}
+		default:
+			if k > 0 && unicode.IsPrint(k) {
+				value = append(value, k)
+			}
+		}
+	}
+}
+
+// message shows lines, scrolling, until the user goes back.
+func (t *tui) message(title string, lines []string) error {
+	top := 0
+	for {
+		_, height := t.size()
+		rows := max(height-4, 1)
+		top = min(max(top, 0), max(len(lines)-rows, 0))
+		t.draw(title, lines[top:min(top+rows, len(lines))], "↑↓ scroll  Esc back  Ctrl-C quit")
+		k, err := t.readKey()
+		if err != nil {
+			return err
+		}
+		switch k {
+		case keyQuit:
+			return errTUIQuit
+		case keyEscape, keyEnter, keyBackspace, 'q':
+			return nil
+		case keyUp:
+			top--
+		case keyDown:
+			top++
+		case keyPageUp:
+			top -= rows
+		case keyPageDown:
+			top += rows
+		}
+	}
+}
+
+// status shows msg while the caller works.
+func (t *tui) status(title, msg string) {
+	t.draw(title, []string{msg}, "")
+}
+
+// draw redraws the screen: title, body lines and a hint line at the bottom.
+func (t *tui) draw(title string, lines []string, hint string) {
+	width, height := t.size()
+	t.out.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(t.out, "\x1b[1m%s\x1b[0m\r\n\r\n", truncate("dbx > "+title, width))
+	for _, line := range lines {
+		// Reset after each line, as the cursor line is drawn in reverse.
+		t.out.WriteString(truncate(line, width) + "\x1b[0m\r\n")
+	}
+	if hint != "" {
+		fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[2m%s\x1b[0m", height, truncate(hint, width))
+	}
+	t.out.Flush()
+}
+
+func (t *tui) size() (width, height int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width == 0 || height == 0 {
+		// Terminals that do not report a size, such as some serial
+		// consoles.
+		return 80, 24
+	}
+	return width, height
+}
+
+// drainLogs returns the lines logged since the last call.
+func (t *tui) drainLogs() []string {
+	if t.logs.Len() == 0 {
+		return nil
+	}
+	lines := append([]string{""}, strings.Split(strings.TrimSpace(t.logs.String()), "\n")...)
+	t.logs.Reset()
+	return lines
+}
+
+// readKey returns the next key pressed. The terminal closing quits.
+func (t *tui) readKey() (rune, error) {
+	k, ok := <-t.keys
+	if !ok {
+		return 0, errTUIQuit
+	}
+	return k, nil
+}
+
+// readKeys reads keys from the terminal into t.keys. Keys are read in the
+// background so that Ctrl-C can cancel an export in progress.
+func (t *tui) readKeys() {
+	defer close(t.keys)
+	for {
+		k, err := t.parseKey()
+		if err != nil {
+			return
+		}
+		if k != 0 {
+			t.keys <- k
+		}
+	}
+}
+
+// parseKey reads one key press from the raw terminal, or 0 for keys dbX
+// does not use.
+func (t *tui) parseKey() (rune, error) {
+	r, _, err := t.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch r {
+	case 3, 4: // Ctrl-C, Ctrl-D
+		return keyQuit, nil
+	case '\r', '\n':
+		return keyEnter, nil
+	case 127, 8:
+		return keyBackspace, nil
+	case 0x1b:
+		// A lone Esc, or the start of an arrow or paging key's sequence,
+		// which arrives in the same read.
+		if t.in.Buffered() == 0 {
+			return keyEscape, nil
+		}
+		if b, _ := t.in.ReadByte(); b != '[' && b != 'O' {
+			return keyEscape, nil
+		}
+		b, _ := t.in.ReadByte()
+		switch b {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		case '5', '6':
+			t.in.ReadByte() // the trailing ~
+			if b == '5' {
+				return keyPageUp, nil
+			}
+			return keyPageDown, nil
+		}
+		return 0, nil
+	}
+	return r, nil
+}
+
+// truncate cuts s to width columns, not counting escape sequences.
+func truncate(s string, width int) string {
+	var b strings.Builder
+	cols, inEscape := 0, false
+	for _, r := range s {
+		switch {
+		case r == 0x1b:
+			inEscape = true
+		case inEscape:
+			inEscape = !unicode.IsLetter(r)
+		case cols >= width:
+			return b.String()
+		default:
+			cols++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Drivers dial the database themselves, so connections through an SSH jump
+// host or a proxy are forwarded: each database address gets a listener on
+// localhost, the URI handed to the driver points at it, and connections
+// accepted there are relayed to the database the long way round.
+//
+// The database sees the jump host or proxy as the client, and TLS modes
+// that verify the server's host name against the URI, like
+// sslmode=verify-full, see localhost instead of the database host.
+
+var (
+	forwardersMu sync.Mutex
+	forwarders   = make(map[string]*forwarder)
+)
+
+// tunnelURI returns uri with its host and port replaced by a local address
+// forwarded to them through the jump host of opts.SSH, the proxy of
+// opts.Proxy, or the proxy to the jump host if both are set.
+func tunnelURI(ctx context.Context, opts connOptions, uri string) (string, error) {
+	if opts.SSH == "" && opts.Proxy == "" {
+		return uri, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("-ssh and -proxy require a URI of the form scheme://user@host:port/db")
+	}
+	if u.Port() == "" {
+		port, ok := defaultPorts[u.Scheme]
+		if !ok {
+			return "", fmt.Errorf("-ssh and -proxy require the port in the URI")
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	f, err := openForwarder(opts)
+	if err != nil {
+		return "", err
+	}
+	local, err := f.forward(ctx, u.Host)
+	if err != nil {
+		return "", err
+	}
+	u.Host = local
+	return u.String(), nil
+}
+
+// defaultPorts are the ports of URI schemes that may leave them out.
+var defaultPorts = map[string]string{
+	"postgres":   "5432",
+	"postgresql": "5432",
+	"mysql":      "3306",
+	"sqlserver":  "1433",
+}
+
+// openForwarder returns the forwarder for the jump host and proxy of opts,
+// creating it on first use.
+func openForwarder(opts connOptions) (*forwarder, error) {
+	forwardersMu.Lock()
+	defer forwardersMu.Unlock()
+	key := opts.SSH + " " + opts.SSHKey + " " + opts.Proxy
+	if f, ok := forwarders[key]; ok {
+		return f, nil
+	}
+	dial := func(ctx context.Context, target string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", target)
+	}
+	via := "direct"
+	if opts.Proxy != "" {
+		d, err := proxyDialer(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		dial = d
+		via = "proxy " + redactURI(opts.Proxy)
+	}
+	if opts.SSH != "" {
+		t, err := newSSHTunnel(opts.SSH, opts.SSHKey, dial)
+		if err != nil {
+			return nil, err
+		}
+		dial, via = t.dial, "SSH jump host "+t.dest
+		// Connect now, so that a bad jump host fails here rather than as
+		// a dropped connection in the driver.
+		t.mu.Lock()
+		_, err = t.connect(context.Background())
+		t.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	f := &forwarder{via: via, dial: dial, listeners: make(map[string]net.Listener)}
+	forwarders[key] = f
+	return f, nil
+}
+
+// forwarder relays connections from local listeners to their targets
+// through dial.
+type forwarder struct {
+	via  string
+	dial dialFunc
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+}
+
+// forward returns the local address forwarded to target, host:port,
+// starting to listen on it on first use.
+func (f *forwarder) forward(ctx context.Context, target string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if l, ok := f.listeners[target]; ok {
+		return l.Addr().String(), nil
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for forwarded connections: %w", err)
+	}
+	f.listeners[target] = l
+	logger(ctx).Debug("Forwarding connections", "via", f.via, "target", target, "local", l.Addr().String())
+	go f.accept(l, target)
+	return l.Addr().String(), nil
+}
+
+func (f *forwarder) accept(l net.Listener, target string) {
+	for {
+		local, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer local.Close()
+			remote, err := f.dial(context.Background(), target)
+			if err != nil {
+				logger(context.Background()).Warn("Failed to forward connection", "via", f.via, "target", target, "err", err)
+				return
+			}
+			defer remote.Close()
+			done := make(chan struct{}, 2)
+			go func() { io.Copy(remote, local); done <- struct{}{} }()
+			go func() { io.Copy(local, remote); done <- struct{}{} }()
+			<-done
+		}()
+	}
+}
+
+// sshTunnel dials connections from an SSH jump host. One SSH connection per
+// jump host carries every forwarded connection of the process, and is
+// redialed if it drops.
+type sshTunnel struct {
+	dest   string
+	config *ssh.ClientConfig
+	// jump dials the jump host itself.
+	jump dialFunc
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHTunnel returns a tunnel through dest, user@host[:port], reached
+// with jump. keyPath, if set, is the private key to log in with; else keys
+// come from the SSH agent and the default key files.
+func newSSHTunnel(dest, keyPath string, jump dialFunc) (*sshTunnel, error) {
+	config, err := sshClientConfig(dest, keyPath, "-ssh-key")
+	if err != nil {
+		return nil, err
+	}
+	host := dest[strings.LastIndex(dest, "@")+1:]
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	return &sshTunnel{dest: host, config: config, jump: jump}, nil
+}
+
+// sshClientConfig logs in as the user of dest, verifying the server's key
+// against ~/.ssh/known_hosts. keyFlag names the flag keyPath is given with.
+func sshClientConfig(dest, keyPath, keyFlag string) (*ssh.ClientConfig, error) {
+	user, _, ok := strings.Cut(dest, "@")
+	if !ok || user == "" {
+		return nil, fmt.Errorf("invalid -ssh %q, use user@host[:port]", dest)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ~/.ssh: %w", err)
+	}
+	hostKeys, err := knownHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	var signers []ssh.Signer
+	keyPaths := []string{keyPath}
+	if keyPath == "" {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if conn, err := net.Dial("unix", sock); err == nil {
+				if s, err := agent.NewClient(conn).Signers(); err == nil {
+					signers = append(signers, s...)
+				}
+			}
+		}
+		keyPaths = []string{
+			filepath.Join(home, ".ssh", "id_ed25519"),
+			filepath.Join(home, ".ssh", "id_ecdsa"),
+			filepath.Join(home, ".ssh", "id_rsa"),
+		}
+	}
+	for _, path := range keyPaths {
+		pem, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) && keyPath == "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && keyPath == "" {
+			// Encrypted default keys are used through the agent.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH key %s: %w", path, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no SSH key for %s: give %s, or load one into ssh-agent", dest, keyFlag)
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+	}, nil
+}
+
+// knownHosts verifies the keys of SSH servers against ~/.ssh/known_hosts.
+func knownHosts() (ssh.HostKeyCallback, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ~/.ssh: %w", err)
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH known hosts: %w", err)
+	}
+	return hostKeys, nil
+}
+
+// connect returns the SSH connection to the jump host, dialing it if there
+// is none. t.mu must be held.
+func (t *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	if t.client != nil {
+		return t.client, nil
+	}
+	conn, err := t.jump(ctx, t.dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH jump host %s: %w", t.dest, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.dest, t.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to SSH jump host %s: %w", t.dest, err)
+	}
+	t.client = ssh.NewClient(c, chans, reqs)
+	return t.client, nil
+}
+
+// dial opens a connection to target from the jump host, reconnecting to
+// the jump host once if the SSH connection has dropped.
+func (t *sshTunnel) dial(ctx context.Context, target string) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		client, err := t.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.Dial("tcp", target)
+		if err == nil {
+			return conn, nil
+		}
+		// The jump host refusing the target leaves the SSH connection,
+		// and the other tunneled connections on it, intact.
+		var refused *ssh.OpenChannelError
+		if errors.As(err, &refused) || attempt == 1 {
+			return nil, fmt.Errorf("failed to reach %s through SSH jump host %s: %w", target, t.dest, err)
+		}
+		client.Close()
+		t.client = nil
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// vacuumResult reports what `dbx vacuum` removed from a dataset.
+type vacuumResult struct {
+	Dataset string        `json:"dataset"`
+	Cutoff  time.Time     `json:"cutoff"`
+	Removed []datasetPart `json:"removed"`
+	// Rows and Bytes are those of the parts removed.
+	Rows  int64 `json:"rows"`
+	Bytes int64 `json:"bytes"`
+	// Kept is the number of parts left.
+	Kept   int  `json:"kept"`
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// runVacuum implements `dbx vacuum [flags] <dataset-dir>`, removing the
+// parts appended to a dataset with -append-to before the retention window
+// from its manifest and from disk, and from the S3 copy of the dataset with
+// -s3-location.
+func runVacuum(cfg config, args []string) error {
+	fs := flag.NewFlagSet("vacuum", flag.ExitOnError)
+	keepDays := fs.Int("keep-days", 30, "Keep the parts appended in this many most recent days")
+	dryRun := fs.Bool("dry-run", false, "List the parts that would be removed without removing them")
+	s3Location := fs.String("s3-location", "", "S3 URI the dataset directory is synced to, as for -glue-location; expired parts are deleted from it too")
+	region := fs.String("region", "", "AWS region of the -s3-location bucket (default AWS_REGION or AWS_DEFAULT_REGION)")
+	parseFlags(fs, args)
+	dirs := fs.Args()
+	// Flags may also follow the dataset, as in dbx vacuum dir -keep-days 7.
+	if len(dirs) > 1 {
+		fs.Parse(dirs[1:])
+		dirs = append(dirs[:1], fs.Args()...)
+	}
+
+	if len(dirs) != 1 {
+		return classify(errUsage, fmt.Errorf("usage: dbx vacuum [-keep-days n] [-dry-run] [-s3-location s3://bucket/prefix] <dataset-dir>"))
+	}
+	if *keepDays < 0 {
+		return classify(errUsage, fmt.Errorf("-keep-days must not be negative"))
+	}
+	var mirror *s3Mirror
+	if *s3Location != "" {
+		var err error
+		if mirror, err = newS3Mirror(*s3Location, *region); err != nil {
+			return classify(errUsage, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -*keepDays)
+	res, err := vacuumDataset(ctx, dirs[0], cutoff, mirror, *dryRun)
+	if err != nil {
+		return err
+	}
+	msg := "Vacuumed dataset"
+	if res.DryRun {
+		msg = "Parts that would be removed"
+	}
+	slog.Info(msg, "dataset", res.Dataset, "removed", len(res.Removed), "rows", res.Rows, "bytes", formatBytes(res.Bytes), "kept", res.Kept)
+	if cfg.json {
+		printJSON(res)
+	}
+	return nil
+}
+
+// vacuumDataset removes the parts of the dataset at dir appended before
+// cutoff, except those of tagged snapshots. The manifest is rewritten
+// before any file is deleted, so it never lists a missing file; a failure
+// part way leaves files it no longer lists, to be removed by hand. The
+// dataset's cursor is kept, so incremental exports carry on from where they
+// were even if every part is removed.
+func vacuumDataset(ctx context.Context, dir string, cutoff time.Time, mirror *s3Mirror, dryRun bool) (*vacuumResult, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, classify(errUsage, fmt.Errorf("%s has no manifest; only datasets written with -append-to can be vacuumed", dir))
+	}
+	res := &vacuumResult{Dataset: dir, Cutoff: cutoff, Removed: []datasetPart{}, DryRun: dryRun}
+	var kept []datasetPart
+	tagged := m.tagged()
+	for _, p := range m.Parts {
+		if p.CreatedAt.Before(cutoff) && !tagged[p.Path] {
+			res.Removed = append(res.Removed, p)
+			res.Rows += p.Rows
+			res.Bytes += p.Bytes
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	res.Kept = len(kept)
+	if dryRun || len(res.Removed) == 0 {
+		return res, nil
+	}
+
+	m.Parts, m.Rows, m.Bytes = kept, m.Rows-res.Rows, m.Bytes-res.Bytes
+	if err := writeManifest(dir, *m); err != nil {
+		return nil, classify(errWrite, err)
+	}
+	root := filepath.Clean(dir)
+	for _, p := range res.Removed {
+		file := filepath.Join(root, filepath.FromSlash(p.Path))
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return nil, classify(errWrite, fmt.Errorf("failed to remove %s: %w", file, err))
+		}
+		logger(ctx).Debug("Removed part", "path", file, "created_at", p.CreatedAt)
+		// Remove partition directories left empty.
+		for d := filepath.Dir(file); d != root && d != "."; d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break
+			}
+		}
+		if mirror != nil {
+			if err := mirror.remove(ctx, p.Path); err != nil {
+				return nil, classify(errWrite, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// s3Mirror is the copy of a dataset directory in S3.
+type s3Mirror struct {
+	Bucket string
+	Prefix string
+	Region string
+}
+
+// newS3Mirror parses location, s3://bucket/prefix.
+func newS3Mirror(location, region string) (*s3Mirror, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("-s3-location %q must be an s3://bucket/prefix URI", location)
+	}
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(env)
+		}
+	}
+	if region == "" {
+		return nil, fmt.Errorf("cannot tell the AWS region of %s, set -region or AWS_REGION", location)
+	}
+	return &s3Mirror{Bucket: u.Host, Prefix: strings.Trim(u.Path, "/"), Region: region}, nil
+}
+
+// remove deletes the object of the dataset file at rel, a slash-separated
+// path relative to the dataset. An object already gone is not an error.
+func (m *s3Mirror) remove(ctx context.Context, rel string) error {
+	creds, err := loadAWSCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	key := path.Join(m.Prefix, rel)
+	// AWS_ENDPOINT_URL_S3 points at a compatible store, such as MinIO, as
+	// it does for the AWS SDKs; it is addressed path-style.
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = "https://s3." + m.Region + ".amazonaws.com"
+	}
+	escaped := make([]string, 0, strings.Count(key, "/")+2)
+	for _, seg := range append([]string{m.Bucket}, strings.Split(key, "/")...) {
+		escaped = append(escaped, awsEscape(seg))
+	}
+	u := strings.TrimSuffix(endpoint, "/") + "/" + strings.Join(escaped, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	empty := sha256.Sum256(nil)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(empty[:]))
+	signAWSRequest(req, nil, creds, m.Region, "s3", time.Now())
+
+	resp, err := (&http.Client{Timeout: notifyTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete s3://%s/%s: %w", m.Bucket, key, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete s3://%s/%s: %s: %s", m.Bucket, key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	logger(ctx).Debug("Deleted object", "bucket", m.Bucket, "key", key)
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// check is a data quality assertion evaluated over the records of an export.
+// A failed check fails the export.
+type check interface {
+	bind(schema *arrow.Schema) error
+	observe(rec arrow.Record)
+	result() error
+	// expectation describes the outcome of the check, once every record
+	// was observed.
+	expectation() expectationResult
+}
+
+// notNullCheck fails if a column contains any nulls.
+type notNullCheck struct {
+	column string
+	index  int
+	rows   int64
+	nulls  int64
+}
+
+func (c *notNullCheck) bind(schema *arrow.Schema) error {
+	indices := schema.FieldIndices(c.column)
+	if len(indices) == 0 {
+		return fmt.Errorf("not_null: column %q not found", c.column)
+	}
+	c.index = indices[0]
+	return nil
+}
+
+func (c *notNullCheck) observe(rec arrow.Record) {
+	c.rows += rec.NumRows()
+	c.nulls += int64(rec.Column(c.index).NullN())
+}
+
+func (c *notNullCheck) result() error {
+	if c.nulls > 0 {
+		return fmt.Errorf("not_null: column %s has %d nulls", c.column, c.nulls)
+	}
+	return nil
+}
+
+func (c *notNullCheck) expectation() expectationResult {
+	r := expectationResult{Success: c.nulls == 0}
+	r.Config.Type = "expect_column_values_to_not_be_null"
+	r.Config.Kwargs = map[string]any{"column": c.column}
+	r.Result.ElementCount = &c.rows
+	r.Result.UnexpectedCount = &c.nulls
+	var pct float64
+	if c.rows > 0 {
+		pct = 100 * float64(c.nulls) / float64(c.rows)
+	}
+	r.Result.UnexpectedPercent = &pct
+	return r
+}
+
+// rowCountCheck fails if the export row count is outside [min, max]. A zero
+// max means no upper bound.
+type rowCountCheck struct {
+	min, max int64
+	rows     int64
+}
+
+func (c *rowCountCheck) bind(*arrow.Schema) error { return nil }
+
+func (c *rowCountCheck) observe(rec arrow.Record) {
+	c.rows += rec.NumRows()
+}
+
+func (c *rowCountCheck) result() error {
+	if c.rows < c.min {
+		return fmt.Errorf("min_rows: got %d rows, want at least %d", c.rows, c.min)
+	}
+	if c.max > 0 && c.rows > c.max {
+		return fmt.Errorf("max_rows: got %d rows, want at most %d", c.rows, c.max)
+	}
+	return nil
+}
+
+func (c *rowCountCheck) expectation() expectationResult {
+	r := expectationResult{Success: c.result() == nil}
+	r.Config.Type = "expect_table_row_count_to_be_between"
+	r.Config.Kwargs = map[string]any{"min_value": nil, "max_value": nil}
+	if c.min > 0 {
+		r.Config.Kwargs["min_value"] = c.min
+	}
+	if c.max > 0 {
+		r.Config.Kwargs["max_value"] = c.max
+	}
+	r.Result.ObservedValue = c.rows
+	return r
+}
+
+// validationResult is the outcome of the checks of an export, shaped like
+// a Great Expectations validation result so data quality dashboards that
+// read those can read it too.
+type validationResult struct {
+	Success    bool                `json:"success"`
+	Statistics validationStats     `json:"statistics"`
+	Results    []expectationResult `json:"results"`
+	Meta       validationMeta      `json:"meta"`
+}
+
+type validationStats struct {
+	Evaluated      int     `json:"evaluated_expectations"`
+	Successful     int     `json:"successful_expectations"`
+	Unsuccessful   int     `json:"unsuccessful_expectations"`
+	SuccessPercent float64 `json:"success_percent"`
+}
+
+type validationMeta struct {
+	// Query is the SQL whose result was validated, and Output where it
+	// was written.
+	Query      string    `json:"query"`
+	Output     string    `json:"output"`
+	RunTime    time.Time `json:"run_time"`
+	DbxVersion string    `json:"dbx_version"`
+}
+
+// expectationResult is the outcome of a single check.
+type expectationResult struct {
+	Success bool `json:"success"`
+	Config  struct {
+		Type   string         `json:"expectation_type"`
+		Kwargs map[string]any `json:"kwargs"`
+	} `json:"expectation_config"`
+	Result struct {
+		ObservedValue     any      `json:"observed_value,omitempty"`
+		ElementCount      *int64   `json:"element_count,omitempty"`
+		UnexpectedCount   *int64   `json:"unexpected_count,omitempty"`
+		UnexpectedPercent *float64 `json:"unexpected_percent,omitempty"`
+	} `json:"result"`
+}
+
+// newValidationResult collects the outcomes of the checks of spec.
+func newValidationResult(spec exportSpec) *validationResult {
+	v := &validationResult{
+		Success: true,
+		Results: []expectationResult{},
+		Meta:    validationMeta{Query: spec.Query, Output: spec.Output, RunTime: time.Now().UTC(), DbxVersion: version},
+	}
+	for _, c := range spec.Checks {
+		r := c.expectation()
+		v.Results = append(v.Results, r)
+		v.Statistics.Evaluated++
+		if r.Success {
+			v.Statistics.Successful++
+		} else {
+			v.Statistics.Unsuccessful++
+			v.Success = false
+		}
+	}
+	if v.Statistics.Evaluated > 0 {
+		v.Statistics.SuccessPercent = 100 * float64(v.Statistics.Successful) / float64(v.Statistics.Evaluated)
+	} else {
+		v.Statistics.SuccessPercent = 100
+	}
+	return v
+}
+
+// writeValidationFile writes the validation result v as JSON to path.
+func writeValidationFile(path string, v *validationResult) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode validation result: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write validation result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// watchExport keeps re-running j until ctx is done: every interval, and on
+// every notification on the Postgres channel listen when it is set.
+// Notifications arriving while a run is in progress are coalesced into one
+// more run. The job's state is kept like a scheduled job's, so with a cursor
+// each run only exports the rows added since the previous one, even across
+// restarts. A failed run is logged and retried on the next trigger.
+func watchExport(ctx context.Context, cfg config, j scheduledJob, interval time.Duration, listen string) error {
+	stateDir := dbxPath("state")
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	notify := make(chan string, 1)
+	listenErr := make(chan error, 1)
+	if listen != "" {
+		if name := cfg.conn.dialect().name; name != "postgres" {
+			return classify(errUsage, fmt.Errorf("-listen requires a Postgres connection, not %s", name))
+		}
+		uri, err := authURI(ctx, cfg.conn)
+		if err == nil {
+			uri, err = tunnelURI(ctx, cfg.conn, uri)
+		}
+		if err != nil {
+			return classify(errConnection, err)
+		}
+		conn, err := pgx.Connect(ctx, uri)
+		if err != nil {
+			return classify(errConnection, fmt.Errorf("failed to connect to listen: %w", err))
+		}
+		defer conn.Close(context.Background())
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{listen}.Sanitize()); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", listen, err)
+		}
+		go func() {
+			for {
+				n, err := conn.WaitForNotification(ctx)
+				if err != nil {
+					listenErr <- err
+					return
+				}
+				select {
+				case notify <- n.Payload:
+				default:
+				}
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	slog.Info("Watching", "job", j.Name, "interval", interval, "channel", listen)
+	trigger := "start"
+	for {
+		logger(ctx).Debug("Watch triggered", "job", j.Name, "trigger", trigger)
+		if _, err := j.run(ctx, cfg, stateDir); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			slog.Error("Watch run failed", "job", j.Name, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			trigger = "interval"
+		case payload := <-notify:
+			trigger = "notify " + payload
+		case err := <-listenErr:
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return classify(errConnection, fmt.Errorf("failed waiting for notifications on %s: %w", listen, err))
+		}
+	}
+}